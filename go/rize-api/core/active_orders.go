@@ -0,0 +1,210 @@
+package core
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// These are how an order reached the kitchen, worked out from what is set on it
+const (
+	OrderTypePickup = "pickup"
+	OrderTypePager  = "pager"
+	OrderTypeTable  = "table"
+	OrderTypeOther  = "other"
+)
+
+// activeOrderStatuses are the statuses of orders staff still have to work on
+var activeOrderStatuses = []string{OrderStatusSubmitted, OrderStatusPending, OrderStatusAccepted, OrderStatusOnHold}
+
+// ActiveOrderFilter narrows down a site's active orders. Empty fields don't filter.
+type ActiveOrderFilter struct {
+	Statuses []string      `json:"statuses"`
+	Types    []string      `json:"types"`
+	MaxAge   time.Duration `json:"max_age"`
+}
+
+// ActiveOrder is an order staff still have to work on, with its lines
+type ActiveOrder struct {
+	Order   Order  `json:"order"`
+	Type    string `json:"type"`
+	Minutes int    `json:"minutes"` // Minutes is how long ago the order was created
+}
+
+// OrderActionError is returned when staff can't do something to an order in its current state
+type OrderActionError struct {
+	Reason string
+}
+
+func (e OrderActionError) Error() string {
+	return e.Reason
+}
+
+// ListActiveOrders will return the orders at a site that aren't complete, rejected or deleted, pickup orders first by
+// pickup time and then the rest oldest first
+func (app AppContext) ListActiveOrders(siteID KountaID, filter ActiveOrderFilter, now time.Time) (*[]ActiveOrder, error) {
+	statuses := activeOrderStatuses
+	if len(filter.Statuses) > 0 {
+		statuses = []string{}
+		for _, status := range filter.Statuses {
+			if !containsString(activeOrderStatuses, status) {
+				return nil, errors.Wrap(OrderActionError{Reason: "status " + status + " isn't an active order status"}, "list active orders")
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	for _, orderType := range filter.Types {
+		if !containsString([]string{OrderTypePickup, OrderTypePager, OrderTypeTable, OrderTypeOther}, orderType) {
+			return nil, errors.Wrap(OrderActionError{Reason: "unknown order type " + orderType}, "list active orders")
+		}
+	}
+
+	createdAfter := time.Time{}
+	if filter.MaxAge > 0 {
+		createdAfter = now.Add(-filter.MaxAge)
+	}
+
+	orders, err := app.DB.SelectActiveOrdersBySiteID(siteID, statuses, createdAfter)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list active orders for site %d", siteID)
+	}
+
+	matching := []Order{}
+	for _, order := range *orders {
+		if len(filter.Types) == 0 || containsString(filter.Types, orderType(order)) {
+			matching = append(matching, order)
+		}
+	}
+	if err := app.loadLinesForOrders(matching); err != nil {
+		return nil, errors.Wrapf(err, "list active orders for site %d", siteID)
+	}
+
+	activeOrders := []ActiveOrder{}
+	for _, order := range matching {
+		activeOrders = append(activeOrders, ActiveOrder{Order: order, Type: orderType(order), Minutes: int(now.Sub(order.CreatedAt).Minutes())})
+	}
+	return &activeOrders, nil
+}
+
+// KountaOrderAccepter is implemented by Kounta clients that can move a submitted order to ACCEPTED status
+type KountaOrderAccepter interface {
+	AcceptOrder(orderID KountaID) (KountaOrder, error)
+}
+
+// AcceptOrder will accept a submitted order in Kounta so the kitchen starts on it
+func (app AppContext) AcceptOrder(orderID DatabaseID) error {
+	order, err := app.getActiveOrder(orderID)
+	if err != nil {
+		return errors.Wrap(err, "accept order")
+	}
+	if order.Status != OrderStatusSubmitted && order.Status != OrderStatusPending {
+		return errors.Wrap(OrderActionError{Reason: "only submitted orders can be accepted"}, "accept order")
+	}
+
+	accepter, ok := app.Kounta.(KountaOrderAccepter)
+	if !ok {
+		return errors.Errorf("accept order: the kounta client can't accept order %d, it needs to be accepted in kounta", order.PosID)
+	}
+	kountaOrder, err := accepter.AcceptOrder(order.PosID)
+	if err != nil {
+		return errors.Wrap(err, "accept order")
+	}
+	if _, err := app.CreateOrUpdateOrderFromKounta(kountaOrder); err != nil {
+		return errors.Wrap(err, "accept order")
+	}
+	return nil
+}
+
+// RejectActiveOrder will reject an order staff can't make
+func (app AppContext) RejectActiveOrder(orderID DatabaseID) error {
+	order, err := app.getActiveOrder(orderID)
+	if err != nil {
+		return errors.Wrap(err, "reject active order")
+	}
+	return app.RejectOrder(order.PosID)
+}
+
+// MarkOrderReady will complete a pickup order in Kounta, which tells the customer it's ready. Orders eaten in are
+// completed when they are paid for, so only pickup orders can be marked ready.
+func (app AppContext) MarkOrderReady(orderID DatabaseID) error {
+	order, err := app.getActiveOrder(orderID)
+	if err != nil {
+		return errors.Wrap(err, "mark order ready")
+	}
+	if orderType(*order) != OrderTypePickup {
+		return errors.Wrap(OrderActionError{Reason: "only pickup orders can be marked ready"}, "mark order ready")
+	}
+
+	if err := app.Kounta.CompleteOrder(order.PosID); err != nil {
+		return errors.Wrap(err, "mark order ready")
+	}
+
+	// turn around and get order from kounta now that we've updated
+	kountaOrder, err := app.Kounta.GetOrderByID(order.PosID)
+	if err != nil {
+		return errors.Wrap(err, "mark order ready")
+	}
+	if _, err := app.CreateOrUpdateOrderFromKounta(kountaOrder); err != nil {
+		return errors.Wrap(err, "mark order ready")
+	}
+	return nil
+}
+
+// ReassignOrderTable will move an order to another table, like when a guest changes seats
+func (app AppContext) ReassignOrderTable(orderID DatabaseID, tableName string) error {
+	if tableName == "" {
+		return errors.Wrap(OrderActionError{Reason: "a table is required"}, "reassign order table")
+	}
+	order, err := app.getActiveOrder(orderID)
+	if err != nil {
+		return errors.Wrap(err, "reassign order table")
+	}
+
+	if err := app.Kounta.LinkOrderWithTable(order.PosID, tableName); err != nil {
+		return errors.Wrap(err, "reassign order table")
+	}
+	if err := app.DB.UpdateOrderTableName(order, tableName); err != nil {
+		return errors.Wrap(err, "reassign order table")
+	}
+
+	previousTable := order.TableName
+	order.TableName = tableName
+	app.publishTableLinked(order, previousTable)
+	return nil
+}
+
+func (app AppContext) getActiveOrder(orderID DatabaseID) (*Order, error) {
+	order, err := app.DB.GetOrderByDatabaseID(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order == nil {
+		return nil, OrderActionError{Reason: "order not found"}
+	}
+	if !containsString(activeOrderStatuses, order.Status) {
+		return nil, OrderActionError{Reason: "order is already " + order.Status}
+	}
+	return order, nil
+}
+
+// orderType is pickup for orders with a pickup time, then pager or table for orders eaten in
+func orderType(order Order) string {
+	switch {
+	case order.PickupTime != nil:
+		return OrderTypePickup
+	case order.PagerNumber != "":
+		return OrderTypePager
+	case order.TableName != "":
+		return OrderTypeTable
+	}
+	return OrderTypeOther
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+func TestListActiveOrders(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	now := time.Now()
+
+	laterPickup := insertKountaOrder(t, app, 801, core.OrderStatusOnHold, "", "")
+	app.DB.UpdateOrderPickupTime(laterPickup, now.Add(30*time.Minute))
+	soonerPickup := insertKountaOrder(t, app, 802, core.OrderStatusOnHold, "", "")
+	app.DB.UpdateOrderPickupTime(soonerPickup, now.Add(10*time.Minute))
+	tableOrder := insertKountaOrder(t, app, 803, core.OrderStatusAccepted, "7", "")
+	pagerOrder := insertKountaOrder(t, app, 804, core.OrderStatusSubmitted, "", "20")
+	insertKountaOrder(t, app, 805, core.OrderStatusComplete, "8", "")
+
+	// act
+	all, err := app.ListActiveOrders(core.TestSitePosID, core.ActiveOrderFilter{}, now)
+	pagers, _ := app.ListActiveOrders(core.TestSitePosID, core.ActiveOrderFilter{Types: []string{core.OrderTypePager}}, now)
+	accepted, _ := app.ListActiveOrders(core.TestSitePosID, core.ActiveOrderFilter{Statuses: []string{core.OrderStatusAccepted}}, now)
+	recent, _ := app.ListActiveOrders(core.TestSitePosID, core.ActiveOrderFilter{MaxAge: time.Minute}, now.Add(time.Hour))
+	_, badStatusErr := app.ListActiveOrders(core.TestSitePosID, core.ActiveOrderFilter{Statuses: []string{core.OrderStatusComplete}}, now)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []core.DatabaseID{soonerPickup.ID, laterPickup.ID, tableOrder.ID, pagerOrder.ID}, activeOrderIDs(all))
+	assert.Equal(t, []string{core.OrderTypePickup, core.OrderTypePickup, core.OrderTypeTable, core.OrderTypePager},
+		[]string{(*all)[0].Type, (*all)[1].Type, (*all)[2].Type, (*all)[3].Type})
+	assert.Equal(t, 2, len((*all)[0].Order.Lines))
+
+	assert.Equal(t, []core.DatabaseID{pagerOrder.ID}, activeOrderIDs(pagers))
+	assert.Equal(t, []core.DatabaseID{tableOrder.ID}, activeOrderIDs(accepted))
+	assert.Equal(t, 0, len(*recent))
+	assert.IsType(t, core.OrderActionError{}, errors.Cause(badStatusErr))
+}
+
+func TestStaffOrderActions(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	submitted := insertKountaOrder(t, app, 801, core.OrderStatusSubmitted, "7", "")
+	pickup := insertKountaOrder(t, app, 802, core.OrderStatusOnHold, "", "")
+	app.DB.UpdateOrderPickupTime(pickup, time.Now().Add(10*time.Minute))
+	unwanted := insertKountaOrder(t, app, 803, core.OrderStatusSubmitted, "", "")
+	app.Kounta = &acceptingKounta{MockKounta: app.Kounta.(*pos.MockKounta)}
+
+	// act
+	acceptErr := app.AcceptOrder(submitted.ID)
+	acceptAgainErr := app.AcceptOrder(submitted.ID)
+	readyTableErr := app.MarkOrderReady(submitted.ID)
+	readyErr := app.MarkOrderReady(pickup.ID)
+	reassignErr := app.ReassignOrderTable(submitted.ID, "12")
+	rejectErr := app.RejectActiveOrder(unwanted.ID)
+	rejectCompleteErr := app.RejectActiveOrder(pickup.ID)
+
+	// assert
+	assert.NoError(t, acceptErr)
+	assert.IsType(t, core.OrderActionError{}, errors.Cause(acceptAgainErr))
+	assert.IsType(t, core.OrderActionError{}, errors.Cause(readyTableErr))
+	assert.NoError(t, readyErr)
+	assert.NoError(t, reassignErr)
+	assert.NoError(t, rejectErr)
+	assert.IsType(t, core.OrderActionError{}, errors.Cause(rejectCompleteErr))
+
+	accepted, _ := app.FindOrderByID(submitted.ID)
+	assert.Equal(t, core.OrderStatusAccepted, accepted.Status)
+	assert.Equal(t, "12", accepted.TableName)
+	ready, _ := app.FindOrderByID(pickup.ID)
+	assert.Equal(t, core.OrderStatusComplete, ready.Status)
+	rejected, _ := app.FindOrderByID(unwanted.ID)
+	assert.Equal(t, core.OrderStatusRejected, rejected.Status)
+}
+
+func TestAcceptOrderNeedsKountaSupport(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	submitted := insertKountaOrder(t, app, 801, core.OrderStatusSubmitted, "7", "")
+
+	// act
+	err := app.AcceptOrder(submitted.ID)
+
+	// assert
+	assert.Error(t, err)
+	order, _ := app.FindOrderByID(submitted.ID)
+	assert.Equal(t, core.OrderStatusSubmitted, order.Status)
+}
+
+// helpers
+
+// acceptingKounta accepts the orders it has
+type acceptingKounta struct {
+	*pos.MockKounta
+}
+
+func (k *acceptingKounta) AcceptOrder(orderID core.KountaID) (core.KountaOrder, error) {
+	for i := range k.Orders {
+		if k.Orders[i].PosID == orderID {
+			k.Orders[i].Status = core.OrderStatusAccepted
+			return &k.Orders[i], nil
+		}
+	}
+	return nil, errors.New("order not found")
+}
+
+func insertKountaOrder(t *testing.T, app core.AppContext, posID core.KountaID, status, tableName, pagerNumber string) *core.Order {
+	posOrder := pos.NewMockKountaOrder()
+	posOrder.PosID = posID
+	posOrder.Status = status
+	posOrder.Table = tableName
+	posOrder.PagerNumber = pagerNumber
+	order, err := app.CreateOrUpdateOrderFromKounta(posOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mockKounta, ok := app.Kounta.(*pos.MockKounta); ok {
+		mockKounta.Orders = append(mockKounta.Orders, *posOrder)
+	}
+	return order
+}
+
+func activeOrderIDs(activeOrders *[]core.ActiveOrder) []core.DatabaseID {
+	ids := []core.DatabaseID{}
+	for _, activeOrder := range *activeOrders {
+		ids = append(ids, activeOrder.Order.ID)
+	}
+	return ids
+}
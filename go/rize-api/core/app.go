@@ -1,11 +1,18 @@
 package core
 
 type AppContext struct {
-	DB            DB
-	Kounta        Kounta
-	Cayan         Cayan
-	Stripe        Stripe
-	CardConnect   CardConnect
-	Mailer        Mailer
-	SiteWhitelist []int64
+	DB          DB
+	Kounta      Kounta
+	Cayan       Cayan
+	Stripe      Stripe
+	CardConnect CardConnect
+	Mailer      Mailer
+	SMS         SMSProvider
+	// OrderEvents publishes order changes to guests and staff following them, nil when nobody can follow orders
+	OrderEvents OrderEventBroker
+	// TokenSigningKey is the secret used to sign customer access tokens
+	TokenSigningKey []byte
+	// IdentityProviders verify social logins, keyed by service
+	IdentityProviders map[string]IdentityProvider
+	SiteWhitelist     []int64
 }
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// These name the tokens issued to customers
+const (
+	TokenServiceRize        = "rize"
+	TokenNameForgotPassword = "forgot_password"
+)
+
+const (
+	// passwordCost is the bcrypt cost new hashes are created with. Hashes with a lower cost are upgraded on login.
+	passwordCost      = 12
+	minPasswordLength = 8
+
+	passwordResetDuration = time.Hour
+
+	// After maxLoginFailures failed logins within lockoutDuration of each other the email is locked for lockoutDuration
+	maxLoginFailures = 5
+	lockoutDuration  = 15 * time.Minute
+)
+
+// AuthError is returned when a customer can not be signed up or logged in, Reason is safe to show the customer
+type AuthError struct {
+	Reason string
+}
+
+func (e AuthError) Error() string {
+	return e.Reason
+}
+
+// LockoutError is returned when logging in to an email that has had too many failed login attempts
+type LockoutError struct {
+	Until time.Time
+}
+
+func (e LockoutError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, try again after %s", e.Until.Format(time.RFC3339))
+}
+
+// LoginFailures tracks the failed login attempts for an email
+type LoginFailures struct {
+	Email         string     `json:"email"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+// SignUp will create a customer with a password and start a session for them on device
+func (app AppContext) SignUp(customer *Customer, password, device string) (*SessionTokens, error) {
+	customer.Email = strings.TrimSpace(customer.Email)
+	if customer.Email == "" {
+		return nil, AuthError{Reason: "an email is required"}
+	}
+	if len(password) < minPasswordLength {
+		return nil, AuthError{Reason: fmt.Sprintf("passwords must be at least %d characters", minPasswordLength)}
+	}
+
+	existingCustomer, err := app.DB.GetCustomerByEmail(customer.Email)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign up")
+	}
+	if existingCustomer != nil {
+		return nil, AuthError{Reason: "an account already exists for this email"}
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign up")
+	}
+	customer.Password = hash
+
+	if err := app.AddCustomer(customer); err != nil {
+		return nil, errors.Wrap(err, "sign up")
+	}
+
+	tokens, err := app.StartSession(customer.ID, device)
+	if err != nil {
+		return nil, errors.Wrap(err, "sign up")
+	}
+	return tokens, nil
+}
+
+// Login will check a customer's credential and start a session on device. Wrong credentials return an AuthError and
+// count towards locking the email, which returns a LockoutError until the lockout expires.
+func (app AppContext) Login(credential Credential, device string) (*SessionTokens, error) {
+	email := strings.TrimSpace(credential.Email)
+	now := time.Now()
+
+	failures, err := app.DB.GetLoginFailures(email)
+	if err != nil {
+		return nil, errors.Wrap(err, "login")
+	}
+	if failures != nil && failures.LockedUntil != nil && now.Before(*failures.LockedUntil) {
+		return nil, LockoutError{Until: *failures.LockedUntil}
+	}
+
+	customer, err := app.DB.GetCustomerByEmail(email)
+	if err != nil {
+		return nil, errors.Wrap(err, "login")
+	}
+
+	// Customers who only sign in through a connection have no password. A dummy hash is checked instead, so that the
+	// response takes as long whether or not the account exists.
+	hash := dummyPasswordHash()
+	if customer != nil && customer.Password != "" {
+		hash = []byte(customer.Password)
+	}
+	passwordErr := bcrypt.CompareHashAndPassword(hash, []byte(credential.Password))
+	if customer == nil || customer.Password == "" || passwordErr != nil {
+		if err := app.recordLoginFailure(email, now); err != nil {
+			return nil, errors.Wrap(err, "login")
+		}
+		return nil, AuthError{Reason: "invalid email or password"}
+	}
+
+	if failures != nil {
+		if err := app.DB.DeleteLoginFailures(email); err != nil {
+			return nil, errors.Wrap(err, "login")
+		}
+	}
+
+	if cost, err := bcrypt.Cost([]byte(customer.Password)); err == nil && cost < passwordCost {
+		hash, err := hashPassword(credential.Password)
+		if err != nil {
+			return nil, errors.Wrap(err, "login")
+		}
+		if _, err := app.DB.UpdateCustomerPassword(customer.ID, hash); err != nil {
+			return nil, errors.Wrap(err, "login")
+		}
+	}
+
+	tokens, err := app.StartSession(customer.ID, device)
+	if err != nil {
+		return nil, errors.Wrap(err, "login")
+	}
+	return tokens, nil
+}
+
+// Logout will revoke the session a refresh token belongs to. Logging out of a session that does not exist is not an
+// error.
+func (app AppContext) Logout(refreshToken string) error {
+	token, err := app.DB.GetRefreshTokenByHash(hashRefreshToken(refreshToken))
+	if err != nil {
+		return errors.Wrap(err, "logout")
+	}
+	if token == nil {
+		return nil
+	}
+
+	if err := app.DB.RevokeSession(token.SessionID, time.Now()); err != nil {
+		return errors.Wrap(err, "logout")
+	}
+	return nil
+}
+
+// RequestPasswordReset will email a customer a token to reset their password with. Nothing is sent, and no error is
+// returned, for an email without an account so that accounts can not be discovered.
+func (app AppContext) RequestPasswordReset(email string) error {
+	if app.Mailer == nil {
+		return errors.New("request password reset: no mailer configured")
+	}
+
+	customer, err := app.DB.GetCustomerByEmail(strings.TrimSpace(email))
+	if err != nil {
+		return errors.Wrap(err, "request password reset")
+	}
+	if customer == nil {
+		return nil
+	}
+
+	token, err := app.CreateTokenForCustomerWithID(TokenServiceRize, TokenNameForgotPassword, customer.ID, time.Now().Add(passwordResetDuration))
+	if err != nil {
+		return errors.Wrap(err, "request password reset")
+	}
+
+	tokenEmail := TokenEmail{Token: token.Token, Expiry: "in 1 hour"}
+	if err := app.Mailer.SendEmail(TokenNameForgotPassword, customer.Email, "Reset your password", tokenEmail); err != nil {
+		return errors.Wrap(err, "request password reset")
+	}
+	return nil
+}
+
+// ResetPassword will set a new password using a token from RequestPasswordReset. Every other token and session for
+// the customer is ended, signing them out everywhere, and a new session is started on device.
+func (app AppContext) ResetPassword(update PasswordUpdate, device string) (*SessionTokens, error) {
+	token, err := app.DB.GetToken(update.Token)
+	if err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+	if token == nil || token.Name != TokenNameForgotPassword || time.Now().After(token.Expiry) {
+		return nil, AuthError{Reason: "this password reset link is invalid or has expired"}
+	}
+	if len(update.Password) < minPasswordLength {
+		return nil, AuthError{Reason: fmt.Sprintf("passwords must be at least %d characters", minPasswordLength)}
+	}
+
+	hash, err := hashPassword(update.Password)
+	if err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+
+	customer, err := app.DB.UpdateCustomerPassword(token.CustomerID, hash)
+	if err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+	if customer == nil {
+		return nil, errors.Errorf("reset password: customer %d not found", token.CustomerID)
+	}
+
+	if err := app.DB.DeleteLoginFailures(customer.Email); err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+
+	if err := app.DB.DeleteTokens(customer.ID); err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+	if err := app.DB.RevokeSessionsByCustomerID(customer.ID, time.Now()); err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+
+	tokens, err := app.StartSession(customer.ID, device)
+	if err != nil {
+		return nil, errors.Wrap(err, "reset password")
+	}
+	return tokens, nil
+}
+
+// recordLoginFailure counts a failed login, starting the count over when the last failure is older than the lockout
+func (app AppContext) recordLoginFailure(email string, now time.Time) error {
+	failures, err := app.DB.IncrementLoginFailures(email, now, now.Add(-lockoutDuration))
+	if err != nil {
+		return err
+	}
+	if failures.Failures < maxLoginFailures {
+		return nil
+	}
+	return app.DB.UpdateLoginFailuresLockedUntil(email, now.Add(lockoutDuration))
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash is a hash at passwordCost that no password is checked against successfully
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a customer's password"), passwordCost)
+	})
+	return dummyHash
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	return string(hash), err
+}
@@ -0,0 +1,152 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"notify"
+)
+
+func TestSignUpCreatesCustomerAndToken(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := &core.Customer{FirstName: "Jane", Email: " jane@doe.com "}
+
+	// act
+	tokens, err := app.SignUp(customer, "correct horse", "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	claims, err := app.VerifyAccessToken(tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+
+	savedCustomer, _ := app.DB.GetCustomerByEmail("jane@doe.com")
+	assert.NotNil(t, savedCustomer)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(savedCustomer.Password), []byte("correct horse")))
+}
+
+func TestSignUpRejectsExistingEmail(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createTestCustomer(app)
+
+	// act
+	_, err := app.SignUp(&core.Customer{Email: "bob@smith.com"}, "correct horse", "iPhone")
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestLoginRehashesWeakPassword(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+
+	// act
+	tokens, err := app.Login(core.Credential{Email: "bob@smith.com", Password: "testCustomerPassword"}, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+
+	savedCustomer, _ := app.DB.GetCustomer(customer.ID)
+	cost, _ := bcrypt.Cost([]byte(savedCustomer.Password))
+	assert.True(t, cost > bcrypt.DefaultCost)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(savedCustomer.Password), []byte("testCustomerPassword")))
+}
+
+func TestLoginLocksOutAfterRepeatedFailures(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createTestCustomer(app)
+
+	for i := 0; i < 5; i++ {
+		_, err := app.Login(core.Credential{Email: "bob@smith.com", Password: "wrong"}, "iPhone")
+		assert.IsType(t, core.AuthError{}, errors.Cause(err))
+	}
+
+	// act
+	_, err := app.Login(core.Credential{Email: "bob@smith.com", Password: "testCustomerPassword"}, "iPhone")
+
+	// assert
+	assert.IsType(t, core.LockoutError{}, errors.Cause(err))
+}
+
+func TestLoginFailuresStartOverAfterLockoutDuration(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createTestCustomer(app)
+	for i := 0; i < 4; i++ {
+		app.DB.IncrementLoginFailures("bob@smith.com", time.Now().Add(-time.Hour), time.Now().Add(-2*time.Hour))
+	}
+
+	// act
+	_, err := app.Login(core.Credential{Email: "nobody@smith.com", Password: "wrong"}, "iPhone")
+	_, bobErr := app.Login(core.Credential{Email: "bob@smith.com", Password: "wrong"}, "iPhone")
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+	assert.IsType(t, core.AuthError{}, errors.Cause(bobErr))
+	failures, _ := app.DB.GetLoginFailures("bob@smith.com")
+	assert.Equal(t, 1, failures.Failures)
+	assert.Nil(t, failures.LockedUntil)
+	unknownFailures, _ := app.DB.GetLoginFailures("nobody@smith.com")
+	assert.Equal(t, 1, unknownFailures.Failures)
+}
+
+func TestLogoutEndsSession(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	tokens, _ := app.Login(core.Credential{Email: "bob@smith.com", Password: "testCustomerPassword"}, "iPhone")
+
+	// act
+	err := app.Logout(tokens.RefreshToken)
+
+	// assert
+	assert.NoError(t, err)
+	sessions, _ := app.ListSessions(customer.ID)
+	assert.Equal(t, 0, len(*sessions))
+	_, err = app.RefreshSession(tokens.RefreshToken)
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestResetPasswordSignsOutOtherSessions(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	mailer := &notify.FakeMailer{}
+	app.Mailer = mailer
+	customer := createTestCustomer(app)
+	oldTokens, _ := app.Login(core.Credential{Email: "bob@smith.com", Password: "testCustomerPassword"}, "iPhone")
+
+	assert.NoError(t, app.RequestPasswordReset("bob@smith.com"))
+	resetToken := mailer.Sent[0].Data.(core.TokenEmail).Token
+
+	// act
+	newTokens, err := app.ResetPassword(core.PasswordUpdate{Token: resetToken, Password: "new password"}, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	_, err = app.RefreshSession(oldTokens.RefreshToken)
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+
+	sessions, _ := app.ListSessions(customer.ID)
+	assert.Equal(t, 1, len(*sessions))
+	assert.Equal(t, newTokens.SessionID, (*sessions)[0].ID)
+
+	_, err = app.Login(core.Credential{Email: "bob@smith.com", Password: "new password"}, "iPhone")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	BeaconTelemetryClient = "client"
+	BeaconTelemetryGimbal = "gimbal"
+
+	BeaconProblemLowBattery = "low_battery"
+	BeaconProblemStale      = "stale"
+	BeaconProblemNeverSeen  = "never_seen"
+)
+
+const (
+	// lowBatteryMillivolts is when a beacon's batteries should be swapped, it stops advertising reliably not long after
+	lowBatteryMillivolts = 2500
+	// staleBeaconAge is how long a beacon can go unseen before it's probably dead or missing, sites are closed overnight
+	staleBeaconAge = 36 * time.Hour
+)
+
+// BeaconTelemetry is the latest Eddystone TLM frame, or Gimbal status, received for a beacon
+type BeaconTelemetry struct {
+	BeaconID string `json:"beacon_id"`
+	// BatteryMillivolts is 0 when the beacon doesn't report its battery, like when it's plugged in
+	BatteryMillivolts int `json:"battery_millivolts"`
+	// Temperature is in celsius, nil when the beacon doesn't report it
+	Temperature *float64  `json:"temperature"`
+	Source      string    `json:"source"`
+	ReportedAt  time.Time `json:"reported_at"`
+}
+
+// BeaconHealthReport lists a site's mapped beacons that need attention
+type BeaconHealthReport struct {
+	SiteID    KountaID       `json:"site_id"`
+	Healthy   int            `json:"healthy"`
+	Unhealthy []BeaconHealth `json:"unhealthy"`
+}
+
+// BeaconHealth is a beacon's last telemetry and the table it's on. LastSeen is nil if nothing was ever received.
+type BeaconHealth struct {
+	BeaconID          string     `json:"beacon_id"`
+	TableName         string     `json:"table_name"`
+	BatteryMillivolts int        `json:"battery_millivolts"`
+	Temperature       *float64   `json:"temperature"`
+	LastSeen          *time.Time `json:"last_seen"`
+	Problems          []string   `json:"problems"`
+}
+
+// RecordBeaconTelemetry will save telemetry reported by guests' phones or Gimbal. Telemetry older than what is
+// already saved for a beacon is ignored, so reports can arrive in any order, and a report without a battery keeps the
+// battery last reported. Phones' clocks can't be trusted, so their telemetry is recorded as reported now, and Gimbal's
+// can't be reported later than now.
+func (app AppContext) RecordBeaconTelemetry(telemetry []BeaconTelemetry) error {
+	now := time.Now()
+	for _, t := range telemetry {
+		t.BeaconID = normalizeBeaconID(t.BeaconID)
+		if t.Source != BeaconTelemetryClient && t.Source != BeaconTelemetryGimbal {
+			return errors.Wrap(TableMapError{Reason: "unknown telemetry source " + t.Source}, "record beacon telemetry")
+		}
+		if t.Source == BeaconTelemetryClient || t.ReportedAt.After(now) {
+			t.ReportedAt = now
+		}
+		if t.BeaconID == "" || t.ReportedAt.IsZero() {
+			return errors.Wrap(TableMapError{Reason: "telemetry needs a beacon ID and when it was reported"}, "record beacon telemetry")
+		}
+		if t.BatteryMillivolts < 0 {
+			t.BatteryMillivolts = 0
+		}
+
+		if err := app.DB.UpsertBeaconTelemetry(&t); err != nil {
+			return errors.Wrapf(err, "record beacon telemetry for %s", t.BeaconID)
+		}
+	}
+	return nil
+}
+
+// GetBeaconHealthReport will return the beacons mapped to tables at a site that have a low battery or haven't been
+// seen recently, ordered by table name
+func (app AppContext) GetBeaconHealthReport(siteID KountaID, now time.Time) (*BeaconHealthReport, error) {
+	tableMaps, err := app.DB.SelectTableMapsBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get beacon health report for site %d", siteID)
+	}
+	telemetry, err := app.DB.SelectBeaconTelemetryBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get beacon health report for site %d", siteID)
+	}
+
+	latest := map[string]BeaconTelemetry{}
+	for _, t := range *telemetry {
+		latest[t.BeaconID] = t
+	}
+
+	report := BeaconHealthReport{SiteID: siteID, Unhealthy: []BeaconHealth{}}
+	for _, tableMap := range *tableMaps {
+		health := BeaconHealth{BeaconID: tableMap.BeaconID, TableName: tableMap.TableName, Problems: []string{}}
+		if t, ok := latest[tableMap.BeaconID]; ok {
+			reportedAt := t.ReportedAt
+			health.LastSeen = &reportedAt
+			health.BatteryMillivolts = t.BatteryMillivolts
+			health.Temperature = t.Temperature
+		}
+
+		switch {
+		case health.LastSeen == nil:
+			health.Problems = append(health.Problems, BeaconProblemNeverSeen)
+		case now.Sub(*health.LastSeen) > staleBeaconAge:
+			health.Problems = append(health.Problems, BeaconProblemStale)
+		}
+		if health.BatteryMillivolts > 0 && health.BatteryMillivolts < lowBatteryMillivolts {
+			health.Problems = append(health.Problems, BeaconProblemLowBattery)
+		}
+
+		if len(health.Problems) == 0 {
+			report.Healthy++
+			continue
+		}
+		report.Unhealthy = append(report.Unhealthy, health)
+	}
+	return &report, nil
+}
@@ -0,0 +1,93 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetBeaconHealthReport(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	now := time.Now()
+	for beaconID, tableName := range map[string]string{"ff0000000001": "1", "ff0000000002": "2", "ff0000000003": "3", "ff0000000004": "4"} {
+		app.AddTableMap(&core.TableMap{BeaconID: beaconID, SiteID: core.TestSitePosID, TableName: tableName})
+	}
+	temperature := 21.5
+
+	app.RecordBeaconTelemetry([]core.BeaconTelemetry{
+		{BeaconID: "ff0000000001", BatteryMillivolts: 3000, Temperature: &temperature, Source: core.BeaconTelemetryClient, ReportedAt: now.Add(-time.Hour)},
+		{BeaconID: "FF0000000002", BatteryMillivolts: 2300, Source: core.BeaconTelemetryGimbal, ReportedAt: now.Add(-time.Hour)},
+		{BeaconID: "ff0000000003", BatteryMillivolts: 3000, Source: core.BeaconTelemetryGimbal, ReportedAt: now.Add(-72 * time.Hour)},
+	})
+
+	// act
+	report, err := app.GetBeaconHealthReport(core.TestSitePosID, now.Add(time.Minute))
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Healthy)
+	assert.Equal(t, 3, len(report.Unhealthy))
+	assert.Equal(t, "2", report.Unhealthy[0].TableName)
+	assert.Equal(t, []string{core.BeaconProblemLowBattery}, report.Unhealthy[0].Problems)
+	assert.Equal(t, "3", report.Unhealthy[1].TableName)
+	assert.Equal(t, []string{core.BeaconProblemStale}, report.Unhealthy[1].Problems)
+	assert.Equal(t, "4", report.Unhealthy[2].TableName)
+	assert.Equal(t, []string{core.BeaconProblemNeverSeen}, report.Unhealthy[2].Problems)
+	assert.Nil(t, report.Unhealthy[2].LastSeen)
+}
+
+func TestRecordBeaconTelemetryKeepsNewest(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	now := time.Now()
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000001", SiteID: core.TestSitePosID, TableName: "1"})
+	app.RecordBeaconTelemetry([]core.BeaconTelemetry{{BeaconID: "ff0000000001", BatteryMillivolts: 2900, Source: core.BeaconTelemetryClient, ReportedAt: now}})
+
+	// act
+	err := app.RecordBeaconTelemetry([]core.BeaconTelemetry{{BeaconID: "ff0000000001", BatteryMillivolts: 3100, Source: core.BeaconTelemetryGimbal, ReportedAt: now.Add(-time.Hour)}})
+
+	// assert
+	assert.NoError(t, err)
+	telemetry, _ := app.DB.SelectBeaconTelemetryBySiteID(core.TestSitePosID)
+	assert.Equal(t, 1, len(*telemetry))
+	assert.Equal(t, 2900, (*telemetry)[0].BatteryMillivolts)
+
+	// a Gimbal status without a battery doesn't lose the battery from the last TLM frame
+	app.RecordBeaconTelemetry([]core.BeaconTelemetry{{BeaconID: "ff0000000001", Source: core.BeaconTelemetryGimbal, ReportedAt: now.Add(time.Minute)}})
+	telemetry, _ = app.DB.SelectBeaconTelemetryBySiteID(core.TestSitePosID)
+	assert.Equal(t, 2900, (*telemetry)[0].BatteryMillivolts)
+	assert.Equal(t, core.BeaconTelemetryGimbal, (*telemetry)[0].Source)
+
+	badSourceErr := app.RecordBeaconTelemetry([]core.BeaconTelemetry{{BeaconID: "ff0000000001", Source: "phone", ReportedAt: now}})
+	assert.Error(t, badSourceErr)
+}
+
+func TestRecordBeaconTelemetryUsesServerTime(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000001", SiteID: core.TestSitePosID, TableName: "1"})
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000002", SiteID: core.TestSitePosID, TableName: "2"})
+	before := time.Now().Add(-time.Second) // postgres drops nanoseconds
+
+	// act
+	err := app.RecordBeaconTelemetry([]core.BeaconTelemetry{
+		{BeaconID: "ff0000000001", BatteryMillivolts: 3000, Source: core.BeaconTelemetryClient, ReportedAt: before.Add(-72 * time.Hour)},
+		{BeaconID: "ff0000000002", BatteryMillivolts: 3000, Source: core.BeaconTelemetryGimbal, ReportedAt: before.Add(24 * time.Hour)},
+	})
+
+	// assert
+	assert.NoError(t, err)
+	after := time.Now()
+	telemetry, _ := app.DB.SelectBeaconTelemetryBySiteID(core.TestSitePosID)
+	assert.Equal(t, 2, len(*telemetry))
+	for _, reported := range *telemetry {
+		assert.False(t, reported.ReportedAt.Before(before))
+		assert.False(t, reported.ReportedAt.After(after))
+	}
+}
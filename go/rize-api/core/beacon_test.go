@@ -0,0 +1,181 @@
+package core_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupNewBeaconConfigurations(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	gimbal := newFakeGimbal(core.Beacon{FactoryID: "A1", Name: "123_01"}, core.Beacon{FactoryID: "A2", Name: "123_02"})
+	server := httptest.NewServer(gimbal)
+	defer server.Close()
+	api := core.Gimbal{BaseURL: server.URL, APIToken: "secret"}
+
+	// act
+	plan, err := api.SetupNewBeaconConfigurations(app.DB, core.TestSitePosID, 1, -4, 2)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, "Token token=secret", gimbal.authorization)
+	assert.Equal(t, 2, len(plan.Configurations))
+	assert.Equal(t, "123_01_v1", plan.Configurations[0].Name)
+	assert.Equal(t, 2, len(gimbal.configs))
+	assert.Equal(t, plan.Configurations[0].ID, gimbal.beacons["A1"].ConfigID)
+	assert.Equal(t, plan.Configurations[1].ID, gimbal.beacons["A2"].ConfigID)
+
+	tableMap, _ := app.DB.GetTableMapByBeaconID("ff000000000000000000000001230001")
+	assert.Equal(t, "1", tableMap.TableName)
+}
+
+func TestSetupNewBeaconConfigurationsDryRun(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	gimbal := newFakeGimbal(core.Beacon{FactoryID: "A1", Name: "123_01"})
+	server := httptest.NewServer(gimbal)
+	defer server.Close()
+	api := core.Gimbal{BaseURL: server.URL, DryRun: true}
+
+	// act
+	plan, err := api.SetupNewBeaconConfigurations(app.DB, core.TestSitePosID, 1, -4, 1)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plan.Configurations))
+	assert.Equal(t, []core.BeaconAssignment{{FactoryID: "A1", BeaconName: "123_01", ConfigName: "123_01_v1"}}, plan.Assignments)
+	assert.Equal(t, 1, len(plan.TableMaps))
+	assert.Equal(t, []string{"GET", "GET"}, gimbal.methods)
+
+	tableMap, _ := app.DB.GetTableMapByBeaconID(plan.TableMaps[0].BeaconID)
+	assert.Nil(t, tableMap)
+}
+
+func TestSetupNewBeaconConfigurationsRollsBack(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	gimbal := newFakeGimbal(core.Beacon{FactoryID: "A1", Name: "123_01", ConfigID: 7}, core.Beacon{FactoryID: "A2", Name: "123_02", ConfigID: 8})
+	gimbal.failAssigning = "A2"
+	server := httptest.NewServer(gimbal)
+	defer server.Close()
+	api := core.Gimbal{BaseURL: server.URL}
+
+	// act
+	_, err := api.SetupNewBeaconConfigurations(app.DB, core.TestSitePosID, 2, -4, 2)
+
+	// assert
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(gimbal.configs))
+	assert.Equal(t, 7, gimbal.beacons["A1"].ConfigID)
+	assert.Equal(t, 8, gimbal.beacons["A2"].ConfigID)
+
+	tableMap, _ := app.DB.GetTableMapByBeaconID("ff000000000000000000000001230001")
+	assert.Nil(t, tableMap)
+}
+
+func TestRotateBeaconConfigurationsRetiresOldVersions(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	gimbal := newFakeGimbal(core.Beacon{FactoryID: "A1", Name: "123_01"}, core.Beacon{FactoryID: "B1", Name: "999_01"})
+	server := httptest.NewServer(gimbal)
+	defer server.Close()
+	api := core.Gimbal{BaseURL: server.URL}
+
+	api.SetupNewBeaconConfigurations(app.DB, core.TestSitePosID, 1, -4, 1)
+	api.SetupNewBeaconConfigurations(app.DB, 999, 1, -4, 1)
+
+	// act
+	plan, err := api.RotateBeaconConfigurations(app.DB, core.TestSitePosID, 2, -8, 1)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plan.Retired))
+	assert.Equal(t, "123_01_v1", plan.Retired[0].Name)
+	// the table mapping from v1 is kept
+	assert.Equal(t, 0, len(plan.TableMaps))
+	assert.Equal(t, []string{"123_01_v2", "999_01_v1"}, gimbal.configNames())
+	assert.Equal(t, plan.Configurations[0].ID, gimbal.beacons["A1"].ConfigID)
+}
+
+// helpers
+
+// fakeGimbal is enough of the Gimbal Manager API to create, assign and delete configurations
+type fakeGimbal struct {
+	configs       map[int]core.BeaconConfiguration
+	beacons       map[string]core.Beacon
+	nextID        int
+	failAssigning string
+	authorization string
+	methods       []string
+}
+
+func newFakeGimbal(beacons ...core.Beacon) *fakeGimbal {
+	gimbal := fakeGimbal{configs: map[int]core.BeaconConfiguration{}, beacons: map[string]core.Beacon{}, nextID: 100}
+	for _, beacon := range beacons {
+		gimbal.beacons[beacon.FactoryID] = beacon
+	}
+	return &gimbal
+}
+
+func (g *fakeGimbal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.authorization = r.Header.Get("Authorization")
+	g.methods = append(g.methods, r.Method)
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/beacon_configurations":
+		configs := []core.BeaconConfiguration{}
+		for _, config := range g.configs {
+			configs = append(configs, config)
+		}
+		json.NewEncoder(w).Encode(configs)
+	case r.Method == "GET" && r.URL.Path == "/beacons":
+		beacons := []core.Beacon{}
+		for _, beacon := range g.beacons {
+			beacons = append(beacons, beacon)
+		}
+		json.NewEncoder(w).Encode(beacons)
+	case r.Method == "POST" && r.URL.Path == "/beacon_configurations":
+		config := core.BeaconConfiguration{}
+		json.NewDecoder(r.Body).Decode(&config)
+		g.nextID++
+		config.ID = g.nextID
+		g.configs[config.ID] = config
+		json.NewEncoder(w).Encode(config)
+	case r.Method == "PUT" && strings.HasPrefix(r.URL.Path, "/beacons/"):
+		beacon := core.Beacon{}
+		json.NewDecoder(r.Body).Decode(&beacon)
+		if beacon.FactoryID == g.failAssigning && beacon.ConfigID > 100 {
+			http.Error(w, "beacon is offline", http.StatusUnprocessableEntity)
+			return
+		}
+		g.beacons[beacon.FactoryID] = beacon
+		json.NewEncoder(w).Encode(beacon)
+	case r.Method == "DELETE" && strings.HasPrefix(r.URL.Path, "/beacon_configurations/"):
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/beacon_configurations/"))
+		delete(g.configs, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (g *fakeGimbal) configNames() []string {
+	names := []string{}
+	for _, config := range g.configs {
+		names = append(names, config.Name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -16,6 +16,8 @@ type Category struct {
 	InstoreOnly  bool       `json:"instore_only"`
 }
 
+// GetCategoriesForSite will return every category for a site with its menu items, modifiers and option sets attached.
+// The whole menu is loaded in a fixed number of queries regardless of how many categories or items the site has.
 func (app AppContext) GetCategoriesForSite(siteID KountaID) ([]Category, error) {
 	categories, err := app.DB.SelectCategoriesBySiteID(siteID)
 	if err != nil {
@@ -25,19 +27,37 @@ func (app AppContext) GetCategoriesForSite(siteID KountaID) ([]Category, error)
 		return []Category{}, nil
 	}
 
+	menuItems, err := app.DB.SelectMenuItemsBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get categories for site")
+	}
+
+	modifiers, err := app.DB.SelectMenuItemModifiersBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get categories for site")
+	}
+
+	optionSets, err := app.DB.SelectOptionSetsBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get categories for site")
+	}
+
+	menuItemsByCategoryID := map[DatabaseID][]MenuItem{}
+	for _, menuItem := range *menuItems {
+		menuItem.SitePosID = siteID
+		menuItem.Modifiers = modifiers[menuItem.ID]
+		menuItem.OptionSets = optionSets[menuItem.ID]
+		menuItemsByCategoryID[menuItem.CategoryID] = append(menuItemsByCategoryID[menuItem.CategoryID], menuItem)
+	}
+
 	for i, category := range *categories {
 		(*categories)[i].SitePosID = siteID
 
-		menuItems, err := app.DB.SelectMenuItemsByCategoryID(siteID, category.ID)
-		if err != nil {
-			return nil, errors.Wrap(err, "get categories for site")
-		}
-
-		for i := range *menuItems {
-			(*menuItems)[i].SitePosID = siteID
+		categoryItems := menuItemsByCategoryID[category.ID]
+		if categoryItems == nil {
+			categoryItems = []MenuItem{}
 		}
-
-		(*categories)[i].MenuItems = *menuItems
+		(*categories)[i].MenuItems = categoryItems
 	}
 	return *categories, nil
 }
@@ -29,3 +29,57 @@ func TestGetCategoriesForSite(t *testing.T) {
 	assert.Equal(t, "Test Menu Item 1", menuItems1[0].Name)
 	assert.Equal(t, "Test Menu Item 2", menuItems1[1].Name)
 }
+
+func TestGetCategoriesForSiteLoadsModifiersAndOptionSets(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+
+	// act
+	categories, err := app.GetCategoriesForSite(core.TestSitePosID)
+
+	// assert
+	assert.NoError(t, err)
+	menuItem1 := categories[0].MenuItems[0]
+	assert.Equal(t, 1, len(menuItem1.Modifiers))
+	assert.Equal(t, "Test Modifier 1", menuItem1.Modifiers[0].Name)
+
+	menuItem3 := categories[1].MenuItems[0]
+	assert.Equal(t, "Test Menu Item 3", menuItem3.Name)
+	assert.Equal(t, 1, len(menuItem3.OptionSets))
+	assert.Equal(t, 2, len(menuItem3.OptionSets[0].Options))
+}
+
+// BenchmarkGetCategoriesPerCategory measures the previous approach of one menu item query per category
+func BenchmarkGetCategoriesPerCategory(b *testing.B) {
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(b)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		categories, err := app.DB.SelectCategoriesBySiteID(core.TestSitePosID)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for _, category := range *categories {
+			if _, err := app.DB.SelectMenuItemsByCategoryID(core.TestSitePosID, category.ID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkGetCategoriesForSite(b *testing.B) {
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(b)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := app.GetCategoriesForSite(core.TestSitePosID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// checkPaymentLease is how long a check's orders stay locked if paying it never finishes
+const checkPaymentLease = 5 * time.Minute
+
+// Check is one bill for every payable order at a table and on a pager, so a group pays for all their orders at once
+type Check struct {
+	SiteID      KountaID `json:"site_id"`
+	TableName   string   `json:"table_name"`
+	PagerNumber int64    `json:"puck_id"`
+	Orders      []Order  `json:"orders"`
+	Total       int      `json:"total"`
+	TotalTax    int      `json:"total_tax"`
+	Paid        int      `json:"paid"` // Paid is what was already paid on the orders, like by gift card
+	Balance     int      `json:"balance"`
+}
+
+// CheckError is returned when a check can't be paid
+type CheckError struct {
+	Reason string
+}
+
+func (e CheckError) Error() string {
+	return e.Reason
+}
+
+// GetCheck will combine the payable orders at a table and on a pager into one check, oldest order first. Either of
+// tableName or pagerNumber can be left empty.
+func (app AppContext) GetCheck(siteID KountaID, tableName string, pagerNumber int64) (*Check, error) {
+	if tableName == "" && pagerNumber == 0 {
+		return nil, errors.Wrap(CheckError{Reason: "a table or pager is required"}, "get check")
+	}
+
+	orders := []Order{}
+	if tableName != "" {
+		tableOrders, err := app.FindPayableOrdersByTableName(siteID, tableName)
+		if err != nil {
+			return nil, errors.Wrap(err, "get check")
+		}
+		orders = app.AppendUniqueOrders(orders, tableOrders)
+	}
+	if pagerNumber != 0 {
+		pagerOrders, err := app.FindPayableOrdersByPagerID(siteID, pagerNumber)
+		if err != nil {
+			return nil, errors.Wrap(err, "get check")
+		}
+		orders = app.AppendUniqueOrders(orders, pagerOrders)
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+
+	payments, err := app.DB.SelectPaymentsByOrderIDs(orderIDs(orders))
+	if err != nil {
+		return nil, errors.Wrap(err, "get check")
+	}
+
+	check := Check{SiteID: siteID, TableName: tableName, PagerNumber: pagerNumber, Orders: orders}
+	for _, order := range orders {
+		check.Total += order.Total
+		check.TotalTax += order.TotalTax
+		check.Paid += amountPaid(payments[order.ID])
+	}
+	check.Balance = check.Total - check.Paid
+	return &check, nil
+}
+
+// PayCheck will charge the whole balance of a check in one transaction and split the payment across its orders, each
+// saved with SavePayment and recorded in Kounta. payment.Amount has to match the balance, so a guest never pays for a
+// check that changed since they saw it. The tip is split in proportion to what each order owes. Every order's payment
+// has the gateway's transaction ID followed by the order ID and the card's last four digits.
+//
+// The check's orders are locked while it is paid, so paying it again at the same time fails with a CheckError rather
+// than charging the card twice.
+//
+// Once the card is charged every order is paid, even if saving or recording one of them fails, and the first error is
+// returned after the rest.
+func (app AppContext) PayCheck(siteID KountaID, tableName string, pagerNumber int64, payment TokenizedPayment) (*[]Payment, error) {
+	check, err := app.GetCheck(siteID, tableName, pagerNumber)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay check")
+	}
+	if check.Balance <= 0 {
+		return nil, errors.Wrap(CheckError{Reason: "there is nothing left to pay on this check"}, "pay check")
+	}
+	if payment.Amount != check.Balance {
+		return nil, errors.Wrap(CheckError{Reason: fmt.Sprintf("the check balance is now %s", Cents(check.Balance))}, "pay check")
+	}
+	if err := app.ValidateTip(siteID, payment.Amount, payment.Tip); err != nil {
+		return nil, errors.Wrap(err, "pay check")
+	}
+
+	ids := orderIDs(check.Orders)
+	locked, err := app.DB.LockOrdersForPayment(ids, time.Now(), checkPaymentLease)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay check")
+	}
+	if !locked {
+		return nil, errors.Wrap(CheckError{Reason: "this check is already being paid"}, "pay check")
+	}
+	defer func() {
+		if err := app.DB.UnlockOrdersForPayment(ids); err != nil {
+			log.Printf("Error unlocking orders %v after paying check: %v", ids, err)
+		}
+	}()
+
+	// the check may have been paid while waiting for the lock
+	payments, err := app.DB.SelectPaymentsByOrderIDs(ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay check")
+	}
+	balance := 0
+	for _, order := range check.Orders {
+		balance += order.Total - amountPaid(payments[order.ID])
+	}
+	if balance != check.Balance {
+		return nil, errors.Wrap(CheckError{Reason: fmt.Sprintf("the check balance is now %s", Cents(balance))}, "pay check")
+	}
+
+	payment.SiteID = siteID
+	payment.OrderID = check.Orders[0].ID
+	var transactionID string
+	switch payment.Gateway {
+	case GatewayStripe:
+		transactionID, err = app.Stripe.MakePayment(payment)
+	case GatewayCardConnect:
+		transactionID, err = app.CardConnect.MakePayment(payment)
+	default:
+		return nil, errors.Wrap(PaymentGatewayError{Reason: "unknown gateway " + payment.Gateway}, "pay check")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pay check")
+	}
+
+	var firstErr error
+	now := time.Now()
+	tipLeft := payment.Tip
+	orderPayments := []Payment{}
+	for i, order := range check.Orders {
+		// payable orders are never fully paid, so each one owes something
+		due := order.Total - amountPaid(payments[order.ID])
+		tip := payment.Tip * due / check.Balance
+		if i == len(check.Orders)-1 {
+			tip = tipLeft
+		}
+		tipLeft -= tip
+
+		orderPayment := Payment{
+			Amount:        due,
+			Tip:           tip,
+			OrderID:       order.ID,
+			TransactionID: fmt.Sprintf("%s-%d", transactionID, order.ID),
+			Date:          now,
+			CardLast4:     payment.CardLast4,
+		}
+		if payment.CustomerID != 0 {
+			orderPayment.CustomerID = sql.NullInt64{Int64: int64(payment.CustomerID), Valid: true}
+		}
+		orderPayments = append(orderPayments, orderPayment)
+
+		if err := app.SavePayment(&orderPayment, &check.Orders[i]); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "pay check: order %d", order.ID)
+		}
+		if err := app.Kounta.RecordPayment(orderPayment, order.PosID); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "pay check: recording payment for order %d in kounta", order.ID)
+		}
+	}
+	return &orderPayments, firstErr
+}
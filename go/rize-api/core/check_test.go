@@ -0,0 +1,141 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+func TestGetCheckCombinesTableAndPagerOrders(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	first := insertKountaOrder(t, app, 801, core.OrderStatusOnHold, "7", "")
+	insertKountaOrder(t, app, 802, core.OrderStatusOnHold, "7", "")
+	insertKountaOrder(t, app, 803, core.OrderStatusOnHold, "", "20")
+	insertKountaOrder(t, app, 804, core.OrderStatusOnHold, "8", "")
+	app.DB.InsertPayment(&core.Payment{Amount: 500, OrderID: first.ID, Date: time.Now(), Method: core.PaymentMethodGiftCard}, first)
+
+	// act
+	check, err := app.GetCheck(core.TestSitePosID, "7", 20)
+	_, noTableErr := app.GetCheck(core.TestSitePosID, "", 0)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(check.Orders))
+	assert.Equal(t, first.ID, check.Orders[0].ID)
+	assert.Equal(t, 4500, check.Total)
+	assert.Equal(t, 360, check.TotalTax)
+	assert.Equal(t, 500, check.Paid)
+	assert.Equal(t, 4000, check.Balance)
+	assert.IsType(t, core.CheckError{}, errors.Cause(noTableErr))
+}
+
+func TestPayCheckSplitsPaymentAcrossOrders(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	cardConnect := &vaultCardConnect{}
+	app.CardConnect = cardConnect
+	first := insertKountaOrder(t, app, 801, core.OrderStatusOnHold, "7", "")
+	insertKountaOrder(t, app, 802, core.OrderStatusOnHold, "7", "")
+	insertKountaOrder(t, app, 803, core.OrderStatusOnHold, "", "20")
+	app.DB.InsertPayment(&core.Payment{Amount: 500, OrderID: first.ID, Date: time.Now(), Method: core.PaymentMethodGiftCard}, first)
+	kounta := &paymentKounta{MockKounta: app.Kounta.(*pos.MockKounta)}
+	app.Kounta = kounta
+
+	// act
+	payments, err := app.PayCheck(core.TestSitePosID, "7", 20, core.TokenizedPayment{Gateway: core.GatewayCardConnect, Token: "token", Amount: 4000, Tip: 400, CardLast4: "4242"})
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cardConnect.payments))
+	assert.Equal(t, 4000, cardConnect.payments[0].Amount)
+
+	assert.Equal(t, 3, len(*payments))
+	assert.Equal(t, []int{1000, 1500, 1500}, []int{(*payments)[0].Amount, (*payments)[1].Amount, (*payments)[2].Amount})
+	assert.Equal(t, []int{100, 150, 150}, []int{(*payments)[0].Tip, (*payments)[1].Tip, (*payments)[2].Tip})
+	assert.Equal(t, "transaction-1-1", (*payments)[0].TransactionID)
+	assert.Equal(t, "4242", (*payments)[2].CardLast4)
+	assert.Equal(t, 3, len(kounta.payments))
+
+	check, _ := app.GetCheck(core.TestSitePosID, "7", 20)
+	assert.Equal(t, 0, len(check.Orders))
+	assert.Equal(t, 0, check.Balance)
+}
+
+func TestPayCheckRejectsChangedBalance(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	cardConnect := &vaultCardConnect{}
+	app.CardConnect = cardConnect
+	insertKountaOrder(t, app, 801, core.OrderStatusOnHold, "7", "")
+	insertKountaOrder(t, app, 802, core.OrderStatusOnHold, "7", "")
+
+	// act
+	_, err := app.PayCheck(core.TestSitePosID, "7", 0, core.TokenizedPayment{Gateway: core.GatewayCardConnect, Token: "token", Amount: 1500})
+
+	// assert
+	assert.IsType(t, core.CheckError{}, errors.Cause(err))
+	assert.Equal(t, 0, len(cardConnect.payments))
+}
+
+func TestPayCheckOnlyChargesOnce(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	cardConnect := &reentrantCardConnect{vaultCardConnect: &vaultCardConnect{}, app: &app}
+	app.CardConnect = cardConnect
+	insertKountaOrder(t, app, 801, core.OrderStatusOnHold, "7", "")
+
+	// act
+	payments, err := app.PayCheck(core.TestSitePosID, "7", 0, core.TokenizedPayment{Gateway: core.GatewayCardConnect, Token: "token", Amount: 1500})
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*payments))
+	assert.IsType(t, core.CheckError{}, errors.Cause(cardConnect.err))
+	assert.Equal(t, 1, len(cardConnect.payments))
+
+	_, againErr := app.PayCheck(core.TestSitePosID, "7", 0, core.TokenizedPayment{Gateway: core.GatewayCardConnect, Token: "token", Amount: 1500})
+	assert.IsType(t, core.CheckError{}, errors.Cause(againErr))
+}
+
+// helpers
+
+// paymentKounta records the payments recorded in Kounta
+type paymentKounta struct {
+	*pos.MockKounta
+	payments []core.Payment
+}
+
+func (k *paymentKounta) RecordPayment(payment core.Payment, posOrderID core.KountaID) error {
+	k.payments = append(k.payments, payment)
+	return nil
+}
+
+// reentrantCardConnect pays the same check again while the first payment is being charged, like a second guest at
+// the table paying at the same time
+type reentrantCardConnect struct {
+	*vaultCardConnect
+	app       *core.AppContext
+	reentered bool
+	err       error
+}
+
+func (c *reentrantCardConnect) MakePayment(p core.TokenizedPayment) (string, error) {
+	if !c.reentered {
+		c.reentered = true
+		_, c.err = c.app.PayCheck(p.SiteID, "7", 0, core.TokenizedPayment{Gateway: p.Gateway, Token: p.Token, Amount: p.Amount})
+	}
+	return c.vaultCardConnect.MakePayment(p)
+}
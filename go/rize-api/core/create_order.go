@@ -4,6 +4,51 @@ package core
 type CreateOrder struct {
 	SiteID    KountaID              `json:"site_id"`
 	MenuItems []CreateOrderMenuItem `json:"menu_items"`
+	RewardID  DatabaseID            `json:"reward_id"` // RewardID is an optional loyalty reward to redeem on the order
+	PromoCode string                `json:"promo_code"`
+	PartySize int                   `json:"party_size"` // PartySize is how many guests the order is for, for auto gratuity
+	// CustomerID is the signed in customer placing the order, it is required to redeem a reward
+	CustomerID DatabaseID      `json:"-"`
+	Discounts  []OrderDiscount `json:"-"`
+	Fees       []OrderFee      `json:"-"`
+}
+
+// DiscountTotal is the total of all discounts on the order in cents
+func (c CreateOrder) DiscountTotal() int {
+	total := 0
+	for _, discount := range c.Discounts {
+		total += discount.Amount
+	}
+	return total
+}
+
+// PriceLines returns the lines added to the Kounta order for its fees and discounts, discounts have a negative amount
+func (c CreateOrder) PriceLines() []PriceLine {
+	lines := feePriceLines(c.Fees)
+	for _, discount := range c.Discounts {
+		lines = append(lines, PriceLine{Name: discount.Name, Amount: -discount.Amount})
+	}
+	return lines
+}
+
+// OrderDiscount is an amount taken off a new order, which is added to the Kounta order as a discount line
+type OrderDiscount struct {
+	Name   string
+	Amount int // Amount in cents, including tax
+}
+
+// PriceLine is a line on a new Kounta order that isn't a menu item
+type PriceLine struct {
+	Name   string
+	Amount int // Amount in cents, including tax
+}
+
+// OrderFee is a site's fee charged on a new order, which is added to the Kounta order as a line
+type OrderFee struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Amount int    `json:"amount"` // Amount in cents, including tax
+	Tax    int    `json:"tax"`
 }
 
 // CreateOrderMenuItem represents a single item on a CreateOrder
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"log"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -37,6 +39,7 @@ type Connection struct {
 	ID                 string    `json:"id"`
 	Name               string    `json:"name"`
 	Email              string    `json:"email"`
+	EmailVerified      bool      `json:"email_verified"`
 	Service            string    `json:"service"`
 	AccessToken        string    `json:"access_token"`
 	AccessTokenExpiry  time.Time `json:"access_token_expiry"`
@@ -65,3 +68,142 @@ func (app AppContext) AddCustomer(c *Customer) error {
 
 	return nil
 }
+
+// KountaCustomerUpdater is implemented by Kounta clients that can change a POS customer record
+type KountaCustomerUpdater interface {
+	UpdateCustomer(customerID KountaID, email, firstName, lastName, phone string) error
+}
+
+// UpdateProfile will save a customer's name, email, phone and image and copy the change to their POS customer record
+func (app AppContext) UpdateProfile(c *Customer) error {
+	c.Email = strings.TrimSpace(c.Email)
+	if c.Email == "" {
+		return AuthError{Reason: "an email is required"}
+	}
+
+	existingCustomer, err := app.DB.GetCustomer(c.ID)
+	if err != nil {
+		return errors.Wrap(err, "update profile")
+	}
+	if existingCustomer == nil {
+		return errors.Errorf("update profile: customer %d not found", c.ID)
+	}
+
+	if c.Email != existingCustomer.Email {
+		emailCustomer, err := app.DB.GetCustomerByEmail(c.Email)
+		if err != nil {
+			return errors.Wrap(err, "update profile")
+		}
+		if emailCustomer != nil {
+			return AuthError{Reason: "an account already exists for this email"}
+		}
+	}
+
+	if err := app.updatePosCustomer(existingCustomer.PosID, c.Email, c.FirstName, c.LastName, c.Phone); err != nil {
+		return errors.Wrap(err, "update profile")
+	}
+
+	if err := app.DB.UpdateCustomerProfile(c); err != nil {
+		return errors.Wrap(err, "update profile")
+	}
+
+	c.Password = existingCustomer.Password
+	c.ExternalID = existingCustomer.ExternalID
+	c.PosID = existingCustomer.PosID
+	return nil
+}
+
+// DeleteAccount will delete a customer along with their saved cards, sessions and linked accounts. Their orders are
+// kept for reporting but no longer identify them, and their POS customer record is anonymized.
+func (app AppContext) DeleteAccount(customerID DatabaseID) error {
+	customer, err := app.DB.GetCustomer(customerID)
+	if err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+	if customer == nil {
+		return errors.Errorf("delete account: customer %d not found", customerID)
+	}
+
+	cards, err := app.DB.SelectSavedCardsByCustomerID(customerID)
+	if err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+
+	// A customer's CardConnect cards share one profile, deleting it removes them all
+	deletedProfiles := map[string]bool{}
+	for _, card := range *cards {
+		if card.Gateway != GatewayCardConnect {
+			continue
+		}
+
+		profileID := strings.SplitN(card.VaultID, "/", 2)[0]
+		if deletedProfiles[profileID] {
+			continue
+		}
+		if err := app.CardConnect.DeleteCreditCard(profileID); err != nil {
+			return errors.Wrap(err, "delete account")
+		}
+		deletedProfiles[profileID] = true
+	}
+
+	if err := app.updatePosCustomer(customer.PosID, "", "Deleted", "Customer", ""); err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+	if err := app.removeContactFromPickupNotes(customerID); err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+
+	if err := app.DB.AnonymizeOrdersByCustomerID(customerID); err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+	if err := app.DB.DeleteLoginFailures(customer.Email); err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+	if err := app.DB.DeleteCustomer(customerID); err != nil {
+		return errors.Wrap(err, "delete account")
+	}
+	return nil
+}
+
+// updatePosCustomer copies a change to a customer's POS record. Kounta clients that can't update customers leave the
+// record as it was, which is logged so the change can be made by hand.
+func (app AppContext) updatePosCustomer(posID KountaID, email, firstName, lastName, phone string) error {
+	if posID == 0 {
+		return nil
+	}
+	updater, ok := app.Kounta.(KountaCustomerUpdater)
+	if !ok {
+		log.Println(errors.Errorf("update pos customer: the kounta client can't update customer %d, it needs to be changed in kounta", posID))
+		return nil
+	}
+	return updater.UpdateCustomer(posID, email, firstName, lastName, phone)
+}
+
+// removeContactFromPickupNotes rewrites the Kounta notes on a customer's pickup orders without their name and phone
+func (app AppContext) removeContactFromPickupNotes(customerID DatabaseID) error {
+	orders, err := app.DB.SelectOrdersByCustomerID(customerID)
+	if err != nil {
+		return err
+	}
+
+	for _, order := range *orders {
+		details, err := app.DB.GetPickupDetails(order.ID)
+		if err != nil {
+			return err
+		}
+		if details == nil {
+			continue
+		}
+
+		pickupTime := ""
+		if order.PickupTime != nil {
+			if pickupTime, err = app.formatPickupTime(order.SiteID, *order.PickupTime); err != nil {
+				return err
+			}
+		}
+		if err := app.Kounta.SetOrderNotes(order.PosID, pickupNotes(pickupTime, "", "")); err != nil {
+			return errors.Wrapf(err, "removing contact details from order %d", order.ID)
+		}
+	}
+	return nil
+}
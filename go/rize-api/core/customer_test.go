@@ -0,0 +1,114 @@
+package core_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+// customerKounta creates POS customer records with ID 99 and records changes to them
+type customerKounta struct {
+	*pos.MockKounta
+	updates []string
+}
+
+type posCustomer core.KountaID
+
+func (c posCustomer) GetPosID() core.KountaID { return core.KountaID(c) }
+
+func (k *customerKounta) GetCustomerByEmail(email string) (core.KountaCustomer, error) {
+	return nil, nil
+}
+
+func (k *customerKounta) CreateCustomer(email, firstName, lastName, phone string, rizeID core.DatabaseID) (core.KountaCustomer, error) {
+	return posCustomer(99), nil
+}
+
+func (k *customerKounta) UpdateCustomer(customerID core.KountaID, email, firstName, lastName, phone string) error {
+	k.updates = append(k.updates, firstName+" "+lastName+" "+email)
+	return nil
+}
+
+func TestUpdateProfile(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &customerKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	customer := createTestCustomer(app)
+
+	// act
+	err := app.UpdateProfile(&core.Customer{ID: customer.ID, FirstName: "Robert", LastName: "Smith", Email: "robert@smith.com", Phone: "4041234567"})
+
+	// assert
+	assert.NoError(t, err)
+	savedCustomer, _ := app.DB.GetCustomer(customer.ID)
+	assert.Equal(t, "Robert", savedCustomer.FirstName)
+	assert.Equal(t, "robert@smith.com", savedCustomer.Email)
+	assert.Equal(t, "4041234567", savedCustomer.Phone)
+	assert.Equal(t, customer.Password, savedCustomer.Password)
+	assert.Equal(t, []string{"Robert Smith robert@smith.com"}, kounta.updates)
+}
+
+func TestUpdateProfileRejectsEmailInUse(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	app.AddCustomer(&core.Customer{Email: "jane@doe.com"})
+
+	// act
+	err := app.UpdateProfile(&core.Customer{ID: customer.ID, Email: "jane@doe.com"})
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestDeleteAccount(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	cardConnect := &vaultCardConnect{}
+	app.CardConnect = cardConnect
+	customer := createTestCustomer(app)
+	tokens, _ := app.StartSession(customer.ID, "iPhone")
+	app.DB.InsertSavedCard(&core.SavedCard{CustomerID: customer.ID, Gateway: core.GatewayCardConnect, VaultID: "12345/1"})
+	app.DB.InsertSavedCard(&core.SavedCard{CustomerID: customer.ID, Gateway: core.GatewayCardConnect, VaultID: "12345/2"})
+
+	order := insertPickupOrder(t, app)
+	app.DB.UpdateOrderCustomerID(order, customer.ID)
+	app.DB.UpsertPickupDetails(order.ID, core.PickupDetails{CustomerName: "Bob Smith", PhoneNumber: "4041234567"})
+	pickupTime := time.Date(2018, 3, 9, 18, 5, 0, 0, time.UTC)
+	app.DB.UpdateOrderPickupTime(order, pickupTime)
+	payment := core.Payment{Amount: 1500, OrderID: order.ID, Date: time.Now(), CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true}}
+	app.DB.InsertPayment(&payment, order)
+
+	// act
+	err := app.DeleteAccount(customer.ID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"12345"}, cardConnect.deleted)
+	cards, _ := app.ListCards(customer.ID)
+	assert.Equal(t, 0, len(*cards))
+
+	deletedCustomer, _ := app.DB.GetCustomer(customer.ID)
+	assert.Nil(t, deletedCustomer)
+
+	anonymizedOrder, _ := app.DB.GetOrderByDatabaseID(order.ID)
+	assert.False(t, anonymizedOrder.CustomerID.Valid)
+	details, _ := app.DB.GetPickupDetails(order.ID)
+	assert.Nil(t, details)
+	savedPayment, _ := app.DB.GetPaymentByOrderID(order.ID)
+	assert.False(t, savedPayment.CustomerID.Valid)
+	assert.Equal(t, "TO-GO APP - PAID\n\n6:05 pm", app.Kounta.(*pos.MockKounta).Notes)
+
+	_, err = app.RefreshSession(tokens.RefreshToken)
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
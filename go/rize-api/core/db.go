@@ -7,6 +7,27 @@ type DatabaseID int64
 type DB interface {
 	InsertTableMap(tableMap *TableMap) error
 	GetTableMapByBeaconID(id string) (*TableMap, error)
+	// SelectTableMapsByBeaconIDs will return the mappings of any of the (lower case) beacons
+	SelectTableMapsByBeaconIDs(ids []string) (*[]TableMap, error)
+	UpdateTableMap(tableMap *TableMap) error
+	DeleteTableMap(beaconID string) error
+	// SelectTableMapsBySiteID will return a site's table mappings ordered by table name and then beacon ID
+	SelectTableMapsBySiteID(siteID KountaID) (*[]TableMap, error)
+	// SelectDuplicateTableMaps will return the mappings of beacons that are mapped more than once
+	SelectDuplicateTableMaps() (*[]TableMap, error)
+	// UpsertTableMaps will insert or move every mapping, or none of them
+	UpsertTableMaps(tableMaps []TableMap) error
+	InsertFloorSection(section *FloorSection) error
+	SelectFloorSectionsBySiteID(siteID KountaID) (*[]FloorSection, error)
+	// UpsertFloorTable will insert a table or update the table with the same site and name
+	UpsertFloorTable(table *FloorTable) error
+	SelectFloorTablesBySiteID(siteID KountaID) (*[]FloorTable, error)
+	DeleteFloorTable(siteID KountaID, name string) error
+	// UpsertBeaconTelemetry will save a beacon's telemetry unless newer telemetry has already been saved. A battery of 0
+	// keeps the last battery reported, since not every report includes it.
+	UpsertBeaconTelemetry(telemetry *BeaconTelemetry) error
+	// SelectBeaconTelemetryBySiteID will return the telemetry of beacons mapped to tables at a site
+	SelectBeaconTelemetryBySiteID(siteID KountaID) (*[]BeaconTelemetry, error)
 
 	UpdateCayanKey(token string) error
 	GetCayanKey() (*Key, error)
@@ -16,40 +37,226 @@ type DB interface {
 	DeleteToken(id DatabaseID) error
 	DeleteTokens(customerID DatabaseID) error
 
+	InsertSession(session *Session) error
+	GetSession(id DatabaseID) (*Session, error)
+	UpdateSession(session *Session) error
+	// SelectActiveSessionsByCustomerID will return the sessions that are not revoked or expired, most recently used first
+	SelectActiveSessionsByCustomerID(customerID DatabaseID, now time.Time) (*[]Session, error)
+	RevokeSession(id DatabaseID, revokedAt time.Time) error
+	RevokeSessionsByCustomerID(customerID DatabaseID, revokedAt time.Time) error
+	InsertRefreshToken(token *RefreshToken) error
+	GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error)
+	// UseRefreshToken will mark a refresh token used, returning false if it had already been used
+	UseRefreshToken(id DatabaseID, usedAt time.Time) (bool, error)
+	// DeleteExpiredTokens will delete tokens, refresh tokens, sessions and login nonces that expired before now, and
+	// revoked sessions
+	DeleteExpiredTokens(now time.Time) error
+
 	InsertCustomer(customer *Customer) error
 	UpdateCustomerPassword(id DatabaseID, passwordHash string) (*Customer, error)
 	GetCustomer(id DatabaseID) (*Customer, error)
 	GetCustomerByExternalID(id string) (*Customer, error)
 	GetCustomerByEmail(email string) (*Customer, error)
+	// UpdateCustomerProfile will save a customer's name, email, phone and image
+	UpdateCustomerProfile(customer *Customer) error
+	// DeleteCustomer will delete a customer along with their tokens, sessions and connections
+	DeleteCustomer(id DatabaseID) error
+
+	InsertCustomerConnection(connection *CustomerConnection) error
+	GetCustomerConnection(service, externalID string) (*CustomerConnection, error)
+	SelectCustomerConnections(customerID DatabaseID) (*[]CustomerConnection, error)
+	DeleteCustomerConnection(customerID DatabaseID, service string) error
+
+	InsertLoginNonce(nonce string, expiresAt time.Time) error
+	// UseLoginNonce will delete a nonce that hasn't expired at now, returning false if it had expired or was already used
+	UseLoginNonce(nonce string, now time.Time) (bool, error)
+
+	GetLoginFailures(email string) (*LoginFailures, error)
+	// IncrementLoginFailures will count a failed login for an email at now in one statement, starting the count over if
+	// the last failure was before resetBefore, and return the new count
+	IncrementLoginFailures(email string, now, resetBefore time.Time) (*LoginFailures, error)
+	UpdateLoginFailuresLockedUntil(email string, lockedUntil time.Time) error
+	DeleteLoginFailures(email string) error
 
 	InsertOrder(order *Order) error
 	UpdateOrder(order *Order) error
 	UpdateOrderTableName(order *Order, tableName string) error
+	UpdateOrderPagerNumber(order *Order, pagerNumber string) error
 	UpdateOrderCustomerID(order *Order, customerID DatabaseID) error
 	UpdateOrderPickupTime(order *Order, pickupTime time.Time) error
+	// ReservePickupTime will only set the order's pickup time, and return true, if fewer than maxOrders other orders at
+	// its site are picked up in [slotStart, slotEnd), or maxOrders is 0. The count and update are atomic so that
+	// concurrent orders can't overfill a slot.
+	ReservePickupTime(order *Order, pickupTime, slotStart, slotEnd time.Time, maxOrders int) (bool, error)
+	// ReleasePickupTime will set the order's pickup time back to previous, clearing it when previous is nil
+	ReleasePickupTime(order *Order, previous *time.Time) error
 	GetOrder(orderID KountaID) (*Order, error)
 	GetOrderByDatabaseID(orderID DatabaseID) (*Order, error)
 	GetOrderByPagerID(siteID KountaID, pagerID int64) (*Order, error)
 	SelectOrdersByCustomerID(customerID DatabaseID) (*[]Order, error)
+	// SelectActiveOrdersBySiteID will return a site's orders with one of statuses created after createdAfter, orders
+	// with a pickup time first by pickup time and then the rest by when they were created
+	SelectActiveOrdersBySiteID(siteID KountaID, statuses []string, createdAfter time.Time) (*[]Order, error)
+	// SelectOrdersByCustomerIDBefore will return up to limit of a customer's orders, newest first, that are older than the
+	// order before (or the newest orders when before is 0), leaving out deleted orders
+	SelectOrdersByCustomerIDBefore(customerID, before DatabaseID, limit int) (*[]Order, error)
+	// AnonymizeOrdersByCustomerID will remove a customer, and their pickup and notification contact details, from their
+	// orders and payments
+	AnonymizeOrdersByCustomerID(customerID DatabaseID) error
 
 	// SelectOnHoldAndPendingOrdersByTable will return all orders that are either 'on hold' or 'pending' for a given table
 	SelectOnHoldAndPendingOrdersByTable(siteID KountaID, tableName string) (*[]Order, error)
 	// SelectOnHoldAndPendingOrdersByPagerID will return all orders that are either 'on hold' or 'pending' for a pager ID
 	SelectOnHoldAndPendingOrdersByPagerID(siteID KountaID, pagerID int64) (*[]Order, error)
+	// InsertOrderUpdate will only log an order update, use AppContext.RecordOrderUpdate to also keep its tips
 	InsertOrderUpdate(orderUpdate KountaOrderUpdate) error
+
+	InsertLRSEvent(record *LRSEventRecord) error
+	UpdateLRSEventOutcome(id DatabaseID, outcome, errorMessage string) error
+	// SelectLRSEvents will return a site's LRS events received in [from, to), in the order they were received
+	SelectLRSEvents(siteID KountaID, from, to time.Time) (*[]LRSEventRecord, error)
+	// InsertPagerSession will only insert the session, and return true, if there is no session with its UUID yet
+	InsertPagerSession(session *PagerSession) (bool, error)
+	DeletePagerSession(id DatabaseID) error
+	GetPagerSessionByUUID(uuid string) (*PagerSession, error)
+	UpdatePagerSession(session *PagerSession) error
+	// SelectOpenPagerSessions will return the sessions on a pager that haven't been cleared
+	SelectOpenPagerSessions(siteID KountaID, pagerNumber int64) (*[]PagerSession, error)
+	// InsertPagers will add pagers that aren't already in a site's inventory as available
+	InsertPagers(siteID KountaID, numbers []int64, now time.Time) error
+	GetPager(siteID KountaID, number int64) (*Pager, error)
+	SelectPagersBySiteID(siteID KountaID) (*[]Pager, error)
+	// AssignPager will add the pager to its site's inventory if needed and assign it to its order. A lost pager is left
+	// as it is and false is returned.
+	AssignPager(pager *Pager) (bool, error)
+	// UpdatePagerStatus will set a pager's status and take it off any order
+	UpdatePagerStatus(siteID KountaID, number int64, status string, now time.Time) error
+	// ReleasePagersByOrderID will make the pagers assigned to an order available
+	ReleasePagersByOrderID(orderID DatabaseID, now time.Time) error
 	GetLine(lineID DatabaseID) (*Line, error)
 	SelectLines(orderID DatabaseID) (*[]Line, error)
 	SelectAddedModifiers(lineID DatabaseID) (*[]Modifier, error)
 	SelectRemovedModifiers(lineID DatabaseID) (*[]Modifier, error)
+	// SelectLinesByOrderIDs will return the lines, with their added and removed modifiers attached, for all of the
+	// given orders keyed by order ID
+	SelectLinesByOrderIDs(orderIDs []DatabaseID) (map[DatabaseID][]Line, error)
+
+	InsertSavedCard(card *SavedCard) error
+	GetSavedCard(id DatabaseID) (*SavedCard, error)
+	// SelectSavedCardsByCustomerID will return a customer's saved cards, oldest first
+	SelectSavedCardsByCustomerID(customerID DatabaseID) (*[]SavedCard, error)
+	DeleteSavedCard(id DatabaseID) error
+	// SetDefaultSavedCard will make one of a customer's cards the default and unset the others
+	SetDefaultSavedCard(customerID, cardID DatabaseID) error
+
+	// InsertGiftCard will save a new gift card along with an issue entry for its initial balance
+	InsertGiftCard(card *GiftCard) error
+	// GetGiftCardByCode will return nil if there is no gift card with the (normalized) code
+	GetGiftCardByCode(code string) (*GiftCard, error)
+	// RedeemGiftCard will spend from a gift card and save the payment it makes on the order, reducing the entry's and
+	// payment's amount to what is left on the card or left to pay on the order. The card and order are locked while
+	// their balances are checked, and the entry and payment are saved together. Nothing is saved if either balance is
+	// empty and the entry's amount is set to 0.
+	RedeemGiftCard(entry *GiftCardEntry, payment *Payment, order *Order) error
+	// InsertGiftCardEntry will add an entry to a gift card's ledger and its amount to the card's balance
+	InsertGiftCardEntry(entry *GiftCardEntry) error
+	// InsertGiftCardRefund will add a refund to a gift card's ledger and the negative payment taking it off the order,
+	// together
+	InsertGiftCardRefund(refund *GiftCardEntry, refundPayment *Payment) error
+	GetGiftCardEntryByTransactionID(transactionID string) (*GiftCardEntry, error)
+	// SelectGiftCardEntries will return a gift card's ledger, oldest first
+	SelectGiftCardEntries(giftCardID DatabaseID) (*[]GiftCardEntry, error)
 
 	InsertPayment(payment *Payment, order *Order) error
 	// GetPaymentByOrderID will get a payment for a given order ID
 	GetPaymentByOrderID(id DatabaseID) (*Payment, error)
+	// SelectPaymentsByOrderIDs will return any payments, oldest first, for the given orders keyed by order ID
+	SelectPaymentsByOrderIDs(orderIDs []DatabaseID) (map[DatabaseID][]Payment, error)
+	// LockOrdersForPayment will only lock the orders, and return true, if none of them are already locked. Locks expire
+	// after lease in case they are never unlocked.
+	LockOrdersForPayment(orderIDs []DatabaseID, now time.Time, lease time.Duration) (bool, error)
+	UnlockOrdersForPayment(orderIDs []DatabaseID) error
+
+	UpsertLoyaltyRule(rule *LoyaltyRule) error
+	// GetLoyaltyRule will return nil if the site does not earn points
+	GetLoyaltyRule(siteID KountaID) (*LoyaltyRule, error)
+	InsertLoyaltyReward(reward *LoyaltyReward) error
+	GetLoyaltyReward(id DatabaseID) (*LoyaltyReward, error)
+	SelectActiveLoyaltyRewards() (*[]LoyaltyReward, error)
+	InsertLoyaltyEntry(entry *LoyaltyEntry) error
+	// InsertLoyaltyRedemption will only insert the entry, and return true, if the customer's balance covers it. The
+	// balance check and insert are atomic so that concurrent redemptions can't spend the same points.
+	InsertLoyaltyRedemption(entry *LoyaltyEntry) (bool, error)
+	UpdateLoyaltyEntryOrderID(entryID, orderID DatabaseID) error
+	GetLoyaltyBalance(customerID DatabaseID) (int, error)
+	// SelectLoyaltyEntriesByCustomerID will return a customer's entries, newest first
+	SelectLoyaltyEntriesByCustomerID(customerID DatabaseID) (*[]LoyaltyEntry, error)
+	// SelectLoyaltyEntriesByOrderID will return an order's entries, including reversals, oldest first
+	SelectLoyaltyEntriesByOrderID(orderID DatabaseID) (*[]LoyaltyEntry, error)
+	// SelectLoyaltyEntriesByTransactionID will return the entries for a payment, including reversals, oldest first
+	SelectLoyaltyEntriesByTransactionID(transactionID string) (*[]LoyaltyEntry, error)
+
+	// InsertPromoCode will save a promo code along with its site, menu item and category restrictions
+	InsertPromoCode(promo *PromoCode) error
+	// GetPromoCodeByCode will return nil if there is no promo code with the (upper case) code
+	GetPromoCodeByCode(code string) (*PromoCode, error)
+	UpdatePromoCodeActive(id DatabaseID, active bool) error
+	// InsertPromoRedemption will only insert the redemption, and return true, if its customer has used the promo code
+	// fewer than perCustomerLimit times, or the limit is 0. The count and insert are atomic so that concurrent orders
+	// can't go over the limit.
+	InsertPromoRedemption(redemption *PromoRedemption, perCustomerLimit int) (bool, error)
+	UpdatePromoRedemptionOrderID(redemptionID, orderID DatabaseID) error
+	DeletePromoRedemption(id DatabaseID) error
+	// CountPromoRedemptionsByCustomer will count the times a customer has used a promo code, including orders still
+	// being created and not counting orders that were rejected or deleted
+	CountPromoRedemptionsByCustomer(promoCodeID, customerID DatabaseID) (int, error)
+	// SelectPromoCodeUsage will total the use of each promo code on a site's orders created in [from, to), not counting
+	// orders that were rejected or deleted
+	SelectPromoCodeUsage(siteID KountaID, from, to time.Time) (*[]PromoCodeUsage, error)
 
 	InsertSite(site *Site) error
 	UpdateSiteMenuHash(site *Site, menuHash string) error
 	SelectSites() (*[]Site, error)
 	GetSite(id KountaID) (*Site, error)
+	UpdateSiteTimeZone(siteID KountaID, timeZone string) error
+	UpdateSiteTaxRate(siteID KountaID, rate float64) error
+
+	InsertFeeRule(rule *FeeRule) error
+	UpdateFeeRuleActive(id DatabaseID, active bool) error
+	SelectActiveFeeRulesBySiteID(siteID KountaID) (*[]FeeRule, error)
+	InsertOrderFees(orderID DatabaseID, fees []OrderFee) error
+	SelectOrderFees(orderID DatabaseID) (*[]OrderFee, error)
+	// SelectFeeUsage will total each fee charged on a site's orders created in [from, to), not counting orders that
+	// were rejected or deleted
+	SelectFeeUsage(siteID KountaID, from, to time.Time) (*[]FeeUsage, error)
+
+	UpsertPickupSettings(settings *PickupSettings) error
+	UpsertTipSettings(settings *TipSettings) error
+	// GetTipSettings will return nil if the site uses the default tip settings
+	GetTipSettings(siteID KountaID) (*TipSettings, error)
+	// UpsertStaffTip will save the tips for an order unless a newer update was already saved for it
+	UpsertStaffTip(tip *StaffTip) error
+	// SelectStaffTipsBySiteID will return the tips for a site's orders sold in [from, to)
+	SelectStaffTipsBySiteID(siteID KountaID, from, to time.Time) (*[]StaffTip, error)
+	// GetPickupSettings will return nil if the site does not schedule pickups into slots
+	GetPickupSettings(siteID KountaID) (*PickupSettings, error)
+	// SelectPickupTimesBySiteID will return the pickup times, keyed by order ID, of orders picked up in [from, to)
+	// that have not been rejected or deleted
+	SelectPickupTimesBySiteID(siteID KountaID, from, to time.Time) (map[DatabaseID]time.Time, error)
+	UpsertPickupDetails(orderID DatabaseID, details PickupDetails) error
+	GetPickupDetails(orderID DatabaseID) (*PickupDetails, error)
+
+	UpsertNotificationSettings(settings *NotificationSettings) error
+	// GetNotificationSettings will return nil if the site does not notify customers
+	GetNotificationSettings(siteID KountaID) (*NotificationSettings, error)
+	InsertNotification(notification *Notification) error
+	SelectNotificationsByOrderID(orderID DatabaseID) (*[]Notification, error)
+
+	InsertOutboxEmail(email *OutboxEmail) error
+	UpdateOutboxEmail(email *OutboxEmail) error
+	// ClaimDueOutboxEmails will return up to limit pending emails due by now and push their next attempt back by lease,
+	// so that other processes working through the outbox will not deliver them at the same time
+	ClaimDueOutboxEmails(now time.Time, lease time.Duration, limit int) (*[]OutboxEmail, error)
 
 	// UpsertCategory will either update or insert core.Category into database
 	UpsertCategory(category *Category) error
@@ -60,6 +267,8 @@ type DB interface {
 	UpsertMenuItem(item *MenuItem) error
 	SelectMenuItems() (*[]MenuItem, error)
 	SelectMenuItemsByCategoryID(siteID KountaID, categoryID DatabaseID) (*[]MenuItem, error)
+	// SelectMenuItemsBySiteID will return every menu item for a site, priced for that site
+	SelectMenuItemsBySiteID(siteID KountaID) (*[]MenuItem, error)
 	GetMenuItem(siteID KountaID, menuItemID DatabaseID) (*MenuItem, error)
 	DeleteMenuItem(menuItemID KountaID) error
 
@@ -69,11 +278,16 @@ type DB interface {
 	GetMenuModifierByKountaID(siteID, modifierID KountaID) (*Modifier, error)
 	SelectMenuModifiers() (*[]Modifier, error)
 	SelectMenuItemModifiers(siteID KountaID, menuItemID DatabaseID) (*[]Modifier, error)
+	// SelectMenuItemModifiersBySiteID will return the modifiers for every menu item at a site keyed by menu item ID
+	SelectMenuItemModifiersBySiteID(siteID KountaID) (map[DatabaseID][]Modifier, error)
 	DeleteMenuModifier(modifierID KountaID) error
 
 	UpsertOptionSet(item *MenuItem, optionSet *OptionSet) error
 	GetOptionSet(optionSetID DatabaseID) (*OptionSet, error)
 	SelectOptionSets() (*[]OptionSet, error)
 	SelectOptionSetsByItemID(menuItemID DatabaseID) (*[]OptionSet, error)
+	// SelectOptionSetsBySiteID will return the option sets, with their options, for every menu item at a site keyed by
+	// menu item ID
+	SelectOptionSetsBySiteID(siteID KountaID) (map[DatabaseID][]OptionSet, error)
 	DeleteOptionSet(optionSetID KountaID) error
 }
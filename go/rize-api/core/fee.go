@@ -0,0 +1,335 @@
+package core
+
+import (
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// These are the kinds of fee a site can charge on new orders
+const (
+	// FeeKindServiceCharge is a percent of the order charged on every order
+	FeeKindServiceCharge = "service_charge"
+	// FeeKindAutoGratuity is a percent of the order charged on orders for large parties
+	FeeKindAutoGratuity = "auto_gratuity"
+	// FeeKindPackaging is a flat fee charged on pickup orders, once their pickup time is set
+	FeeKindPackaging = "packaging"
+)
+
+// maxTaxRate is the highest tax rate a site can set, anything higher is a typo
+const maxTaxRate = 30
+
+// FeeRule is a fee a site adds to new orders
+type FeeRule struct {
+	ID     DatabaseID `json:"id"`
+	SiteID KountaID   `json:"site_id"`
+	Name   string     `json:"name"`
+	Kind   string     `json:"kind"`
+	// Percent of the order subtotal for service charges and auto gratuity
+	Percent float64 `json:"percent"`
+	// Amount in cents, before tax, for packaging fees
+	Amount int `json:"amount"`
+	// MinPartySize is the smallest party auto gratuity is charged to
+	MinPartySize int  `json:"min_party_size"`
+	Taxable      bool `json:"taxable"` // Taxable fees are charged the site's tax rate on top
+	Active       bool `json:"active"`
+}
+
+// OrderQuote is what a new order will cost, worked out the same way as when it is created
+type OrderQuote struct {
+	Subtotal int        `json:"subtotal"` // Subtotal of the menu items, including their tax
+	Discount int        `json:"discount"`
+	Fees     []OrderFee `json:"fees"`
+	Tax      int        `json:"tax"` // Tax included in the items and charged on the fees
+	Total    int        `json:"total"`
+}
+
+// FeeUsage is how much a fee was charged at a site
+type FeeUsage struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Orders int    `json:"orders"`
+	Amount int    `json:"amount"`
+	Tax    int    `json:"tax"`
+}
+
+// FeeError is returned for fee rules and tax rates that aren't allowed
+type FeeError struct {
+	Reason string
+}
+
+func (e FeeError) Error() string {
+	return e.Reason
+}
+
+// UpdateSiteTaxRate will set the percent tax charged on a site's taxable fees. Menu prices come from Kounta with their
+// tax included.
+func (app AppContext) UpdateSiteTaxRate(siteID KountaID, rate float64) error {
+	if rate < 0 || rate > maxTaxRate {
+		return errors.Wrap(FeeError{Reason: "the tax rate has to be between 0 and 30 percent"}, "update site tax rate")
+	}
+
+	if err := app.DB.UpdateSiteTaxRate(siteID, rate); err != nil {
+		return errors.Wrap(err, "update site tax rate")
+	}
+	return nil
+}
+
+// AddFeeRule will validate and save a fee for a site's new orders
+func (app AppContext) AddFeeRule(rule *FeeRule) error {
+	if rule.Name == "" {
+		return errors.Wrap(FeeError{Reason: "a fee needs a name"}, "add fee rule")
+	}
+	switch rule.Kind {
+	case FeeKindServiceCharge, FeeKindAutoGratuity:
+		if rule.Percent <= 0 || rule.Percent > 100 {
+			return errors.Wrap(FeeError{Reason: "the fee percent has to be between 0 and 100"}, "add fee rule")
+		}
+		if rule.Kind == FeeKindAutoGratuity && rule.MinPartySize < 2 {
+			return errors.Wrap(FeeError{Reason: "auto gratuity needs a party size of at least 2"}, "add fee rule")
+		}
+	case FeeKindPackaging:
+		if rule.Amount <= 0 {
+			return errors.Wrap(FeeError{Reason: "a packaging fee needs an amount"}, "add fee rule")
+		}
+	default:
+		return errors.Wrap(FeeError{Reason: "unknown fee kind " + rule.Kind}, "add fee rule")
+	}
+
+	rule.Active = true
+	if err := app.DB.InsertFeeRule(rule); err != nil {
+		return errors.Wrap(err, "add fee rule")
+	}
+	return nil
+}
+
+// DeactivateFeeRule will stop a fee from being charged on new orders
+func (app AppContext) DeactivateFeeRule(ruleID DatabaseID) error {
+	if err := app.DB.UpdateFeeRuleActive(ruleID, false); err != nil {
+		return errors.Wrap(err, "deactivate fee rule")
+	}
+	return nil
+}
+
+// ListFeeRules will return a site's active fees
+func (app AppContext) ListFeeRules(siteID KountaID) (*[]FeeRule, error) {
+	rules, err := app.DB.SelectActiveFeeRulesBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "list fee rules")
+	}
+	return rules, nil
+}
+
+// QuoteOrder will return what a new order will cost with its discounts, fees and tax, so it can be shown before the
+// order is placed. Nothing is redeemed. A pickup quote includes the packaging fees charged once the order's pickup time
+// is set.
+func (app AppContext) QuoteOrder(siteID KountaID, createOrder CreateOrder, pickup bool) (*OrderQuote, error) {
+	site, err := app.getSiteForFees(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "quote order")
+	}
+	subtotal, err := app.orderSubtotal(siteID, createOrder)
+	if err != nil {
+		return nil, errors.Wrap(err, "quote order")
+	}
+	rules, err := app.DB.SelectActiveFeeRulesBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "quote order")
+	}
+	if err := validatePartySize(*rules, createOrder.PartySize); err != nil {
+		return nil, errors.Wrap(err, "quote order")
+	}
+
+	fees := feesForOrder(*rules, site.TaxRate, createOrder.PartySize, pickup, subtotal)
+	quote := OrderQuote{Subtotal: subtotal, Fees: fees}
+	if createOrder.PromoCode != "" {
+		_, discount, err := app.discountForPromoCode(siteID, createOrder, time.Now())
+		if err != nil {
+			return nil, errors.Wrap(err, "quote order")
+		}
+		quote.Discount += discount
+	}
+	if createOrder.RewardID != 0 {
+		reward, err := app.DB.GetLoyaltyReward(createOrder.RewardID)
+		if err != nil {
+			return nil, errors.Wrap(err, "quote order")
+		}
+		if reward == nil || !reward.Active {
+			return nil, errors.Wrap(LoyaltyError{Reason: "this reward is no longer available"}, "quote order")
+		}
+		quote.Discount += rewardDiscount(*reward, subtotal, quote.Discount)
+	}
+	if quote.Discount > subtotal {
+		quote.Discount = subtotal
+	}
+
+	itemsTotal := subtotal - quote.Discount
+	quote.Tax = roundCents(float64(itemsTotal) * site.TaxRate / (100 + site.TaxRate))
+	quote.Total = itemsTotal
+	for _, fee := range fees {
+		quote.Tax += fee.Tax
+		quote.Total += fee.Amount
+	}
+	return &quote, nil
+}
+
+// GetFeeReport will return how much each fee was charged at a site on orders created in [from, to)
+func (app AppContext) GetFeeReport(siteID KountaID, from, to time.Time) (*[]FeeUsage, error) {
+	usage, err := app.DB.SelectFeeUsage(siteID, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "get fee report")
+	}
+	return usage, nil
+}
+
+// applyFees will add the site's fees to a new order. Packaging fees are left for applyPickupFees, a new order isn't a
+// pickup order until its pickup time is set.
+func (app AppContext) applyFees(siteID KountaID, createOrder *CreateOrder) error {
+	rules, err := app.DB.SelectActiveFeeRulesBySiteID(siteID)
+	if err != nil {
+		return errors.Wrap(err, "apply fees")
+	}
+	if len(*rules) == 0 {
+		return nil
+	}
+	if err := validatePartySize(*rules, createOrder.PartySize); err != nil {
+		return errors.Wrap(err, "apply fees")
+	}
+
+	site, err := app.getSiteForFees(siteID)
+	if err != nil {
+		return errors.Wrap(err, "apply fees")
+	}
+	subtotal, err := app.orderSubtotal(siteID, *createOrder)
+	if err != nil {
+		return errors.Wrap(err, "apply fees")
+	}
+
+	createOrder.Fees = append(createOrder.Fees, feesForOrder(*rules, site.TaxRate, createOrder.PartySize, false, subtotal)...)
+	return nil
+}
+
+// applyPickupFees will charge the site's packaging fees on an order that is becoming a pickup order, by adding them to
+// its Kounta order as lines. They are only charged once, however often the pickup time changes.
+func (app AppContext) applyPickupFees(order *Order) error {
+	rules, err := app.DB.SelectActiveFeeRulesBySiteID(order.SiteID)
+	if err != nil {
+		return errors.Wrap(err, "apply pickup fees")
+	}
+	packagingRules := []FeeRule{}
+	for _, rule := range *rules {
+		if rule.Kind == FeeKindPackaging {
+			packagingRules = append(packagingRules, rule)
+		}
+	}
+	if len(packagingRules) == 0 {
+		return nil
+	}
+
+	existingFees, err := app.DB.SelectOrderFees(order.ID)
+	if err != nil {
+		return errors.Wrap(err, "apply pickup fees")
+	}
+	for _, fee := range *existingFees {
+		if fee.Kind == FeeKindPackaging {
+			return nil
+		}
+	}
+
+	adder, ok := app.Kounta.(KountaPriceLineAdder)
+	if !ok {
+		return errors.New("apply pickup fees: the kounta client can't add fee lines to orders")
+	}
+	site, err := app.getSiteForFees(order.SiteID)
+	if err != nil {
+		return errors.Wrap(err, "apply pickup fees")
+	}
+
+	fees := feesForOrder(packagingRules, site.TaxRate, 0, true, 0)
+	if _, err := adder.AddPriceLinesToOrder(order.PosID, feePriceLines(fees)); err != nil {
+		return errors.Wrap(err, "apply pickup fees")
+	}
+	if err := app.DB.InsertOrderFees(order.ID, fees); err != nil {
+		return errors.Wrap(err, "apply pickup fees")
+	}
+	return nil
+}
+
+// validatePartySize returns a FeeError if the party size can't be used to work out a site's fees. Sites with auto
+// gratuity need to know the party size, rather than taking a missing one as a party too small to charge.
+func validatePartySize(rules []FeeRule, partySize int) error {
+	if partySize < 0 {
+		return FeeError{Reason: "the party size can not be negative"}
+	}
+	if partySize > 0 {
+		return nil
+	}
+	for _, rule := range rules {
+		if rule.Kind == FeeKindAutoGratuity {
+			return FeeError{Reason: "a party size is required"}
+		}
+	}
+	return nil
+}
+
+// feePriceLines returns the lines added to a Kounta order for its fees
+func feePriceLines(fees []OrderFee) []PriceLine {
+	lines := []PriceLine{}
+	for _, fee := range fees {
+		lines = append(lines, PriceLine{Name: fee.Name, Amount: fee.Amount})
+	}
+	return lines
+}
+
+// feesForOrder works out the fees for an order from its subtotal before discounts
+func feesForOrder(rules []FeeRule, taxRate float64, partySize int, pickup bool, subtotal int) []OrderFee {
+	fees := []OrderFee{}
+	for _, rule := range rules {
+		amount := 0
+		switch rule.Kind {
+		case FeeKindServiceCharge:
+			amount = roundCents(float64(subtotal) * rule.Percent / 100)
+		case FeeKindAutoGratuity:
+			if partySize >= rule.MinPartySize {
+				amount = roundCents(float64(subtotal) * rule.Percent / 100)
+			}
+		case FeeKindPackaging:
+			if pickup {
+				amount = rule.Amount
+			}
+		}
+		if amount == 0 {
+			continue
+		}
+
+		fee := OrderFee{Name: rule.Name, Kind: rule.Kind, Amount: amount}
+		if rule.Taxable {
+			fee.Tax = roundCents(float64(amount) * taxRate / 100)
+			fee.Amount += fee.Tax
+		}
+		fees = append(fees, fee)
+	}
+	return fees
+}
+
+// orderSubtotal prices a new order from the site's menu, an unrestricted promo code qualifies every item
+func (app AppContext) orderSubtotal(siteID KountaID, createOrder CreateOrder) (int, error) {
+	subtotal, _, err := app.promoSubtotals(siteID, createOrder, &PromoCode{})
+	return subtotal, err
+}
+
+func (app AppContext) getSiteForFees(siteID KountaID) (*Site, error) {
+	site, err := app.DB.GetSite(siteID)
+	if err != nil {
+		return nil, err
+	}
+	if site == nil {
+		return nil, errors.Errorf("site %d not found", siteID)
+	}
+	return site, nil
+}
+
+func roundCents(cents float64) int {
+	return int(math.Round(cents))
+}
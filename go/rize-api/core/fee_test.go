@@ -0,0 +1,238 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+func TestQuoteOrderAddsFeesAndTax(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createOrder := pricedPromoOrder(t, app)
+	insertTestFeeRules(t, app)
+	createOrder.PartySize = 6
+
+	// act
+	quote, err := app.QuoteOrder(core.TestSitePosID, createOrder, true)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 2500, quote.Subtotal)
+	assert.Equal(t, []core.OrderFee{
+		{Name: "Service charge", Kind: core.FeeKindServiceCharge, Amount: 250},
+		{Name: "Large party", Kind: core.FeeKindAutoGratuity, Amount: 450},
+		{Name: "Packaging", Kind: core.FeeKindPackaging, Amount: 54, Tax: 4},
+	}, quote.Fees)
+	// 8% tax is already in the item prices and charged on top of the taxable packaging fee
+	assert.Equal(t, 189, quote.Tax)
+	assert.Equal(t, 3254, quote.Total)
+}
+
+func TestQuoteOrderWithPromoCode(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createOrder := pricedPromoOrder(t, app)
+	insertTestFeeRules(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "save10", DiscountType: core.PromoDiscountPercent, DiscountValue: 10, CategoryIDs: []core.KountaID{core.TestCategory1PosID}})
+	createOrder.PromoCode = "SAVE10"
+	createOrder.PartySize = 2
+
+	// act
+	quote, err := app.QuoteOrder(core.TestSitePosID, createOrder, false)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 200, quote.Discount)
+	// fees are on the subtotal before discounts
+	assert.Equal(t, []core.OrderFee{{Name: "Service charge", Kind: core.FeeKindServiceCharge, Amount: 250}}, quote.Fees)
+	assert.Equal(t, 170, quote.Tax)
+	assert.Equal(t, 2550, quote.Total)
+}
+
+func TestQuoteOrderNeedsPartySizeForAutoGratuity(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createOrder := pricedPromoOrder(t, app)
+	insertTestFeeRules(t, app)
+
+	// act
+	_, missingErr := app.QuoteOrder(core.TestSitePosID, createOrder, false)
+	createOrder.PartySize = -1
+	_, negativeErr := app.QuoteOrder(core.TestSitePosID, createOrder, false)
+	_, createErr := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.IsType(t, core.FeeError{}, errors.Cause(missingErr))
+	assert.IsType(t, core.FeeError{}, errors.Cause(negativeErr))
+	assert.IsType(t, core.FeeError{}, errors.Cause(createErr))
+}
+
+func TestAddFeeRuleValidates(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	packaging := core.FeeRule{SiteID: core.TestSitePosID, Name: "Packaging", Kind: core.FeeKindPackaging, Amount: 50}
+	app.AddFeeRule(&packaging)
+
+	// act
+	unknownErr := app.AddFeeRule(&core.FeeRule{SiteID: core.TestSitePosID, Name: "Delivery", Kind: "delivery", Amount: 500})
+	percentErr := app.AddFeeRule(&core.FeeRule{SiteID: core.TestSitePosID, Name: "Service charge", Kind: core.FeeKindServiceCharge, Percent: 150})
+	partyErr := app.AddFeeRule(&core.FeeRule{SiteID: core.TestSitePosID, Name: "Large party", Kind: core.FeeKindAutoGratuity, Percent: 18})
+	taxErr := app.UpdateSiteTaxRate(core.TestSitePosID, 80)
+	deactivateErr := app.DeactivateFeeRule(packaging.ID)
+	rules, _ := app.ListFeeRules(core.TestSitePosID)
+
+	// assert
+	assert.IsType(t, core.FeeError{}, errors.Cause(unknownErr))
+	assert.IsType(t, core.FeeError{}, errors.Cause(percentErr))
+	assert.IsType(t, core.FeeError{}, errors.Cause(partyErr))
+	assert.IsType(t, core.FeeError{}, errors.Cause(taxErr))
+	assert.NoError(t, deactivateErr)
+	assert.Equal(t, 0, len(*rules))
+}
+
+func TestCreateNewOrderAddsFees(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	createOrder := pricedPromoOrder(t, app)
+	insertTestFeeRules(t, app)
+	createOrder.PartySize = 6
+
+	// act
+	order, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	// packaging isn't charged until the order has a pickup time
+	fees := []core.OrderFee{
+		{Name: "Service charge", Kind: core.FeeKindServiceCharge, Amount: 250},
+		{Name: "Large party", Kind: core.FeeKindAutoGratuity, Amount: 450},
+	}
+	assert.Equal(t, fees, kounta.orders[0].Fees)
+	assert.Equal(t, []core.PriceLine{{Name: "Service charge", Amount: 250}, {Name: "Large party", Amount: 450}}, kounta.lines)
+
+	savedFees, _ := app.DB.SelectOrderFees(order.ID)
+	assert.Equal(t, fees, *savedFees)
+
+	now := time.Now()
+	report, _ := app.GetFeeReport(core.TestSitePosID, now.Add(-time.Hour), now.Add(time.Hour))
+	assert.Equal(t, []core.FeeUsage{
+		{Name: "Large party", Kind: core.FeeKindAutoGratuity, Orders: 1, Amount: 450},
+		{Name: "Service charge", Kind: core.FeeKindServiceCharge, Orders: 1, Amount: 250},
+	}, *report)
+}
+
+func TestCreateNewOrderWithFeesNeedsFeeLines(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pos.MockKounta{}
+	createOrder := pricedPromoOrder(t, app)
+	insertTestFeeRules(t, app)
+	createOrder.PartySize = 2
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.Error(t, err)
+	now := time.Now()
+	report, _ := app.GetFeeReport(core.TestSitePosID, now.Add(-time.Hour), now.Add(time.Hour))
+	assert.Equal(t, 0, len(*report))
+}
+
+func TestUpdatePickupDetailsChargesPackagingOnce(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	insertTestFeeRules(t, app)
+	order := insertPickupOrder(t, app)
+	kounta := &orderKounta{MockKounta: app.Kounta.(*pos.MockKounta)}
+	app.Kounta = kounta
+	pickupTime := time.Now().Add(time.Hour)
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &pickupTime})
+	rescheduledTime := pickupTime.Add(time.Hour)
+	rescheduleErr := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &rescheduledTime})
+
+	// assert
+	assert.NoError(t, err)
+	assert.NoError(t, rescheduleErr)
+	assert.Equal(t, []core.PriceLine{{Name: "Packaging", Amount: 54}}, kounta.lines)
+	savedFees, _ := app.DB.SelectOrderFees(order.ID)
+	assert.Equal(t, []core.OrderFee{{Name: "Packaging", Kind: core.FeeKindPackaging, Amount: 54, Tax: 4}}, *savedFees)
+}
+
+func TestUpdatePickupDetailsWithPackagingNeedsFeeLines(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	insertTestFeeRules(t, app)
+	order := insertPickupOrder(t, app)
+	pickupTime := time.Now().Add(time.Hour)
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &pickupTime})
+
+	// assert
+	assert.Error(t, err)
+	updatedOrder, _ := app.DB.GetOrderByDatabaseID(order.ID)
+	assert.Nil(t, updatedOrder.PickupTime)
+}
+
+func TestGetReceiptBreaksOutFees(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+
+	order := insertPickupOrder(t, app)
+	app.DB.InsertOrderFees(order.ID, []core.OrderFee{{Name: "Packaging", Kind: core.FeeKindPackaging, Amount: 54, Tax: 4}})
+	assert.NoError(t, app.SavePayment(&core.Payment{Amount: 1500, OrderID: order.ID, Date: time.Now()}, order))
+
+	// act
+	receipt, err := app.GetReceipt(order.ID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, core.Cents(1330), receipt.Subtotal)
+	assert.Equal(t, []core.ReceiptFee{{Name: "Packaging", Amount: 50}}, receipt.Fees)
+	assert.Equal(t, core.Cents(120), receipt.Tax)
+	assert.Equal(t, core.Cents(1500), receipt.Total)
+	assert.Contains(t, receipt.TextLines(), "Packaging                          $0.50")
+}
+
+// helpers
+
+// insertTestFeeRules adds a 10% service charge, 18% gratuity for parties of 6 and a taxable 50 cent packaging fee to
+// the test site, which has an 8% tax rate
+func insertTestFeeRules(t *testing.T, app core.AppContext) {
+	rules := []core.FeeRule{
+		{SiteID: core.TestSitePosID, Name: "Service charge", Kind: core.FeeKindServiceCharge, Percent: 10},
+		{SiteID: core.TestSitePosID, Name: "Large party", Kind: core.FeeKindAutoGratuity, Percent: 18, MinPartySize: 6},
+		{SiteID: core.TestSitePosID, Name: "Packaging", Kind: core.FeeKindPackaging, Amount: 50, Taxable: true},
+	}
+	for i := range rules {
+		if err := app.AddFeeRule(&rules[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.UpdateSiteTaxRate(core.TestSitePosID, 8); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,246 @@
+package core
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/nu7hatch/gouuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// PaymentMethodGiftCard marks a payment made from a gift card, card payments have no method
+	PaymentMethodGiftCard = "gift_card"
+
+	GiftCardEntryIssue  = "issue"
+	GiftCardEntryRedeem = "redeem"
+	GiftCardEntryRefund = "refund"
+)
+
+// giftCardCodeAlphabet leaves out characters that are easily confused when read aloud or typed, like 0/O and 1/I
+const giftCardCodeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+const giftCardCodeLength = 16
+
+// GiftCard is stored value a guest can pay with. Its balance always equals the sum of its ledger entries.
+type GiftCard struct {
+	ID             DatabaseID `json:"-"`
+	Code           string     `json:"code"`
+	InitialBalance int        `json:"initial_balance"`
+	Balance        int        `json:"balance"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// GiftCardEntry is a change to a gift card's balance
+type GiftCardEntry struct {
+	ID            DatabaseID    `json:"-"`
+	GiftCardID    DatabaseID    `json:"-"`
+	Kind          string        `json:"kind"`
+	Amount        int           `json:"amount"` // Amount is negative when the balance was spent
+	OrderID       sql.NullInt64 `json:"-"`
+	TransactionID string        `json:"transaction_id"`
+	CreatedAt     time.Time     `json:"created_at"`
+}
+
+// GiftCardError is returned when a gift card can't be found or used
+type GiftCardError struct {
+	Reason string
+}
+
+func (e GiftCardError) Error() string {
+	return e.Reason
+}
+
+// IssueGiftCard will create a gift card with a new random code and a starting balance in cents
+func (app AppContext) IssueGiftCard(amount int) (*GiftCard, error) {
+	if amount <= 0 {
+		return nil, errors.Wrap(GiftCardError{Reason: "a gift card needs a positive balance"}, "issue gift card")
+	}
+
+	code, err := newGiftCardCode()
+	if err != nil {
+		return nil, errors.Wrap(err, "issue gift card")
+	}
+
+	card := GiftCard{Code: code, InitialBalance: amount, Balance: amount, CreatedAt: time.Now()}
+	if err := app.DB.InsertGiftCard(&card); err != nil {
+		return nil, errors.Wrap(err, "issue gift card")
+	}
+	return &card, nil
+}
+
+// GetGiftCard will look up a gift card, and its balance, by code. Codes can be entered with or without dashes.
+func (app AppContext) GetGiftCard(code string) (*GiftCard, error) {
+	card, err := app.DB.GetGiftCardByCode(normalizeGiftCardCode(code))
+	if err != nil {
+		return nil, errors.Wrap(err, "get gift card")
+	}
+	if card == nil {
+		return nil, errors.Wrap(GiftCardError{Reason: "gift card not found"}, "get gift card")
+	}
+	return card, nil
+}
+
+// GetGiftCardHistory will return every change to a gift card's balance, oldest first
+func (app AppContext) GetGiftCardHistory(code string) (*[]GiftCardEntry, error) {
+	card, err := app.GetGiftCard(code)
+	if err != nil {
+		return nil, errors.Wrap(err, "get gift card history")
+	}
+
+	entries, err := app.DB.SelectGiftCardEntries(card.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get gift card history")
+	}
+	return entries, nil
+}
+
+// GetOrderBalance will return how much of an order is left to pay after any payments already made, such as a gift
+// card covering part of it
+func (app AppContext) GetOrderBalance(orderID DatabaseID) (int, error) {
+	order, err := app.DB.GetOrderByDatabaseID(orderID)
+	if err != nil {
+		return 0, errors.Wrap(err, "get order balance")
+	}
+	if order == nil {
+		return 0, errors.Errorf("get order balance: order %d not found", orderID)
+	}
+
+	payments, err := app.DB.SelectPaymentsByOrderIDs([]DatabaseID{orderID})
+	if err != nil {
+		return 0, errors.Wrap(err, "get order balance")
+	}
+
+	balance := order.Total - amountPaid(payments[orderID])
+	if balance < 0 {
+		return 0, nil
+	}
+	return balance, nil
+}
+
+// PayWithGiftCard will spend up to amount from a gift card on an order, never more than the card's balance or what is
+// left to pay on the order, which are both checked again while the payment is saved. The payment is saved along with
+// the gift card's ledger entry, and followed up like one saved with SavePayment. Anything left is paid by card.
+func (app AppContext) PayWithGiftCard(code string, order *Order, amount int) (*Payment, error) {
+	card, err := app.GetGiftCard(code)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay with gift card")
+	}
+
+	orderBalance, err := app.GetOrderBalance(order.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay with gift card")
+	}
+	if amount > orderBalance {
+		amount = orderBalance
+	}
+	if amount <= 0 {
+		return nil, errors.Wrap(GiftCardError{Reason: "there is nothing left to pay on this order"}, "pay with gift card")
+	}
+
+	transactionID, err := uuid.NewV4()
+	if err != nil {
+		return nil, errors.Wrap(err, "pay with gift card")
+	}
+
+	entry := GiftCardEntry{
+		GiftCardID:    card.ID,
+		Kind:          GiftCardEntryRedeem,
+		Amount:        -amount,
+		OrderID:       sql.NullInt64{Int64: int64(order.ID), Valid: true},
+		TransactionID: transactionID.String(),
+		CreatedAt:     time.Now(),
+	}
+	payment := Payment{
+		Amount:        amount,
+		OrderID:       order.ID,
+		TransactionID: entry.TransactionID,
+		Date:          entry.CreatedAt,
+		CardLast4:     lastFour(card.Code),
+		Method:        PaymentMethodGiftCard,
+	}
+	if err := app.DB.RedeemGiftCard(&entry, &payment, order); err != nil {
+		return nil, errors.Wrap(err, "pay with gift card")
+	}
+	if entry.Amount == 0 {
+		// the card or the order was used up by a payment made at the same time
+		card, err := app.GetGiftCard(code)
+		if err != nil {
+			return nil, errors.Wrap(err, "pay with gift card")
+		}
+		if card.Balance == 0 {
+			return nil, errors.Wrap(GiftCardError{Reason: "this gift card has no balance left"}, "pay with gift card")
+		}
+		return nil, errors.Wrap(GiftCardError{Reason: "there is nothing left to pay on this order"}, "pay with gift card")
+	}
+
+	app.paymentSaved(&payment, order)
+	return &payment, nil
+}
+
+// RefundGiftCardPayment will put a gift card payment back on the card it came from and take the payment off its order
+// with a negative payment, so the order has that much left to pay again. The loyalty points earned on the payment are
+// taken back.
+func (app AppContext) RefundGiftCardPayment(transactionID string) error {
+	redemption, err := app.DB.GetGiftCardEntryByTransactionID(transactionID)
+	if err != nil {
+		return errors.Wrap(err, "refund gift card payment")
+	}
+	if redemption == nil || redemption.Kind != GiftCardEntryRedeem {
+		return errors.Wrap(GiftCardError{Reason: "gift card payment not found"}, "refund gift card payment")
+	}
+
+	refund := GiftCardEntry{
+		GiftCardID:    redemption.GiftCardID,
+		Kind:          GiftCardEntryRefund,
+		Amount:        -redemption.Amount,
+		OrderID:       redemption.OrderID,
+		TransactionID: "refund-" + transactionID,
+		CreatedAt:     time.Now(),
+	}
+	refundPayment := Payment{
+		Amount:        redemption.Amount,
+		OrderID:       DatabaseID(redemption.OrderID.Int64),
+		TransactionID: refund.TransactionID,
+		Date:          refund.CreatedAt,
+		Method:        PaymentMethodGiftCard,
+	}
+	if err := app.DB.InsertGiftCardRefund(&refund, &refundPayment); err != nil {
+		return errors.Wrap(err, "refund gift card payment")
+	}
+
+	// the refund has been made, so failing to take back points shouldn't fail it
+	if err := app.ReverseLoyaltyPointsForPayment(transactionID, "payment refunded"); err != nil {
+		log.Println(errors.Wrap(err, "refund gift card payment"))
+	}
+	return nil
+}
+
+// amountPaid is the total of payments on an order, not including tips
+func amountPaid(payments []Payment) int {
+	paid := 0
+	for _, payment := range payments {
+		paid += payment.Amount
+	}
+	return paid
+}
+
+func newGiftCardCode() (string, error) {
+	random := make([]byte, giftCardCodeLength)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	code := make([]byte, giftCardCodeLength)
+	for i, b := range random {
+		code[i] = giftCardCodeAlphabet[int(b)%len(giftCardCodeAlphabet)]
+	}
+	return string(code), nil
+}
+
+func normalizeGiftCardCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
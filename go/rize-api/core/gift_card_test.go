@@ -0,0 +1,193 @@
+package core_test
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"notify"
+)
+
+func TestIssueGiftCard(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+
+	// act
+	card, err := app.IssueGiftCard(5000)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 16, len(card.Code))
+
+	// codes can be typed in lower case and with dashes
+	found, findErr := app.GetGiftCard(card.Code[:4] + "-" + strings.ToLower(card.Code[4:]))
+	assert.NoError(t, findErr)
+	assert.Equal(t, 5000, found.Balance)
+
+	history, _ := app.GetGiftCardHistory(card.Code)
+	assert.Equal(t, 1, len(*history))
+	assert.Equal(t, core.GiftCardEntryIssue, (*history)[0].Kind)
+	assert.Equal(t, 5000, (*history)[0].Amount)
+}
+
+func TestSplitPaymentWithGiftCardAndCard(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	mailer := &notify.FakeMailer{}
+	app.Mailer = mailer
+
+	customer := createTestCustomer(app)
+	order := insertPickupOrder(t, app)
+	card, _ := app.IssueGiftCard(1000)
+
+	// act
+	giftCardPayment, giftCardErr := app.PayWithGiftCard(card.Code, order, 1000)
+	sentAfterGiftCard := len(mailer.Sent)
+	balance, _ := app.GetOrderBalance(order.ID)
+
+	cardPayment := core.Payment{
+		Amount:     balance,
+		Tip:        200,
+		OrderID:    order.ID,
+		Date:       time.Now(),
+		CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true},
+		CardLast4:  "4242",
+	}
+	cardErr := app.SavePayment(&cardPayment, order)
+
+	// assert
+	assert.NoError(t, giftCardErr)
+	assert.Equal(t, 1000, giftCardPayment.Amount)
+	assert.Equal(t, core.PaymentMethodGiftCard, giftCardPayment.Method)
+	assert.Equal(t, 500, balance)
+	assert.Equal(t, 0, sentAfterGiftCard)
+
+	assert.NoError(t, cardErr)
+	assert.Equal(t, 1, len(mailer.Sent))
+	receipt := mailer.Sent[0].Data.(*core.Receipt)
+	assert.Equal(t, core.Cents(1000), receipt.GiftCard)
+	assert.Equal(t, core.Cents(1700), receipt.Total)
+	assert.Equal(t, "4242", receipt.CardLast4)
+
+	spent, _ := app.GetGiftCard(card.Code)
+	assert.Equal(t, 0, spent.Balance)
+}
+
+func TestPartlyPaidOrderIsStillPayable(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	order := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusPending, Total: 1500}
+	app.TestInsertOrder(t, &order)
+	card, _ := app.IssueGiftCard(600)
+
+	// act
+	payment, err := app.PayWithGiftCard(card.Code, &order, 1500)
+	payable, _ := app.IsOrderPayable(order)
+
+	// assert
+	assert.NoError(t, err)
+	// the payment is capped at the card's balance
+	assert.Equal(t, 600, payment.Amount)
+	assert.True(t, payable)
+
+	_, emptyErr := app.PayWithGiftCard(card.Code, &order, 100)
+	assert.IsType(t, core.GiftCardError{}, errors.Cause(emptyErr))
+}
+
+func TestRefundGiftCardPayment(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	order := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusPending, Total: 1500}
+	app.TestInsertOrder(t, &order)
+	card, _ := app.IssueGiftCard(2000)
+	payment, _ := app.PayWithGiftCard(card.Code, &order, 1500)
+	paidBalance, _ := app.GetOrderBalance(order.ID)
+
+	// act
+	err := app.RefundGiftCardPayment(payment.TransactionID)
+	secondErr := app.RefundGiftCardPayment(payment.TransactionID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Error(t, secondErr)
+
+	refunded, _ := app.GetGiftCard(card.Code)
+	assert.Equal(t, 2000, refunded.Balance)
+
+	// the order has to be paid again
+	balance, _ := app.GetOrderBalance(order.ID)
+	assert.Equal(t, 0, paidBalance)
+	assert.Equal(t, 1500, balance)
+
+	history, _ := app.GetGiftCardHistory(card.Code)
+	assert.Equal(t, []string{core.GiftCardEntryIssue, core.GiftCardEntryRedeem, core.GiftCardEntryRefund}, giftCardEntryKinds(*history))
+
+	// the payment is kept and taken off by the refund
+	payments, _ := app.DB.SelectPaymentsByOrderIDs([]core.DatabaseID{order.ID})
+	assert.Equal(t, 2, len(payments[order.ID]))
+}
+
+func TestRefundGiftCardPaymentReversesLoyaltyPoints(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	app.UpdateLoyaltyRule(core.LoyaltyRule{SiteID: core.TestSitePosID, CentsPerPoint: 100})
+	order := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusPending, Total: 1500, CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true}}
+	app.TestInsertOrder(t, &order)
+	card, _ := app.IssueGiftCard(2000)
+	payment, _ := app.PayWithGiftCard(card.Code, &order, 1500)
+	earned, _ := app.GetLoyaltyBalance(customer.ID)
+
+	// act
+	err := app.RefundGiftCardPayment(payment.TransactionID)
+
+	// assert
+	assert.NoError(t, err)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 15, earned)
+	assert.Equal(t, 0, balance)
+}
+
+func TestRedeemGiftCardOnlyPaysWhatIsLeftOnTheOrder(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	order := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusPending, Total: 1500}
+	app.TestInsertOrder(t, &order)
+	first, _ := app.IssueGiftCard(2000)
+	second, _ := app.IssueGiftCard(2000)
+	app.PayWithGiftCard(first.Code, &order, 1000)
+
+	// a redemption that checked the order balance before the first payment was saved
+	entry := core.GiftCardEntry{GiftCardID: second.ID, Kind: core.GiftCardEntryRedeem, Amount: -1500, TransactionID: "late", CreatedAt: time.Now()}
+	payment := core.Payment{Amount: 1500, OrderID: order.ID, TransactionID: "late", Date: time.Now(), Method: core.PaymentMethodGiftCard}
+
+	// act
+	err := app.DB.RedeemGiftCard(&entry, &payment, &order)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 500, payment.Amount)
+	balance, _ := app.GetOrderBalance(order.ID)
+	assert.Equal(t, 0, balance)
+}
+
+// helpers
+
+func giftCardEntryKinds(entries []core.GiftCardEntry) []string {
+	kinds := []string{}
+	for _, entry := range entries {
+		kinds = append(kinds, entry.Kind)
+	}
+	return kinds
+}
@@ -32,6 +32,19 @@ type Kounta interface {
 	DeleteLineItem(orderID KountaID, lineID KountaID) error
 }
 
+// KountaPriceLineCreator is implemented by Kounta clients that can create an order with fee and discount lines. Orders
+// with fees, a promo code or a reward are refused when the client can't, so their total is always what the customer
+// was quoted.
+type KountaPriceLineCreator interface {
+	CreateOrderWithPriceLines(siteID KountaID, newOrder CreateOrder, lines []PriceLine) (KountaOrder, error)
+}
+
+// KountaPriceLineAdder is implemented by Kounta clients that can add fee lines to an existing order. Pickup times are
+// refused at sites with a packaging fee when the client can't.
+type KountaPriceLineAdder interface {
+	AddPriceLinesToOrder(orderID KountaID, lines []PriceLine) (KountaOrder, error)
+}
+
 type KountaOrder interface {
 	GetPosID() KountaID
 	GetStatus() string
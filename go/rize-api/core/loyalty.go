@@ -0,0 +1,279 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	LoyaltyEntryEarn     = "earn"
+	LoyaltyEntryRedeem   = "redeem"
+	LoyaltyEntryReversal = "reversal"
+)
+
+// LoyaltyRule is how guests earn points at a site. Payments at sites without a rule don't earn points.
+type LoyaltyRule struct {
+	SiteID        KountaID `json:"site_id"`
+	CentsPerPoint int      `json:"cents_per_point"`
+	MinimumSpend  int      `json:"minimum_spend"` // payments under MinimumSpend cents, before tip, earn nothing
+}
+
+// LoyaltyReward is a discount a customer can spend points on when creating an order
+type LoyaltyReward struct {
+	ID       DatabaseID `json:"id"`
+	Name     string     `json:"name"`
+	Points   int        `json:"points"`
+	Discount int        `json:"discount"` // Discount is taken off the order total in cents, including tax
+	Active   bool       `json:"active"`
+}
+
+// LoyaltyEntry is a change to a customer's points. Entries are never updated or deleted, a mistake is corrected by
+// adding a reversal entry, so a customer's balance is always the sum of their entries.
+type LoyaltyEntry struct {
+	ID          DatabaseID    `json:"id"`
+	CustomerID  DatabaseID    `json:"-"`
+	OrderID     sql.NullInt64 `json:"-"`
+	Kind        string        `json:"kind"`
+	Points      int           `json:"points"`
+	Description string        `json:"description"`
+	ReversalOf  sql.NullInt64 `json:"-"`
+	// TransactionID is the payment points were earned on, and is kept on the entry reversing them
+	TransactionID string    `json:"-"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// LoyaltyError is returned when a reward can't be redeemed or a rule or reward is invalid
+type LoyaltyError struct {
+	Reason string
+}
+
+func (e LoyaltyError) Error() string {
+	return e.Reason
+}
+
+// UpdateLoyaltyRule will validate and save how points are earned at a site
+func (app AppContext) UpdateLoyaltyRule(rule LoyaltyRule) error {
+	if rule.CentsPerPoint <= 0 {
+		return errors.Wrap(LoyaltyError{Reason: "cents per point must be positive"}, "update loyalty rule")
+	}
+	if rule.MinimumSpend < 0 {
+		return errors.Wrap(LoyaltyError{Reason: "minimum spend can't be negative"}, "update loyalty rule")
+	}
+
+	if err := app.DB.UpsertLoyaltyRule(&rule); err != nil {
+		return errors.Wrap(err, "update loyalty rule")
+	}
+	return nil
+}
+
+// AddLoyaltyReward will validate and save a new reward
+func (app AppContext) AddLoyaltyReward(reward *LoyaltyReward) error {
+	if reward.Points <= 0 || reward.Discount <= 0 {
+		return errors.Wrap(LoyaltyError{Reason: "a reward needs a positive points cost and discount"}, "add loyalty reward")
+	}
+
+	reward.Active = true
+	if err := app.DB.InsertLoyaltyReward(reward); err != nil {
+		return errors.Wrap(err, "add loyalty reward")
+	}
+	return nil
+}
+
+// ListLoyaltyRewards will return the rewards customers can currently redeem
+func (app AppContext) ListLoyaltyRewards() (*[]LoyaltyReward, error) {
+	rewards, err := app.DB.SelectActiveLoyaltyRewards()
+	if err != nil {
+		return nil, errors.Wrap(err, "list loyalty rewards")
+	}
+	return rewards, nil
+}
+
+// GetLoyaltyBalance will return a customer's points. It can be negative if points that were already spent have been
+// reversed.
+func (app AppContext) GetLoyaltyBalance(customerID DatabaseID) (int, error) {
+	balance, err := app.DB.GetLoyaltyBalance(customerID)
+	if err != nil {
+		return 0, errors.Wrap(err, "get loyalty balance")
+	}
+	return balance, nil
+}
+
+// GetLoyaltyHistory will return every change to a customer's points, newest first
+func (app AppContext) GetLoyaltyHistory(customerID DatabaseID) (*[]LoyaltyEntry, error) {
+	entries, err := app.DB.SelectLoyaltyEntriesByCustomerID(customerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get loyalty history")
+	}
+	return entries, nil
+}
+
+// ReverseLoyaltyPoints will undo the points earned and redeemed on an order. It is called automatically when an order
+// is rejected. Entries that were already reversed are skipped.
+func (app AppContext) ReverseLoyaltyPoints(orderID DatabaseID, reason string) error {
+	entries, err := app.DB.SelectLoyaltyEntriesByOrderID(orderID)
+	if err != nil {
+		return errors.Wrapf(err, "reverse loyalty points for order %d", orderID)
+	}
+	if err := app.reverseLoyaltyEntries(*entries, reason); err != nil {
+		return errors.Wrapf(err, "reverse loyalty points for order %d", orderID)
+	}
+	return nil
+}
+
+// ReverseLoyaltyPointsForPayment will undo the points earned on one payment, and should be called when the payment is
+// refunded. Points redeemed on the order are left alone. Points that were already reversed are skipped.
+func (app AppContext) ReverseLoyaltyPointsForPayment(transactionID string, reason string) error {
+	entries, err := app.DB.SelectLoyaltyEntriesByTransactionID(transactionID)
+	if err != nil {
+		return errors.Wrapf(err, "reverse loyalty points for payment %s", transactionID)
+	}
+	if err := app.reverseLoyaltyEntries(*entries, reason); err != nil {
+		return errors.Wrapf(err, "reverse loyalty points for payment %s", transactionID)
+	}
+	return nil
+}
+
+// reverseLoyaltyEntries will reverse each of entries that isn't a reversal or already reversed by one of entries
+func (app AppContext) reverseLoyaltyEntries(entries []LoyaltyEntry, reason string) error {
+	reversed := map[int64]bool{}
+	for _, entry := range entries {
+		if entry.ReversalOf.Valid {
+			reversed[entry.ReversalOf.Int64] = true
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.ReversalOf.Valid || reversed[int64(entry.ID)] {
+			continue
+		}
+		if err := app.reverseLoyaltyEntry(entry, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// earnLoyaltyPoints will credit the customer who made a payment with points under the site's rule
+func (app AppContext) earnLoyaltyPoints(payment *Payment, order *Order) error {
+	customerID := payment.CustomerID
+	if !customerID.Valid {
+		customerID = order.CustomerID
+	}
+	if !customerID.Valid {
+		return nil
+	}
+
+	rule, err := app.DB.GetLoyaltyRule(order.SiteID)
+	if err != nil {
+		return errors.Wrap(err, "earn loyalty points")
+	}
+	if rule == nil || payment.Amount < rule.MinimumSpend {
+		return nil
+	}
+
+	points := payment.Amount / rule.CentsPerPoint
+	if points == 0 {
+		return nil
+	}
+
+	err = app.DB.InsertLoyaltyEntry(&LoyaltyEntry{
+		CustomerID:    DatabaseID(customerID.Int64),
+		OrderID:       sql.NullInt64{Int64: int64(order.ID), Valid: true},
+		Kind:          LoyaltyEntryEarn,
+		Points:        points,
+		Description:   fmt.Sprintf("Earned on order %d", order.ID),
+		TransactionID: payment.TransactionID,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "earn loyalty points")
+	}
+	return nil
+}
+
+// redeemLoyaltyReward will spend the points for the reward on a new order, if there is one, and add its discount to
+// the order. The returned entry is not linked to an order until the order has been created.
+func (app AppContext) redeemLoyaltyReward(siteID KountaID, createOrder *CreateOrder) (*LoyaltyEntry, error) {
+	if createOrder.RewardID == 0 {
+		return nil, nil
+	}
+	if createOrder.CustomerID == 0 {
+		return nil, errors.Wrap(LoyaltyError{Reason: "sign in to redeem a reward"}, "redeem loyalty reward")
+	}
+
+	reward, err := app.DB.GetLoyaltyReward(createOrder.RewardID)
+	if err != nil {
+		return nil, errors.Wrap(err, "redeem loyalty reward")
+	}
+	if reward == nil || !reward.Active {
+		return nil, errors.Wrap(LoyaltyError{Reason: "this reward is no longer available"}, "redeem loyalty reward")
+	}
+
+	subtotal, err := app.orderSubtotal(siteID, *createOrder)
+	if err != nil {
+		return nil, errors.Wrap(err, "redeem loyalty reward")
+	}
+	discount := rewardDiscount(*reward, subtotal, createOrder.DiscountTotal())
+	if discount == 0 {
+		return nil, errors.Wrap(LoyaltyError{Reason: "there is nothing on this order for " + reward.Name + " to take off"}, "redeem loyalty reward")
+	}
+
+	entry := LoyaltyEntry{
+		CustomerID:  createOrder.CustomerID,
+		Kind:        LoyaltyEntryRedeem,
+		Points:      -reward.Points,
+		Description: "Redeemed " + reward.Name,
+		CreatedAt:   time.Now(),
+	}
+	redeemed, err := app.DB.InsertLoyaltyRedemption(&entry)
+	if err != nil {
+		return nil, errors.Wrap(err, "redeem loyalty reward")
+	}
+	if !redeemed {
+		return nil, errors.Wrap(LoyaltyError{Reason: "not enough points for " + reward.Name}, "redeem loyalty reward")
+	}
+
+	createOrder.Discounts = append(createOrder.Discounts, OrderDiscount{Name: reward.Name, Amount: discount})
+	return &entry, nil
+}
+
+// rewardDiscount is the reward's discount on an order, which can't take off more than is left of the order's subtotal
+// after its other discounts
+func rewardDiscount(reward LoyaltyReward, subtotal, discounted int) int {
+	discount := reward.Discount
+	if remaining := subtotal - discounted; discount > remaining {
+		discount = remaining
+	}
+	if discount < 0 {
+		return 0
+	}
+	return discount
+}
+
+// reverseLoyaltyIfRejected will give back any points redeemed, and take back any points earned, on a rejected order
+func (app AppContext) reverseLoyaltyIfRejected(existingOrder, updatedOrder *Order) {
+	if existingOrder.Status == OrderStatusRejected || updatedOrder.Status != OrderStatusRejected {
+		return
+	}
+
+	if err := app.ReverseLoyaltyPoints(existingOrder.ID, "order rejected"); err != nil {
+		log.Println(err)
+	}
+}
+
+func (app AppContext) reverseLoyaltyEntry(entry LoyaltyEntry, reason string) error {
+	return app.DB.InsertLoyaltyEntry(&LoyaltyEntry{
+		CustomerID:    entry.CustomerID,
+		OrderID:       entry.OrderID,
+		Kind:          LoyaltyEntryReversal,
+		Points:        -entry.Points,
+		Description:   "Reversed " + entry.Kind + ": " + reason,
+		ReversalOf:    sql.NullInt64{Int64: int64(entry.ID), Valid: true},
+		TransactionID: entry.TransactionID,
+		CreatedAt:     time.Now(),
+	})
+}
@@ -0,0 +1,244 @@
+package core_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+// orderKounta records the new orders it creates and the discount and fee lines added to them
+type orderKounta struct {
+	*pos.MockKounta
+	orders []core.CreateOrder
+	lines  []core.PriceLine
+}
+
+func (k *orderKounta) CreateOrder(siteID core.KountaID, newOrder core.CreateOrder) (core.KountaOrder, error) {
+	k.orders = append(k.orders, newOrder)
+	return pos.NewMockKountaOrder(), nil
+}
+
+func (k *orderKounta) CreateOrderWithPriceLines(siteID core.KountaID, newOrder core.CreateOrder, lines []core.PriceLine) (core.KountaOrder, error) {
+	k.lines = append(k.lines, lines...)
+	return k.CreateOrder(siteID, newOrder)
+}
+
+func (k *orderKounta) AddPriceLinesToOrder(orderID core.KountaID, lines []core.PriceLine) (core.KountaOrder, error) {
+	k.lines = append(k.lines, lines...)
+	return k.GetOrderByID(orderID)
+}
+
+func TestSavePaymentEarnsLoyaltyPoints(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	app.UpdateLoyaltyRule(core.LoyaltyRule{SiteID: core.TestSitePosID, CentsPerPoint: 100})
+
+	order := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusPending, CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true}}
+	app.TestInsertOrder(t, &order)
+
+	// act
+	err := app.SavePayment(&core.Payment{Amount: 1550, Tip: 300, OrderID: order.ID, Date: time.Now()}, &order)
+
+	// assert
+	assert.NoError(t, err)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 15, balance)
+	history, _ := app.GetLoyaltyHistory(customer.ID)
+	assert.Equal(t, 1, len(*history))
+	assert.Equal(t, core.LoyaltyEntryEarn, (*history)[0].Kind)
+}
+
+func TestCreateNewOrderRedeemsReward(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	customer := createTestCustomer(app)
+	reward := giveLoyaltyPoints(t, app, customer.ID, 20)
+	createOrder := pricedPromoOrder(t, app)
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+
+	// act
+	order, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []core.PriceLine{{Name: "Free Coffee", Amount: -450}}, kounta.lines)
+	assert.Equal(t, 450, order.Discount)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 5, balance)
+	entries, _ := app.DB.SelectLoyaltyEntriesByOrderID(order.ID)
+	assert.Equal(t, 1, len(*entries))
+	assert.Equal(t, -15, (*entries)[0].Points)
+}
+
+func TestCreateNewOrderWithoutEnoughPointsFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	customer := createTestCustomer(app)
+	reward := giveLoyaltyPoints(t, app, customer.ID, 10)
+	createOrder := pricedPromoOrder(t, app)
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.IsType(t, core.LoyaltyError{}, errors.Cause(err))
+	assert.Equal(t, 0, len(kounta.orders))
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 10, balance)
+}
+
+func TestRejectedOrderReversesLoyaltyPoints(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &orderKounta{MockKounta: &pos.MockKounta{}}
+	customer := createTestCustomer(app)
+	reward := giveLoyaltyPoints(t, app, customer.ID, 20)
+	app.UpdateLoyaltyRule(core.LoyaltyRule{SiteID: core.TestSitePosID, CentsPerPoint: 100})
+	createOrder := pricedPromoOrder(t, app)
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+
+	order, _ := app.CreateNewOrder(core.TestSitePosID, createOrder)
+	app.SavePayment(&core.Payment{Amount: 1000, OrderID: order.ID, Date: time.Now(), CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true}}, order)
+
+	rejectedOrder := pos.NewMockKountaOrder()
+	rejectedOrder.Status = core.OrderStatusRejected
+
+	// act
+	_, err := app.CreateOrUpdateOrderFromKounta(rejectedOrder)
+
+	// assert
+	assert.NoError(t, err)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 20, balance)
+
+	// reversing again, e.g. when the payment is also refunded, doesn't reverse twice
+	assert.NoError(t, app.ReverseLoyaltyPoints(order.ID, "payment refunded"))
+	balance, _ = app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 20, balance)
+}
+
+func TestReverseLoyaltyPointsForPayment(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	giveLoyaltyPoints(t, app, customer.ID, 20)
+	app.UpdateLoyaltyRule(core.LoyaltyRule{SiteID: core.TestSitePosID, CentsPerPoint: 100})
+
+	order := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusPending, CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true}}
+	app.TestInsertOrder(t, &order)
+	app.SavePayment(&core.Payment{Amount: 1000, OrderID: order.ID, TransactionID: "refunded", Date: time.Now()}, &order)
+	app.SavePayment(&core.Payment{Amount: 500, OrderID: order.ID, TransactionID: "kept", Date: time.Now()}, &order)
+
+	// act
+	err := app.ReverseLoyaltyPointsForPayment("refunded", "payment refunded")
+	againErr := app.ReverseLoyaltyPointsForPayment("refunded", "payment refunded")
+
+	// assert
+	assert.NoError(t, err)
+	assert.NoError(t, againErr)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 25, balance)
+}
+
+func TestCreateNewOrderCapsRewardAtSubtotal(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	customer := createTestCustomer(app)
+	createOrder := pricedPromoOrder(t, app)
+	app.DB.InsertLoyaltyEntry(&core.LoyaltyEntry{CustomerID: customer.ID, Kind: core.LoyaltyEntryEarn, Points: 100, CreatedAt: time.Now()})
+	reward := core.LoyaltyReward{Name: "Free Dinner", Points: 50, Discount: 5000}
+	app.AddLoyaltyReward(&reward)
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+
+	// act
+	order, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []core.PriceLine{{Name: "Free Dinner", Amount: -2500}}, kounta.lines)
+	assert.Equal(t, 2500, order.Discount)
+}
+
+func TestCreateNewOrderCapsPromoAndRewardAtSubtotal(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	customer := createTestCustomer(app)
+	createOrder := pricedPromoOrder(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "save10", DiscountType: core.PromoDiscountPercent, DiscountValue: 10, CategoryIDs: []core.KountaID{core.TestCategory1PosID}})
+	app.DB.InsertLoyaltyEntry(&core.LoyaltyEntry{CustomerID: customer.ID, Kind: core.LoyaltyEntryEarn, Points: 100, CreatedAt: time.Now()})
+	reward := core.LoyaltyReward{Name: "Free Dinner", Points: 50, Discount: 5000}
+	app.AddLoyaltyReward(&reward)
+	createOrder.PromoCode = "SAVE10"
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+
+	// act
+	order, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []core.PriceLine{{Name: "Promo SAVE10", Amount: -200}, {Name: "Free Dinner", Amount: -2300}}, kounta.lines)
+	assert.Equal(t, 2500, order.Discount)
+}
+
+func TestCreateNewOrderWithRewardNeedsDiscountLines(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pos.MockKounta{}
+	customer := createTestCustomer(app)
+	reward := giveLoyaltyPoints(t, app, customer.ID, 20)
+	createOrder := pricedPromoOrder(t, app)
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.Error(t, err)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 20, balance)
+}
+
+// helpers
+
+// giveLoyaltyPoints credits a customer with points and returns a 15 point reward
+func giveLoyaltyPoints(t *testing.T, app core.AppContext, customerID core.DatabaseID, points int) *core.LoyaltyReward {
+	err := app.DB.InsertLoyaltyEntry(&core.LoyaltyEntry{CustomerID: customerID, Kind: core.LoyaltyEntryEarn, Points: points, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reward := core.LoyaltyReward{Name: "Free Coffee", Points: 15, Discount: 450}
+	if err := app.AddLoyaltyReward(&reward); err != nil {
+		t.Fatal(err)
+	}
+	return &reward
+}
@@ -1,8 +1,24 @@
 package core
 
 import (
+	"database/sql"
 	"log"
 	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	LRSStateStarted = "started"
+	LRSStateLocated = "located"
+	LRSStatePaged   = "paged"
+	LRSStateCleared = "cleared"
+
+	LRSOutcomeProcessed = "processed"
+	LRSOutcomeDuplicate = "duplicate"
+	LRSOutcomeStale     = "stale"
+	LRSOutcomeFailed    = "failed"
 )
 
 type LRSEvent struct {
@@ -15,28 +31,222 @@ type LRSEvent struct {
 	Paged       bool   `json:"paged"`
 }
 
-func (app AppContext) HandleLRSEvent(siteID KountaID, lrsEvent LRSEvent) {
-	pagerNumber, err := strconv.ParseInt(lrsEvent.PagerNumber, 10, 64)
+// LRSEventRecord is an LRS event as it was received, kept so events can be replayed
+type LRSEventRecord struct {
+	ID     DatabaseID `json:"id"`
+	SiteID KountaID   `json:"site_id"`
+	LRSEvent
+	ReceivedAt time.Time `json:"received_at"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error"`
+}
+
+// PagerSession is one use of a pager, from LRS starting to track it until it is cleared. LRS gives each session its
+// own UUID and counts ElapsedTime from the start of the session, so an event with a lower ElapsedTime than the last
+// one processed arrived out of order.
+type PagerSession struct {
+	ID          DatabaseID    `json:"id"`
+	SiteID      KountaID      `json:"site_id"`
+	PagerNumber int64         `json:"pager_number"`
+	UUID        string        `json:"uuid"`
+	State       string        `json:"state"`
+	ElapsedTime uint          `json:"elapsed_time"`
+	OrderID     sql.NullInt64 `json:"-"`
+	TableName   string        `json:"table_name"`
+	StartedAt   time.Time     `json:"started_at"`
+	PagedAt     *time.Time    `json:"paged_at"`
+	ClearedAt   *time.Time    `json:"cleared_at"`
+}
+
+// LRSError is returned for LRS events that can never be processed
+type LRSError struct {
+	Reason string
+}
+
+func (e LRSError) Error() string {
+	return e.Reason
+}
+
+// HandleLRSEvent will save an LRS event and then process it. Events for a pager session that was already cleared or
+// replaced by a newer session, or that are older than the last event processed for their session, are saved as stale
+// and otherwise ignored. LRS sends a clear on its own some time after a session starts, so clearing only closes the
+// session and never changes its order.
+func (app AppContext) HandleLRSEvent(siteID KountaID, lrsEvent LRSEvent) error {
+	record := LRSEventRecord{SiteID: siteID, LRSEvent: lrsEvent, ReceivedAt: time.Now()}
+	if err := app.DB.InsertLRSEvent(&record); err != nil {
+		return errors.Wrapf(err, "handle lrs event for pager %s", lrsEvent.PagerNumber)
+	}
+
+	if err := app.processLRSEventRecord(record); err != nil {
+		return errors.Wrapf(err, "handle lrs event for pager %s", lrsEvent.PagerNumber)
+	}
+	return nil
+}
+
+// ReplayLRSEvents will process a site's saved LRS events received in [from, to) again, in the order they were
+// received, like after fixing whatever made them fail. Events that were already processed come back as duplicate or
+// stale, so replaying is safe. It returns the number of events replayed and the first error, after replaying the rest.
+func (app AppContext) ReplayLRSEvents(siteID KountaID, from, to time.Time) (int, error) {
+	records, err := app.DB.SelectLRSEvents(siteID, from, to)
 	if err != nil {
-		log.Println(err)
-		return
+		return 0, errors.Wrapf(err, "replay lrs events for site %d", siteID)
 	}
 
-	switch lrsEvent.State {
-	case "started":
-		_, err := app.CreateOrderForPager(siteID, pagerNumber)
-		if err != nil {
-			log.Println(err)
-			return
+	var firstErr error
+	for _, record := range *records {
+		if err := app.processLRSEventRecord(record); err != nil && firstErr == nil {
+			firstErr = errors.Wrapf(err, "replay lrs event %d", record.ID)
 		}
+	}
+	return len(*records), firstErr
+}
+
+// GetPagerSession will return the pager session LRS tracks with a UUID
+func (app AppContext) GetPagerSession(uuid string) (*PagerSession, error) {
+	session, err := app.DB.GetPagerSessionByUUID(uuid)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pager session")
+	}
+	if session == nil {
+		return nil, errors.Wrap(LRSError{Reason: "pager session not found"}, "get pager session")
+	}
+	return session, nil
+}
+
+// processLRSEventRecord processes an event and saves how that went. A replayed event keeps its processed outcome.
+func (app AppContext) processLRSEventRecord(record LRSEventRecord) error {
+	outcome, err := app.processLRSEvent(record.SiteID, record.LRSEvent, record.ReceivedAt)
+	if err == nil && record.Outcome == LRSOutcomeProcessed {
+		return nil
+	}
 
-	case "located":
-		err := app.LinkOrderWithTable(siteID, pagerNumber, lrsEvent.TableName)
+	message := ""
+	if err != nil {
+		outcome = LRSOutcomeFailed
+		message = err.Error()
+	}
+	if updateErr := app.DB.UpdateLRSEventOutcome(record.ID, outcome, message); updateErr != nil && err == nil {
+		err = updateErr
+	}
+	return err
+}
+
+func (app AppContext) processLRSEvent(siteID KountaID, event LRSEvent, receivedAt time.Time) (string, error) {
+	pagerNumber, err := strconv.ParseInt(event.PagerNumber, 10, 64)
+	if err != nil {
+		return "", LRSError{Reason: "invalid pager number " + event.PagerNumber}
+	}
+	if event.UUID == "" {
+		return "", LRSError{Reason: "lrs event has no uuid"}
+	}
+	switch event.State {
+	case LRSStateStarted, LRSStateLocated, LRSStatePaged, LRSStateCleared:
+	default:
+		return "", LRSError{Reason: "unknown lrs state " + event.State}
+	}
+
+	session, err := app.DB.GetPagerSessionByUUID(event.UUID)
+	if err != nil {
+		return "", err
+	}
+
+	started := false
+	if session == nil && event.State != LRSStateCleared {
+		// a session can be seen for the first time after it has started when its started event is lost or late
+		session, started, err = app.startPagerSession(siteID, pagerNumber, event, receivedAt)
 		if err != nil {
-			log.Println(err)
-			return
+			return "", err
+		}
+		if started && event.State == LRSStateStarted {
+			return LRSOutcomeProcessed, nil
+		}
+	}
+
+	if session == nil {
+		// there is nothing to clear for a session that was never seen
+		return LRSOutcomeStale, nil
+	}
+	if !started {
+		if session.SiteID != siteID || session.PagerNumber != pagerNumber {
+			return "", LRSError{Reason: "uuid " + event.UUID + " belongs to another pager"}
+		}
+		if session.ClearedAt != nil || event.ElapsedTime < session.ElapsedTime {
+			return LRSOutcomeStale, nil
+		}
+		if event.State == LRSStateStarted || (event.State == session.State && event.ElapsedTime == session.ElapsedTime && event.TableName == session.TableName) {
+			return LRSOutcomeDuplicate, nil
+		}
+	}
+
+	switch event.State {
+	case LRSStateLocated:
+		if event.TableName != session.TableName {
+			if err := app.LinkOrderWithTable(siteID, pagerNumber, event.TableName); err != nil {
+				return "", err
+			}
+			session.TableName = event.TableName
+		}
+	case LRSStatePaged:
+		session.PagedAt = &receivedAt
+	case LRSStateCleared:
+		session.ClearedAt = &receivedAt
+	}
+
+	session.State = event.State
+	session.ElapsedTime = event.ElapsedTime
+	if err := app.DB.UpdatePagerSession(session); err != nil {
+		return "", err
+	}
+	return LRSOutcomeProcessed, nil
+}
+
+// startPagerSession claims a new pager session, creates its order and clears any older sessions still open on the
+// pager, so their late events are stale. When another event for the session claimed it first, that session is
+// returned and started is false. A session whose order can't be created is removed again, so the event can be
+// replayed.
+func (app AppContext) startPagerSession(siteID KountaID, pagerNumber int64, event LRSEvent, receivedAt time.Time) (session *PagerSession, started bool, err error) {
+	session = &PagerSession{
+		SiteID:      siteID,
+		PagerNumber: pagerNumber,
+		UUID:        event.UUID,
+		State:       LRSStateStarted,
+		StartedAt:   receivedAt,
+	}
+	started, err = app.DB.InsertPagerSession(session)
+	if err != nil {
+		return nil, false, err
+	}
+	if !started {
+		session, err = app.DB.GetPagerSessionByUUID(event.UUID)
+		return session, false, err
+	}
+
+	order, err := app.CreateOrderForPager(siteID, pagerNumber)
+	if err != nil {
+		if deleteErr := app.DB.DeletePagerSession(session.ID); deleteErr != nil {
+			log.Println(errors.Wrapf(deleteErr, "start pager session %s", event.UUID))
 		}
+		return nil, false, err
+	}
+
+	session.OrderID = sql.NullInt64{Int64: int64(order.ID), Valid: true}
+	if err := app.DB.UpdatePagerSession(session); err != nil {
+		return nil, false, err
+	}
 
-		//no longer handling "cleared" since LRS will automatically send us a cleared after a while and screw things up
+	openSessions, err := app.DB.SelectOpenPagerSessions(siteID, pagerNumber)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, open := range *openSessions {
+		if open.ID == session.ID {
+			continue
+		}
+		open.ClearedAt = &receivedAt
+		open.State = LRSStateCleared
+		if err := app.DB.UpdatePagerSession(&open); err != nil {
+			return nil, false, err
+		}
 	}
+	return session, true, nil
 }
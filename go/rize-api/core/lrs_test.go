@@ -0,0 +1,200 @@
+package core_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+// pagerKounta creates a new Kounta order on each call, on the pager it was asked for
+type pagerKounta struct {
+	*pos.MockKounta
+	created int
+	down    bool
+}
+
+func (k *pagerKounta) CreateOrderForPager(siteID core.KountaID, pagerNumber int64) (core.KountaOrder, error) {
+	if k.down {
+		return nil, errors.New("kounta is down")
+	}
+	k.created++
+	o := pos.NewMockKountaOrder()
+	o.PosID = core.KountaID(1000 + k.created)
+	o.SiteID = siteID
+	o.Table = ""
+	o.PagerNumber = strconv.FormatInt(pagerNumber, 10)
+	return *o, nil
+}
+
+func TestHandleLRSEventFollowsPagerLifecycle(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+
+	// act
+	startErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateStarted, 0, ""))
+	locateErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateLocated, 30, "12"))
+	pageErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStatePaged, 600, "12"))
+	clearErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateCleared, 900, "12"))
+
+	// assert
+	assert.NoError(t, startErr)
+	assert.NoError(t, locateErr)
+	assert.NoError(t, pageErr)
+	assert.NoError(t, clearErr)
+
+	session, _ := app.GetPagerSession("session-1")
+	assert.Equal(t, core.LRSStateCleared, session.State)
+	assert.NotNil(t, session.PagedAt)
+	assert.NotNil(t, session.ClearedAt)
+
+	// clearing the pager doesn't change its order
+	order, _ := app.FindOrderByID(core.DatabaseID(session.OrderID.Int64))
+	assert.Equal(t, "12", order.TableName)
+	assert.Equal(t, "20", order.PagerNumber)
+	assert.Equal(t, []string{"processed", "processed", "processed", "processed"}, lrsOutcomes(t, app))
+}
+
+func TestHandleLRSEventIgnoresOutOfOrderAndLateEvents(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+
+	app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateStarted, 0, ""))
+	app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateLocated, 30, "12"))
+
+	// the first guest pays and the pager goes to the next guest
+	session, _ := app.GetPagerSession("session-1")
+	firstOrder, _ := app.FindOrderByID(core.DatabaseID(session.OrderID.Int64))
+	firstOrder.Status = core.OrderStatusComplete
+	app.UpdateOrder(firstOrder)
+	app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-2", core.LRSStateStarted, 0, ""))
+	app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-2", core.LRSStateLocated, 40, "3"))
+
+	// act
+	// an older location for the second guest arrives late
+	outOfOrderErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-2", core.LRSStateLocated, 20, "4"))
+	// LRS clears the first guest's session on its own
+	lateClearErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateCleared, 3600, "12"))
+	duplicateErr := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-2", core.LRSStateLocated, 40, "3"))
+
+	// assert
+	assert.NoError(t, outOfOrderErr)
+	assert.NoError(t, lateClearErr)
+	assert.NoError(t, duplicateErr)
+
+	secondSession, _ := app.GetPagerSession("session-2")
+	assert.Equal(t, "3", secondSession.TableName)
+	assert.Nil(t, secondSession.ClearedAt)
+	secondOrder, _ := app.FindOrderByID(core.DatabaseID(secondSession.OrderID.Int64))
+	assert.Equal(t, "3", secondOrder.TableName)
+	assert.Equal(t, "20", secondOrder.PagerNumber)
+
+	outcomes := lrsOutcomes(t, app)
+	assert.Equal(t, []string{"stale", "stale", "duplicate"}, outcomes[len(outcomes)-3:])
+}
+
+func TestHandleLRSEventReturnsErrors(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	event := lrsEvent("session-1", core.LRSStateStarted, 0, "")
+	event.PagerNumber = "abc"
+
+	// act
+	err := app.HandleLRSEvent(core.TestSitePosID, event)
+
+	// assert
+	assert.IsType(t, core.LRSError{}, errors.Cause(err))
+	records, _ := app.DB.SelectLRSEvents(core.TestSitePosID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.Equal(t, 1, len(*records))
+	assert.Equal(t, core.LRSOutcomeFailed, (*records)[0].Outcome)
+	assert.Equal(t, "invalid pager number abc", (*records)[0].Error)
+}
+
+func TestReplayLRSEvents(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &pagerKounta{MockKounta: &pos.MockKounta{}, down: true}
+	app.Kounta = kounta
+	app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateStarted, 0, ""))
+	kounta.down = false
+	app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateLocated, 30, "12"))
+	otherPager := lrsEvent("session-2", core.LRSStateStarted, 0, "")
+	otherPager.PagerNumber = "21"
+	app.HandleLRSEvent(core.TestSitePosID, otherPager)
+	outcomesBeforeReplay := lrsOutcomes(t, app)
+
+	// act
+	replayed, err := app.ReplayLRSEvents(core.TestSitePosID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	// assert
+	// session 1 was started by its located event, so its failed started event is now older than the session
+	assert.NoError(t, err)
+	assert.Equal(t, 3, replayed)
+	assert.Equal(t, []string{"failed", "processed", "processed"}, outcomesBeforeReplay)
+	assert.Equal(t, []string{"stale", "processed", "processed"}, lrsOutcomes(t, app))
+	assert.Equal(t, 2, kounta.created)
+}
+
+func TestHandleLRSEventStartsSessionOnce(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &pagerKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = &resentLRSKounta{pagerKounta: kounta, app: &app, event: lrsEvent("session-1", core.LRSStateStarted, 0, "")}
+
+	// act
+	// LRS resends the started event while the first one is still creating the order
+	err := app.HandleLRSEvent(core.TestSitePosID, lrsEvent("session-1", core.LRSStateStarted, 0, ""))
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, kounta.created)
+	assert.Equal(t, []string{"processed", "duplicate"}, lrsOutcomes(t, app))
+	session, _ := app.GetPagerSession("session-1")
+	assert.True(t, session.OrderID.Valid)
+}
+
+// helpers
+
+// resentLRSKounta handles an LRS event again from inside the first event's order creation
+type resentLRSKounta struct {
+	*pagerKounta
+	app    *core.AppContext
+	event  core.LRSEvent
+	resent bool
+}
+
+func (k *resentLRSKounta) CreateOrderForPager(siteID core.KountaID, pagerNumber int64) (core.KountaOrder, error) {
+	if !k.resent {
+		k.resent = true
+		k.app.HandleLRSEvent(siteID, k.event)
+	}
+	return k.pagerKounta.CreateOrderForPager(siteID, pagerNumber)
+}
+
+func lrsEvent(uuid, state string, elapsedTime uint, tableName string) core.LRSEvent {
+	return core.LRSEvent{UUID: uuid, State: state, ElapsedTime: elapsedTime, TableName: tableName, PagerNumber: "20", OrderType: "dine in"}
+}
+
+func lrsOutcomes(t *testing.T, app core.AppContext) []string {
+	records, err := app.DB.SelectLRSEvents(core.TestSitePosID, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outcomes := []string{}
+	for _, record := range *records {
+		outcomes = append(outcomes, record.Outcome)
+	}
+	return outcomes
+}
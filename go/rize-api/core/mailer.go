@@ -1,5 +1,31 @@
 package core
 
+import "time"
+
 type Mailer interface {
 	SendEmail(template, toString, subject string, data interface{}) error
 }
+
+// These are used to indicate the delivery state of an OutboxEmail
+const (
+	OutboxStatusPending = "pending"
+	OutboxStatusSent    = "sent"
+	OutboxStatusFailed  = "failed"
+)
+
+// OutboxEmail is a rendered email saved before delivery so that transient failures can be retried
+type OutboxEmail struct {
+	ID            DatabaseID `json:"id"`
+	Template      string     `json:"template"`
+	From          string     `json:"from"`
+	To            string     `json:"to"`
+	Subject       string     `json:"subject"`
+	HTMLBody      string     `json:"html_body"`
+	TextBody      string     `json:"text_body"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+}
@@ -23,9 +23,10 @@ func testServer(app *core.AppContext) func() {
 		memoryDB.Init()
 
 		*app = core.AppContext{
-			DB:            &memoryDB,
-			Kounta:        &pos.MockKounta{},
-			SiteWhitelist: []int64{29716, 87654},
+			DB:              &memoryDB,
+			Kounta:          &pos.MockKounta{},
+			SiteWhitelist:   []int64{29716, 87654},
+			TokenSigningKey: []byte("test-signing-key"),
 		}
 
 		return func() {}
@@ -41,9 +42,10 @@ func testServer(app *core.AppContext) func() {
 		}
 
 		*app = core.AppContext{
-			DB:            postgresDB,
-			Kounta:        &pos.MockKounta{},
-			SiteWhitelist: []int64{29716, 87654},
+			DB:              postgresDB,
+			Kounta:          &pos.MockKounta{},
+			SiteWhitelist:   []int64{29716, 87654},
+			TokenSigningKey: []byte("test-signing-key"),
 		}
 
 		return func() {
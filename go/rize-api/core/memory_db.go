@@ -1,6 +1,7 @@
 package core
 
 import (
+	"database/sql"
 	"math"
 	"math/rand"
 	"sort"
@@ -12,37 +13,95 @@ import (
 )
 
 type MemoryDB struct {
-	Error           error
-	TableMaps       map[string]TableMap
-	CayanKeyVersion int
-	CayanKey        *Key
-	Tokens          map[DatabaseID]Token
-	Customers       map[DatabaseID]Customer
-	Orders          map[DatabaseID]Order
-	LineCount       int
-	Payments        map[string]Payment
-	Sites           map[DatabaseID]Site
-	Categories      map[DatabaseID]Category
-	MenuItems       map[DatabaseID]MenuItem
-	Modifiers       map[DatabaseID]Modifier
-	OptionSets      map[DatabaseID]OptionSet
+	Error                error
+	TableMaps            map[string]TableMap
+	FloorSections        map[DatabaseID]FloorSection
+	FloorTables          map[DatabaseID]FloorTable
+	BeaconTelemetry      map[string]BeaconTelemetry
+	LRSEvents            map[DatabaseID]LRSEventRecord
+	PagerSessions        map[DatabaseID]PagerSession
+	Pagers               map[DatabaseID]Pager
+	CayanKeyVersion      int
+	CayanKey             *Key
+	Tokens               map[DatabaseID]Token
+	Sessions             map[DatabaseID]Session
+	RefreshTokens        map[DatabaseID]RefreshToken
+	Customers            map[DatabaseID]Customer
+	Connections          map[DatabaseID]CustomerConnection
+	LoginFailures        map[string]LoginFailures
+	LoginNonces          map[string]time.Time
+	Orders               map[DatabaseID]Order
+	LineCount            int
+	Payments             map[string]Payment
+	PaymentLocks         map[DatabaseID]time.Time
+	GiftCards            map[DatabaseID]GiftCard
+	GiftCardEntries      map[DatabaseID]GiftCardEntry
+	SavedCards           map[DatabaseID]SavedCard
+	LoyaltyRules         map[KountaID]LoyaltyRule
+	LoyaltyRewards       map[DatabaseID]LoyaltyReward
+	LoyaltyEntries       map[DatabaseID]LoyaltyEntry
+	PromoCodes           map[DatabaseID]PromoCode
+	PromoRedemptions     map[DatabaseID]PromoRedemption
+	Sites                map[DatabaseID]Site
+	Categories           map[DatabaseID]Category
+	MenuItems            map[DatabaseID]MenuItem
+	Modifiers            map[DatabaseID]Modifier
+	OptionSets           map[DatabaseID]OptionSet
+	PickupSettings       map[KountaID]PickupSettings
+	TipSettings          map[KountaID]TipSettings
+	StaffTips            map[KountaID]StaffTip
+	FeeRules             map[DatabaseID]FeeRule
+	OrderFees            map[DatabaseID][]OrderFee
+	PickupDetails        map[DatabaseID]PickupDetails
+	NotificationSettings map[KountaID]NotificationSettings
+	Notifications        map[DatabaseID]Notification
+	Outbox               map[DatabaseID]OutboxEmail
 }
 
 func (db *MemoryDB) Init() {
 	db.Error = nil
 	db.TableMaps = map[string]TableMap{}
+	db.FloorSections = map[DatabaseID]FloorSection{}
+	db.FloorTables = map[DatabaseID]FloorTable{}
+	db.BeaconTelemetry = map[string]BeaconTelemetry{}
+	db.LRSEvents = map[DatabaseID]LRSEventRecord{}
+	db.PagerSessions = map[DatabaseID]PagerSession{}
+	db.Pagers = map[DatabaseID]Pager{}
 	db.CayanKeyVersion = 0
 	db.CayanKey = nil
 	db.Tokens = map[DatabaseID]Token{}
+	db.Sessions = map[DatabaseID]Session{}
+	db.RefreshTokens = map[DatabaseID]RefreshToken{}
 	db.Customers = map[DatabaseID]Customer{}
+	db.Connections = map[DatabaseID]CustomerConnection{}
+	db.LoginFailures = map[string]LoginFailures{}
+	db.LoginNonces = map[string]time.Time{}
 	db.Orders = map[DatabaseID]Order{}
 	db.LineCount = 0
 	db.Payments = map[string]Payment{}
+	db.PaymentLocks = map[DatabaseID]time.Time{}
+	db.GiftCards = map[DatabaseID]GiftCard{}
+	db.GiftCardEntries = map[DatabaseID]GiftCardEntry{}
+	db.SavedCards = map[DatabaseID]SavedCard{}
+	db.LoyaltyRules = map[KountaID]LoyaltyRule{}
+	db.LoyaltyRewards = map[DatabaseID]LoyaltyReward{}
+	db.LoyaltyEntries = map[DatabaseID]LoyaltyEntry{}
+	db.PromoCodes = map[DatabaseID]PromoCode{}
+	db.PromoRedemptions = map[DatabaseID]PromoRedemption{}
 	db.Sites = map[DatabaseID]Site{}
 	db.Categories = map[DatabaseID]Category{}
 	db.MenuItems = map[DatabaseID]MenuItem{}
 	db.Modifiers = map[DatabaseID]Modifier{}
 	db.OptionSets = map[DatabaseID]OptionSet{}
+	db.PickupSettings = map[KountaID]PickupSettings{}
+	db.TipSettings = map[KountaID]TipSettings{}
+	db.StaffTips = map[KountaID]StaffTip{}
+	db.FeeRules = map[DatabaseID]FeeRule{}
+	db.OrderFees = map[DatabaseID][]OrderFee{}
+	db.PickupDetails = map[DatabaseID]PickupDetails{}
+	db.NotificationSettings = map[KountaID]NotificationSettings{}
+	db.Notifications = map[DatabaseID]Notification{}
+	db.Outbox = map[DatabaseID]OutboxEmail{}
 }
 
 type databaseIDSlice []DatabaseID
@@ -76,6 +135,187 @@ func (db *MemoryDB) GetTableMapByBeaconID(id string) (*TableMap, error) {
 	return &tableMap, nil
 }
 
+func (db *MemoryDB) SelectTableMapsByBeaconIDs(ids []string) (*[]TableMap, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	tableMaps := []TableMap{}
+	for _, id := range ids {
+		if tableMap, contains := db.TableMaps[id]; contains {
+			tableMaps = append(tableMaps, tableMap)
+		}
+	}
+	return &tableMaps, nil
+}
+
+func (db *MemoryDB) UpdateTableMap(tableMap *TableMap) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if _, contains := db.TableMaps[tableMap.BeaconID]; !contains {
+		return errors.Errorf("table map for beacon %s not found", tableMap.BeaconID)
+	}
+	db.TableMaps[tableMap.BeaconID] = *tableMap
+	return nil
+}
+
+func (db *MemoryDB) DeleteTableMap(beaconID string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	delete(db.TableMaps, beaconID)
+	return nil
+}
+
+func (db *MemoryDB) SelectTableMapsBySiteID(siteID KountaID) (*[]TableMap, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	tableMaps := []TableMap{}
+	for _, tableMap := range db.TableMaps {
+		if tableMap.SiteID == siteID {
+			tableMaps = append(tableMaps, tableMap)
+		}
+	}
+	sort.Slice(tableMaps, func(i, j int) bool {
+		if tableMaps[i].TableName != tableMaps[j].TableName {
+			return tableMaps[i].TableName < tableMaps[j].TableName
+		}
+		return tableMaps[i].BeaconID < tableMaps[j].BeaconID
+	})
+	return &tableMaps, nil
+}
+
+// SelectDuplicateTableMaps is always empty, TableMaps is keyed by beacon ID
+func (db *MemoryDB) SelectDuplicateTableMaps() (*[]TableMap, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	return &[]TableMap{}, nil
+}
+
+func (db *MemoryDB) UpsertTableMaps(tableMaps []TableMap) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for _, tableMap := range tableMaps {
+		db.TableMaps[tableMap.BeaconID] = tableMap
+	}
+	return nil
+}
+
+func (db *MemoryDB) InsertFloorSection(section *FloorSection) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for _, existing := range db.FloorSections {
+		if existing.SiteID == section.SiteID && existing.Name == section.Name {
+			return errors.Errorf("floor section %s already exists", section.Name)
+		}
+	}
+
+	section.ID = DatabaseID(len(db.FloorSections) + 1)
+	db.FloorSections[section.ID] = *section
+	return nil
+}
+
+func (db *MemoryDB) SelectFloorSectionsBySiteID(siteID KountaID) (*[]FloorSection, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	sections := []FloorSection{}
+	for _, section := range db.FloorSections {
+		if section.SiteID == siteID {
+			sections = append(sections, section)
+		}
+	}
+	sort.Slice(sections, func(i, j int) bool { return sections[i].Name < sections[j].Name })
+	return &sections, nil
+}
+
+func (db *MemoryDB) UpsertFloorTable(table *FloorTable) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	table.ID = DatabaseID(len(db.FloorTables) + 1)
+	for id, existing := range db.FloorTables {
+		if existing.SiteID == table.SiteID && existing.Name == table.Name {
+			table.ID = id
+		}
+	}
+	db.FloorTables[table.ID] = *table
+	return nil
+}
+
+func (db *MemoryDB) SelectFloorTablesBySiteID(siteID KountaID) (*[]FloorTable, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	tables := []FloorTable{}
+	for _, table := range db.FloorTables {
+		if table.SiteID == siteID {
+			tables = append(tables, table)
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+	return &tables, nil
+}
+
+func (db *MemoryDB) DeleteFloorTable(siteID KountaID, name string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, table := range db.FloorTables {
+		if table.SiteID == siteID && table.Name == name {
+			delete(db.FloorTables, id)
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) UpsertBeaconTelemetry(telemetry *BeaconTelemetry) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	t := *telemetry
+	if existing, contains := db.BeaconTelemetry[t.BeaconID]; contains {
+		if !existing.ReportedAt.Before(t.ReportedAt) {
+			return nil
+		}
+		if t.BatteryMillivolts == 0 {
+			t.BatteryMillivolts = existing.BatteryMillivolts
+		}
+	}
+	db.BeaconTelemetry[t.BeaconID] = t
+	return nil
+}
+
+func (db *MemoryDB) SelectBeaconTelemetryBySiteID(siteID KountaID) (*[]BeaconTelemetry, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	telemetry := []BeaconTelemetry{}
+	for beaconID, t := range db.BeaconTelemetry {
+		if tableMap, contains := db.TableMaps[beaconID]; contains && tableMap.SiteID == siteID {
+			telemetry = append(telemetry, t)
+		}
+	}
+	return &telemetry, nil
+}
+
 func (db *MemoryDB) UpdateCayanKey(token string) error {
 	if db.Error != nil {
 		return db.Error
@@ -140,6 +380,145 @@ func (db *MemoryDB) DeleteTokens(customerID DatabaseID) error {
 	return nil
 }
 
+func (db *MemoryDB) InsertSession(session *Session) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	session.ID = DatabaseID(len(db.Sessions) + 1)
+	db.Sessions[session.ID] = *session
+	return nil
+}
+
+func (db *MemoryDB) GetSession(id DatabaseID) (*Session, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	session, contains := db.Sessions[id]
+	if !contains {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (db *MemoryDB) UpdateSession(session *Session) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	db.Sessions[session.ID] = *session
+	return nil
+}
+
+func (db *MemoryDB) SelectActiveSessionsByCustomerID(customerID DatabaseID, now time.Time) (*[]Session, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	sessions := []Session{}
+	for _, session := range db.Sessions {
+		if session.CustomerID == customerID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt) })
+	return &sessions, nil
+}
+
+func (db *MemoryDB) RevokeSession(id DatabaseID, revokedAt time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	session, contains := db.Sessions[id]
+	if contains && session.RevokedAt == nil {
+		session.RevokedAt = &revokedAt
+		db.Sessions[id] = session
+	}
+	return nil
+}
+
+func (db *MemoryDB) RevokeSessionsByCustomerID(customerID DatabaseID, revokedAt time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, session := range db.Sessions {
+		if session.CustomerID == customerID && session.RevokedAt == nil {
+			session.RevokedAt = &revokedAt
+			db.Sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) InsertRefreshToken(token *RefreshToken) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	token.ID = DatabaseID(len(db.RefreshTokens) + 1)
+	db.RefreshTokens[token.ID] = *token
+	return nil
+}
+
+func (db *MemoryDB) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, token := range db.RefreshTokens {
+		if token.TokenHash == tokenHash {
+			return &token, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) UseRefreshToken(id DatabaseID, usedAt time.Time) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	token, contains := db.RefreshTokens[id]
+	if !contains || token.UsedAt != nil {
+		return false, nil
+	}
+
+	token.UsedAt = &usedAt
+	db.RefreshTokens[id] = token
+	return true, nil
+}
+
+func (db *MemoryDB) DeleteExpiredTokens(now time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, token := range db.Tokens {
+		if token.Expiry.Before(now) {
+			delete(db.Tokens, id)
+		}
+	}
+	for id, session := range db.Sessions {
+		if session.RevokedAt != nil || session.ExpiresAt.Before(now) {
+			delete(db.Sessions, id)
+		}
+	}
+	for id, token := range db.RefreshTokens {
+		if _, contains := db.Sessions[token.SessionID]; !contains || token.ExpiresAt.Before(now) {
+			delete(db.RefreshTokens, id)
+		}
+	}
+	for nonce, expiresAt := range db.LoginNonces {
+		if expiresAt.Before(now) {
+			delete(db.LoginNonces, nonce)
+		}
+	}
+	return nil
+}
+
 func (db *MemoryDB) InsertCustomer(customer *Customer) error {
 	if db.Error != nil {
 		return db.Error
@@ -203,60 +582,269 @@ func (db *MemoryDB) GetCustomerByEmail(email string) (*Customer, error) {
 	return nil, nil
 }
 
-func (db *MemoryDB) InsertOrder(order *Order) error {
+func (db *MemoryDB) UpdateCustomerProfile(customer *Customer) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	order.ID = DatabaseID(len(db.Orders) + 1)
-	for i := range order.Lines {
-		line := &order.Lines[i]
-		line.OrderID = order.ID
-		db.LineCount++
-		line.ID = DatabaseID(db.LineCount)
+	existingCustomer, contains := db.Customers[customer.ID]
+	if !contains {
+		return errors.New("customer not in database")
+	}
 
-		for _, modifierID := range line.ModifierIDs {
-			absoluteValueModifierID := KountaID(math.Abs(float64(modifierID)))
-			modifier, err := db.GetMenuModifierByKountaID(order.SiteID, absoluteValueModifierID)
-			if err != nil {
-				return err
-			}
-			if modifier == nil {
-				continue
-			}
+	existingCustomer.FirstName = customer.FirstName
+	existingCustomer.LastName = customer.LastName
+	existingCustomer.Email = customer.Email
+	existingCustomer.Phone = customer.Phone
+	existingCustomer.ImageURL = customer.ImageURL
+	db.Customers[customer.ID] = existingCustomer
+	return nil
+}
 
-			modifier.SiteID = 0
-			modifier.LineID = line.ID
-			modifier.OrderID = line.OrderID
-			modifier.Added = modifierID >= 0
+func (db *MemoryDB) DeleteCustomer(id DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
 
-			if modifier.Added {
-				line.AddedModifiers = append(line.AddedModifiers, *modifier)
-			} else {
-				line.RemovedModifiers = append(line.RemovedModifiers, *modifier)
-			}
+	for tokenID, token := range db.Tokens {
+		if token.CustomerID == id {
+			delete(db.Tokens, tokenID)
 		}
 	}
-	db.Orders[order.ID] = *order
+	for sessionID, session := range db.Sessions {
+		if session.CustomerID == id {
+			delete(db.Sessions, sessionID)
+		}
+	}
+	for tokenID, token := range db.RefreshTokens {
+		if _, contains := db.Sessions[token.SessionID]; !contains {
+			delete(db.RefreshTokens, tokenID)
+		}
+	}
+	for connectionID, connection := range db.Connections {
+		if connection.CustomerID == id {
+			delete(db.Connections, connectionID)
+		}
+	}
+	for cardID, card := range db.SavedCards {
+		if card.CustomerID == id {
+			delete(db.SavedCards, cardID)
+		}
+	}
+	for entryID, entry := range db.LoyaltyEntries {
+		if entry.CustomerID == id {
+			delete(db.LoyaltyEntries, entryID)
+		}
+	}
+	for redemptionID, redemption := range db.PromoRedemptions {
+		if redemption.CustomerID.Int64 == int64(id) {
+			redemption.CustomerID = sql.NullInt64{}
+			db.PromoRedemptions[redemptionID] = redemption
+		}
+	}
+
+	delete(db.Customers, id)
 	return nil
 }
 
-func (db *MemoryDB) UpdateOrder(order *Order) error {
+func (db *MemoryDB) InsertCustomerConnection(connection *CustomerConnection) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	existingOrder, err := db.GetOrder(order.PosID)
-	if err != nil {
-		return err
+	for _, c := range db.Connections {
+		if (c.Service == connection.Service && c.ExternalID == connection.ExternalID) ||
+			(c.CustomerID == connection.CustomerID && c.Service == connection.Service) {
+			return errors.Errorf("connection to %s already exists", connection.Service)
+		}
 	}
-	order.ID = existingOrder.ID
 
-	for i := range order.Lines {
-		line := &order.Lines[i]
-		line.OrderID = order.ID
-		db.LineCount++
-		line.ID = DatabaseID(db.LineCount)
+	connection.ID = DatabaseID(len(db.Connections) + 1)
+	db.Connections[connection.ID] = *connection
+	return nil
+}
+
+func (db *MemoryDB) GetCustomerConnection(service, externalID string) (*CustomerConnection, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, connection := range db.Connections {
+		if connection.Service == service && connection.ExternalID == externalID {
+			return &connection, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) SelectCustomerConnections(customerID DatabaseID) (*[]CustomerConnection, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, connection := range db.Connections {
+		if connection.CustomerID == customerID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	connections := []CustomerConnection{}
+	for _, id := range ids {
+		connections = append(connections, db.Connections[id])
+	}
+	return &connections, nil
+}
+
+func (db *MemoryDB) DeleteCustomerConnection(customerID DatabaseID, service string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, connection := range db.Connections {
+		if connection.CustomerID == customerID && connection.Service == service {
+			delete(db.Connections, id)
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) InsertLoginNonce(nonce string, expiresAt time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if _, contains := db.LoginNonces[nonce]; contains {
+		return errors.New("login nonce already exists")
+	}
+	db.LoginNonces[nonce] = expiresAt
+	return nil
+}
+
+func (db *MemoryDB) UseLoginNonce(nonce string, now time.Time) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	expiresAt, contains := db.LoginNonces[nonce]
+	if !contains || expiresAt.Before(now) {
+		return false, nil
+	}
+
+	delete(db.LoginNonces, nonce)
+	return true, nil
+}
+
+func (db *MemoryDB) GetLoginFailures(email string) (*LoginFailures, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	failures, contains := db.LoginFailures[email]
+	if !contains {
+		return nil, nil
+	}
+	return &failures, nil
+}
+
+func (db *MemoryDB) IncrementLoginFailures(email string, now, resetBefore time.Time) (*LoginFailures, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	failures, contains := db.LoginFailures[email]
+	if !contains || failures.LastFailureAt.Before(resetBefore) {
+		failures = LoginFailures{Email: email}
+	}
+	failures.Failures++
+	failures.LastFailureAt = now
+	failures.LockedUntil = nil
+	db.LoginFailures[email] = failures
+	return &failures, nil
+}
+
+func (db *MemoryDB) UpdateLoginFailuresLockedUntil(email string, lockedUntil time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	failures, contains := db.LoginFailures[email]
+	if !contains {
+		return errors.Errorf("login failures for %s not found", email)
+	}
+	failures.LockedUntil = &lockedUntil
+	db.LoginFailures[email] = failures
+	return nil
+}
+
+func (db *MemoryDB) DeleteLoginFailures(email string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	delete(db.LoginFailures, email)
+	return nil
+}
+
+func (db *MemoryDB) InsertOrder(order *Order) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	order.ID = DatabaseID(len(db.Orders) + 1)
+	order.CreatedAt = time.Now()
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		line.OrderID = order.ID
+		db.LineCount++
+		line.ID = DatabaseID(db.LineCount)
+
+		for _, modifierID := range line.ModifierIDs {
+			absoluteValueModifierID := KountaID(math.Abs(float64(modifierID)))
+			modifier, err := db.GetMenuModifierByKountaID(order.SiteID, absoluteValueModifierID)
+			if err != nil {
+				return err
+			}
+			if modifier == nil {
+				continue
+			}
+
+			modifier.SiteID = 0
+			modifier.LineID = line.ID
+			modifier.OrderID = line.OrderID
+			modifier.Added = modifierID >= 0
+
+			if modifier.Added {
+				line.AddedModifiers = append(line.AddedModifiers, *modifier)
+			} else {
+				line.RemovedModifiers = append(line.RemovedModifiers, *modifier)
+			}
+		}
+	}
+	db.Orders[order.ID] = *order
+	return nil
+}
+
+func (db *MemoryDB) UpdateOrder(order *Order) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingOrder, err := db.GetOrder(order.PosID)
+	if err != nil {
+		return err
+	}
+	order.ID = existingOrder.ID
+	order.Discount = existingOrder.Discount // only set when the order is created
+	order.CreatedAt = existingOrder.CreatedAt
+	order.PickupTime = existingOrder.PickupTime // set on its own by UpdateOrderPickupTime
+
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		line.OrderID = order.ID
+		db.LineCount++
+		line.ID = DatabaseID(db.LineCount)
 
 		for _, modifierID := range line.ModifierIDs {
 			absoluteValueModifierID := KountaID(math.Abs(float64(modifierID)))
@@ -273,272 +861,1510 @@ func (db *MemoryDB) UpdateOrder(order *Order) error {
 			modifier.OrderID = line.OrderID
 			modifier.Added = modifierID >= 0
 
-			if modifier.Added {
-				line.AddedModifiers = append(line.AddedModifiers, *modifier)
-			} else {
-				line.RemovedModifiers = append(line.RemovedModifiers, *modifier)
-			}
+			if modifier.Added {
+				line.AddedModifiers = append(line.AddedModifiers, *modifier)
+			} else {
+				line.RemovedModifiers = append(line.RemovedModifiers, *modifier)
+			}
+		}
+	}
+
+	db.Orders[order.ID] = *order
+	return nil
+}
+
+func (db *MemoryDB) UpdateOrderTableName(order *Order, tableName string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingOrder := db.Orders[order.ID]
+	existingOrder.TableName = tableName
+	db.Orders[order.ID] = existingOrder
+	return nil
+}
+
+func (db *MemoryDB) UpdateOrderPagerNumber(order *Order, pagerNumber string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingOrder := db.Orders[order.ID]
+	existingOrder.PagerNumber = pagerNumber
+	db.Orders[order.ID] = existingOrder
+	return nil
+}
+
+func (db *MemoryDB) UpdateOrderCustomerID(order *Order, customerID DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingOrder := db.Orders[order.ID]
+	existingOrder.CustomerID.Int64 = int64(customerID)
+	existingOrder.CustomerID.Valid = customerID > 0
+	db.Orders[order.ID] = existingOrder
+	return nil
+}
+
+func (db *MemoryDB) AnonymizeOrdersByCustomerID(customerID DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, order := range db.Orders {
+		if !order.CustomerID.Valid || DatabaseID(order.CustomerID.Int64) != customerID {
+			continue
+		}
+
+		order.CustomerID.Int64 = 0
+		order.CustomerID.Valid = false
+		db.Orders[id] = order
+
+		delete(db.PickupDetails, id)
+		for notificationID, notification := range db.Notifications {
+			if notification.OrderID == id {
+				notification.Recipient = ""
+				db.Notifications[notificationID] = notification
+			}
+		}
+	}
+
+	for transactionID, payment := range db.Payments {
+		if payment.CustomerID.Valid && DatabaseID(payment.CustomerID.Int64) == customerID {
+			payment.CustomerID.Int64 = 0
+			payment.CustomerID.Valid = false
+			db.Payments[transactionID] = payment
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) UpdateOrderPickupTime(order *Order, pickupTime time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingOrder := db.Orders[order.ID]
+	existingOrder.PickupTime = &pickupTime
+	db.Orders[order.ID] = existingOrder
+	return nil
+}
+
+func (db *MemoryDB) ReservePickupTime(order *Order, pickupTime, slotStart, slotEnd time.Time, maxOrders int) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	if maxOrders > 0 {
+		pickupTimes, err := db.SelectPickupTimesBySiteID(order.SiteID, slotStart, slotEnd)
+		if err != nil {
+			return false, err
+		}
+		delete(pickupTimes, order.ID)
+		if len(pickupTimes) >= maxOrders {
+			return false, nil
+		}
+	}
+
+	return true, db.UpdateOrderPickupTime(order, pickupTime)
+}
+
+func (db *MemoryDB) ReleasePickupTime(order *Order, previous *time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingOrder := db.Orders[order.ID]
+	existingOrder.PickupTime = previous
+	db.Orders[order.ID] = existingOrder
+	return nil
+}
+
+func (db *MemoryDB) GetOrder(orderID KountaID) (*Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, order := range db.Orders {
+		if order.PosID == orderID {
+			return &order, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (db *MemoryDB) GetOrderByDatabaseID(orderID DatabaseID) (*Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	order, contains := db.Orders[orderID]
+	if !contains {
+		return nil, nil
+	}
+
+	return &order, nil
+}
+
+func (db *MemoryDB) InsertLRSEvent(record *LRSEventRecord) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	record.ID = DatabaseID(len(db.LRSEvents) + 1)
+	db.LRSEvents[record.ID] = *record
+	return nil
+}
+
+func (db *MemoryDB) UpdateLRSEventOutcome(id DatabaseID, outcome, errorMessage string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	record, contains := db.LRSEvents[id]
+	if !contains {
+		return errors.Errorf("lrs event %d not found", id)
+	}
+	record.Outcome = outcome
+	record.Error = errorMessage
+	db.LRSEvents[id] = record
+	return nil
+}
+
+func (db *MemoryDB) SelectLRSEvents(siteID KountaID, from, to time.Time) (*[]LRSEventRecord, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, record := range db.LRSEvents {
+		if record.SiteID == siteID && !record.ReceivedAt.Before(from) && record.ReceivedAt.Before(to) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	records := []LRSEventRecord{}
+	for _, id := range ids {
+		records = append(records, db.LRSEvents[id])
+	}
+	return &records, nil
+}
+
+func (db *MemoryDB) InsertPagerSession(session *PagerSession) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	if existing, _ := db.GetPagerSessionByUUID(session.UUID); existing != nil {
+		return false, nil
+	}
+
+	session.ID = DatabaseID(len(db.PagerSessions) + 1)
+	db.PagerSessions[session.ID] = *session
+	return true, nil
+}
+
+func (db *MemoryDB) DeletePagerSession(id DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	delete(db.PagerSessions, id)
+	return nil
+}
+
+func (db *MemoryDB) GetPagerSessionByUUID(uuid string) (*PagerSession, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, session := range db.PagerSessions {
+		if session.UUID == uuid {
+			return &session, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) UpdatePagerSession(session *PagerSession) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if _, contains := db.PagerSessions[session.ID]; !contains {
+		return errors.Errorf("pager session %d not found", session.ID)
+	}
+	db.PagerSessions[session.ID] = *session
+	return nil
+}
+
+func (db *MemoryDB) SelectOpenPagerSessions(siteID KountaID, pagerNumber int64) (*[]PagerSession, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, session := range db.PagerSessions {
+		if session.SiteID == siteID && session.PagerNumber == pagerNumber && session.ClearedAt == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	sessions := []PagerSession{}
+	for _, id := range ids {
+		sessions = append(sessions, db.PagerSessions[id])
+	}
+	return &sessions, nil
+}
+
+func (db *MemoryDB) InsertPagers(siteID KountaID, numbers []int64, now time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for _, number := range numbers {
+		if existing, _ := db.GetPager(siteID, number); existing != nil {
+			continue
+		}
+		id := DatabaseID(len(db.Pagers) + 1)
+		db.Pagers[id] = Pager{ID: id, SiteID: siteID, Number: number, Status: PagerStatusAvailable, UpdatedAt: now}
+	}
+	return nil
+}
+
+func (db *MemoryDB) GetPager(siteID KountaID, number int64) (*Pager, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, pager := range db.Pagers {
+		if pager.SiteID == siteID && pager.Number == number {
+			return &pager, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) SelectPagersBySiteID(siteID KountaID) (*[]Pager, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	pagers := []Pager{}
+	for _, pager := range db.Pagers {
+		if pager.SiteID == siteID {
+			pagers = append(pagers, pager)
+		}
+	}
+	sort.Slice(pagers, func(i, j int) bool { return pagers[i].Number < pagers[j].Number })
+	return &pagers, nil
+}
+
+func (db *MemoryDB) AssignPager(pager *Pager) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	existing, _ := db.GetPager(pager.SiteID, pager.Number)
+	if existing != nil && existing.Status == PagerStatusLost {
+		return false, nil
+	}
+	if existing != nil {
+		pager.ID = existing.ID
+	} else {
+		pager.ID = DatabaseID(len(db.Pagers) + 1)
+	}
+	db.Pagers[pager.ID] = *pager
+	return true, nil
+}
+
+func (db *MemoryDB) UpdatePagerStatus(siteID KountaID, number int64, status string, now time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	pager, _ := db.GetPager(siteID, number)
+	if pager == nil {
+		return errors.Errorf("pager %d not found", number)
+	}
+	pager.Status = status
+	pager.OrderID = sql.NullInt64{}
+	pager.AssignedAt = nil
+	pager.UpdatedAt = now
+	db.Pagers[pager.ID] = *pager
+	return nil
+}
+
+func (db *MemoryDB) ReleasePagersByOrderID(orderID DatabaseID, now time.Time) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, pager := range db.Pagers {
+		if pager.Status == PagerStatusAssigned && pager.OrderID.Valid && DatabaseID(pager.OrderID.Int64) == orderID {
+			pager.Status = PagerStatusAvailable
+			pager.OrderID = sql.NullInt64{}
+			pager.AssignedAt = nil
+			pager.UpdatedAt = now
+			db.Pagers[id] = pager
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) GetOrderByPagerID(siteID KountaID, pagerID int64) (*Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	pagerString := strconv.FormatInt(pagerID, 10)
+	for _, order := range db.Orders {
+		if order.SiteID == siteID && order.PagerNumber == pagerString {
+			return &order, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (db *MemoryDB) SelectActiveOrdersBySiteID(siteID KountaID, statuses []string, createdAfter time.Time) (*[]Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	orders := []Order{}
+	for _, order := range db.Orders {
+		if order.SiteID == siteID && containsString(statuses, order.Status) && order.CreatedAt.After(createdAfter) {
+			orders = append(orders, order)
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if (a.PickupTime == nil) != (b.PickupTime == nil) {
+			return a.PickupTime != nil
+		}
+		if a.PickupTime != nil && !a.PickupTime.Equal(*b.PickupTime) {
+			return a.PickupTime.Before(*b.PickupTime)
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return &orders, nil
+}
+
+func (db *MemoryDB) SelectOrdersByCustomerID(customerID DatabaseID) (*[]Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	orders := []Order{}
+	for _, order := range db.Orders {
+		if order.CustomerID.Int64 == int64(customerID) {
+			orders = append(orders, order)
+		}
+	}
+
+	return &orders, nil
+}
+
+func (db *MemoryDB) SelectOrdersByCustomerIDBefore(customerID, before DatabaseID, limit int) (*[]Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, order := range db.Orders {
+		if order.CustomerID.Int64 != int64(customerID) || order.Status == OrderStatusDeleted {
+			continue
+		}
+		if before != 0 && id >= before {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(sort.Reverse(ids))
+
+	orders := []Order{}
+	for _, id := range ids {
+		if len(orders) == limit {
+			break
+		}
+		orders = append(orders, db.Orders[id])
+	}
+
+	return &orders, nil
+}
+
+func (db *MemoryDB) SelectOnHoldAndPendingOrdersByTable(siteID KountaID, tableName string) (*[]Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	orders := []Order{}
+	for _, order := range db.Orders {
+		if order.SiteID == siteID && order.TableName == tableName &&
+			(order.Status == OrderStatusOnHold || order.Status == OrderStatusPending) {
+			orders = append(orders, order)
+		}
+	}
+
+	return &orders, nil
+}
+
+func (db *MemoryDB) SelectOnHoldAndPendingOrdersByPagerID(siteID KountaID, pagerID int64) (*[]Order, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	pagerString := strconv.FormatInt(pagerID, 10)
+	orders := []Order{}
+	for _, order := range db.Orders {
+		if order.SiteID == siteID && order.PagerNumber == pagerString &&
+			(order.Status == OrderStatusOnHold || order.Status == OrderStatusPending) {
+			orders = append(orders, order)
+		}
+	}
+
+	return &orders, nil
+}
+
+func (db *MemoryDB) InsertOrderUpdate(orderUpdate KountaOrderUpdate) error {
+	return db.Error
+}
+
+func (db *MemoryDB) GetLine(lineID DatabaseID) (*Line, error) {
+	for _, order := range db.Orders {
+		for _, line := range order.Lines {
+			if line.ID == lineID {
+				return &line, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) SelectLines(orderID DatabaseID) (*[]Line, error) {
+	lines := db.Orders[orderID].Lines
+	return &lines, db.Error
+}
+
+func (db *MemoryDB) SelectAddedModifiers(lineID DatabaseID) (*[]Modifier, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, order := range db.Orders {
+		for _, line := range order.Lines {
+			if line.ID == lineID {
+				if line.AddedModifiers == nil {
+					return &[]Modifier{}, nil
+				}
+				return &line.AddedModifiers, nil
+			}
+		}
+	}
+
+	return &[]Modifier{}, nil
+}
+
+func (db *MemoryDB) SelectRemovedModifiers(lineID DatabaseID) (*[]Modifier, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, order := range db.Orders {
+		for _, line := range order.Lines {
+			if line.ID == lineID {
+				if line.RemovedModifiers == nil {
+					return &[]Modifier{}, nil
+				}
+				return &line.RemovedModifiers, nil
+			}
+		}
+	}
+
+	return &[]Modifier{}, nil
+}
+
+func (db *MemoryDB) SelectLinesByOrderIDs(orderIDs []DatabaseID) (map[DatabaseID][]Line, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	linesByOrderID := make(map[DatabaseID][]Line, len(orderIDs))
+	for _, orderID := range orderIDs {
+		order, contains := db.Orders[orderID]
+		if !contains || len(order.Lines) == 0 {
+			continue
+		}
+
+		lines := make([]Line, len(order.Lines))
+		copy(lines, order.Lines)
+		linesByOrderID[orderID] = lines
+	}
+
+	return linesByOrderID, nil
+}
+
+func (db *MemoryDB) InsertSavedCard(card *SavedCard) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	card.ID = DatabaseID(len(db.SavedCards) + 1)
+	db.SavedCards[card.ID] = *card
+	return nil
+}
+
+func (db *MemoryDB) GetSavedCard(id DatabaseID) (*SavedCard, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	card, contains := db.SavedCards[id]
+	if !contains {
+		return nil, nil
+	}
+	return &card, nil
+}
+
+func (db *MemoryDB) SelectSavedCardsByCustomerID(customerID DatabaseID) (*[]SavedCard, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, card := range db.SavedCards {
+		if card.CustomerID == customerID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	cards := []SavedCard{}
+	for _, id := range ids {
+		cards = append(cards, db.SavedCards[id])
+	}
+	return &cards, nil
+}
+
+func (db *MemoryDB) DeleteSavedCard(id DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	delete(db.SavedCards, id)
+	return nil
+}
+
+func (db *MemoryDB) SetDefaultSavedCard(customerID, cardID DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for id, card := range db.SavedCards {
+		if card.CustomerID == customerID {
+			card.IsDefault = id == cardID
+			db.SavedCards[id] = card
+		}
+	}
+	return nil
+}
+
+func (db *MemoryDB) UpsertLoyaltyRule(rule *LoyaltyRule) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	db.LoyaltyRules[rule.SiteID] = *rule
+	return nil
+}
+
+func (db *MemoryDB) GetLoyaltyRule(siteID KountaID) (*LoyaltyRule, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	rule, contains := db.LoyaltyRules[siteID]
+	if !contains {
+		return nil, nil
+	}
+	return &rule, nil
+}
+
+func (db *MemoryDB) InsertLoyaltyReward(reward *LoyaltyReward) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	reward.ID = DatabaseID(len(db.LoyaltyRewards) + 1)
+	db.LoyaltyRewards[reward.ID] = *reward
+	return nil
+}
+
+func (db *MemoryDB) GetLoyaltyReward(id DatabaseID) (*LoyaltyReward, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	reward, contains := db.LoyaltyRewards[id]
+	if !contains {
+		return nil, nil
+	}
+	return &reward, nil
+}
+
+func (db *MemoryDB) SelectActiveLoyaltyRewards() (*[]LoyaltyReward, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	rewards := []LoyaltyReward{}
+	for _, reward := range db.LoyaltyRewards {
+		if reward.Active {
+			rewards = append(rewards, reward)
+		}
+	}
+	sort.Slice(rewards, func(i, j int) bool {
+		if rewards[i].Points != rewards[j].Points {
+			return rewards[i].Points < rewards[j].Points
+		}
+		return rewards[i].ID < rewards[j].ID
+	})
+	return &rewards, nil
+}
+
+func (db *MemoryDB) InsertLoyaltyEntry(entry *LoyaltyEntry) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	entry.ID = DatabaseID(len(db.LoyaltyEntries) + 1)
+	db.LoyaltyEntries[entry.ID] = *entry
+	return nil
+}
+
+func (db *MemoryDB) InsertLoyaltyRedemption(entry *LoyaltyEntry) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	balance, _ := db.GetLoyaltyBalance(entry.CustomerID)
+	if balance+entry.Points < 0 {
+		return false, nil
+	}
+	return true, db.InsertLoyaltyEntry(entry)
+}
+
+func (db *MemoryDB) UpdateLoyaltyEntryOrderID(entryID, orderID DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	entry, contains := db.LoyaltyEntries[entryID]
+	if !contains {
+		return errors.Errorf("loyalty entry %d not found", entryID)
+	}
+	entry.OrderID = sql.NullInt64{Int64: int64(orderID), Valid: true}
+	db.LoyaltyEntries[entryID] = entry
+	return nil
+}
+
+func (db *MemoryDB) GetLoyaltyBalance(customerID DatabaseID) (int, error) {
+	if db.Error != nil {
+		return 0, db.Error
+	}
+
+	balance := 0
+	for _, entry := range db.LoyaltyEntries {
+		if entry.CustomerID == customerID {
+			balance += entry.Points
+		}
+	}
+	return balance, nil
+}
+
+func (db *MemoryDB) SelectLoyaltyEntriesByCustomerID(customerID DatabaseID) (*[]LoyaltyEntry, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, entry := range db.LoyaltyEntries {
+		if entry.CustomerID == customerID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(ids))
+
+	entries := []LoyaltyEntry{}
+	for _, id := range ids {
+		entries = append(entries, db.LoyaltyEntries[id])
+	}
+	return &entries, nil
+}
+
+func (db *MemoryDB) SelectLoyaltyEntriesByOrderID(orderID DatabaseID) (*[]LoyaltyEntry, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, entry := range db.LoyaltyEntries {
+		if entry.OrderID.Valid && DatabaseID(entry.OrderID.Int64) == orderID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	entries := []LoyaltyEntry{}
+	for _, id := range ids {
+		entries = append(entries, db.LoyaltyEntries[id])
+	}
+	return &entries, nil
+}
+
+func (db *MemoryDB) SelectLoyaltyEntriesByTransactionID(transactionID string) (*[]LoyaltyEntry, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, entry := range db.LoyaltyEntries {
+		if transactionID != "" && entry.TransactionID == transactionID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	entries := []LoyaltyEntry{}
+	for _, id := range ids {
+		entries = append(entries, db.LoyaltyEntries[id])
+	}
+	return &entries, nil
+}
+
+func (db *MemoryDB) InsertPromoCode(promo *PromoCode) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	promo.ID = DatabaseID(len(db.PromoCodes) + 1)
+	db.PromoCodes[promo.ID] = *promo
+	return nil
+}
+
+func (db *MemoryDB) GetPromoCodeByCode(code string) (*PromoCode, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, promo := range db.PromoCodes {
+		if promo.Code == code {
+			return &promo, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) UpdatePromoCodeActive(id DatabaseID, active bool) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	promo, contains := db.PromoCodes[id]
+	if !contains {
+		return errors.Errorf("promo code %d not found", id)
+	}
+	promo.Active = active
+	db.PromoCodes[id] = promo
+	return nil
+}
+
+func (db *MemoryDB) InsertPromoRedemption(redemption *PromoRedemption, perCustomerLimit int) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	if perCustomerLimit > 0 {
+		uses, _ := db.CountPromoRedemptionsByCustomer(redemption.PromoCodeID, DatabaseID(redemption.CustomerID.Int64))
+		if uses >= perCustomerLimit {
+			return false, nil
+		}
+	}
+
+	redemption.ID = DatabaseID(len(db.PromoRedemptions) + 1)
+	db.PromoRedemptions[redemption.ID] = *redemption
+	return true, nil
+}
+
+func (db *MemoryDB) UpdatePromoRedemptionOrderID(redemptionID, orderID DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	redemption, contains := db.PromoRedemptions[redemptionID]
+	if !contains {
+		return errors.Errorf("promo redemption %d not found", redemptionID)
+	}
+	redemption.OrderID = sql.NullInt64{Int64: int64(orderID), Valid: true}
+	db.PromoRedemptions[redemptionID] = redemption
+	return nil
+}
+
+func (db *MemoryDB) DeletePromoRedemption(id DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	delete(db.PromoRedemptions, id)
+	return nil
+}
+
+func (db *MemoryDB) CountPromoRedemptionsByCustomer(promoCodeID, customerID DatabaseID) (int, error) {
+	if db.Error != nil {
+		return 0, db.Error
+	}
+
+	count := 0
+	for _, redemption := range db.PromoRedemptions {
+		if redemption.PromoCodeID != promoCodeID || redemption.CustomerID.Int64 != int64(customerID) {
+			continue
+		}
+		order := db.Orders[DatabaseID(redemption.OrderID.Int64)]
+		if !redemption.OrderID.Valid || order.Status != OrderStatusRejected && order.Status != OrderStatusDeleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *MemoryDB) SelectPromoCodeUsage(siteID KountaID, from, to time.Time) (*[]PromoCodeUsage, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	usageByCode := map[string]*PromoCodeUsage{}
+	for _, redemption := range db.PromoRedemptions {
+		order := db.Orders[DatabaseID(redemption.OrderID.Int64)]
+		if !redemption.OrderID.Valid || order.SiteID != siteID || order.CreatedAt.Before(from) || !order.CreatedAt.Before(to) ||
+			order.Status == OrderStatusRejected || order.Status == OrderStatusDeleted {
+			continue
+		}
+
+		code := db.PromoCodes[redemption.PromoCodeID].Code
+		if usageByCode[code] == nil {
+			usageByCode[code] = &PromoCodeUsage{Code: code}
+		}
+		usageByCode[code].Orders++
+		usageByCode[code].Discount += redemption.Discount
+		usageByCode[code].OrderTotal += order.Total
+	}
+
+	usage := []PromoCodeUsage{}
+	for _, codeUsage := range usageByCode {
+		usage = append(usage, *codeUsage)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Code < usage[j].Code })
+	return &usage, nil
+}
+
+func (db *MemoryDB) InsertGiftCard(card *GiftCard) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	card.ID = DatabaseID(len(db.GiftCards) + 1)
+	db.GiftCards[card.ID] = *card
+
+	issue := GiftCardEntry{GiftCardID: card.ID, Kind: GiftCardEntryIssue, Amount: card.InitialBalance, CreatedAt: card.CreatedAt}
+	issue.ID = DatabaseID(len(db.GiftCardEntries) + 1)
+	db.GiftCardEntries[issue.ID] = issue
+	return nil
+}
+
+func (db *MemoryDB) GetGiftCardByCode(code string) (*GiftCard, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, card := range db.GiftCards {
+		if card.Code == code {
+			return &card, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) RedeemGiftCard(entry *GiftCardEntry, payment *Payment, order *Order) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	card, contains := db.GiftCards[entry.GiftCardID]
+	if !contains {
+		return errors.Errorf("gift card %d not found", entry.GiftCardID)
+	}
+	if -entry.Amount > card.Balance {
+		entry.Amount = -card.Balance
+	}
+	orderBalance := db.Orders[order.ID].Total
+	for _, existing := range db.Payments {
+		if existing.OrderID == order.ID {
+			orderBalance -= existing.Amount
+		}
+	}
+	if -entry.Amount > orderBalance {
+		entry.Amount = -orderBalance
+	}
+	if entry.Amount >= 0 {
+		entry.Amount = 0
+		return nil
+	}
+	if err := db.InsertGiftCardEntry(entry); err != nil {
+		return err
+	}
+
+	payment.Amount = -entry.Amount
+	return db.InsertPayment(payment, order)
+}
+
+func (db *MemoryDB) InsertGiftCardRefund(refund *GiftCardEntry, refundPayment *Payment) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if err := db.InsertGiftCardEntry(refund); err != nil {
+		return err
+	}
+	db.Payments[refundPayment.TransactionID] = *refundPayment
+	return nil
+}
+
+func (db *MemoryDB) InsertGiftCardEntry(entry *GiftCardEntry) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	card, contains := db.GiftCards[entry.GiftCardID]
+	if !contains {
+		return errors.Errorf("gift card %d not found", entry.GiftCardID)
+	}
+	if card.Balance+entry.Amount < 0 {
+		return errors.Errorf("gift card %d balance can't go below zero", entry.GiftCardID)
+	}
+	if entry.TransactionID != "" {
+		if existing, _ := db.GetGiftCardEntryByTransactionID(entry.TransactionID); existing != nil {
+			return errors.Errorf("gift card transaction %s already exists", entry.TransactionID)
+		}
+	}
+
+	entry.ID = DatabaseID(len(db.GiftCardEntries) + 1)
+	db.GiftCardEntries[entry.ID] = *entry
+	card.Balance += entry.Amount
+	db.GiftCards[card.ID] = card
+	return nil
+}
+
+func (db *MemoryDB) GetGiftCardEntryByTransactionID(transactionID string) (*GiftCardEntry, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, entry := range db.GiftCardEntries {
+		if entry.TransactionID == transactionID {
+			return &entry, nil
+		}
+	}
+	return nil, nil
+}
+
+func (db *MemoryDB) SelectGiftCardEntries(giftCardID DatabaseID) (*[]GiftCardEntry, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	ids := databaseIDSlice{}
+	for id, entry := range db.GiftCardEntries {
+		if entry.GiftCardID == giftCardID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(ids)
+
+	entries := []GiftCardEntry{}
+	for _, id := range ids {
+		entries = append(entries, db.GiftCardEntries[id])
+	}
+	return &entries, nil
+}
+
+func (db *MemoryDB) InsertPayment(payment *Payment, order *Order) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	// card gateways aren't called in tests, so make up their transaction IDs
+	if payment.TransactionID == "" {
+		payment.TransactionID = strconv.FormatInt(rand.Int63(), 10)
+	}
+	db.Payments[payment.TransactionID] = *payment
+
+	existingOrder := db.Orders[order.ID]
+	existingOrder.Status = order.Status
+	db.Orders[order.ID] = existingOrder
+	return nil
+}
+
+func (db *MemoryDB) GetPaymentByOrderID(id DatabaseID) (*Payment, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, payment := range db.Payments {
+		if payment.OrderID == id {
+			return &payment, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (db *MemoryDB) SelectPaymentsByOrderIDs(orderIDs []DatabaseID) (map[DatabaseID][]Payment, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	paymentsByOrderID := make(map[DatabaseID][]Payment, len(orderIDs))
+	for _, payment := range db.Payments {
+		for _, orderID := range orderIDs {
+			if payment.OrderID == orderID {
+				paymentsByOrderID[orderID] = append(paymentsByOrderID[orderID], payment)
+			}
+		}
+	}
+	for _, payments := range paymentsByOrderID {
+		sort.Slice(payments, func(i, j int) bool { return payments[i].Date.Before(payments[j].Date) })
+	}
+
+	return paymentsByOrderID, nil
+}
+
+func (db *MemoryDB) LockOrdersForPayment(orderIDs []DatabaseID, now time.Time, lease time.Duration) (bool, error) {
+	if db.Error != nil {
+		return false, db.Error
+	}
+
+	for _, orderID := range orderIDs {
+		if lockedUntil, locked := db.PaymentLocks[orderID]; locked && lockedUntil.After(now) {
+			return false, nil
+		}
+	}
+	for _, orderID := range orderIDs {
+		db.PaymentLocks[orderID] = now.Add(lease)
+	}
+	return true, nil
+}
+
+func (db *MemoryDB) UnlockOrdersForPayment(orderIDs []DatabaseID) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	for _, orderID := range orderIDs {
+		delete(db.PaymentLocks, orderID)
+	}
+	return nil
+}
+
+func (db *MemoryDB) InsertSite(site *Site) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	site.ID = DatabaseID(len(db.Sites) + 1)
+	db.Sites[site.ID] = *site
+	return nil
+}
+
+func (db *MemoryDB) UpdateSiteMenuHash(site *Site, menuHash string) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	existingSite := db.Sites[site.ID]
+	existingSite.MenuHash = menuHash
+	db.Sites[site.ID] = existingSite
+	return nil
+}
+
+func (db *MemoryDB) SelectSites() (*[]Site, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	sites := make([]Site, 0, len(db.Sites))
+	for _, site := range db.Sites {
+		sites = append(sites, site)
+	}
+	return &sites, nil
+}
+
+func (db *MemoryDB) GetSite(id KountaID) (*Site, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	for _, site := range db.Sites {
+		if site.PosID == id {
+			return &site, nil
 		}
 	}
+	return nil, nil
+}
+
+func (db *MemoryDB) UpdateSiteTimeZone(siteID KountaID, timeZone string) error {
+	if db.Error != nil {
+		return db.Error
+	}
 
-	db.Orders[order.ID] = *order
+	for id, site := range db.Sites {
+		if site.PosID == siteID {
+			site.TimeZone = timeZone
+			db.Sites[id] = site
+		}
+	}
 	return nil
 }
 
-func (db *MemoryDB) UpdateOrderTableName(order *Order, tableName string) error {
+func (db *MemoryDB) UpdateSiteTaxRate(siteID KountaID, rate float64) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	existingOrder := db.Orders[order.ID]
-	existingOrder.TableName = tableName
-	db.Orders[order.ID] = existingOrder
+	for id, site := range db.Sites {
+		if site.PosID == siteID {
+			site.TaxRate = rate
+			db.Sites[id] = site
+		}
+	}
 	return nil
 }
 
-func (db *MemoryDB) UpdateOrderCustomerID(order *Order, customerID DatabaseID) error {
+func (db *MemoryDB) InsertFeeRule(rule *FeeRule) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	existingOrder := db.Orders[order.ID]
-	existingOrder.CustomerID.Int64 = int64(customerID)
-	existingOrder.CustomerID.Valid = customerID > 0
-	db.Orders[order.ID] = existingOrder
+	rule.ID = DatabaseID(len(db.FeeRules) + 1)
+	db.FeeRules[rule.ID] = *rule
 	return nil
 }
 
-func (db *MemoryDB) UpdateOrderPickupTime(order *Order, pickupTime time.Time) error {
+func (db *MemoryDB) UpdateFeeRuleActive(id DatabaseID, active bool) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	existingOrder := db.Orders[order.ID]
-	existingOrder.PickupTime = &pickupTime
-	db.Orders[order.ID] = existingOrder
+	rule, contains := db.FeeRules[id]
+	if !contains {
+		return errors.Errorf("fee rule %d not found", id)
+	}
+	rule.Active = active
+	db.FeeRules[id] = rule
 	return nil
 }
 
-func (db *MemoryDB) GetOrder(orderID KountaID) (*Order, error) {
+func (db *MemoryDB) SelectActiveFeeRulesBySiteID(siteID KountaID) (*[]FeeRule, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	for _, order := range db.Orders {
-		if order.PosID == orderID {
-			return &order, nil
+	rules := []FeeRule{}
+	for _, rule := range db.FeeRules {
+		if rule.SiteID == siteID && rule.Active {
+			rules = append(rules, rule)
 		}
 	}
-
-	return nil, nil
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return &rules, nil
 }
 
-func (db *MemoryDB) GetOrderByDatabaseID(orderID DatabaseID) (*Order, error) {
+func (db *MemoryDB) InsertOrderFees(orderID DatabaseID, fees []OrderFee) error {
 	if db.Error != nil {
-		return nil, db.Error
+		return db.Error
 	}
 
-	order, contains := db.Orders[orderID]
-	if !contains {
-		return nil, nil
+	db.OrderFees[orderID] = append(db.OrderFees[orderID], fees...)
+	return nil
+}
+
+func (db *MemoryDB) SelectOrderFees(orderID DatabaseID) (*[]OrderFee, error) {
+	if db.Error != nil {
+		return nil, db.Error
 	}
 
-	return &order, nil
+	fees := append([]OrderFee{}, db.OrderFees[orderID]...)
+	return &fees, nil
 }
 
-func (db *MemoryDB) GetOrderByPagerID(siteID KountaID, pagerID int64) (*Order, error) {
+func (db *MemoryDB) SelectFeeUsage(siteID KountaID, from, to time.Time) (*[]FeeUsage, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	pagerString := strconv.FormatInt(pagerID, 10)
-	for _, order := range db.Orders {
-		if order.SiteID == siteID && order.PagerNumber == pagerString {
-			return &order, nil
+	usageByFee := map[string]*FeeUsage{}
+	for orderID, fees := range db.OrderFees {
+		order := db.Orders[orderID]
+		if order.SiteID != siteID || order.CreatedAt.Before(from) || !order.CreatedAt.Before(to) ||
+			order.Status == OrderStatusRejected || order.Status == OrderStatusDeleted {
+			continue
+		}
+
+		for _, fee := range fees {
+			key := fee.Kind + "/" + fee.Name
+			if usageByFee[key] == nil {
+				usageByFee[key] = &FeeUsage{Name: fee.Name, Kind: fee.Kind}
+			}
+			usageByFee[key].Orders++
+			usageByFee[key].Amount += fee.Amount
+			usageByFee[key].Tax += fee.Tax
 		}
 	}
 
-	return nil, nil
+	usage := []FeeUsage{}
+	for _, feeUsage := range usageByFee {
+		usage = append(usage, *feeUsage)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Kind != usage[j].Kind {
+			return usage[i].Kind < usage[j].Kind
+		}
+		return usage[i].Name < usage[j].Name
+	})
+	return &usage, nil
 }
 
-func (db *MemoryDB) SelectOrdersByCustomerID(customerID DatabaseID) (*[]Order, error) {
+func (db *MemoryDB) UpsertTipSettings(settings *TipSettings) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	db.TipSettings[settings.SiteID] = *settings
+	return nil
+}
+
+func (db *MemoryDB) GetTipSettings(siteID KountaID) (*TipSettings, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	orders := []Order{}
-	for _, order := range db.Orders {
-		if order.CustomerID.Int64 == int64(customerID) {
-			orders = append(orders, order)
-		}
+	settings, contains := db.TipSettings[siteID]
+	if !contains {
+		return nil, nil
 	}
+	return &settings, nil
+}
 
-	return &orders, nil
+func (db *MemoryDB) UpsertStaffTip(tip *StaffTip) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	if existing, contains := db.StaffTips[tip.PosOrderID]; contains && existing.UpdatedAt.After(tip.UpdatedAt) {
+		return nil
+	}
+	db.StaffTips[tip.PosOrderID] = *tip
+	return nil
 }
 
-func (db *MemoryDB) SelectOnHoldAndPendingOrdersByTable(siteID KountaID, tableName string) (*[]Order, error) {
+func (db *MemoryDB) SelectStaffTipsBySiteID(siteID KountaID, from, to time.Time) (*[]StaffTip, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	orders := []Order{}
-	for _, order := range db.Orders {
-		if order.SiteID == siteID && order.TableName == tableName &&
-			(order.Status == OrderStatusOnHold || order.Status == OrderStatusPending) {
-			orders = append(orders, order)
+	tips := []StaffTip{}
+	for _, tip := range db.StaffTips {
+		if tip.SiteID == siteID && !tip.SoldAt.Before(from) && tip.SoldAt.Before(to) {
+			tips = append(tips, tip)
 		}
 	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].PosOrderID < tips[j].PosOrderID })
+	return &tips, nil
+}
 
-	return &orders, nil
+func (db *MemoryDB) UpsertPickupSettings(settings *PickupSettings) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	db.PickupSettings[settings.SiteID] = *settings
+	return nil
 }
 
-func (db *MemoryDB) SelectOnHoldAndPendingOrdersByPagerID(siteID KountaID, pagerID int64) (*[]Order, error) {
+func (db *MemoryDB) GetPickupSettings(siteID KountaID) (*PickupSettings, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	pagerString := strconv.FormatInt(pagerID, 10)
-	orders := []Order{}
-	for _, order := range db.Orders {
-		if order.SiteID == siteID && order.PagerNumber == pagerString &&
-			(order.Status == OrderStatusOnHold || order.Status == OrderStatusPending) {
-			orders = append(orders, order)
-		}
+	settings, contains := db.PickupSettings[siteID]
+	if !contains {
+		return nil, nil
 	}
-
-	return &orders, nil
+	return &settings, nil
 }
 
-func (db *MemoryDB) InsertOrderUpdate(orderUpdate KountaOrderUpdate) error {
-	return db.Error
-}
+func (db *MemoryDB) SelectPickupTimesBySiteID(siteID KountaID, from, to time.Time) (map[DatabaseID]time.Time, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
 
-func (db *MemoryDB) GetLine(lineID DatabaseID) (*Line, error) {
+	pickupTimes := map[DatabaseID]time.Time{}
 	for _, order := range db.Orders {
-		for _, line := range order.Lines {
-			if line.ID == lineID {
-				return &line, nil
-			}
+		if order.SiteID != siteID || order.PickupTime == nil ||
+			order.Status == OrderStatusRejected || order.Status == OrderStatusDeleted {
+			continue
+		}
+		if !order.PickupTime.Before(from) && order.PickupTime.Before(to) {
+			pickupTimes[order.ID] = *order.PickupTime
 		}
 	}
-	return nil, nil
+	return pickupTimes, nil
 }
 
-func (db *MemoryDB) SelectLines(orderID DatabaseID) (*[]Line, error) {
-	lines := db.Orders[orderID].Lines
-	return &lines, db.Error
+func (db *MemoryDB) UpsertPickupDetails(orderID DatabaseID, details PickupDetails) error {
+	if db.Error != nil {
+		return db.Error
+	}
+
+	details.PickupTime = nil
+	db.PickupDetails[orderID] = details
+	return nil
 }
 
-func (db *MemoryDB) SelectAddedModifiers(lineID DatabaseID) (*[]Modifier, error) {
+func (db *MemoryDB) GetPickupDetails(orderID DatabaseID) (*PickupDetails, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	for _, order := range db.Orders {
-		for _, line := range order.Lines {
-			if line.ID == lineID {
-				if line.AddedModifiers == nil {
-					return &[]Modifier{}, nil
-				}
-				return &line.AddedModifiers, nil
-			}
-		}
+	details, contains := db.PickupDetails[orderID]
+	if !contains {
+		return nil, nil
+	}
+	return &details, nil
+}
+
+func (db *MemoryDB) UpsertNotificationSettings(settings *NotificationSettings) error {
+	if db.Error != nil {
+		return db.Error
 	}
 
-	return &[]Modifier{}, nil
+	db.NotificationSettings[settings.SiteID] = *settings
+	return nil
 }
 
-func (db *MemoryDB) SelectRemovedModifiers(lineID DatabaseID) (*[]Modifier, error) {
+func (db *MemoryDB) GetNotificationSettings(siteID KountaID) (*NotificationSettings, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	for _, order := range db.Orders {
-		for _, line := range order.Lines {
-			if line.ID == lineID {
-				if line.RemovedModifiers == nil {
-					return &[]Modifier{}, nil
-				}
-				return &line.RemovedModifiers, nil
-			}
-		}
+	settings, contains := db.NotificationSettings[siteID]
+	if !contains {
+		return nil, nil
 	}
-
-	return &[]Modifier{}, nil
+	return &settings, nil
 }
 
-func (db *MemoryDB) InsertPayment(payment *Payment, order *Order) error {
+func (db *MemoryDB) InsertNotification(notification *Notification) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	payment.TransactionID = strconv.FormatInt(rand.Int63(), 10)
-	db.Payments[payment.TransactionID] = *payment
-
-	existingOrder := db.Orders[order.ID]
-	existingOrder.Status = order.Status
-	db.Orders[order.ID] = existingOrder
+	notification.ID = DatabaseID(len(db.Notifications) + 1)
+	db.Notifications[notification.ID] = *notification
 	return nil
 }
 
-func (db *MemoryDB) GetPaymentByOrderID(id DatabaseID) (*Payment, error) {
+func (db *MemoryDB) SelectNotificationsByOrderID(orderID DatabaseID) (*[]Notification, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	for _, payment := range db.Payments {
-		if payment.OrderID == id {
-			return &payment, nil
+	ids := make(databaseIDSlice, 0, len(db.Notifications))
+	for _, notification := range db.Notifications {
+		if notification.OrderID == orderID {
+			ids = append(ids, notification.ID)
 		}
 	}
 
-	return nil, nil
+	sort.Sort(ids)
+	notifications := make([]Notification, 0, len(ids))
+	for _, id := range ids {
+		notifications = append(notifications, db.Notifications[id])
+	}
+	return &notifications, nil
 }
 
-func (db *MemoryDB) InsertSite(site *Site) error {
+func (db *MemoryDB) InsertOutboxEmail(email *OutboxEmail) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	site.ID = DatabaseID(len(db.Sites) + 1)
-	db.Sites[site.ID] = *site
+	email.ID = DatabaseID(len(db.Outbox) + 1)
+	db.Outbox[email.ID] = *email
 	return nil
 }
 
-func (db *MemoryDB) UpdateSiteMenuHash(site *Site, menuHash string) error {
+func (db *MemoryDB) UpdateOutboxEmail(email *OutboxEmail) error {
 	if db.Error != nil {
 		return db.Error
 	}
 
-	existingSite := db.Sites[site.ID]
-	existingSite.MenuHash = menuHash
-	db.Sites[site.ID] = existingSite
+	existingEmail, contains := db.Outbox[email.ID]
+	if !contains {
+		return errors.New("outbox email not in database")
+	}
+
+	existingEmail.Status = email.Status
+	existingEmail.Attempts = email.Attempts
+	existingEmail.LastError = email.LastError
+	existingEmail.NextAttemptAt = email.NextAttemptAt
+	existingEmail.SentAt = email.SentAt
+	db.Outbox[email.ID] = existingEmail
 	return nil
 }
 
-func (db *MemoryDB) SelectSites() (*[]Site, error) {
+func (db *MemoryDB) ClaimDueOutboxEmails(now time.Time, lease time.Duration, limit int) (*[]OutboxEmail, error) {
 	if db.Error != nil {
 		return nil, db.Error
 	}
 
-	sites := make([]Site, 0, len(db.Sites))
-	for _, site := range db.Sites {
-		sites = append(sites, site)
-	}
-	return &sites, nil
-}
-
-func (db *MemoryDB) GetSite(id KountaID) (*Site, error) {
-	if db.Error != nil {
-		return nil, db.Error
+	ids := make(databaseIDSlice, 0, len(db.Outbox))
+	for _, email := range db.Outbox {
+		if email.Status == OutboxStatusPending && !email.NextAttemptAt.After(now) {
+			ids = append(ids, email.ID)
+		}
 	}
 
-	for _, site := range db.Sites {
-		if site.PosID == id {
-			return &site, nil
+	sort.Sort(ids)
+	emails := []OutboxEmail{}
+	for _, id := range ids {
+		if len(emails) == limit {
+			break
 		}
+
+		email := db.Outbox[id]
+		email.NextAttemptAt = now.Add(lease)
+		db.Outbox[id] = email
+		emails = append(emails, email)
 	}
-	return nil, nil
+	return &emails, nil
 }
 
 func (db *MemoryDB) UpsertCategory(category *Category) error {
@@ -683,6 +2509,26 @@ func (db *MemoryDB) SelectMenuItemsByCategoryID(siteID KountaID, categoryID Data
 	return &items, nil
 }
 
+func (db *MemoryDB) SelectMenuItemsBySiteID(siteID KountaID) (*[]MenuItem, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	itemIDs := make(databaseIDSlice, 0, len(db.MenuItems))
+	for _, item := range db.MenuItems {
+		if db.Sites[item.SiteID].PosID == siteID {
+			itemIDs = append(itemIDs, item.ID)
+		}
+	}
+
+	sort.Sort(itemIDs)
+	items := make([]MenuItem, 0, len(itemIDs))
+	for _, itemID := range itemIDs {
+		items = append(items, db.MenuItems[itemID])
+	}
+	return &items, nil
+}
+
 func (db *MemoryDB) GetMenuItem(siteID KountaID, menuItemID DatabaseID) (*MenuItem, error) {
 	if db.Error != nil {
 		return nil, db.Error
@@ -858,6 +2704,28 @@ func (db *MemoryDB) SelectMenuItemModifiers(siteID KountaID, menuItemID Database
 	return &modifiers, nil
 }
 
+func (db *MemoryDB) SelectMenuItemModifiersBySiteID(siteID KountaID) (map[DatabaseID][]Modifier, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	modifiersByItemID := map[DatabaseID][]Modifier{}
+	for _, menuItem := range db.MenuItems {
+		if db.Sites[menuItem.SiteID].PosID != siteID {
+			continue
+		}
+
+		for _, v := range menuItem.Modifiers {
+			modifier, contains := db.Modifiers[v.ID]
+			if contains {
+				modifiersByItemID[menuItem.ID] = append(modifiersByItemID[menuItem.ID], modifier)
+			}
+		}
+	}
+
+	return modifiersByItemID, nil
+}
+
 func (db *MemoryDB) DeleteMenuModifier(modifierID KountaID) error {
 	if db.Error != nil {
 		return db.Error
@@ -969,6 +2837,28 @@ func (db *MemoryDB) SelectOptionSetsByItemID(menuItemID DatabaseID) (*[]OptionSe
 
 	return &optionSets, nil
 }
+func (db *MemoryDB) SelectOptionSetsBySiteID(siteID KountaID) (map[DatabaseID][]OptionSet, error) {
+	if db.Error != nil {
+		return nil, db.Error
+	}
+
+	optionSetsByItemID := map[DatabaseID][]OptionSet{}
+	for _, menuItem := range db.MenuItems {
+		if db.Sites[menuItem.SiteID].PosID != siteID {
+			continue
+		}
+
+		for _, v := range menuItem.OptionSets {
+			optionSet, contains := db.OptionSets[v.ID]
+			if contains {
+				optionSetsByItemID[menuItem.ID] = append(optionSetsByItemID[menuItem.ID], optionSet)
+			}
+		}
+	}
+
+	return optionSetsByItemID, nil
+}
+
 func (db *MemoryDB) DeleteOptionSet(optionSetID KountaID) error {
 	if db.Error != nil {
 		return db.Error
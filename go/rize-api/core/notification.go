@@ -0,0 +1,220 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// These are the channels a Notification can be delivered through
+const (
+	NotificationChannelSMS   = "sms"
+	NotificationChannelEmail = "email"
+)
+
+// These are used to indicate whether a Notification was delivered
+const (
+	NotificationStatusSent   = "sent"
+	NotificationStatusFailed = "failed"
+)
+
+// NotificationTemplatePickupReady is the template used to tell a customer their pickup order is ready
+const NotificationTemplatePickupReady = "pickup_ready"
+
+var smsTemplates = map[string]*template.Template{
+	NotificationTemplatePickupReady: template.Must(template.New(NotificationTemplatePickupReady).Parse(
+		`Hi {{.CustomerName}}, your order from {{.SenderName}} is ready for pickup!`)),
+}
+
+var emailSubjects = map[string]string{
+	NotificationTemplatePickupReady: "Your order is ready for pickup",
+	NotificationTemplateReceipt:     "Your receipt",
+}
+
+// SMSProvider sends text messages to customers
+type SMSProvider interface {
+	SendSMS(from, to, body string) (messageID string, err error)
+}
+
+// NotificationSettings are the per-site sender settings used when notifying customers
+type NotificationSettings struct {
+	SiteID       KountaID `json:"site_id"`
+	SenderName   string   `json:"sender_name"` // SenderName is how the site is named in messages, defaults to the site name
+	SMSFrom      string   `json:"sms_from"`
+	SMSEnabled   bool     `json:"sms_enabled"`
+	EmailEnabled bool     `json:"email_enabled"`
+}
+
+// Notification is the delivery record of a message sent, or attempted, to a customer
+type Notification struct {
+	ID                DatabaseID `json:"id"`
+	OrderID           DatabaseID `json:"order_id"`
+	Channel           string     `json:"channel"`
+	Recipient         string     `json:"recipient"`
+	Template          string     `json:"template"`
+	ProviderMessageID string     `json:"provider_message_id"`
+	Status            string     `json:"status"`
+	Error             string     `json:"error"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// PickupReadyMessage is the data available to pickup ready templates
+type PickupReadyMessage struct {
+	CustomerName string
+	SenderName   string
+	SiteName     string
+	SitePhone    string
+	OrderID      DatabaseID
+	PickupTime   string
+}
+
+// UpdateNotificationSettings will save the sender settings used to notify a site's customers
+func (app AppContext) UpdateNotificationSettings(settings NotificationSettings) error {
+	if settings.SMSEnabled && settings.SMSFrom == "" {
+		return errors.New("update notification settings: a sender number is required to send SMS")
+	}
+
+	if err := app.DB.UpsertNotificationSettings(&settings); err != nil {
+		return errors.Wrap(err, "update notification settings")
+	}
+	return nil
+}
+
+// NotifyPickupReady will send a customer a text and/or email, based on the site's settings, saying their order is
+// ready. A delivery record is saved for every message attempted; a failure to deliver is recorded and not returned.
+func (app AppContext) NotifyPickupReady(order *Order) error {
+	settings, err := app.DB.GetNotificationSettings(order.SiteID)
+	if err != nil {
+		return errors.Wrap(err, "notify pickup ready")
+	}
+	if settings == nil {
+		return nil
+	}
+
+	site, err := app.DB.GetSite(order.SiteID)
+	if err != nil {
+		return errors.Wrap(err, "notify pickup ready")
+	}
+	if site == nil {
+		return errors.Errorf("notify pickup ready: site %d not found", order.SiteID)
+	}
+
+	details, err := app.DB.GetPickupDetails(order.ID)
+	if err != nil {
+		return errors.Wrap(err, "notify pickup ready")
+	}
+	if details == nil {
+		details = &PickupDetails{}
+	}
+
+	var customer *Customer
+	if order.CustomerID.Valid {
+		customer, err = app.DB.GetCustomer(DatabaseID(order.CustomerID.Int64))
+		if err != nil {
+			return errors.Wrap(err, "notify pickup ready")
+		}
+	}
+
+	message := PickupReadyMessage{
+		CustomerName: details.CustomerName,
+		SenderName:   settings.SenderName,
+		SiteName:     site.Name,
+		SitePhone:    site.PhoneNumber,
+		OrderID:      order.ID,
+	}
+	if message.SenderName == "" {
+		message.SenderName = site.Name
+	}
+	if message.CustomerName == "" && customer != nil {
+		message.CustomerName = customer.FirstName
+	}
+	if order.PickupTime != nil {
+		message.PickupTime, err = app.formatPickupTime(order.SiteID, *order.PickupTime)
+		if err != nil {
+			return errors.Wrap(err, "notify pickup ready")
+		}
+	}
+
+	if settings.SMSEnabled && app.SMS != nil && details.PhoneNumber != "" {
+		if err := app.sendSMS(order, settings.SMSFrom, details.PhoneNumber, NotificationTemplatePickupReady, message); err != nil {
+			return errors.Wrap(err, "notify pickup ready")
+		}
+	}
+
+	if settings.EmailEnabled && app.Mailer != nil && customer != nil && customer.Email != "" {
+		if err := app.sendEmail(order, customer.Email, NotificationTemplatePickupReady, message); err != nil {
+			return errors.Wrap(err, "notify pickup ready")
+		}
+	}
+
+	return nil
+}
+
+// notifyIfPickupReady will notify the customer when a pickup order moves to complete
+func (app AppContext) notifyIfPickupReady(existingOrder, updatedOrder *Order) {
+	if existingOrder.PickupTime == nil || existingOrder.Status == OrderStatusComplete || updatedOrder.Status != OrderStatusComplete {
+		return
+	}
+
+	readyOrder := *existingOrder
+	readyOrder.Status = updatedOrder.Status
+	if err := app.NotifyPickupReady(&readyOrder); err != nil {
+		log.Println(err)
+	}
+}
+
+func (app AppContext) sendSMS(order *Order, from, to, templateName string, data interface{}) error {
+	var body bytes.Buffer
+	if err := smsTemplates[templateName].Execute(&body, data); err != nil {
+		return errors.Wrapf(err, "render %s sms", templateName)
+	}
+
+	notification := Notification{
+		OrderID:   order.ID,
+		Channel:   NotificationChannelSMS,
+		Recipient: to,
+		Template:  templateName,
+		CreatedAt: time.Now(),
+	}
+
+	messageID, err := app.SMS.SendSMS(from, to, body.String())
+	notification.setResult(messageID, err)
+
+	return app.DB.InsertNotification(&notification)
+}
+
+func (app AppContext) sendEmail(order *Order, to, templateName string, data interface{}) error {
+	notification := Notification{
+		OrderID:   order.ID,
+		Channel:   NotificationChannelEmail,
+		Recipient: to,
+		Template:  templateName,
+		CreatedAt: time.Now(),
+	}
+
+	subject := emailSubjects[templateName]
+	if subject == "" {
+		return errors.New(fmt.Sprintf("send email: no subject for template %s", templateName))
+	}
+
+	err := app.Mailer.SendEmail(templateName, to, subject, data)
+	notification.setResult("", err)
+
+	return app.DB.InsertNotification(&notification)
+}
+
+func (n *Notification) setResult(providerMessageID string, err error) {
+	if err != nil {
+		n.Status = NotificationStatusFailed
+		n.Error = err.Error()
+		log.Printf("notification: failed to send %s %s to %s: %s", n.Template, n.Channel, n.Recipient, err)
+		return
+	}
+
+	n.Status = NotificationStatusSent
+	n.ProviderMessageID = providerMessageID
+}
@@ -0,0 +1,101 @@
+package core_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+	"notify"
+	"pos"
+)
+
+func TestCompletingPickupOrderNotifiesCustomer(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	sms := &notify.FakeSMS{}
+	app.SMS = sms
+
+	order := insertReadyPickupOrder(t, app)
+
+	// act
+	completedOrder := pos.NewMockKountaOrder()
+	completedOrder.Status = core.OrderStatusComplete
+	_, err := app.CreateOrUpdateOrderFromKounta(completedOrder)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(sms.Sent))
+	assert.Equal(t, "+15550001111", sms.Sent[0].From)
+	assert.Equal(t, "4041234567", sms.Sent[0].To)
+	assert.Equal(t, "Hi John Doe, your order from Test Pizza is ready for pickup!", sms.Sent[0].Body)
+
+	notifications, err := app.DB.SelectNotificationsByOrderID(order.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*notifications))
+	assert.Equal(t, core.NotificationStatusSent, (*notifications)[0].Status)
+}
+
+func TestRepeatedCompleteUpdateDoesNotNotifyAgain(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	sms := &notify.FakeSMS{}
+	app.SMS = sms
+
+	insertReadyPickupOrder(t, app)
+
+	completedOrder := pos.NewMockKountaOrder()
+	completedOrder.Status = core.OrderStatusComplete
+
+	// act
+	app.CreateOrUpdateOrderFromKounta(completedOrder)
+	app.CreateOrUpdateOrderFromKounta(completedOrder)
+
+	// assert
+	assert.Equal(t, 1, len(sms.Sent))
+}
+
+func TestFailedNotificationIsRecorded(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	app.SMS = &notify.FakeSMS{Error: errors.New("provider unavailable")}
+
+	order := insertReadyPickupOrder(t, app)
+
+	// act
+	completedOrder := pos.NewMockKountaOrder()
+	completedOrder.Status = core.OrderStatusComplete
+	_, err := app.CreateOrUpdateOrderFromKounta(completedOrder)
+
+	// assert
+	assert.NoError(t, err)
+	notifications, _ := app.DB.SelectNotificationsByOrderID(order.ID)
+	assert.Equal(t, 1, len(*notifications))
+	assert.Equal(t, core.NotificationStatusFailed, (*notifications)[0].Status)
+	assert.Equal(t, "provider unavailable", (*notifications)[0].Error)
+}
+
+// helpers
+
+// insertReadyPickupOrder inserts an on hold pickup order for a site that notifies customers by SMS
+func insertReadyPickupOrder(t *testing.T, app core.AppContext) *core.Order {
+	settings := core.NotificationSettings{SiteID: core.TestSitePosID, SenderName: "Test Pizza", SMSFrom: "+15550001111", SMSEnabled: true}
+	if err := app.UpdateNotificationSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	order := insertPickupOrder(t, app)
+	pickupTime := time.Now().Add(time.Hour)
+	pickupDetails := core.PickupDetails{CustomerName: "John Doe", PhoneNumber: "4041234567", PickupTime: &pickupTime}
+	if err := app.UpdatePickupDetails(order.ID, pickupDetails); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
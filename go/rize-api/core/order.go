@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/pkg/errors"
@@ -30,6 +31,7 @@ type Order struct {
 	CustomerID  sql.NullInt64 `json:"customer_id"`
 	Total       int           `json:"total"`
 	TotalTax    int           `json:"total_tax"`
+	Discount    int           `json:"discount"` // Discount is the total of the discounts applied when the order was created
 	Lines       []Line        `json:"lines"`
 	PagerNumber string        `json:"puck_id"` //todo: coordinate the rename with the client apps
 	SiteID      KountaID      `json:"site_id"`
@@ -70,6 +72,10 @@ func (app AppContext) CreateOrUpdateOrderFromKounta(kountaOrder KountaOrder) (*O
 		if err != nil {
 			return nil, errors.Wrap(err, "create or update order from kounta")
 		}
+
+		app.notifyIfPickupReady(existingOrder, order)
+		app.reverseLoyaltyIfRejected(existingOrder, order)
+		app.publishOrderEvent(OrderEventUpdated, order)
 		return order, nil
 	}
 
@@ -82,7 +88,14 @@ func (app *AppContext) CreateOrderForPager(siteID KountaID, pagerNumber int64) (
 		return nil, errors.Wrapf(err, "order: error getting order for site '%d' and pager '%d'", pagerNumber)
 	}
 	if existingOrder != nil {
-		return nil, errors.Errorf("order: existing order '%d' found for pager '%d' at site '%d'", existingOrder.ID, pagerNumber, siteID)
+		return nil, PagerError{Reason: fmt.Sprintf("pager %d at site %d is still on order %d, release it first", pagerNumber, siteID, existingOrder.ID)}
+	}
+	pager, err := app.DB.GetPager(siteID, pagerNumber)
+	if err != nil {
+		return nil, errors.Wrapf(err, "order: error getting pager '%d' for site '%d'", pagerNumber, siteID)
+	}
+	if pager != nil && pager.Status == PagerStatusLost {
+		return nil, lostPagerError(siteID, pagerNumber)
 	}
 
 	kountaOrder, err := app.Kounta.CreateOrderForPager(siteID, pagerNumber)
@@ -95,6 +108,11 @@ func (app *AppContext) CreateOrderForPager(siteID KountaID, pagerNumber int64) (
 		return nil, err
 	}
 
+	// the order is already in Kounta, so failing to track the pager shouldn't fail it
+	if err := app.assignPager(siteID, pagerNumber, createdOrder.ID); err != nil {
+		log.Println(errors.Wrapf(err, "order: error assigning pager '%d'", pagerNumber))
+	}
+
 	return createdOrder, nil
 }
 
@@ -104,19 +122,115 @@ func (app *AppContext) CreateNewOrder(siteID KountaID, createOrder CreateOrder)
 		return nil, errors.Wrap(err, "create new order")
 	}
 
-	kountaOrder, err := app.Kounta.CreateOrder(siteID, createOrder)
+	if err := app.applyFees(siteID, &createOrder); err != nil {
+		return nil, errors.Wrap(err, "create new order")
+	}
+
+	if len(createOrder.Fees) > 0 || createOrder.PromoCode != "" || createOrder.RewardID != 0 {
+		if _, err := app.kountaPriceLineCreator(); err != nil {
+			return nil, errors.Wrap(err, "create new order")
+		}
+	}
+
+	promoRedemption, err := app.applyPromoCode(siteID, &createOrder)
 	if err != nil {
 		return nil, errors.Wrap(err, "create new order")
 	}
 
-	createdOrder, err := app.createOrderFromKountaOrder(kountaOrder)
+	redemption, err := app.redeemLoyaltyReward(siteID, &createOrder)
 	if err != nil {
+		app.cancelPromoRedemption(promoRedemption)
 		return nil, errors.Wrap(err, "create new order")
 	}
 
+	kountaOrder, err := app.createKountaOrder(siteID, createOrder)
+	if err != nil {
+		app.cancelRedemptions(promoRedemption, redemption)
+		return nil, errors.Wrap(err, "create new order")
+	}
+
+	createdOrder := newOrderFromKountaOrder(kountaOrder)
+	createdOrder.Discount = createOrder.DiscountTotal()
+	if err := app.DB.InsertOrder(createdOrder); err != nil {
+		app.cancelRedemptions(promoRedemption, redemption)
+		return nil, errors.Wrap(err, "create new order")
+	}
+
+	// linked before anything else can fail, so the redemptions are either on the order or cancelled
+	if err := app.linkRedemptions(createdOrder.ID, promoRedemption, redemption); err != nil {
+		app.cancelRedemptions(promoRedemption, redemption)
+		return nil, errors.Wrap(err, "create new order")
+	}
+
+	if len(createOrder.Fees) > 0 {
+		if err := app.DB.InsertOrderFees(createdOrder.ID, createOrder.Fees); err != nil {
+			return nil, errors.Wrap(err, "create new order")
+		}
+	}
+
+	app.publishOrderEvent(OrderEventCreated, createdOrder)
 	return createdOrder, nil
 }
 
+// linkRedemptions will set the order a promo code and loyalty reward were redeemed for, if there were any
+func (app AppContext) linkRedemptions(orderID DatabaseID, promoRedemption *PromoRedemption, redemption *LoyaltyEntry) error {
+	if promoRedemption != nil {
+		if err := app.DB.UpdatePromoRedemptionOrderID(promoRedemption.ID, orderID); err != nil {
+			return err
+		}
+	}
+	if redemption != nil {
+		if err := app.DB.UpdateLoyaltyEntryOrderID(redemption.ID, orderID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelRedemptions will free up the promo code and give back the points redeemed for an order that wasn't saved
+func (app AppContext) cancelRedemptions(promoRedemption *PromoRedemption, redemption *LoyaltyEntry) {
+	app.cancelPromoRedemption(promoRedemption)
+	if redemption != nil {
+		// give the points back, the customer didn't get the discount
+		if err := app.reverseLoyaltyEntry(*redemption, "order not created"); err != nil {
+			log.Println(errors.Wrap(err, "cancel redemptions"))
+		}
+	}
+}
+
+// cancelPromoRedemption will free up a promo code redeemed for an order that wasn't created, if there was one
+func (app AppContext) cancelPromoRedemption(redemption *PromoRedemption) {
+	if redemption == nil {
+		return
+	}
+	if err := app.DB.DeletePromoRedemption(redemption.ID); err != nil {
+		log.Println(errors.Wrap(err, "cancel promo redemption"))
+	}
+}
+
+// createKountaOrder will create a new order in Kounta with a line for each of its fees and discounts
+func (app AppContext) createKountaOrder(siteID KountaID, createOrder CreateOrder) (KountaOrder, error) {
+	lines := createOrder.PriceLines()
+	if len(lines) == 0 {
+		return app.Kounta.CreateOrder(siteID, createOrder)
+	}
+
+	creator, err := app.kountaPriceLineCreator()
+	if err != nil {
+		return nil, err
+	}
+	return creator.CreateOrderWithPriceLines(siteID, createOrder, lines)
+}
+
+// kountaPriceLineCreator returns the Kounta client if it can add fee and discount lines to new orders
+func (app AppContext) kountaPriceLineCreator() (KountaPriceLineCreator, error) {
+	creator, ok := app.Kounta.(KountaPriceLineCreator)
+	if !ok {
+		return nil, errors.New("the kounta client can't add fee and discount lines to new orders")
+	}
+	return creator, nil
+}
+
 // AddMenuItemsToOrder will add a a list of new menu items to an existing order
 func (app *AppContext) AddMenuItemsToOrder(orderID DatabaseID, menuItems []CreateOrderMenuItem) (*Order, error) {
 	// find existing order in db
@@ -154,6 +268,7 @@ func (app AppContext) createOrderFromKountaOrder(kountaOrder KountaOrder) (*Orde
 		return nil, errors.Wrapf(err, "createOrderFromKountaOrder(%d)", kountaOrder.GetPosID())
 	}
 
+	app.publishOrderEvent(OrderEventCreated, order)
 	return order, nil
 }
 
@@ -213,8 +328,8 @@ func (app AppContext) FindPayableOrdersByTableName(siteID KountaID, tableName st
 		return nil, errors.Wrapf(err, "find payable orders by table %s", tableName)
 	}
 
-	for i := range payableOrders {
-		app.loadLines(&(payableOrders)[i])
+	if err = app.loadLinesForOrders(payableOrders); err != nil {
+		return nil, errors.Wrapf(err, "find payable orders by table %s", tableName)
 	}
 
 	return payableOrders, nil
@@ -232,11 +347,8 @@ func (app AppContext) FindPayableOrdersByPagerID(siteID KountaID, pagerID int64)
 		return nil, errors.Wrapf(err, "find payable orders by pager %d", pagerID)
 	}
 
-	for i := range payableOrders {
-		err = app.loadLines(&(payableOrders)[i])
-		if err != nil {
-			return nil, errors.Wrapf(err, "find payable orders by pager %d", pagerID)
-		}
+	if err = app.loadLinesForOrders(payableOrders); err != nil {
+		return nil, errors.Wrapf(err, "find payable orders by pager %d", pagerID)
 	}
 
 	return payableOrders, nil
@@ -244,15 +356,18 @@ func (app AppContext) FindPayableOrdersByPagerID(siteID KountaID, pagerID int64)
 
 // getPayableOrders will return a slice of only the payable orders from the given slice
 func (app AppContext) getPayableOrders(orders []Order) ([]Order, error) {
+	payments, err := app.DB.SelectPaymentsByOrderIDs(orderIDs(orders))
+	if err != nil {
+		return nil, errors.Wrapf(err, "filter out payable orders")
+	}
+
 	// filter out any paid orders
 	payableOrders := []Order{}
 	for _, order := range orders {
-
-		payable, err := app.IsOrderPayable(order)
-		if err != nil {
-			return nil, errors.Wrapf(err, "filter out payable orders")
+		if isPaid(order, payments[order.ID]) {
+			continue
 		}
-		if payable {
+		if order.Status == OrderStatusPending || order.Status == OrderStatusOnHold {
 			payableOrders = append(payableOrders, order)
 		}
 	}
@@ -262,46 +377,61 @@ func (app AppContext) getPayableOrders(orders []Order) ([]Order, error) {
 
 // IsOrderPayable will return boolean on whether order can be paid
 func (app AppContext) IsOrderPayable(order Order) (bool, error) {
-	payment, err := app.DB.GetPaymentByOrderID(order.ID)
+	payments, err := app.DB.SelectPaymentsByOrderIDs([]DatabaseID{order.ID})
 	if err != nil {
 		return false, errors.Wrapf(err, "filter out payable orders")
 	}
-	if payment != nil {
+	if isPaid(order, payments[order.ID]) {
 		return false, nil
 	}
 
 	return order.Status == OrderStatusPending || order.Status == OrderStatusOnHold, nil
 }
 
-// TODO: Move this into database layer
+// isPaid is true once an order's payments cover its total. An order part paid by gift card can still be paid.
+func isPaid(order Order, payments []Payment) bool {
+	return len(payments) > 0 && amountPaid(payments) >= order.Total
+}
+
 // loadLines will find and attach lines to this order
 func (app AppContext) loadLines(o *Order) error {
-	lines, err := app.DB.SelectLines(o.ID)
-	if err != nil {
-		return errors.Wrapf(err, "Error loading lines for Order '%v'", o.ID)
+	orders := []Order{*o}
+	if err := app.loadLinesForOrders(orders); err != nil {
+		return err
 	}
-	o.Lines = *lines
+	*o = orders[0]
+	return nil
+}
 
-	for i, l := range o.Lines {
-		added, err := app.DB.SelectAddedModifiers(l.ID)
-		if err != nil {
-			return errors.Wrapf(err, "Error loading added modifiers for Line '%v'", l.ID)
-		}
-		if len(*added) > 0 {
-			o.Lines[i].AddedModifiers = *added
-		}
+// loadLinesForOrders will find and attach lines, with their modifiers, to every order using a single batched lookup
+func (app AppContext) loadLinesForOrders(orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
 
-		removed, err := app.DB.SelectRemovedModifiers(l.ID)
-		if err != nil {
-			return errors.Wrapf(err, "Error loading removed modifiers for Line '%v'", l.ID)
-		}
-		if len(*removed) > 0 {
-			o.Lines[i].RemovedModifiers = *removed
+	lines, err := app.DB.SelectLinesByOrderIDs(orderIDs(orders))
+	if err != nil {
+		return errors.Wrapf(err, "Error loading lines for Orders %v", orderIDs(orders))
+	}
+
+	for i := range orders {
+		orders[i].Lines = lines[orders[i].ID]
+		if orders[i].Lines == nil {
+			orders[i].Lines = []Line{}
 		}
 	}
 	return nil
 }
 
+// orderIDs returns the database IDs of the given orders
+func orderIDs(orders []Order) []DatabaseID {
+	ids := make([]DatabaseID, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+	return ids
+}
+
 func (app AppContext) DeleteLine(orderID, lineID DatabaseID) error {
 	order, err := app.DB.GetOrderByDatabaseID(orderID)
 	if err != nil {
@@ -357,14 +487,27 @@ func (app AppContext) LinkOrderWithTable(siteID KountaID, pagerNumber int64, tab
 		return errors.Wrap(err, "order: error setting table name on order")
 	}
 
-	return err
+	previousTable := order.TableName
+	order.TableName = tableName
+	app.publishTableLinked(order, previousTable)
+	return nil
 }
 
 func (app AppContext) UpdateOrder(order *Order) error {
-	if order.Status == OrderStatusComplete || order.Status == OrderStatusRejected || order.Status == OrderStatusDeleted {
+	finished := order.Status == OrderStatusComplete || order.Status == OrderStatusRejected || order.Status == OrderStatusDeleted
+	if finished {
 		order.PagerNumber = "" // clear pager number for paid orders so that pager can be reused
 	}
-	return app.DB.UpdateOrder(order)
+	if err := app.DB.UpdateOrder(order); err != nil {
+		return err
+	}
+
+	if finished {
+		if err := app.DB.ReleasePagersByOrderID(order.ID, time.Now()); err != nil {
+			return errors.Wrap(err, "update order: error releasing pager")
+		}
+	}
+	return nil
 }
 
 func (app AppContext) UpdateOrderWithCustomer(orderID, customerID DatabaseID) error {
@@ -425,7 +568,8 @@ func (app AppContext) RejectOrder(orderID KountaID) error {
 	return nil
 }
 
-// UpdatePickupDetails will update any pickup details on an order, or add new details if none set, and move to ON HOLD
+// UpdatePickupDetails will update any pickup details on an order, or add new details if none set, and move to ON HOLD.
+// The site's packaging fees are charged the first time an order gets a pickup time.
 func (app AppContext) UpdatePickupDetails(orderID DatabaseID, pickupDetails PickupDetails) error {
 	order, err := app.DB.GetOrderByDatabaseID(orderID)
 	if err != nil {
@@ -436,39 +580,59 @@ func (app AppContext) UpdatePickupDetails(orderID DatabaseID, pickupDetails Pick
 	}
 
 	pickupTime := pickupDetails.PickupTime
-	if err != nil {
-		errors.Wrap(err, "update pickup details")
+	if pickupTime == nil {
+		return errors.Wrap(PickupTimeError{Reason: "a pickup time is required"}, "update pickup details")
 	}
-	const layout = "3:04 pm"
-	pickupTimeReadableString := pickupTime.Format(layout)
-	notes := fmt.Sprintf("TO-GO APP - PAID\n\n%s\n\n%s\n\n%s", pickupTimeReadableString, pickupDetails.CustomerName, pickupDetails.PhoneNumber)
 
-	err = app.Kounta.SetOrderNotes(order.PosID, notes)
-	if err != nil {
+	// errors.Cause will return a PickupTimeError if the time can't be scheduled
+	if err = app.reservePickupTime(order, *pickupTime); err != nil {
 		return errors.Wrap(err, "update pickup details")
 	}
 
-	err = app.Kounta.PutOrderOnHold(order.PosID)
-	if err != nil {
+	if err = app.updateKountaPickupDetails(order, pickupDetails); err != nil {
+		// give the slot back, as the order hasn't been scheduled in kounta
+		if releaseErr := app.DB.ReleasePickupTime(order, order.PickupTime); releaseErr != nil {
+			log.Println(errors.Wrap(releaseErr, "update pickup details"))
+		}
 		return errors.Wrap(err, "update pickup details")
 	}
 
-	// turn around and get order from kounta now that we've updated
-	kountaOrder, err := app.Kounta.GetOrderByID(order.PosID)
-	if err != nil {
+	// saved so the customer can be notified when their order is ready
+	if err = app.DB.UpsertPickupDetails(order.ID, pickupDetails); err != nil {
 		return errors.Wrap(err, "update pickup details")
 	}
 
-	_, err = app.CreateOrUpdateOrderFromKounta(kountaOrder)
+	return nil
+}
+
+// updateKountaPickupDetails charges the order's pickup fees, writes the pickup details into its notes and puts it on
+// hold in kounta
+func (app AppContext) updateKountaPickupDetails(order *Order, pickupDetails PickupDetails) error {
+	if err := app.applyPickupFees(order); err != nil {
+		return err
+	}
+
+	pickupTimeReadableString, err := app.formatPickupTime(order.SiteID, *pickupDetails.PickupTime)
 	if err != nil {
-		return errors.Wrap(err, "update pickup details")
+		return err
 	}
+	notes := pickupNotes(pickupTimeReadableString, pickupDetails.CustomerName, pickupDetails.PhoneNumber)
 
-	if err = app.DB.UpdateOrderPickupTime(order, *pickupTime); err != nil {
-		return errors.Wrap(err, "update pickup details")
+	if err = app.Kounta.SetOrderNotes(order.PosID, notes); err != nil {
+		return err
+	}
+	if err = app.Kounta.PutOrderOnHold(order.PosID); err != nil {
+		return err
 	}
 
-	return nil
+	// turn around and get order from kounta now that we've updated
+	kountaOrder, err := app.Kounta.GetOrderByID(order.PosID)
+	if err != nil {
+		return err
+	}
+
+	_, err = app.CreateOrUpdateOrderFromKounta(kountaOrder)
+	return err
 }
 
 func (app *AppContext) addKountaIDsToNewOrder(siteID KountaID, createOrder *CreateOrder) error {
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// These are the kinds of change an OrderEvent is published for
+const (
+	OrderEventCreated     = "created"
+	OrderEventUpdated     = "updated"
+	OrderEventTableLinked = "table_linked"
+	OrderEventPayment     = "payment"
+)
+
+// orderEventBuffer is how many events a subscriber can fall behind by before further events are dropped for it
+const orderEventBuffer = 16
+
+// orderEventKeepAlive is how often an idle event stream is written to, so proxies don't close it
+const orderEventKeepAlive = 15 * time.Second
+
+// OrderEvent is published whenever an order changes. It only has what guests and staff need to follow an order, so it
+// can be sent on table and site feeds without giving away who ordered.
+type OrderEvent struct {
+	Type        string     `json:"type"`
+	OrderID     DatabaseID `json:"order_id"`
+	SiteID      KountaID   `json:"site_id"`
+	Status      string     `json:"status"`
+	TableName   string     `json:"table_name"`
+	PagerNumber string     `json:"puck_id"`
+	Total       int        `json:"total"`
+	At          time.Time  `json:"at"`
+}
+
+// OrderEventBroker delivers order events to whoever is subscribed to a topic. Subscribe returns the events and a
+// function to call when the subscriber is done.
+type OrderEventBroker interface {
+	Publish(topic string, event OrderEvent)
+	Subscribe(topic string) (<-chan OrderEvent, func())
+}
+
+// OrderEventsTopic is the topic for a single order's events
+func OrderEventsTopic(orderID DatabaseID) string {
+	return fmt.Sprintf("order:%d", orderID)
+}
+
+// TableEventsTopic is the topic for events of orders at a table
+func TableEventsTopic(siteID KountaID, tableName string) string {
+	return fmt.Sprintf("table:%d:%s", siteID, tableName)
+}
+
+// SiteEventsTopic is the topic for events of every order at a site
+func SiteEventsTopic(siteID KountaID) string {
+	return fmt.Sprintf("site:%d", siteID)
+}
+
+// MemoryOrderEventBroker is an OrderEventBroker for a single process. A subscriber that falls behind misses events
+// rather than holding up the order changes that publish them.
+type MemoryOrderEventBroker struct {
+	mutex       sync.Mutex
+	subscribers map[string]map[chan OrderEvent]bool
+}
+
+func NewMemoryOrderEventBroker() *MemoryOrderEventBroker {
+	return &MemoryOrderEventBroker{subscribers: map[string]map[chan OrderEvent]bool{}}
+}
+
+func (b *MemoryOrderEventBroker) Publish(topic string, event OrderEvent) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for events := range b.subscribers[topic] {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+func (b *MemoryOrderEventBroker) Subscribe(topic string) (<-chan OrderEvent, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	events := make(chan OrderEvent, orderEventBuffer)
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = map[chan OrderEvent]bool{}
+	}
+	b.subscribers[topic][events] = true
+
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			b.mutex.Lock()
+			defer b.mutex.Unlock()
+
+			delete(b.subscribers[topic], events)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			close(events)
+		})
+	}
+}
+
+// ServeOrderEvents will stream a topic's order events to a client as server-sent events until the client goes away.
+// Checking that the client may follow the topic is up to the caller.
+func (app AppContext) ServeOrderEvents(w http.ResponseWriter, r *http.Request, topic string) error {
+	if app.OrderEvents == nil {
+		return errors.New("serve order events: no order event broker")
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("serve order events: streaming isn't supported")
+	}
+
+	events, unsubscribe := app.OrderEvents.Subscribe(topic)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// tells the client it is subscribed, so it doesn't miss changes made right after connecting
+	fmt.Fprint(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(orderEventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return errors.Wrap(err, "serve order events")
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// publishOrderEvent tells anyone following the order, its table or its site that it changed
+func (app AppContext) publishOrderEvent(eventType string, order *Order) {
+	app.publishOrderEventAtTables(eventType, order, "")
+}
+
+// publishTableLinked tells anyone following the order that it moved tables, including guests at the table it left
+func (app AppContext) publishTableLinked(order *Order, previousTable string) {
+	if previousTable == order.TableName {
+		previousTable = ""
+	}
+	app.publishOrderEventAtTables(OrderEventTableLinked, order, previousTable)
+}
+
+func (app AppContext) publishOrderEventAtTables(eventType string, order *Order, previousTable string) {
+	if app.OrderEvents == nil || order == nil {
+		return
+	}
+
+	event := OrderEvent{
+		Type:        eventType,
+		OrderID:     order.ID,
+		SiteID:      order.SiteID,
+		Status:      order.Status,
+		TableName:   order.TableName,
+		PagerNumber: order.PagerNumber,
+		Total:       order.Total,
+		At:          time.Now(),
+	}
+	app.OrderEvents.Publish(OrderEventsTopic(order.ID), event)
+	if order.TableName != "" {
+		app.OrderEvents.Publish(TableEventsTopic(order.SiteID, order.TableName), event)
+	}
+	if previousTable != "" {
+		app.OrderEvents.Publish(TableEventsTopic(order.SiteID, previousTable), event)
+	}
+	app.OrderEvents.Publish(SiteEventsTopic(order.SiteID), event)
+}
@@ -0,0 +1,181 @@
+package core_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+func TestOrderChangesArePublished(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	app.OrderEvents = core.NewMemoryOrderEventBroker()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	siteEvents, unsubscribe := app.OrderEvents.Subscribe(core.SiteEventsTopic(core.TestSitePosID))
+	defer unsubscribe()
+	tableEvents, unsubscribeTable := app.OrderEvents.Subscribe(core.TableEventsTopic(core.TestSitePosID, "9"))
+	defer unsubscribeTable()
+
+	// act
+	order, _ := app.CreateOrderForPager(core.TestSitePosID, 20)
+	app.LinkOrderWithTable(core.TestSitePosID, 20, "9")
+	order, _ = app.FindOrderByID(order.ID)
+	app.SavePayment(&core.Payment{Amount: order.Total, OrderID: order.ID, Date: time.Now(), CardLast4: "1111"}, order)
+	kountaOrder := pos.NewMockKountaOrder()
+	kountaOrder.PosID = order.PosID
+	kountaOrder.Status = core.OrderStatusComplete
+	app.CreateOrUpdateOrderFromKounta(kountaOrder)
+
+	// assert
+	published := receiveOrderEvents(t, siteEvents, 4)
+	assert.Equal(t, core.OrderEventCreated, published[0].Type)
+	assert.Equal(t, order.ID, published[0].OrderID)
+	assert.Equal(t, core.OrderEventTableLinked, published[1].Type)
+	assert.Equal(t, "9", published[1].TableName)
+	assert.Equal(t, core.OrderEventPayment, published[2].Type)
+	assert.Equal(t, core.OrderEventUpdated, published[3].Type)
+	assert.Equal(t, core.OrderStatusComplete, published[3].Status)
+
+	atTable := receiveOrderEvents(t, tableEvents, 2)
+	assert.Equal(t, core.OrderEventTableLinked, atTable[0].Type)
+	assert.Equal(t, core.OrderEventPayment, atTable[1].Type)
+}
+
+func TestNewOrderIsPublished(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.OrderEvents = core.NewMemoryOrderEventBroker()
+	app.Kounta = &orderKounta{MockKounta: &pos.MockKounta{}}
+	createOrder := pricedPromoOrder(t, app)
+	siteEvents, unsubscribe := app.OrderEvents.Subscribe(core.SiteEventsTopic(core.TestSitePosID))
+	defer unsubscribe()
+
+	// act
+	order, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	published := receiveOrderEvents(t, siteEvents, 1)
+	assert.Equal(t, core.OrderEventCreated, published[0].Type)
+	assert.Equal(t, order.ID, published[0].OrderID)
+}
+
+func TestTableMoveIsPublishedToBothTables(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	app.OrderEvents = core.NewMemoryOrderEventBroker()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	order, _ := app.CreateOrderForPager(core.TestSitePosID, 20)
+	app.LinkOrderWithTable(core.TestSitePosID, 20, "9")
+	oldTableEvents, unsubscribeOld := app.OrderEvents.Subscribe(core.TableEventsTopic(core.TestSitePosID, "9"))
+	defer unsubscribeOld()
+	newTableEvents, unsubscribeNew := app.OrderEvents.Subscribe(core.TableEventsTopic(core.TestSitePosID, "10"))
+	defer unsubscribeNew()
+	movedTableEvents, unsubscribeMoved := app.OrderEvents.Subscribe(core.TableEventsTopic(core.TestSitePosID, "11"))
+	defer unsubscribeMoved()
+
+	// act
+	app.LinkOrderWithTable(core.TestSitePosID, 20, "10")
+	app.ReassignOrderTable(order.ID, "11")
+
+	// assert
+	left := receiveOrderEvents(t, oldTableEvents, 1)
+	assert.Equal(t, core.OrderEventTableLinked, left[0].Type)
+	assert.Equal(t, "10", left[0].TableName)
+
+	atNewTable := receiveOrderEvents(t, newTableEvents, 2)
+	assert.Equal(t, "10", atNewTable[0].TableName)
+	assert.Equal(t, "11", atNewTable[1].TableName)
+
+	atMovedTable := receiveOrderEvents(t, movedTableEvents, 1)
+	assert.Equal(t, "11", atMovedTable[0].TableName)
+}
+
+func TestMemoryOrderEventBrokerDropsEventsForSlowSubscribers(t *testing.T) {
+	// arrange
+	broker := core.NewMemoryOrderEventBroker()
+	events, unsubscribe := broker.Subscribe("order:1")
+
+	// act
+	for i := 0; i < 100; i++ {
+		broker.Publish("order:1", core.OrderEvent{Type: core.OrderEventUpdated, OrderID: 1})
+	}
+	broker.Publish("order:2", core.OrderEvent{Type: core.OrderEventUpdated, OrderID: 2})
+	unsubscribe()
+	unsubscribe()
+
+	// assert
+	received := 0
+	for event := range events {
+		assert.Equal(t, core.DatabaseID(1), event.OrderID)
+		received++
+	}
+	assert.True(t, received > 0 && received < 100)
+}
+
+func TestServeOrderEvents(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.OrderEvents = core.NewMemoryOrderEventBroker()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.ServeOrderEvents(w, r, core.OrderEventsTopic(1))
+	}))
+	defer server.Close()
+
+	response, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	reader := bufio.NewReader(response.Body)
+	readEventStreamLine(t, reader) // the subscribed comment
+	readEventStreamLine(t, reader)
+
+	// act
+	app.OrderEvents.Publish(core.OrderEventsTopic(1), core.OrderEvent{Type: core.OrderEventUpdated, OrderID: 1, Status: core.OrderStatusAccepted})
+
+	// assert
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	assert.Equal(t, "event: updated", readEventStreamLine(t, reader))
+	data := readEventStreamLine(t, reader)
+	event := core.OrderEvent{}
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &event))
+	assert.Equal(t, core.OrderStatusAccepted, event.Status)
+}
+
+// helpers
+
+func receiveOrderEvents(t *testing.T, events <-chan core.OrderEvent, count int) []core.OrderEvent {
+	received := []core.OrderEvent{}
+	for len(received) < count {
+		select {
+		case event := <-events:
+			received = append(received, event)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d order events", len(received), count)
+		}
+	}
+	return received
+}
+
+func readEventStreamLine(t *testing.T, reader *bufio.Reader) string {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\n")
+}
@@ -0,0 +1,175 @@
+package core
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultOrderHistoryLimit = 20
+	maxOrderHistoryLimit     = 50
+)
+
+// OrderHistory is one page of a customer's past orders, newest first
+type OrderHistory struct {
+	Orders []PastOrder `json:"orders"`
+	// NextBefore is passed as before to get the next page of older orders, it is 0 on the last page
+	NextBefore DatabaseID `json:"next_before"`
+}
+
+// PastOrder is an order in a customer's order history with a summary of how it was paid
+type PastOrder struct {
+	Order     *Order           `json:"order"`
+	CreatedAt time.Time        `json:"created_at"`
+	Payments  []PaymentSummary `json:"payments"`
+}
+
+// PaymentSummary is what a customer needs to recognise a payment
+type PaymentSummary struct {
+	Amount    int       `json:"amount"`
+	Tip       int       `json:"tip"`
+	CardLast4 string    `json:"card_last_4"`
+	Method    string    `json:"method"`
+	Date      time.Time `json:"date"`
+}
+
+// Reorder is a new order rebuilt from a past order, ready to be reviewed and then passed to CreateNewOrder
+type Reorder struct {
+	Order       CreateOrder       `json:"order"`
+	Unavailable []UnavailableItem `json:"unavailable"`
+}
+
+// UnavailableItem is a line, or a modifier on a line, from a past order that could not be added to a Reorder
+type UnavailableItem struct {
+	Name     string `json:"name"`
+	Modifier string `json:"modifier,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+// GetOrderHistory will return a page of a customer's orders, with their lines and payments, older than the order
+// before. Pass 0 for before to start with the most recent order.
+func (app AppContext) GetOrderHistory(customerID, before DatabaseID, limit int) (*OrderHistory, error) {
+	if limit <= 0 {
+		limit = defaultOrderHistoryLimit
+	}
+	if limit > maxOrderHistoryLimit {
+		limit = maxOrderHistoryLimit
+	}
+
+	// ask for one more order than we need to know if there is another page
+	orders, err := app.DB.SelectOrdersByCustomerIDBefore(customerID, before, limit+1)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get order history for customer %d", customerID)
+	}
+
+	history := OrderHistory{Orders: []PastOrder{}}
+	page := *orders
+	if len(page) > limit {
+		page = page[:limit]
+		history.NextBefore = page[limit-1].ID
+	}
+
+	if err := app.loadLinesForOrders(page); err != nil {
+		return nil, errors.Wrapf(err, "get order history for customer %d", customerID)
+	}
+
+	payments, err := app.DB.SelectPaymentsByOrderIDs(orderIDs(page))
+	if err != nil {
+		return nil, errors.Wrapf(err, "get order history for customer %d", customerID)
+	}
+
+	for i := range page {
+		pastOrder := PastOrder{Order: &page[i], CreatedAt: page[i].CreatedAt, Payments: []PaymentSummary{}}
+		for _, payment := range payments[page[i].ID] {
+			pastOrder.Payments = append(pastOrder.Payments, PaymentSummary{
+				Amount:    payment.Amount,
+				Tip:       payment.Tip,
+				CardLast4: payment.CardLast4,
+				Method:    payment.Method,
+				Date:      payment.Date,
+			})
+		}
+		history.Orders = append(history.Orders, pastOrder)
+	}
+	return &history, nil
+}
+
+// Reorder will rebuild a past order against the site's current menu. Lines whose menu items are no longer on the menu
+// and modifiers that can no longer be selected are left out and reported in Unavailable. Customers can only reorder
+// their own orders.
+func (app AppContext) Reorder(customerID, orderID DatabaseID) (*Reorder, error) {
+	order, err := app.FindOrderByID(orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "reorder")
+	}
+	if order == nil || !order.CustomerID.Valid || DatabaseID(order.CustomerID.Int64) != customerID {
+		return nil, errors.Errorf("reorder: order %d not found", orderID)
+	}
+
+	categories, err := app.GetCategoriesForSite(order.SiteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "reorder")
+	}
+
+	menuItems := map[KountaID]MenuItem{}
+	for _, category := range categories {
+		if !category.ClientFacing {
+			continue
+		}
+		for _, menuItem := range category.MenuItems {
+			menuItems[menuItem.PosID] = menuItem
+		}
+	}
+
+	reorder := Reorder{
+		Order:       CreateOrder{SiteID: order.SiteID, MenuItems: []CreateOrderMenuItem{}},
+		Unavailable: []UnavailableItem{},
+	}
+	for _, line := range order.Lines {
+		menuItem, ok := menuItems[line.PosID]
+		if !ok {
+			reorder.Unavailable = append(reorder.Unavailable, UnavailableItem{Name: line.ProductName, Reason: "no longer on the menu"})
+			continue
+		}
+
+		item := CreateOrderMenuItem{ID: menuItem.ID, Quantity: line.Quantity}
+		for _, modifier := range line.AddedModifiers {
+			if selected, ok := menuItemModifier(menuItem, modifier.PosID); ok {
+				item.SelectedModifierIDs = append(item.SelectedModifierIDs, selected.ID)
+			} else if option, ok := menuItemOption(menuItem, modifier.PosID); ok {
+				item.SelectedOptions = append(item.SelectedOptions, option)
+			} else {
+				reorder.Unavailable = append(reorder.Unavailable, UnavailableItem{Name: menuItem.Name, Modifier: modifier.Name, Reason: "no longer available"})
+			}
+		}
+
+		// a new order can only add modifiers, so anything removed from the original line has to be removed again
+		for _, modifier := range line.RemovedModifiers {
+			reorder.Unavailable = append(reorder.Unavailable, UnavailableItem{Name: menuItem.Name, Modifier: modifier.Name, Reason: "removed modifiers cannot be reordered"})
+		}
+
+		reorder.Order.MenuItems = append(reorder.Order.MenuItems, item)
+	}
+	return &reorder, nil
+}
+
+func menuItemModifier(menuItem MenuItem, posID KountaID) (*Modifier, bool) {
+	for _, modifier := range menuItem.Modifiers {
+		if modifier.PosID == posID {
+			return &modifier, true
+		}
+	}
+	return nil, false
+}
+
+func menuItemOption(menuItem MenuItem, posID KountaID) (MenuItemSelectedOption, bool) {
+	for _, optionSet := range menuItem.OptionSets {
+		for _, option := range optionSet.Options {
+			if option.PosID == posID {
+				return MenuItemSelectedOption{OptionSetID: optionSet.ID, ModifierID: option.ID}, true
+			}
+		}
+	}
+	return MenuItemSelectedOption{}, false
+}
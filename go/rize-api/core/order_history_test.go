@@ -0,0 +1,126 @@
+package core_test
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrderHistoryPages(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	customerID := sql.NullInt64{Int64: int64(customer.ID), Valid: true}
+
+	older := core.Order{PosID: 1001, SiteID: core.TestSitePosID, Status: core.OrderStatusComplete, CustomerID: customerID}
+	app.TestInsertOrder(t, &older)
+	newer := core.Order{PosID: 1002, SiteID: core.TestSitePosID, Status: core.OrderStatusComplete, CustomerID: customerID}
+	app.TestInsertOrder(t, &newer)
+	deleted := core.Order{PosID: 1003, SiteID: core.TestSitePosID, Status: core.OrderStatusDeleted, CustomerID: customerID}
+	app.TestInsertOrder(t, &deleted)
+	app.DB.InsertPayment(&core.Payment{Amount: 1500, Tip: 200, OrderID: older.ID, Date: time.Now(), CardLast4: "1111"}, &older)
+
+	// act
+	firstPage, firstErr := app.GetOrderHistory(customer.ID, 0, 1)
+	secondPage, secondErr := app.GetOrderHistory(customer.ID, firstPage.NextBefore, 1)
+
+	// assert
+	assert.NoError(t, firstErr)
+	assert.Equal(t, 1, len(firstPage.Orders))
+	assert.Equal(t, newer.ID, firstPage.Orders[0].Order.ID)
+	assert.Equal(t, 0, len(firstPage.Orders[0].Payments))
+	assert.Equal(t, newer.ID, firstPage.NextBefore)
+
+	assert.NoError(t, secondErr)
+	assert.Equal(t, 1, len(secondPage.Orders))
+	assert.Equal(t, older.ID, secondPage.Orders[0].Order.ID)
+	assert.Equal(t, 200, secondPage.Orders[0].Payments[0].Tip)
+	assert.Equal(t, "1111", secondPage.Orders[0].Payments[0].CardLast4)
+	assert.Equal(t, core.DatabaseID(0), secondPage.NextBefore)
+}
+
+func TestReorderMapsLinesToCurrentMenu(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	customer := createTestCustomer(app)
+	order := insertPickupOrder(t, app)
+	app.DB.UpdateOrderCustomerID(order, customer.ID)
+
+	menuItems, _ := app.DB.SelectMenuItemsBySiteID(core.TestSitePosID)
+	modifier, _ := app.DB.GetMenuModifierByKountaID(core.TestSitePosID, 456)
+
+	// act
+	reorder, err := app.Reorder(customer.ID, order.ID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, core.KountaID(core.TestSitePosID), reorder.Order.SiteID)
+	assert.Equal(t, 2, len(reorder.Order.MenuItems))
+	assert.Equal(t, menuItemIDByPosID(*menuItems, 345), reorder.Order.MenuItems[0].ID)
+	assert.Equal(t, []core.DatabaseID{modifier.ID}, reorder.Order.MenuItems[0].SelectedModifierIDs)
+	assert.Equal(t, menuItemIDByPosID(*menuItems, 346), reorder.Order.MenuItems[1].ID)
+
+	// the modifier removed from the second line can't be removed on a new order
+	assert.Equal(t, []core.UnavailableItem{{Name: "Test Menu Item 2", Modifier: "Test Modifier 1", Reason: "removed modifiers cannot be reordered"}}, reorder.Unavailable)
+}
+
+func TestReorderReportsItemsNoLongerOnMenu(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	customer := createTestCustomer(app)
+	order := insertPickupOrder(t, app)
+	app.DB.UpdateOrderCustomerID(order, customer.ID)
+
+	categories, _ := app.DB.SelectCategoriesBySiteID(core.TestSitePosID)
+	for _, category := range *categories {
+		if category.PosID == core.TestCategory1PosID {
+			app.TestMarkCategoryClientFacing(t, &category, false)
+		}
+	}
+
+	// act
+	reorder, err := app.Reorder(customer.ID, order.ID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(reorder.Order.MenuItems))
+	assert.Equal(t, 2, len(reorder.Unavailable))
+	assert.Equal(t, "Test Line 1", reorder.Unavailable[0].Name)
+	assert.Equal(t, "no longer on the menu", reorder.Unavailable[0].Reason)
+}
+
+func TestReorderRejectsAnotherCustomersOrder(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	customer := createTestCustomer(app)
+	order := insertPickupOrder(t, app)
+	app.DB.UpdateOrderCustomerID(order, customer.ID)
+
+	// act
+	reorder, err := app.Reorder(customer.ID+1, order.ID)
+
+	// assert
+	assert.Error(t, err)
+	assert.Nil(t, reorder)
+}
+
+// helpers
+
+func menuItemIDByPosID(menuItems []core.MenuItem, posID core.KountaID) core.DatabaseID {
+	for _, menuItem := range menuItems {
+		if menuItem.PosID == posID {
+			return menuItem.ID
+		}
+	}
+	return 0
+}
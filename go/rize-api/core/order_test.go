@@ -348,7 +348,7 @@ func TestUpdatePickupDetailsUpdatesDatabase(t *testing.T) {
 	}
 
 	pickupDetails := core.PickupDetails{}
-	byteBody := []byte(`{"customer_name":"John Doe","phone_number":"4041234567","pickup_time":"2017-04-21T18:05:37+02:00"}`)
+	byteBody := []byte(`{"customer_name":"John Doe","phone_number":"4041234567","pickup_time":"2099-04-21T18:05:37+02:00"}`)
 	json.Unmarshal(byteBody, &pickupDetails)
 
 	// act
@@ -376,7 +376,7 @@ func TestUpdatePickupDetailsUpdatesKountaNotes(t *testing.T) {
 	}
 
 	pickupDetails := core.PickupDetails{}
-	byteBody := []byte(`{"customer_name":"John Doe","phone_number":"4041234567","pickup_time":"2017-04-21T18:05:37+02:00"}`)
+	byteBody := []byte(`{"customer_name":"John Doe","phone_number":"4041234567","pickup_time":"2099-04-21T18:05:37+02:00"}`)
 	json.Unmarshal(byteBody, &pickupDetails)
 
 	// act
@@ -486,8 +486,112 @@ func TestAppendUniqueOrdersWhenDuplicateNewOrders(t *testing.T) {
 	assert.Equal(t, order3ID, updated[2].ID)
 }
 
+func TestFindPayableOrdersByTableNameLoadsLinesForEveryOrder(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+
+	insertOrdersAtTable(t, app, 3)
+
+	// act
+	actualOrders, err := app.FindPayableOrdersByTableName(core.TestSitePosID, "7")
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(actualOrders))
+	for _, order := range actualOrders {
+		assert.Equal(t, 2, len(order.Lines))
+		assert.Equal(t, 1, len(order.Lines[0].AddedModifiers))
+		assert.Equal(t, order.Lines[0].ID, order.Lines[0].AddedModifiers[0].LineID)
+		assert.Equal(t, 1, len(order.Lines[1].RemovedModifiers))
+		assert.Equal(t, order.Lines[1].ID, order.Lines[1].RemovedModifiers[0].LineID)
+	}
+}
+
+// benchmarks
+
+const benchmarkOrderCount = 20
+
+// BenchmarkLoadLinesPerLine measures the previous approach of one query per order and two queries per line
+func BenchmarkLoadLinesPerLine(b *testing.B) {
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(b)
+	orders := insertOrdersAtTable(b, app, benchmarkOrderCount)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, order := range orders {
+			lines, err := app.DB.SelectLines(order.ID)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, line := range *lines {
+				if _, err := app.DB.SelectAddedModifiers(line.ID); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := app.DB.SelectRemovedModifiers(line.ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkLoadLinesBatched measures loading the same lines and modifiers with a single batched lookup
+func BenchmarkLoadLinesBatched(b *testing.B) {
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(b)
+	orders := insertOrdersAtTable(b, app, benchmarkOrderCount)
+
+	ids := make([]core.DatabaseID, len(orders))
+	for i, order := range orders {
+		ids[i] = order.ID
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := app.DB.SelectLinesByOrderIDs(ids); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFindPayableOrdersByTableName(b *testing.B) {
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(b)
+	insertOrdersAtTable(b, app, benchmarkOrderCount)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := app.FindPayableOrdersByTableName(core.TestSitePosID, "7"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 // helpers
 
+// insertOrdersAtTable inserts count on hold orders at table "7", each with one added and one removed modifier
+func insertOrdersAtTable(t testing.TB, app core.AppContext, count int) []core.Order {
+	orders := make([]core.Order, count)
+	for i := range orders {
+		order := core.NewExpectedOrder()
+		order.PosID = core.KountaID(1000 + i)
+		order.PagerNumber = ""
+		order.Lines[0].ModifierIDs = []core.KountaID{456}
+		order.Lines[0].AddedModifiers = nil
+		order.Lines[1].ModifierIDs = []core.KountaID{-456}
+		order.Lines[1].RemovedModifiers = nil
+		app.TestInsertOrder(t, order)
+		orders[i] = *order
+	}
+	return orders
+}
+
 func assertOrdersEqual(t *testing.T, expected *core.Order, actual *core.Order) {
 	if expected == nil || actual == nil {
 		assert.Fail(t, "expected and actual orders cannot be nil")
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PagerStatusAvailable = "available"
+	PagerStatusAssigned  = "assigned"
+	PagerStatusLost      = "lost"
+)
+
+// defaultPagerAlertThreshold is how long a pager can be out on an unpaid order before staff are alerted, most guests
+// have paid and handed their pager back well within it
+const defaultPagerAlertThreshold = 2 * time.Hour
+
+// Pager is one of a site's pagers. OrderID and AssignedAt are only set while it is assigned.
+type Pager struct {
+	ID         DatabaseID    `json:"id"`
+	SiteID     KountaID      `json:"site_id"`
+	Number     int64         `json:"number"`
+	Status     string        `json:"status"`
+	OrderID    sql.NullInt64 `json:"-"`
+	AssignedAt *time.Time    `json:"assigned_at"`
+	UpdatedAt  time.Time     `json:"updated_at"`
+}
+
+// PagerAlert is a pager that has been out on an unpaid order for too long
+type PagerAlert struct {
+	Pager   Pager `json:"pager"`
+	Order   Order `json:"order"`
+	Minutes int   `json:"minutes"`
+}
+
+// PagerError is returned when a pager can't be used or changed
+type PagerError struct {
+	Reason string
+}
+
+func (e PagerError) Error() string {
+	return e.Reason
+}
+
+// AddPagers will add pagers to a site's inventory. Pagers already in it are left as they are. Pagers first used by
+// LRS are added on their own.
+func (app AppContext) AddPagers(siteID KountaID, numbers []int64) error {
+	for _, number := range numbers {
+		if number <= 0 {
+			return errors.Wrap(PagerError{Reason: "pager numbers must be positive"}, "add pagers")
+		}
+	}
+
+	if err := app.DB.InsertPagers(siteID, numbers, time.Now()); err != nil {
+		return errors.Wrapf(err, "add pagers for site %d", siteID)
+	}
+	return nil
+}
+
+// ListPagers will return a site's pagers ordered by number
+func (app AppContext) ListPagers(siteID KountaID) (*[]Pager, error) {
+	pagers, err := app.DB.SelectPagersBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list pagers for site %d", siteID)
+	}
+	return pagers, nil
+}
+
+// MarkPagerLost will take a pager out of use and off the order it was on
+func (app AppContext) MarkPagerLost(siteID KountaID, number int64) error {
+	if err := app.releasePagerFromOrders(siteID, number); err != nil {
+		return errors.Wrapf(err, "mark pager %d lost", number)
+	}
+	if err := app.setPagerStatus(siteID, number, PagerStatusLost); err != nil {
+		return errors.Wrapf(err, "mark pager %d lost", number)
+	}
+	return nil
+}
+
+// MarkPagerReturned will put a lost pager back into use
+func (app AppContext) MarkPagerReturned(siteID KountaID, number int64) error {
+	pager, err := app.DB.GetPager(siteID, number)
+	if err != nil {
+		return errors.Wrapf(err, "mark pager %d returned", number)
+	}
+	if pager == nil || pager.Status != PagerStatusLost {
+		return errors.Wrapf(PagerError{Reason: "pager " + strconv.FormatInt(number, 10) + " isn't lost"}, "mark pager %d returned", number)
+	}
+
+	if err := app.setPagerStatus(siteID, number, PagerStatusAvailable); err != nil {
+		return errors.Wrapf(err, "mark pager %d returned", number)
+	}
+	return nil
+}
+
+// ForceReleasePager will take a pager off whatever order still holds it so it can be given to the next guest, like when
+// a guest left without handing it back. The order itself is left as it is.
+func (app AppContext) ForceReleasePager(siteID KountaID, number int64) error {
+	if err := app.releasePagerFromOrders(siteID, number); err != nil {
+		return errors.Wrapf(err, "force release pager %d", number)
+	}
+	if err := app.setPagerStatus(siteID, number, PagerStatusAvailable); err != nil {
+		return errors.Wrapf(err, "force release pager %d", number)
+	}
+	return nil
+}
+
+// GetPagerAlerts will return a site's pagers that have been assigned to an unpaid order for longer than threshold,
+// longest first. A threshold of 0 uses the default of 2 hours.
+func (app AppContext) GetPagerAlerts(siteID KountaID, now time.Time, threshold time.Duration) (*[]PagerAlert, error) {
+	if threshold <= 0 {
+		threshold = defaultPagerAlertThreshold
+	}
+
+	pagers, err := app.DB.SelectPagersBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get pager alerts for site %d", siteID)
+	}
+
+	overdue := map[DatabaseID]Pager{}
+	orderIDs := []DatabaseID{}
+	for _, pager := range *pagers {
+		if pager.Status != PagerStatusAssigned || !pager.OrderID.Valid || pager.AssignedAt == nil {
+			continue
+		}
+		if now.Sub(*pager.AssignedAt) < threshold {
+			continue
+		}
+		orderID := DatabaseID(pager.OrderID.Int64)
+		overdue[orderID] = pager
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	payments, err := app.DB.SelectPaymentsByOrderIDs(orderIDs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get pager alerts for site %d", siteID)
+	}
+
+	alerts := []PagerAlert{}
+	for _, orderID := range orderIDs {
+		order, err := app.DB.GetOrderByDatabaseID(orderID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get pager alerts for site %d", siteID)
+		}
+		if order == nil || isPaid(*order, payments[orderID]) {
+			continue
+		}
+
+		pager := overdue[orderID]
+		alerts = append(alerts, PagerAlert{Pager: pager, Order: *order, Minutes: int(now.Sub(*pager.AssignedAt).Minutes())})
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Minutes > alerts[j].Minutes })
+	return &alerts, nil
+}
+
+// assignPager records that a pager was given out with an order, which a lost pager can't be
+func (app AppContext) assignPager(siteID KountaID, number int64, orderID DatabaseID) error {
+	now := time.Now()
+	pager := Pager{
+		SiteID:     siteID,
+		Number:     number,
+		Status:     PagerStatusAssigned,
+		OrderID:    sql.NullInt64{Int64: int64(orderID), Valid: true},
+		AssignedAt: &now,
+		UpdatedAt:  now,
+	}
+	assigned, err := app.DB.AssignPager(&pager)
+	if err != nil {
+		return err
+	}
+	if !assigned {
+		return lostPagerError(siteID, number)
+	}
+	return nil
+}
+
+func lostPagerError(siteID KountaID, number int64) PagerError {
+	return PagerError{Reason: fmt.Sprintf("pager %d at site %d is marked lost, mark it returned before using it", number, siteID)}
+}
+
+// releasePagerFromOrders clears the pager number from every order that still holds the pager
+func (app AppContext) releasePagerFromOrders(siteID KountaID, number int64) error {
+	released := map[DatabaseID]bool{}
+	for {
+		order, err := app.DB.GetOrderByPagerID(siteID, number)
+		if err != nil {
+			return err
+		}
+		if order == nil {
+			return nil
+		}
+		if released[order.ID] {
+			return errors.Errorf("pager %d could not be cleared from order %d", number, order.ID)
+		}
+
+		if err := app.DB.UpdateOrderPagerNumber(order, ""); err != nil {
+			return err
+		}
+		released[order.ID] = true
+	}
+}
+
+func (app AppContext) setPagerStatus(siteID KountaID, number int64, status string) error {
+	if err := app.DB.InsertPagers(siteID, []int64{number}, time.Now()); err != nil {
+		return err
+	}
+	return app.DB.UpdatePagerStatus(siteID, number, status, time.Now())
+}
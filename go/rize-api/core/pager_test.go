@@ -0,0 +1,143 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+func TestCreateOrderForPagerAssignsPager(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	app.AddPagers(core.TestSitePosID, []int64{20, 21})
+
+	// act
+	order, err := app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// assert
+	assert.NoError(t, err)
+	pagers, _ := app.ListPagers(core.TestSitePosID)
+	assert.Equal(t, 2, len(*pagers))
+	assert.Equal(t, core.PagerStatusAssigned, (*pagers)[0].Status)
+	assert.Equal(t, int64(order.ID), (*pagers)[0].OrderID.Int64)
+	assert.NotNil(t, (*pagers)[0].AssignedAt)
+	assert.Equal(t, core.PagerStatusAvailable, (*pagers)[1].Status)
+}
+
+func TestCreateOrderForPagerBlocksReuseUntilReleased(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	first, _ := app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// act
+	_, reuseErr := app.CreateOrderForPager(core.TestSitePosID, 20)
+	first.Status = core.OrderStatusComplete
+	updateErr := app.UpdateOrder(first)
+	second, releasedErr := app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// assert
+	assert.IsType(t, core.PagerError{}, errors.Cause(reuseErr))
+	assert.NoError(t, updateErr)
+	assert.NoError(t, releasedErr)
+	pager, _ := app.DB.GetPager(core.TestSitePosID, 20)
+	assert.Equal(t, int64(second.ID), pager.OrderID.Int64)
+}
+
+func TestForceReleasePager(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	abandoned, _ := app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// act
+	err := app.ForceReleasePager(core.TestSitePosID, 20)
+	_, reuseErr := app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// assert
+	assert.NoError(t, err)
+	assert.NoError(t, reuseErr)
+	order, _ := app.FindOrderByID(abandoned.ID)
+	assert.Equal(t, "", order.PagerNumber)
+	assert.Equal(t, abandoned.Status, order.Status)
+}
+
+func TestMarkPagerLostAndReturned(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// act
+	notLostErr := app.MarkPagerReturned(core.TestSitePosID, 20)
+	lostErr := app.MarkPagerLost(core.TestSitePosID, 20)
+	lost, _ := app.DB.GetPager(core.TestSitePosID, 20)
+	returnedErr := app.MarkPagerReturned(core.TestSitePosID, 20)
+
+	// assert
+	assert.IsType(t, core.PagerError{}, errors.Cause(notLostErr))
+	assert.NoError(t, lostErr)
+	assert.Equal(t, core.PagerStatusLost, lost.Status)
+	assert.False(t, lost.OrderID.Valid)
+	assert.NoError(t, returnedErr)
+	returned, _ := app.DB.GetPager(core.TestSitePosID, 20)
+	assert.Equal(t, core.PagerStatusAvailable, returned.Status)
+
+	badNumberErr := app.AddPagers(core.TestSitePosID, []int64{0})
+	assert.IsType(t, core.PagerError{}, errors.Cause(badNumberErr))
+}
+
+func TestCreateOrderForLostPagerFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &pagerKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	app.AddPagers(core.TestSitePosID, []int64{20})
+	app.MarkPagerLost(core.TestSitePosID, 20)
+
+	// act
+	_, err := app.CreateOrderForPager(core.TestSitePosID, 20)
+
+	// assert
+	assert.IsType(t, core.PagerError{}, errors.Cause(err))
+	assert.Equal(t, 0, kounta.created)
+	pager, _ := app.DB.GetPager(core.TestSitePosID, 20)
+	assert.Equal(t, core.PagerStatusLost, pager.Status)
+	assert.False(t, pager.OrderID.Valid)
+}
+
+func TestGetPagerAlerts(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &pagerKounta{MockKounta: &pos.MockKounta{}}
+	unpaid, _ := app.CreateOrderForPager(core.TestSitePosID, 20)
+	paid, _ := app.CreateOrderForPager(core.TestSitePosID, 21)
+	app.DB.InsertPayment(&core.Payment{Amount: paid.Total, OrderID: paid.ID, Date: time.Now(), CardLast4: "1111"}, paid)
+	app.CreateOrderForPager(core.TestSitePosID, 22)
+
+	// act
+	alerts, err := app.GetPagerAlerts(core.TestSitePosID, time.Now().Add(3*time.Hour), 0)
+	recent, recentErr := app.GetPagerAlerts(core.TestSitePosID, time.Now(), 0)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*alerts))
+	alertedOrders := []core.DatabaseID{(*alerts)[0].Order.ID, (*alerts)[1].Order.ID}
+	assert.Contains(t, alertedOrders, unpaid.ID)
+	assert.NotContains(t, alertedOrders, paid.ID)
+	assert.True(t, (*alerts)[0].Minutes >= 179)
+
+	assert.NoError(t, recentErr)
+	assert.Equal(t, 0, len(*recent))
+}
@@ -7,10 +7,12 @@ import (
 
 // Payment represents the metadata regarding a payment for an order
 type Payment struct {
-	Amount        int
+	Amount        int // Amount is negative for refunds
 	Tip           int
 	OrderID       DatabaseID
 	TransactionID string
 	Date          time.Time
 	CustomerID    sql.NullInt64
+	CardLast4     string
+	Method        string // Method is empty for card payments
 }
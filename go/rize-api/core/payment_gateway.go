@@ -14,6 +14,7 @@ type TokenizedPayment struct {
 	Amount     int        `json:"amount"`
 	Tip        int        `json:"tip"`
 	Expiry     string     `json:"expiry"` // Expiry only needed for CardConnect
+	CardLast4  string     `json:"card_last_4"`
 }
 
 type Cayan interface {
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"math"
 	"strconv"
 )
 
@@ -29,12 +30,22 @@ type LegacyPaymentInfo struct {
 	OrderID     DatabaseID
 }
 
+// Tip is the tip in cents from the metadata, which clients send as a string or a number. It is 0 when there is no
+// tip or it can't be read.
 func (p *LegacyPaymentInfo) Tip() int {
-	tip, err := strconv.Atoi(p.Metadata["tip"].(string))
-	if err != nil {
-		tip = 0
+	switch tip := p.Metadata["tip"].(type) {
+	case string:
+		cents, err := strconv.Atoi(tip)
+		if err != nil {
+			return 0
+		}
+		return cents
+	case float64:
+		return int(math.Round(tip))
+	case int:
+		return tip
 	}
-	return tip
+	return 0
 }
 
 func (p *LegacyPaymentInfo) Method() PaymentMethod {
@@ -3,8 +3,13 @@ package core
 import (
 	"encoding/json"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
+// pickupTimeLayout is how pickup times are shown to kitchen staff
+const pickupTimeLayout = "3:04 pm"
+
 // PickupDetails represents a collection of information needed when creating a pickup order.
 type PickupDetails struct {
 	CustomerName string     `json:"customer_name"`
@@ -36,3 +41,35 @@ func (p *PickupDetails) UnmarshalJSON(data []byte) error {
 	p.PickupTime = &pickupTime
 	return nil
 }
+
+// pickupNotes are the notes kitchen staff see on a pickup order, leaving out any empty details
+func pickupNotes(pickupTime, customerName, phoneNumber string) string {
+	notes := "TO-GO APP - PAID"
+	for _, detail := range []string{pickupTime, customerName, phoneNumber} {
+		if detail != "" {
+			notes += "\n\n" + detail
+		}
+	}
+	return notes
+}
+
+// formatPickupTime will format a pickup time for kitchen staff in the site's time zone. If the site has no time zone
+// configured the pickup time is formatted in the zone it was given in.
+func (app AppContext) formatPickupTime(siteID KountaID, pickupTime time.Time) (string, error) {
+	site, err := app.DB.GetSite(siteID)
+	if err != nil {
+		return "", errors.Wrap(err, "format pickup time")
+	}
+	if site == nil {
+		return pickupTime.Format(pickupTimeLayout), nil
+	}
+
+	location, err := site.Location()
+	if err != nil {
+		return "", errors.Wrap(err, "format pickup time")
+	}
+	if location != nil {
+		pickupTime = pickupTime.In(location)
+	}
+	return pickupTime.Format(pickupTimeLayout), nil
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PickupSettings is the per-site configuration used to schedule pickup orders into kitchen capacity slots
+type PickupSettings struct {
+	SiteID           KountaID       `json:"site_id"`
+	SlotMinutes      int            `json:"slot_minutes"`
+	MaxOrdersPerSlot int            `json:"max_orders_per_slot"` // MaxOrdersPerSlot of 0 means slots are never full
+	OpeningHours     []OpeningHours `json:"opening_hours"`
+}
+
+// OpeningHours are the hours pickups are accepted on a weekday, in minutes after midnight in the site's time zone
+type OpeningHours struct {
+	Weekday time.Weekday `json:"weekday"`
+	Opens   int          `json:"opens"`
+	Closes  int          `json:"closes"`
+}
+
+// PickupSlot is a window of time a pickup order can be scheduled into
+type PickupSlot struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Available int       `json:"available"` // Available is -1 when the site has no limit on orders per slot
+}
+
+// PickupTimeError is returned when a requested pickup time can not be scheduled
+type PickupTimeError struct {
+	Reason string
+}
+
+func (e PickupTimeError) Error() string {
+	return e.Reason
+}
+
+const minutesPerDay = 24 * 60
+
+// Location returns the site's time zone, or nil if the site has not been configured with one
+func (s Site) Location() (*time.Location, error) {
+	if s.TimeZone == "" {
+		return nil, nil
+	}
+	return time.LoadLocation(s.TimeZone)
+}
+
+// UpdateSiteTimeZone will set the IANA time zone (ex: America/New_York) used for a site's pickup times
+func (app AppContext) UpdateSiteTimeZone(siteID KountaID, timeZone string) error {
+	if _, err := time.LoadLocation(timeZone); err != nil {
+		return errors.Wrapf(err, "update site time zone")
+	}
+
+	if err := app.DB.UpdateSiteTimeZone(siteID, timeZone); err != nil {
+		return errors.Wrap(err, "update site time zone")
+	}
+	return nil
+}
+
+// UpdatePickupSettings will validate and save the pickup slot configuration for a site
+func (app AppContext) UpdatePickupSettings(settings PickupSettings) error {
+	if settings.SlotMinutes <= 0 {
+		return errors.New("update pickup settings: slot length must be greater than zero")
+	}
+	if settings.MaxOrdersPerSlot < 0 {
+		return errors.New("update pickup settings: max orders per slot can not be negative")
+	}
+
+	for _, hours := range settings.OpeningHours {
+		if hours.Weekday < time.Sunday || hours.Weekday > time.Saturday {
+			return errors.Errorf("update pickup settings: invalid weekday %d", hours.Weekday)
+		}
+		if hours.Opens < 0 || hours.Closes > minutesPerDay || hours.Opens >= hours.Closes {
+			return errors.Errorf("update pickup settings: invalid hours %d-%d on %s", hours.Opens, hours.Closes, hours.Weekday)
+		}
+	}
+
+	if err := app.DB.UpsertPickupSettings(&settings); err != nil {
+		return errors.Wrap(err, "update pickup settings")
+	}
+	return nil
+}
+
+// GetPickupSlots will return the slots on the given day, in the site's time zone, that can still take a pickup order
+func (app AppContext) GetPickupSlots(siteID KountaID, day time.Time) ([]PickupSlot, error) {
+	site, settings, location, err := app.getPickupConfiguration(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pickup slots")
+	}
+	if site == nil || settings == nil {
+		return nil, errors.Errorf("get pickup slots: site %d does not schedule pickups", siteID)
+	}
+
+	slots := settings.slotsForDay(day.In(location))
+	if len(slots) == 0 {
+		return []PickupSlot{}, nil
+	}
+
+	pickupTimes, err := app.DB.SelectPickupTimesBySiteID(siteID, slots[0].Start, slots[len(slots)-1].End)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pickup slots")
+	}
+
+	now := time.Now()
+	availableSlots := []PickupSlot{}
+	for _, slot := range slots {
+		if slot.End.Before(now) {
+			continue
+		}
+
+		slot.Available = settings.availableInSlot(slot, pickupTimes, 0)
+		if slot.Available != 0 {
+			availableSlots = append(availableSlots, slot)
+		}
+	}
+	return availableSlots, nil
+}
+
+// reservePickupTime will set pickupTime on the order, returning a PickupTimeError if it is in the past, outside the
+// site's hours or its slot is full. Checking the slot and taking a place in it is atomic so that two orders can't both
+// take its last place.
+func (app AppContext) reservePickupTime(order *Order, pickupTime time.Time) error {
+	slot, settings, err := app.validatePickupTime(order, pickupTime)
+	if err != nil {
+		return err
+	}
+
+	maxOrders := 0
+	if settings != nil {
+		maxOrders = settings.MaxOrdersPerSlot
+	}
+	reserved, err := app.DB.ReservePickupTime(order, pickupTime, slot.Start, slot.End, maxOrders)
+	if err != nil {
+		return errors.Wrap(err, "reserve pickup time")
+	}
+	if !reserved {
+		return PickupTimeError{Reason: fmt.Sprintf("pickup slot at %s is full", slot.Start.Format(pickupTimeLayout))}
+	}
+	return nil
+}
+
+// validatePickupTime will return a PickupTimeError if pickupTime is in the past or outside the site's hours, and
+// otherwise the slot it is in. Sites without pickup settings accept any future pickup time and return no settings.
+func (app AppContext) validatePickupTime(order *Order, pickupTime time.Time) (PickupSlot, *PickupSettings, error) {
+	if pickupTime.Before(time.Now()) {
+		return PickupSlot{}, nil, PickupTimeError{Reason: "pickup time has already passed"}
+	}
+
+	site, settings, location, err := app.getPickupConfiguration(order.SiteID)
+	if err != nil {
+		return PickupSlot{}, nil, errors.Wrap(err, "validate pickup time")
+	}
+	if site == nil || settings == nil {
+		return PickupSlot{}, nil, nil
+	}
+
+	slot, ok := settings.slotForTime(pickupTime.In(location))
+	if !ok {
+		return PickupSlot{}, nil, PickupTimeError{Reason: fmt.Sprintf("pickup time %s is outside of %s's pickup hours", pickupTime.In(location).Format(pickupTimeLayout), site.Name)}
+	}
+	return slot, settings, nil
+}
+
+// getPickupConfiguration loads the site and pickup settings along with the location pickup times are scheduled in
+func (app AppContext) getPickupConfiguration(siteID KountaID) (*Site, *PickupSettings, *time.Location, error) {
+	site, err := app.DB.GetSite(siteID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if site == nil {
+		return nil, nil, nil, nil
+	}
+
+	settings, err := app.DB.GetPickupSettings(siteID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	location, err := site.Location()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	return site, settings, location, nil
+}
+
+// slotsForDay returns every slot on the day of t, which must already be in the site's location
+func (settings PickupSettings) slotsForDay(t time.Time) []PickupSlot {
+	slots := []PickupSlot{}
+	year, month, day := t.Date()
+	for _, hours := range settings.OpeningHours {
+		if hours.Weekday != t.Weekday() {
+			continue
+		}
+
+		for minute := hours.Opens; minute+settings.SlotMinutes <= hours.Closes; minute += settings.SlotMinutes {
+			slots = append(slots, PickupSlot{
+				Start: time.Date(year, month, day, 0, minute, 0, 0, t.Location()),
+				End:   time.Date(year, month, day, 0, minute+settings.SlotMinutes, 0, 0, t.Location()),
+			})
+		}
+	}
+	return slots
+}
+
+// slotForTime returns the slot containing t, which must already be in the site's location
+func (settings PickupSettings) slotForTime(t time.Time) (PickupSlot, bool) {
+	for _, slot := range settings.slotsForDay(t) {
+		if !t.Before(slot.Start) && t.Before(slot.End) {
+			return slot, true
+		}
+	}
+	return PickupSlot{}, false
+}
+
+// availableInSlot counts the orders still able to be scheduled into slot, ignoring the order with ID excludeOrderID
+func (settings PickupSettings) availableInSlot(slot PickupSlot, pickupTimes map[DatabaseID]time.Time, excludeOrderID DatabaseID) int {
+	if settings.MaxOrdersPerSlot == 0 {
+		return -1
+	}
+
+	scheduled := 0
+	for orderID, pickupTime := range pickupTimes {
+		if orderID != excludeOrderID && !pickupTime.Before(slot.Start) && pickupTime.Before(slot.End) {
+			scheduled++
+		}
+	}
+
+	if scheduled >= settings.MaxOrdersPerSlot {
+		return 0
+	}
+	return settings.MaxOrdersPerSlot - scheduled
+}
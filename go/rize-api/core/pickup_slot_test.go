@@ -0,0 +1,206 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+// onHoldFailingKounta fails to put orders on hold
+type onHoldFailingKounta struct {
+	*pos.MockKounta
+}
+
+func (k onHoldFailingKounta) PutOrderOnHold(id core.KountaID) error {
+	return errors.New("kounta is down")
+}
+
+func TestGetPickupSlotsReturnsSlotsInSiteTimeZone(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	location := insertPickupSettings(t, app, 0)
+
+	tomorrow := time.Now().In(location).AddDate(0, 0, 1)
+
+	// act
+	slots, err := app.GetPickupSlots(core.TestSitePosID, tomorrow)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(slots)) // 11:00 - 12:00 in 15 minute slots
+	assert.Equal(t, 11, slots[0].Start.In(location).Hour())
+	assert.Equal(t, 45, slots[3].Start.In(location).Minute())
+	assert.Equal(t, -1, slots[0].Available)
+}
+
+func TestGetPickupSlotsOmitsFullSlots(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	location := insertPickupSettings(t, app, 1)
+
+	tomorrow := time.Now().In(location).AddDate(0, 0, 1)
+	year, month, day := tomorrow.Date()
+	pickupTime := time.Date(year, month, day, 11, 5, 0, 0, location)
+
+	order := core.NewExpectedOrder()
+	app.TestInsertOrder(t, order)
+	assert.NoError(t, app.DB.UpdateOrderPickupTime(order, pickupTime))
+
+	// act
+	slots, err := app.GetPickupSlots(core.TestSitePosID, tomorrow)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(slots))
+	assert.Equal(t, 15, slots[0].Start.In(location).Minute())
+	assert.Equal(t, 1, slots[0].Available)
+}
+
+func TestUpdatePickupDetailsRejectsTimeOutsideHours(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	location := insertPickupSettings(t, app, 0)
+	order := insertPickupOrder(t, app)
+
+	year, month, day := time.Now().In(location).AddDate(0, 0, 1).Date()
+	pickupTime := time.Date(year, month, day, 12, 0, 0, 0, location)
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &pickupTime})
+
+	// assert
+	assert.IsType(t, core.PickupTimeError{}, errors.Cause(err))
+}
+
+func TestUpdatePickupDetailsRejectsFullSlot(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	location := insertPickupSettings(t, app, 1)
+
+	year, month, day := time.Now().In(location).AddDate(0, 0, 1).Date()
+	pickupTime := time.Date(year, month, day, 11, 30, 0, 0, location)
+
+	otherOrder := core.NewExpectedOrder()
+	otherOrder.PosID = 790
+	app.TestInsertOrder(t, otherOrder)
+	assert.NoError(t, app.DB.UpdateOrderPickupTime(otherOrder, pickupTime.Add(5*time.Minute)))
+
+	order := insertPickupOrder(t, app)
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &pickupTime})
+
+	// assert
+	assert.IsType(t, core.PickupTimeError{}, errors.Cause(err))
+}
+
+func TestUpdatePickupDetailsRejectsPastTime(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	order := insertPickupOrder(t, app)
+	pickupTime := time.Now().Add(-time.Minute)
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &pickupTime})
+
+	// assert
+	assert.IsType(t, core.PickupTimeError{}, errors.Cause(err))
+	updatedOrder, _ := app.DB.GetOrderByDatabaseID(order.ID)
+	assert.Nil(t, updatedOrder.PickupTime)
+}
+
+func TestUpdatePickupDetailsReleasesSlotWhenKountaFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	location := insertPickupSettings(t, app, 1)
+	order := insertPickupOrder(t, app)
+	app.Kounta = onHoldFailingKounta{MockKounta: app.Kounta.(*pos.MockKounta)}
+
+	tomorrow := time.Now().In(location).AddDate(0, 0, 1)
+	year, month, day := tomorrow.Date()
+	pickupTime := time.Date(year, month, day, 11, 30, 0, 0, location)
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PickupTime: &pickupTime})
+
+	// assert
+	assert.Error(t, err)
+	updatedOrder, _ := app.DB.GetOrderByDatabaseID(order.ID)
+	assert.Nil(t, updatedOrder.PickupTime)
+	slots, _ := app.GetPickupSlots(core.TestSitePosID, tomorrow)
+	assert.Equal(t, 4, len(slots))
+}
+
+func TestUpdatePickupDetailsFormatsNotesInSiteTimeZone(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	location := insertPickupSettings(t, app, 1)
+	order := insertPickupOrder(t, app)
+
+	year, month, day := time.Now().In(location).AddDate(0, 0, 1).Date()
+	pickupTime := time.Date(year, month, day, 11, 30, 0, 0, location).UTC()
+
+	// act
+	err := app.UpdatePickupDetails(order.ID, core.PickupDetails{CustomerName: "John Doe", PhoneNumber: "4041234567", PickupTime: &pickupTime})
+
+	// assert
+	assert.NoError(t, err)
+	mockKounta := app.Kounta.(*pos.MockKounta)
+	assert.Equal(t, "TO-GO APP - PAID\n\n11:30 am\n\nJohn Doe\n\n4041234567", mockKounta.Notes)
+}
+
+// helpers
+
+// insertPickupSettings configures the test site to take pickups from 11:00 to 12:00 every day in 15 minute slots
+func insertPickupSettings(t *testing.T, app core.AppContext, maxOrdersPerSlot int) *time.Location {
+	const timeZone = "America/New_York"
+	if err := app.UpdateSiteTimeZone(core.TestSitePosID, timeZone); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := core.PickupSettings{SiteID: core.TestSitePosID, SlotMinutes: 15, MaxOrdersPerSlot: maxOrdersPerSlot}
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		settings.OpeningHours = append(settings.OpeningHours, core.OpeningHours{Weekday: weekday, Opens: 11 * 60, Closes: 12 * 60})
+	}
+	if err := app.UpdatePickupSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+func insertPickupOrder(t *testing.T, app core.AppContext) *core.Order {
+	posOrder := pos.NewMockKountaOrder()
+	posOrder.Status = core.OrderStatusSubmitted
+	order, err := app.CreateOrUpdateOrderFromKounta(posOrder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mockKounta, ok := app.Kounta.(*pos.MockKounta); ok {
+		mockKounta.Orders = append(mockKounta.Orders, *posOrder)
+	}
+	return order
+}
@@ -12,7 +12,7 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // Used to register the Postgres driver with database/sql
+	"github.com/lib/pq" // Also registers the Postgres driver with database/sql
 	"github.com/pkg/errors"
 	"pjd"
 )
@@ -64,10 +64,23 @@ func initPG(url string, clean bool) (pg Postgres, err error) {
 
 func (pg Postgres) dropTables() error {
 	tableNames := []string{
+		"beacon_telemetry",
+		"customer_connections",
 		"customers",
+		"email_outbox",
+		"floor_sections",
+		"floor_tables",
+		"gift_card_entries",
+		"gift_cards",
 		"keys",
 		"kounta_log",
 		"lines",
+		"login_failures",
+		"login_nonces",
+		"loyalty_entries",
+		"loyalty_rewards",
+		"loyalty_rules",
+		"lrs_events",
 		"menu_categories",
 		"menu_item_modifiers_mapping",
 		"menu_item_option_sets_mapping",
@@ -77,12 +90,30 @@ func (pg Postgres) dropTables() error {
 		"menu_option_sets",
 		"migrations",
 		"modifiers",
+		"notification_settings",
+		"notifications",
+		"order_fees",
+		"order_payment_locks",
 		"orders",
+		"pager_sessions",
+		"pagers",
 		"payments",
+		"pickup_details",
+		"promo_code_redemptions",
+		"promo_code_restrictions",
+		"promo_codes",
+		"refresh_tokens",
+		"saved_cards",
+		"sessions",
+		"site_fee_rules",
 		"site_menu_categories_mapping",
 		"site_menu_items_pricing",
 		"site_menu_modifiers_pricing",
+		"site_opening_hours",
+		"site_pickup_settings",
+		"site_tip_settings",
 		"sites",
+		"staff_tips",
 		"table_mapping",
 		"tokens",
 	}
@@ -155,6 +186,15 @@ func (pg Postgres) runMigrations() error {
 	return err
 }
 
+// int64Array converts database IDs to a Postgres array parameter for use with "= ANY($1)"
+func int64Array(ids []DatabaseID) pq.Int64Array {
+	array := make(pq.Int64Array, len(ids))
+	for i, id := range ids {
+		array[i] = int64(id)
+	}
+	return array
+}
+
 func (pg Postgres) transact(exec func(tx *sqlx.Tx) error) (err error) {
 	tx, err := pg.Beginx()
 	if err != nil {
@@ -174,6 +214,12 @@ func (pg Postgres) transact(exec func(tx *sqlx.Tx) error) (err error) {
 	return err
 }
 
+// isUniqueViolation reports whether err is postgres refusing to insert a row that breaks a unique constraint
+func isUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
+
 /// DB Interface
 
 // Table Mapping
@@ -194,6 +240,101 @@ func (pg Postgres) GetTableMapByBeaconID(id string) (*TableMap, error) {
 	return &tableMap, err
 }
 
+func (pg Postgres) SelectTableMapsByBeaconIDs(ids []string) (*[]TableMap, error) {
+	tableMaps := []TableMap{}
+	err := pg.Select(&tableMaps, "SELECT * FROM table_mapping WHERE beacon_id = ANY($1)", pq.StringArray(ids))
+	return &tableMaps, err
+}
+
+func (pg Postgres) UpdateTableMap(tableMap *TableMap) error {
+	_, err := pg.Exec("UPDATE table_mapping SET (site_id, table_name) = ($2, $3) WHERE beacon_id = $1",
+		strings.ToLower(tableMap.BeaconID), tableMap.SiteID, tableMap.TableName)
+	return err
+}
+
+func (pg Postgres) DeleteTableMap(beaconID string) error {
+	_, err := pg.Exec("DELETE FROM table_mapping WHERE beacon_id = $1", strings.ToLower(beaconID))
+	return err
+}
+
+func (pg Postgres) SelectTableMapsBySiteID(siteID KountaID) (*[]TableMap, error) {
+	tableMaps := []TableMap{}
+	err := pg.Select(&tableMaps, "SELECT * FROM table_mapping WHERE site_id = $1 ORDER BY table_name, beacon_id", siteID)
+	return &tableMaps, err
+}
+
+func (pg Postgres) SelectDuplicateTableMaps() (*[]TableMap, error) {
+	tableMaps := []TableMap{}
+	err := pg.Select(&tableMaps, `SELECT * FROM table_mapping
+		WHERE beacon_id IN (SELECT beacon_id FROM table_mapping GROUP BY beacon_id HAVING COUNT(*) > 1)
+		ORDER BY beacon_id, site_id, table_name`)
+	return &tableMaps, err
+}
+
+func (pg Postgres) UpsertTableMaps(tableMaps []TableMap) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		for _, tableMap := range tableMaps {
+			beaconID := strings.ToLower(tableMap.BeaconID)
+			if _, err := tx.Exec("DELETE FROM table_mapping WHERE beacon_id = $1", beaconID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec("INSERT INTO table_mapping (beacon_id, site_id, table_name) VALUES ($1, $2, $3)",
+				beaconID, tableMap.SiteID, tableMap.TableName); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (pg Postgres) InsertFloorSection(section *FloorSection) error {
+	return pg.QueryRow("INSERT INTO floor_sections (site_id, name) VALUES ($1, $2) RETURNING id",
+		section.SiteID, section.Name).Scan(&section.ID)
+}
+
+func (pg Postgres) SelectFloorSectionsBySiteID(siteID KountaID) (*[]FloorSection, error) {
+	sections := []FloorSection{}
+	err := pg.Select(&sections, "SELECT * FROM floor_sections WHERE site_id = $1 ORDER BY name", siteID)
+	return &sections, err
+}
+
+func (pg Postgres) UpsertFloorTable(table *FloorTable) error {
+	return pg.QueryRow(`INSERT INTO floor_tables (site_id, section_id, name, seats) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (site_id, name) DO UPDATE SET (section_id, seats) = (EXCLUDED.section_id, EXCLUDED.seats)
+		RETURNING id`,
+		table.SiteID, table.SectionID, table.Name, table.Seats).Scan(&table.ID)
+}
+
+func (pg Postgres) SelectFloorTablesBySiteID(siteID KountaID) (*[]FloorTable, error) {
+	tables := []FloorTable{}
+	err := pg.Select(&tables, "SELECT * FROM floor_tables WHERE site_id = $1 ORDER BY name", siteID)
+	return &tables, err
+}
+
+func (pg Postgres) DeleteFloorTable(siteID KountaID, name string) error {
+	_, err := pg.Exec("DELETE FROM floor_tables WHERE site_id = $1 AND name = $2", siteID, name)
+	return err
+}
+
+func (pg Postgres) UpsertBeaconTelemetry(telemetry *BeaconTelemetry) error {
+	_, err := pg.Exec(`INSERT INTO beacon_telemetry (beacon_id, battery_millivolts, temperature, source, reported_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (beacon_id) DO UPDATE SET (battery_millivolts, temperature, source, reported_at) =
+			(COALESCE(NULLIF(EXCLUDED.battery_millivolts, 0), beacon_telemetry.battery_millivolts),
+			EXCLUDED.temperature, EXCLUDED.source, EXCLUDED.reported_at)
+		WHERE beacon_telemetry.reported_at < EXCLUDED.reported_at`,
+		strings.ToLower(telemetry.BeaconID), telemetry.BatteryMillivolts, telemetry.Temperature, telemetry.Source, telemetry.ReportedAt)
+	return err
+}
+
+func (pg Postgres) SelectBeaconTelemetryBySiteID(siteID KountaID) (*[]BeaconTelemetry, error) {
+	telemetry := []BeaconTelemetry{}
+	err := pg.Select(&telemetry, `SELECT beacon_telemetry.* FROM beacon_telemetry
+		JOIN table_mapping ON table_mapping.beacon_id = beacon_telemetry.beacon_id
+		WHERE table_mapping.site_id = $1`, siteID)
+	return &telemetry, err
+}
+
 // Cayan
 
 func (pg Postgres) UpdateCayanKey(token string) error {
@@ -238,6 +379,96 @@ func (pg Postgres) DeleteTokens(customerID DatabaseID) error {
 	return err
 }
 
+// Session
+
+func (pg Postgres) InsertSession(session *Session) error {
+	return pg.QueryRow(`INSERT INTO sessions (customer_id, device, created_at, last_used_at, expires_at)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING id`,
+		session.CustomerID, session.Device, session.CreatedAt, session.LastUsedAt, session.ExpiresAt).Scan(&session.ID)
+}
+
+func (pg Postgres) GetSession(id DatabaseID) (*Session, error) {
+	session := Session{}
+	err := pg.Get(&session, `SELECT * FROM sessions WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &session, err
+}
+
+func (pg Postgres) UpdateSession(session *Session) error {
+	_, err := pg.Exec(`UPDATE sessions SET last_used_at = $1, expires_at = $2 WHERE id = $3`,
+		session.LastUsedAt, session.ExpiresAt, session.ID)
+	return err
+}
+
+func (pg Postgres) SelectActiveSessionsByCustomerID(customerID DatabaseID, now time.Time) (*[]Session, error) {
+	sessions := []Session{}
+	err := pg.Select(&sessions, `
+		SELECT * FROM sessions
+		WHERE customer_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC`,
+		customerID, now)
+	return &sessions, err
+}
+
+func (pg Postgres) RevokeSession(id DatabaseID, revokedAt time.Time) error {
+	_, err := pg.Exec(`UPDATE sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, revokedAt, id)
+	return err
+}
+
+func (pg Postgres) RevokeSessionsByCustomerID(customerID DatabaseID, revokedAt time.Time) error {
+	_, err := pg.Exec(`UPDATE sessions SET revoked_at = $1 WHERE customer_id = $2 AND revoked_at IS NULL`, revokedAt, customerID)
+	return err
+}
+
+func (pg Postgres) InsertRefreshToken(token *RefreshToken) error {
+	return pg.QueryRow(`INSERT INTO refresh_tokens (session_id, token_hash, created_at, expires_at)
+		VALUES($1, $2, $3, $4)
+		RETURNING id`,
+		token.SessionID, token.TokenHash, token.CreatedAt, token.ExpiresAt).Scan(&token.ID)
+}
+
+func (pg Postgres) GetRefreshTokenByHash(tokenHash string) (*RefreshToken, error) {
+	token := RefreshToken{}
+	err := pg.Get(&token, `SELECT * FROM refresh_tokens WHERE token_hash = $1`, tokenHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &token, err
+}
+
+func (pg Postgres) UseRefreshToken(id DatabaseID, usedAt time.Time) (bool, error) {
+	result, err := pg.Exec(`UPDATE refresh_tokens SET used_at = $1 WHERE id = $2 AND used_at IS NULL`, usedAt, id)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+func (pg Postgres) DeleteExpiredTokens(now time.Time) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM tokens WHERE expiry < $1`, now); err != nil {
+			return err
+		}
+
+		// Refresh tokens are deleted along with their session
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE revoked_at IS NOT NULL OR expires_at < $1`, now); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE expires_at < $1`, now); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`DELETE FROM login_nonces WHERE expires_at < $1`, now)
+		return err
+	})
+}
+
 // Customer
 
 func (pg Postgres) InsertCustomer(c *Customer) error {
@@ -283,13 +514,114 @@ func (pg Postgres) GetCustomerByEmail(email string) (*Customer, error) {
 	return &customer, err
 }
 
+func (pg Postgres) UpdateCustomerProfile(c *Customer) error {
+	_, err := pg.Exec(
+		`UPDATE customers
+		SET first_name = $1, last_name = $2, email = $3, phone = $4, image_url = $5
+		WHERE id = $6`,
+		c.FirstName, c.LastName, c.Email, c.Phone, c.ImageURL, c.ID)
+	return err
+}
+
+func (pg Postgres) DeleteCustomer(id DatabaseID) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		// Sessions, refresh tokens, connections and saved cards are deleted by cascade
+		if _, err := tx.Exec(`DELETE FROM tokens WHERE customer_id = $1`, id); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`DELETE FROM customers WHERE id = $1`, id)
+		return err
+	})
+}
+
+// Customer connections
+
+func (pg Postgres) InsertCustomerConnection(connection *CustomerConnection) error {
+	return pg.QueryRow(`INSERT INTO customer_connections (customer_id, service, external_id, email, created_at)
+		VALUES($1, $2, $3, $4, $5)
+		RETURNING id`,
+		connection.CustomerID, connection.Service, connection.ExternalID, connection.Email, connection.CreatedAt).Scan(&connection.ID)
+}
+
+func (pg Postgres) GetCustomerConnection(service, externalID string) (*CustomerConnection, error) {
+	connection := CustomerConnection{}
+	err := pg.Get(&connection, `SELECT * FROM customer_connections WHERE service = $1 AND external_id = $2`, service, externalID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &connection, err
+}
+
+func (pg Postgres) SelectCustomerConnections(customerID DatabaseID) (*[]CustomerConnection, error) {
+	connections := []CustomerConnection{}
+	err := pg.Select(&connections, `SELECT * FROM customer_connections WHERE customer_id = $1 ORDER BY id`, customerID)
+	return &connections, err
+}
+
+func (pg Postgres) DeleteCustomerConnection(customerID DatabaseID, service string) error {
+	_, err := pg.Exec(`DELETE FROM customer_connections WHERE customer_id = $1 AND service = $2`, customerID, service)
+	return err
+}
+
+// Login nonces
+
+func (pg Postgres) InsertLoginNonce(nonce string, expiresAt time.Time) error {
+	_, err := pg.Exec(`INSERT INTO login_nonces (nonce, expires_at) VALUES($1, $2)`, nonce, expiresAt)
+	return err
+}
+
+func (pg Postgres) UseLoginNonce(nonce string, now time.Time) (bool, error) {
+	result, err := pg.Exec(`DELETE FROM login_nonces WHERE nonce = $1 AND expires_at >= $2`, nonce, now)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	return rows == 1, err
+}
+
+// Login failures
+
+func (pg Postgres) GetLoginFailures(email string) (*LoginFailures, error) {
+	failures := LoginFailures{}
+	err := pg.Get(&failures, `SELECT * FROM login_failures WHERE email = $1`, email)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &failures, err
+}
+
+func (pg Postgres) IncrementLoginFailures(email string, now, resetBefore time.Time) (*LoginFailures, error) {
+	failures := LoginFailures{}
+	err := pg.Get(&failures,
+		`INSERT INTO login_failures (email, failures, last_failure_at)
+		VALUES($1, 1, $2)
+		ON CONFLICT (email) DO UPDATE
+		SET failures = CASE WHEN login_failures.last_failure_at < $3 THEN 1 ELSE login_failures.failures + 1 END,
+			last_failure_at = $2, locked_until = NULL
+		RETURNING *`,
+		email, now, resetBefore)
+	return &failures, err
+}
+
+func (pg Postgres) UpdateLoginFailuresLockedUntil(email string, lockedUntil time.Time) error {
+	_, err := pg.Exec(`UPDATE login_failures SET locked_until = $1 WHERE email = $2`, lockedUntil, email)
+	return err
+}
+
+func (pg Postgres) DeleteLoginFailures(email string) error {
+	_, err := pg.Exec(`DELETE FROM login_failures WHERE email = $1`, email)
+	return err
+}
+
 // Order
 
 func (pg Postgres) InsertOrder(order *Order) error {
 	return pg.transact(func(tx *sqlx.Tx) error {
 		err := tx.QueryRow(
-			`INSERT INTO orders (pos_id, status, table_name, total, total_tax, pager_number, site_id, customer_id, created_at)
-			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			`INSERT INTO orders (pos_id, status, table_name, total, total_tax, pager_number, site_id, customer_id, created_at, discount)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 			RETURNING id`,
 			order.PosID,
 			order.Status,
@@ -299,7 +631,8 @@ func (pg Postgres) InsertOrder(order *Order) error {
 			order.PagerNumber,
 			order.SiteID,
 			order.CustomerID,
-			time.Now()).
+			time.Now(),
+			order.Discount).
 			Scan(&order.ID)
 		if err != nil {
 			return err
@@ -418,6 +751,31 @@ func (pg Postgres) UpdateOrderCustomerID(order *Order, customerID DatabaseID) er
 	return err
 }
 
+func (pg Postgres) AnonymizeOrdersByCustomerID(customerID DatabaseID) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(
+			`DELETE FROM pickup_details
+			WHERE order_id IN (SELECT id FROM orders WHERE customer_id = $1)`, customerID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			`UPDATE notifications SET recipient = ''
+			WHERE order_id IN (SELECT id FROM orders WHERE customer_id = $1)`, customerID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE payments SET customer_id = NULL WHERE customer_id = $1`, customerID); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE orders SET customer_id = NULL WHERE customer_id = $1`, customerID)
+		return err
+	})
+}
+
 func (pg Postgres) UpdateOrderPickupTime(order *Order, pickupTime time.Time) error {
 	_, err := pg.Exec(
 		`UPDATE orders
@@ -427,10 +785,54 @@ func (pg Postgres) UpdateOrderPickupTime(order *Order, pickupTime time.Time) err
 	return err
 }
 
+func (pg Postgres) ReservePickupTime(order *Order, pickupTime, slotStart, slotEnd time.Time, maxOrders int) (bool, error) {
+	reserved := false
+	err := pg.transact(func(tx *sqlx.Tx) error {
+		if maxOrders > 0 {
+			// lock the site's pickup settings so that concurrent orders wait for each other's reservation
+			if _, err := tx.Exec(`SELECT site_id FROM site_pickup_settings WHERE site_id = $1 FOR UPDATE`, order.SiteID); err != nil {
+				return errors.Wrap(err, "reserve pickup time")
+			}
+
+			var scheduled int
+			err := tx.Get(&scheduled,
+				`SELECT COUNT(*)
+				FROM orders
+				WHERE site_id = $1 AND id <> $2
+				AND pickup_time >= $3 AND pickup_time < $4
+				AND status NOT IN ($5, $6)`,
+				order.SiteID, order.ID, slotStart, slotEnd, OrderStatusRejected, OrderStatusDeleted)
+			if err != nil {
+				return errors.Wrap(err, "reserve pickup time")
+			}
+			if scheduled >= maxOrders {
+				return nil
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE orders SET pickup_time = $1 WHERE id = $2`, pickupTime.Format(time.RFC3339), order.ID); err != nil {
+			return errors.Wrap(err, "reserve pickup time")
+		}
+		reserved = true
+		return nil
+	})
+	return reserved, err
+}
+
+func (pg Postgres) ReleasePickupTime(order *Order, previous *time.Time) error {
+	_, err := pg.Exec(`UPDATE orders SET pickup_time = $1 WHERE id = $2`, previous, order.ID)
+	return err
+}
+
 func (pg Postgres) UpdateOrderTableName(order *Order, tableName string) error {
 	_, err := pg.Exec(`UPDATE orders
 			      SET table_name = $1
-			      WHERE site_id = $2 AND pager_number = $3`, tableName, order.SiteID, order.PagerNumber)
+			      WHERE id = $2`, tableName, order.ID)
+	return err
+}
+
+func (pg Postgres) UpdateOrderPagerNumber(order *Order, pagerNumber string) error {
+	_, err := pg.Exec(`UPDATE orders SET pager_number = $1 WHERE id = $2`, pagerNumber, order.ID)
 	return err
 }
 
@@ -452,6 +854,123 @@ func (pg Postgres) GetOrderByDatabaseID(orderID DatabaseID) (*Order, error) {
 	return &order, err
 }
 
+func (pg Postgres) InsertLRSEvent(record *LRSEventRecord) error {
+	return pg.QueryRow(`INSERT INTO lrs_events
+		(site_id, elapsed_time, uuid, order_type, table_name, state, pager_number, paged, received_at, outcome, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		record.SiteID, record.ElapsedTime, record.UUID, record.OrderType, record.TableName, record.State,
+		record.PagerNumber, record.Paged, record.ReceivedAt, record.Outcome, record.Error).Scan(&record.ID)
+}
+
+func (pg Postgres) UpdateLRSEventOutcome(id DatabaseID, outcome, errorMessage string) error {
+	_, err := pg.Exec("UPDATE lrs_events SET (outcome, error) = ($2, $3) WHERE id = $1", id, outcome, errorMessage)
+	return err
+}
+
+func (pg Postgres) SelectLRSEvents(siteID KountaID, from, to time.Time) (*[]LRSEventRecord, error) {
+	records := []LRSEventRecord{}
+	err := pg.Select(&records, `SELECT * FROM lrs_events
+		WHERE site_id = $1 AND received_at >= $2 AND received_at < $3
+		ORDER BY received_at, id`, siteID, from, to)
+	return &records, err
+}
+
+func (pg Postgres) InsertPagerSession(session *PagerSession) (bool, error) {
+	err := pg.QueryRow(`INSERT INTO pager_sessions
+		(site_id, pager_number, uuid, state, elapsed_time, order_id, table_name, started_at, paged_at, cleared_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (uuid) DO NOTHING
+		RETURNING id`,
+		session.SiteID, session.PagerNumber, session.UUID, session.State, session.ElapsedTime, session.OrderID,
+		session.TableName, session.StartedAt, session.PagedAt, session.ClearedAt).Scan(&session.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (pg Postgres) DeletePagerSession(id DatabaseID) error {
+	_, err := pg.Exec("DELETE FROM pager_sessions WHERE id = $1", id)
+	return err
+}
+
+func (pg Postgres) GetPagerSessionByUUID(uuid string) (*PagerSession, error) {
+	session := PagerSession{}
+	err := pg.Get(&session, "SELECT * FROM pager_sessions WHERE uuid = $1", uuid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &session, err
+}
+
+func (pg Postgres) UpdatePagerSession(session *PagerSession) error {
+	_, err := pg.Exec(`UPDATE pager_sessions SET (state, elapsed_time, order_id, table_name, paged_at, cleared_at) =
+		($2, $3, $4, $5, $6, $7) WHERE id = $1`,
+		session.ID, session.State, session.ElapsedTime, session.OrderID, session.TableName, session.PagedAt, session.ClearedAt)
+	return err
+}
+
+func (pg Postgres) SelectOpenPagerSessions(siteID KountaID, pagerNumber int64) (*[]PagerSession, error) {
+	sessions := []PagerSession{}
+	err := pg.Select(&sessions, `SELECT * FROM pager_sessions
+		WHERE site_id = $1 AND pager_number = $2 AND cleared_at IS NULL ORDER BY id`, siteID, pagerNumber)
+	return &sessions, err
+}
+
+func (pg Postgres) InsertPagers(siteID KountaID, numbers []int64, now time.Time) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		for _, number := range numbers {
+			_, err := tx.Exec(`INSERT INTO pagers (site_id, number, status, updated_at) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (site_id, number) DO NOTHING`, siteID, number, PagerStatusAvailable, now)
+			if err != nil {
+				return errors.Wrapf(err, "error inserting pager %d", number)
+			}
+		}
+		return nil
+	})
+}
+
+func (pg Postgres) GetPager(siteID KountaID, number int64) (*Pager, error) {
+	pager := Pager{}
+	err := pg.Get(&pager, "SELECT * FROM pagers WHERE site_id = $1 AND number = $2", siteID, number)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &pager, err
+}
+
+func (pg Postgres) SelectPagersBySiteID(siteID KountaID) (*[]Pager, error) {
+	pagers := []Pager{}
+	err := pg.Select(&pagers, "SELECT * FROM pagers WHERE site_id = $1 ORDER BY number", siteID)
+	return &pagers, err
+}
+
+func (pg Postgres) AssignPager(pager *Pager) (bool, error) {
+	err := pg.QueryRow(`INSERT INTO pagers (site_id, number, status, order_id, assigned_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (site_id, number) DO UPDATE SET (status, order_id, assigned_at, updated_at) =
+		(EXCLUDED.status, EXCLUDED.order_id, EXCLUDED.assigned_at, EXCLUDED.updated_at)
+		WHERE pagers.status <> $7
+		RETURNING id`,
+		pager.SiteID, pager.Number, pager.Status, pager.OrderID, pager.AssignedAt, pager.UpdatedAt, PagerStatusLost).Scan(&pager.ID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (pg Postgres) UpdatePagerStatus(siteID KountaID, number int64, status string, now time.Time) error {
+	_, err := pg.Exec(`UPDATE pagers SET (status, order_id, assigned_at, updated_at) = ($3, NULL, NULL, $4)
+		WHERE site_id = $1 AND number = $2`, siteID, number, status, now)
+	return err
+}
+
+func (pg Postgres) ReleasePagersByOrderID(orderID DatabaseID, now time.Time) error {
+	_, err := pg.Exec(`UPDATE pagers SET (status, order_id, assigned_at, updated_at) = ($2, NULL, NULL, $3)
+		WHERE order_id = $1 AND status = $4`, orderID, PagerStatusAvailable, now, PagerStatusAssigned)
+	return err
+}
+
 func (pg Postgres) GetOrderByPagerID(siteID KountaID, pagerID int64) (*Order, error) {
 	pagerString := strconv.FormatInt(pagerID, 10)
 	order := Order{}
@@ -462,12 +981,33 @@ func (pg Postgres) GetOrderByPagerID(siteID KountaID, pagerID int64) (*Order, er
 	return &order, err
 }
 
+func (pg Postgres) SelectActiveOrdersBySiteID(siteID KountaID, statuses []string, createdAfter time.Time) (*[]Order, error) {
+	orders := []Order{}
+	err := pg.Select(&orders, `SELECT * FROM orders
+		WHERE site_id = $1 AND status = ANY($2) AND created_at > $3
+		ORDER BY pickup_time NULLS LAST, created_at, id`, siteID, pq.StringArray(statuses), createdAfter)
+	return &orders, err
+}
+
 func (pg Postgres) SelectOrdersByCustomerID(customerID DatabaseID) (*[]Order, error) {
 	orders := []Order{}
 	err := pg.Select(&orders, `SELECT * FROM orders WHERE customer_id = $1`, customerID)
 	return &orders, err
 }
 
+func (pg Postgres) SelectOrdersByCustomerIDBefore(customerID, before DatabaseID, limit int) (*[]Order, error) {
+	orders := []Order{}
+	err := pg.Select(&orders, `
+		SELECT * FROM orders
+		WHERE customer_id = $1
+		AND status <> $2
+		AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4`,
+		customerID, OrderStatusDeleted, before, limit)
+	return &orders, err
+}
+
 func (pg Postgres) SelectOnHoldAndPendingOrdersByTable(siteID KountaID, tableName string) (*[]Order, error) {
 	orders := []Order{}
 	err := pg.Select(&orders, `
@@ -560,30 +1100,499 @@ func (pg Postgres) SelectRemovedModifiers(lineID DatabaseID) (*[]Modifier, error
 	return &modifiers, err
 }
 
-// Payment
+// SelectLinesByOrderIDs loads lines and modifiers for all of the orders in two queries, rather than one query per line
+func (pg Postgres) SelectLinesByOrderIDs(orderIDs []DatabaseID) (map[DatabaseID][]Line, error) {
+	linesByOrderID := make(map[DatabaseID][]Line, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return linesByOrderID, nil
+	}
 
-func (pg Postgres) InsertPayment(payment *Payment, order *Order) error {
-	return pg.transact(func(tx *sqlx.Tx) error {
-		_, err := tx.Exec(
-			`INSERT INTO payments (amount, tip, transaction_id, date, customer_id, order_id)
-			VALUES($1, $2, $3, $4, $5, $6)`,
-			payment.Amount,
-			payment.Tip,
-			payment.TransactionID,
-			payment.Date,
-			payment.CustomerID,
-			payment.OrderID)
-		if err != nil {
-			return err
-		}
+	lines := []Line{}
+	err := pg.Select(&lines, `SELECT * FROM lines WHERE order_id = ANY($1) ORDER BY id`, int64Array(orderIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "select lines by order ids")
+	}
 
-		_, err = tx.Exec(`UPDATE orders SET status = $1, pager_number = '' WHERE id = $2`, order.Status, order.ID)
-		if err != nil {
-			return err
+	modifiers := []Modifier{}
+	err = pg.Select(&modifiers, `SELECT * FROM modifiers WHERE order_id = ANY($1)`, int64Array(orderIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "select lines by order ids")
+	}
+
+	addedByLineID := map[DatabaseID][]Modifier{}
+	removedByLineID := map[DatabaseID][]Modifier{}
+	for _, modifier := range modifiers {
+		if modifier.Added {
+			addedByLineID[modifier.LineID] = append(addedByLineID[modifier.LineID], modifier)
+		} else {
+			removedByLineID[modifier.LineID] = append(removedByLineID[modifier.LineID], modifier)
 		}
+	}
 
-		return nil
-	})
+	for _, line := range lines {
+		line.AddedModifiers = addedByLineID[line.ID]
+		line.RemovedModifiers = removedByLineID[line.ID]
+		linesByOrderID[line.OrderID] = append(linesByOrderID[line.OrderID], line)
+	}
+
+	return linesByOrderID, nil
+}
+
+// Saved cards
+
+func (pg Postgres) InsertSavedCard(card *SavedCard) error {
+	return pg.QueryRow(
+		`INSERT INTO saved_cards (customer_id, gateway, vault_id, card_type, last_4, expiry, is_default, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		card.CustomerID, card.Gateway, card.VaultID, card.CardType, card.Last4, card.Expiry, card.IsDefault, card.CreatedAt).Scan(&card.ID)
+}
+
+func (pg Postgres) GetSavedCard(id DatabaseID) (*SavedCard, error) {
+	card := SavedCard{}
+	err := pg.Get(&card, `SELECT * FROM saved_cards WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &card, err
+}
+
+func (pg Postgres) SelectSavedCardsByCustomerID(customerID DatabaseID) (*[]SavedCard, error) {
+	cards := []SavedCard{}
+	err := pg.Select(&cards, `SELECT * FROM saved_cards WHERE customer_id = $1 ORDER BY id`, customerID)
+	return &cards, err
+}
+
+func (pg Postgres) DeleteSavedCard(id DatabaseID) error {
+	_, err := pg.Exec(`DELETE FROM saved_cards WHERE id = $1`, id)
+	return err
+}
+
+func (pg Postgres) SetDefaultSavedCard(customerID, cardID DatabaseID) error {
+	_, err := pg.Exec(`UPDATE saved_cards SET is_default = (id = $1) WHERE customer_id = $2`, cardID, customerID)
+	return err
+}
+
+// Loyalty
+
+func (pg Postgres) UpsertLoyaltyRule(rule *LoyaltyRule) error {
+	_, err := pg.Exec(
+		`INSERT INTO loyalty_rules (site_id, cents_per_point, minimum_spend)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (site_id) DO UPDATE SET (cents_per_point, minimum_spend) = (EXCLUDED.cents_per_point, EXCLUDED.minimum_spend)`,
+		rule.SiteID, rule.CentsPerPoint, rule.MinimumSpend)
+	return err
+}
+
+func (pg Postgres) GetLoyaltyRule(siteID KountaID) (*LoyaltyRule, error) {
+	rule := LoyaltyRule{}
+	err := pg.Get(&rule, `SELECT * FROM loyalty_rules WHERE site_id = $1`, siteID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &rule, err
+}
+
+func (pg Postgres) InsertLoyaltyReward(reward *LoyaltyReward) error {
+	return pg.QueryRow(
+		`INSERT INTO loyalty_rewards (name, points, discount, active)
+		VALUES($1, $2, $3, $4)
+		RETURNING id`,
+		reward.Name, reward.Points, reward.Discount, reward.Active).Scan(&reward.ID)
+}
+
+func (pg Postgres) GetLoyaltyReward(id DatabaseID) (*LoyaltyReward, error) {
+	reward := LoyaltyReward{}
+	err := pg.Get(&reward, `SELECT * FROM loyalty_rewards WHERE id = $1`, id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &reward, err
+}
+
+func (pg Postgres) SelectActiveLoyaltyRewards() (*[]LoyaltyReward, error) {
+	rewards := []LoyaltyReward{}
+	err := pg.Select(&rewards, `SELECT * FROM loyalty_rewards WHERE active ORDER BY points, id`)
+	return &rewards, err
+}
+
+func (pg Postgres) InsertLoyaltyEntry(entry *LoyaltyEntry) error {
+	return insertLoyaltyEntry(pg, entry)
+}
+
+func (pg Postgres) InsertLoyaltyRedemption(entry *LoyaltyEntry) (bool, error) {
+	redeemed := false
+	err := pg.transact(func(tx *sqlx.Tx) error {
+		// lock the customer so that concurrent redemptions wait for each other's balance
+		if _, err := tx.Exec(`SELECT id FROM customers WHERE id = $1 FOR UPDATE`, entry.CustomerID); err != nil {
+			return errors.Wrap(err, "insert loyalty redemption")
+		}
+
+		var balance int
+		err := tx.Get(&balance, `SELECT COALESCE(SUM(points), 0) FROM loyalty_entries WHERE customer_id = $1`, entry.CustomerID)
+		if err != nil {
+			return errors.Wrap(err, "insert loyalty redemption")
+		}
+		if balance+entry.Points < 0 {
+			return nil
+		}
+
+		if err := insertLoyaltyEntry(tx, entry); err != nil {
+			return errors.Wrap(err, "insert loyalty redemption")
+		}
+		redeemed = true
+		return nil
+	})
+	return redeemed, err
+}
+
+func insertLoyaltyEntry(q sqlx.Queryer, entry *LoyaltyEntry) error {
+	return q.QueryRowx(
+		`INSERT INTO loyalty_entries (customer_id, order_id, kind, points, description, reversal_of, transaction_id, created_at)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		entry.CustomerID, entry.OrderID, entry.Kind, entry.Points, entry.Description, entry.ReversalOf, entry.TransactionID,
+		entry.CreatedAt).Scan(&entry.ID)
+}
+
+func (pg Postgres) UpdateLoyaltyEntryOrderID(entryID, orderID DatabaseID) error {
+	_, err := pg.Exec(`UPDATE loyalty_entries SET order_id = $1 WHERE id = $2`, orderID, entryID)
+	return err
+}
+
+func (pg Postgres) GetLoyaltyBalance(customerID DatabaseID) (int, error) {
+	var balance int
+	err := pg.Get(&balance, `SELECT COALESCE(SUM(points), 0) FROM loyalty_entries WHERE customer_id = $1`, customerID)
+	return balance, err
+}
+
+func (pg Postgres) SelectLoyaltyEntriesByCustomerID(customerID DatabaseID) (*[]LoyaltyEntry, error) {
+	entries := []LoyaltyEntry{}
+	err := pg.Select(&entries, `SELECT * FROM loyalty_entries WHERE customer_id = $1 ORDER BY id DESC`, customerID)
+	return &entries, err
+}
+
+func (pg Postgres) SelectLoyaltyEntriesByOrderID(orderID DatabaseID) (*[]LoyaltyEntry, error) {
+	entries := []LoyaltyEntry{}
+	err := pg.Select(&entries, `SELECT * FROM loyalty_entries WHERE order_id = $1 ORDER BY id`, orderID)
+	return &entries, err
+}
+
+func (pg Postgres) SelectLoyaltyEntriesByTransactionID(transactionID string) (*[]LoyaltyEntry, error) {
+	entries := []LoyaltyEntry{}
+	err := pg.Select(&entries,
+		`SELECT * FROM loyalty_entries WHERE transaction_id = $1 AND transaction_id <> '' ORDER BY id`, transactionID)
+	return &entries, err
+}
+
+// Promo codes
+
+const (
+	promoRestrictionSite     = "site"
+	promoRestrictionMenuItem = "menu_item"
+	promoRestrictionCategory = "category"
+)
+
+func (pg Postgres) InsertPromoCode(promo *PromoCode) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(
+			`INSERT INTO promo_codes (code, description, discount_type, discount_value, minimum_spend, per_customer_limit,
+			starts_at, ends_at, active, created_at)
+			VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			RETURNING id`,
+			promo.Code, promo.Description, promo.DiscountType, promo.DiscountValue, promo.MinimumSpend, promo.PerCustomerLimit,
+			promo.StartsAt, promo.EndsAt, promo.Active, promo.CreatedAt).Scan(&promo.ID)
+		if err != nil {
+			return errors.Wrap(err, "insert promo code")
+		}
+
+		restrictions := map[string][]KountaID{
+			promoRestrictionSite:     promo.SiteIDs,
+			promoRestrictionMenuItem: promo.MenuItemIDs,
+			promoRestrictionCategory: promo.CategoryIDs,
+		}
+		for kind, posIDs := range restrictions {
+			for _, posID := range posIDs {
+				_, err = tx.Exec(`INSERT INTO promo_code_restrictions (promo_code_id, kind, pos_id) VALUES ($1, $2, $3)`,
+					promo.ID, kind, posID)
+				if err != nil {
+					return errors.Wrap(err, "insert promo code")
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (pg Postgres) GetPromoCodeByCode(code string) (*PromoCode, error) {
+	promo := PromoCode{}
+	err := pg.Get(&promo, `SELECT * FROM promo_codes WHERE code = $1`, code)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get promo code")
+	}
+
+	restrictions := []struct {
+		Kind  string
+		PosID KountaID
+	}{}
+	err = pg.Select(&restrictions, `SELECT kind, pos_id FROM promo_code_restrictions WHERE promo_code_id = $1 ORDER BY id`, promo.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get promo code")
+	}
+	for _, restriction := range restrictions {
+		switch restriction.Kind {
+		case promoRestrictionSite:
+			promo.SiteIDs = append(promo.SiteIDs, restriction.PosID)
+		case promoRestrictionMenuItem:
+			promo.MenuItemIDs = append(promo.MenuItemIDs, restriction.PosID)
+		case promoRestrictionCategory:
+			promo.CategoryIDs = append(promo.CategoryIDs, restriction.PosID)
+		}
+	}
+	return &promo, nil
+}
+
+func (pg Postgres) UpdatePromoCodeActive(id DatabaseID, active bool) error {
+	_, err := pg.Exec(`UPDATE promo_codes SET active = $1 WHERE id = $2`, active, id)
+	return err
+}
+
+func (pg Postgres) InsertPromoRedemption(redemption *PromoRedemption, perCustomerLimit int) (bool, error) {
+	redeemed := false
+	err := pg.transact(func(tx *sqlx.Tx) error {
+		if perCustomerLimit > 0 {
+			// lock the promo code so that concurrent orders wait for each other's redemption
+			if _, err := tx.Exec(`SELECT id FROM promo_codes WHERE id = $1 FOR UPDATE`, redemption.PromoCodeID); err != nil {
+				return errors.Wrap(err, "insert promo redemption")
+			}
+
+			uses, err := countPromoRedemptionsByCustomer(tx, redemption.PromoCodeID, DatabaseID(redemption.CustomerID.Int64))
+			if err != nil {
+				return errors.Wrap(err, "insert promo redemption")
+			}
+			if uses >= perCustomerLimit {
+				return nil
+			}
+		}
+
+		err := tx.QueryRow(
+			`INSERT INTO promo_code_redemptions (promo_code_id, order_id, customer_id, discount, created_at)
+			VALUES($1, $2, $3, $4, $5)
+			RETURNING id`,
+			redemption.PromoCodeID, redemption.OrderID, redemption.CustomerID, redemption.Discount, redemption.CreatedAt).Scan(&redemption.ID)
+		if err != nil {
+			return errors.Wrap(err, "insert promo redemption")
+		}
+		redeemed = true
+		return nil
+	})
+	return redeemed, err
+}
+
+func (pg Postgres) UpdatePromoRedemptionOrderID(redemptionID, orderID DatabaseID) error {
+	_, err := pg.Exec(`UPDATE promo_code_redemptions SET order_id = $1 WHERE id = $2`, orderID, redemptionID)
+	return err
+}
+
+func (pg Postgres) DeletePromoRedemption(id DatabaseID) error {
+	_, err := pg.Exec(`DELETE FROM promo_code_redemptions WHERE id = $1`, id)
+	return err
+}
+
+func (pg Postgres) CountPromoRedemptionsByCustomer(promoCodeID, customerID DatabaseID) (int, error) {
+	return countPromoRedemptionsByCustomer(pg, promoCodeID, customerID)
+}
+
+func countPromoRedemptionsByCustomer(q sqlx.Queryer, promoCodeID, customerID DatabaseID) (int, error) {
+	var count int
+	err := sqlx.Get(q, &count, `
+		SELECT COUNT(*)
+		FROM promo_code_redemptions redemption
+		LEFT JOIN orders o ON o.id = redemption.order_id
+		WHERE redemption.promo_code_id = $1
+		AND redemption.customer_id = $2
+		AND (o.id IS NULL OR o.status NOT IN ($3, $4))`,
+		promoCodeID, customerID, OrderStatusRejected, OrderStatusDeleted)
+	return count, err
+}
+
+func (pg Postgres) SelectPromoCodeUsage(siteID KountaID, from, to time.Time) (*[]PromoCodeUsage, error) {
+	usage := []PromoCodeUsage{}
+	err := pg.Select(&usage, `
+		SELECT promo.code, COUNT(*) AS orders, SUM(redemption.discount) AS discount, SUM(o.total) AS order_total
+		FROM promo_code_redemptions redemption
+		JOIN promo_codes promo ON promo.id = redemption.promo_code_id
+		JOIN orders o ON o.id = redemption.order_id
+		WHERE o.site_id = $1
+		AND o.created_at >= $2 AND o.created_at < $3
+		AND o.status NOT IN ($4, $5)
+		GROUP BY promo.code
+		ORDER BY promo.code`,
+		siteID, from, to, OrderStatusRejected, OrderStatusDeleted)
+	return &usage, err
+}
+
+// Gift cards
+
+func (pg Postgres) InsertGiftCard(card *GiftCard) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		err := tx.QueryRow(
+			`INSERT INTO gift_cards (code, initial_balance, balance, created_at)
+			VALUES($1, $2, $3, $4)
+			RETURNING id`,
+			card.Code, card.InitialBalance, card.Balance, card.CreatedAt).Scan(&card.ID)
+		if err != nil {
+			return errors.Wrap(err, "insert gift card")
+		}
+
+		issue := GiftCardEntry{GiftCardID: card.ID, Kind: GiftCardEntryIssue, Amount: card.InitialBalance, CreatedAt: card.CreatedAt}
+		if err := insertGiftCardEntryRow(tx, &issue); err != nil {
+			return errors.Wrap(err, "insert gift card")
+		}
+		return nil
+	})
+}
+
+func (pg Postgres) GetGiftCardByCode(code string) (*GiftCard, error) {
+	card := GiftCard{}
+	err := pg.Get(&card, `SELECT * FROM gift_cards WHERE code = $1`, code)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &card, err
+}
+
+func (pg Postgres) RedeemGiftCard(entry *GiftCardEntry, payment *Payment, order *Order) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		var balance int
+		if err := tx.Get(&balance, `SELECT balance FROM gift_cards WHERE id = $1 FOR UPDATE`, entry.GiftCardID); err != nil {
+			return errors.Wrap(err, "redeem gift card")
+		}
+		if -entry.Amount > balance {
+			entry.Amount = -balance
+		}
+
+		// lock the order so that concurrent redemptions see each other's payments
+		var orderBalance int
+		err := tx.Get(&orderBalance,
+			`SELECT orders.total - COALESCE((SELECT SUM(amount) FROM payments WHERE order_id = orders.id), 0)
+			FROM orders WHERE id = $1 FOR UPDATE`, order.ID)
+		if err != nil {
+			return errors.Wrap(err, "redeem gift card")
+		}
+		if -entry.Amount > orderBalance {
+			entry.Amount = -orderBalance
+		}
+		if entry.Amount >= 0 {
+			entry.Amount = 0
+			return nil
+		}
+
+		if err := insertGiftCardEntry(tx, entry); err != nil {
+			return errors.Wrap(err, "redeem gift card")
+		}
+
+		payment.Amount = -entry.Amount
+		if err := insertPayment(tx, payment, order); err != nil {
+			return errors.Wrap(err, "redeem gift card")
+		}
+		return nil
+	})
+}
+
+func (pg Postgres) InsertGiftCardEntry(entry *GiftCardEntry) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		return insertGiftCardEntry(tx, entry)
+	})
+}
+
+func (pg Postgres) InsertGiftCardRefund(refund *GiftCardEntry, refundPayment *Payment) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		if err := insertGiftCardEntry(tx, refund); err != nil {
+			return errors.Wrap(err, "insert gift card refund")
+		}
+
+		if err := insertPaymentRow(tx, refundPayment); err != nil {
+			return errors.Wrap(err, "insert gift card refund")
+		}
+		return nil
+	})
+}
+
+// insertGiftCardEntry adds an entry and applies it to the card's balance, which can't go below zero
+func insertGiftCardEntry(tx *sqlx.Tx, entry *GiftCardEntry) error {
+	if err := insertGiftCardEntryRow(tx, entry); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE gift_cards SET balance = balance + $1 WHERE id = $2`, entry.Amount, entry.GiftCardID)
+	return err
+}
+
+func insertGiftCardEntryRow(tx *sqlx.Tx, entry *GiftCardEntry) error {
+	return tx.QueryRow(
+		`INSERT INTO gift_card_entries (gift_card_id, kind, amount, order_id, transaction_id, created_at)
+		VALUES($1, $2, $3, $4, NULLIF($5, ''), $6)
+		RETURNING id`,
+		entry.GiftCardID, entry.Kind, entry.Amount, entry.OrderID, entry.TransactionID, entry.CreatedAt).Scan(&entry.ID)
+}
+
+func (pg Postgres) GetGiftCardEntryByTransactionID(transactionID string) (*GiftCardEntry, error) {
+	entry := GiftCardEntry{}
+	err := pg.Get(&entry,
+		`SELECT id, gift_card_id, kind, amount, order_id, COALESCE(transaction_id, '') AS transaction_id, created_at
+		FROM gift_card_entries WHERE transaction_id = $1`, transactionID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &entry, err
+}
+
+func (pg Postgres) SelectGiftCardEntries(giftCardID DatabaseID) (*[]GiftCardEntry, error) {
+	entries := []GiftCardEntry{}
+	err := pg.Select(&entries,
+		`SELECT id, gift_card_id, kind, amount, order_id, COALESCE(transaction_id, '') AS transaction_id, created_at
+		FROM gift_card_entries WHERE gift_card_id = $1 ORDER BY id`, giftCardID)
+	return &entries, err
+}
+
+// Payment
+
+func (pg Postgres) InsertPayment(payment *Payment, order *Order) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		return insertPayment(tx, payment, order)
+	})
+}
+
+func insertPayment(tx *sqlx.Tx, payment *Payment, order *Order) error {
+	if err := insertPaymentRow(tx, payment); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(`UPDATE orders SET status = $1, pager_number = '' WHERE id = $2`, order.Status, order.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func insertPaymentRow(tx *sqlx.Tx, payment *Payment) error {
+	_, err := tx.Exec(
+		`INSERT INTO payments (amount, tip, transaction_id, date, customer_id, order_id, card_last_4, method)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)`,
+		payment.Amount,
+		payment.Tip,
+		payment.TransactionID,
+		payment.Date,
+		payment.CustomerID,
+		payment.OrderID,
+		payment.CardLast4,
+		payment.Method)
+	return err
 }
 
 func (pg Postgres) GetPaymentByOrderID(id DatabaseID) (*Payment, error) {
@@ -595,6 +1604,62 @@ func (pg Postgres) GetPaymentByOrderID(id DatabaseID) (*Payment, error) {
 	return &payment, err
 }
 
+func (pg Postgres) SelectPaymentsByOrderIDs(orderIDs []DatabaseID) (map[DatabaseID][]Payment, error) {
+	paymentsByOrderID := make(map[DatabaseID][]Payment, len(orderIDs))
+	if len(orderIDs) == 0 {
+		return paymentsByOrderID, nil
+	}
+
+	payments := []Payment{}
+	err := pg.Select(&payments, `SELECT * FROM payments WHERE order_id = ANY($1) ORDER BY date`, int64Array(orderIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "select payments by order ids")
+	}
+
+	for _, payment := range payments {
+		paymentsByOrderID[payment.OrderID] = append(paymentsByOrderID[payment.OrderID], payment)
+	}
+	return paymentsByOrderID, nil
+}
+
+func (pg Postgres) LockOrdersForPayment(orderIDs []DatabaseID, now time.Time, lease time.Duration) (bool, error) {
+	locked := false
+	err := pg.transact(func(tx *sqlx.Tx) error {
+		// lock the orders so that concurrent payments wait to see each other's locks
+		if _, err := tx.Exec(`SELECT id FROM orders WHERE id = ANY($1) ORDER BY id FOR UPDATE`, int64Array(orderIDs)); err != nil {
+			return errors.Wrap(err, "lock orders for payment")
+		}
+
+		var held int
+		err := tx.Get(&held,
+			`SELECT COUNT(*) FROM order_payment_locks WHERE order_id = ANY($1) AND locked_until > $2`,
+			int64Array(orderIDs), now)
+		if err != nil {
+			return errors.Wrap(err, "lock orders for payment")
+		}
+		if held > 0 {
+			return nil
+		}
+
+		_, err = tx.Exec(
+			`INSERT INTO order_payment_locks (order_id, locked_until)
+			SELECT UNNEST($1::BIGINT[]), $2
+			ON CONFLICT (order_id) DO UPDATE SET locked_until = EXCLUDED.locked_until`,
+			int64Array(orderIDs), now.Add(lease))
+		if err != nil {
+			return errors.Wrap(err, "lock orders for payment")
+		}
+		locked = true
+		return nil
+	})
+	return locked, err
+}
+
+func (pg Postgres) UnlockOrdersForPayment(orderIDs []DatabaseID) error {
+	_, err := pg.Exec(`DELETE FROM order_payment_locks WHERE order_id = ANY($1)`, int64Array(orderIDs))
+	return err
+}
+
 // Menu
 
 func (pg Postgres) InsertSite(site *Site) error {
@@ -634,6 +1699,292 @@ func (pg Postgres) GetSite(id KountaID) (*Site, error) {
 	return &site, err
 }
 
+func (pg Postgres) UpdateSiteTimeZone(siteID KountaID, timeZone string) error {
+	_, err := pg.Exec(`UPDATE sites SET time_zone = $1 WHERE pos_id = $2`, timeZone, siteID)
+	return err
+}
+
+func (pg Postgres) UpdateSiteTaxRate(siteID KountaID, rate float64) error {
+	_, err := pg.Exec(`UPDATE sites SET tax_rate = $1 WHERE pos_id = $2`, rate, siteID)
+	return err
+}
+
+// Fees
+
+func (pg Postgres) InsertFeeRule(rule *FeeRule) error {
+	return pg.QueryRow(
+		`INSERT INTO site_fee_rules (site_id, name, kind, percent, amount, min_party_size, taxable, active)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		rule.SiteID, rule.Name, rule.Kind, rule.Percent, rule.Amount, rule.MinPartySize, rule.Taxable, rule.Active).Scan(&rule.ID)
+}
+
+func (pg Postgres) UpdateFeeRuleActive(id DatabaseID, active bool) error {
+	_, err := pg.Exec(`UPDATE site_fee_rules SET active = $1 WHERE id = $2`, active, id)
+	return err
+}
+
+func (pg Postgres) SelectActiveFeeRulesBySiteID(siteID KountaID) (*[]FeeRule, error) {
+	rules := []FeeRule{}
+	err := pg.Select(&rules, `SELECT * FROM site_fee_rules WHERE site_id = $1 AND active ORDER BY id`, siteID)
+	return &rules, err
+}
+
+func (pg Postgres) InsertOrderFees(orderID DatabaseID, fees []OrderFee) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		for _, fee := range fees {
+			_, err := tx.Exec(`INSERT INTO order_fees (order_id, name, kind, amount, tax) VALUES ($1, $2, $3, $4, $5)`,
+				orderID, fee.Name, fee.Kind, fee.Amount, fee.Tax)
+			if err != nil {
+				return errors.Wrap(err, "insert order fees")
+			}
+		}
+		return nil
+	})
+}
+
+func (pg Postgres) SelectOrderFees(orderID DatabaseID) (*[]OrderFee, error) {
+	fees := []OrderFee{}
+	err := pg.Select(&fees, `SELECT name, kind, amount, tax FROM order_fees WHERE order_id = $1 ORDER BY id`, orderID)
+	return &fees, err
+}
+
+func (pg Postgres) SelectFeeUsage(siteID KountaID, from, to time.Time) (*[]FeeUsage, error) {
+	usage := []FeeUsage{}
+	err := pg.Select(&usage, `
+		SELECT fee.name, fee.kind, COUNT(*) AS orders, SUM(fee.amount) AS amount, SUM(fee.tax) AS tax
+		FROM order_fees fee
+		JOIN orders o ON o.id = fee.order_id
+		WHERE o.site_id = $1
+		AND o.created_at >= $2 AND o.created_at < $3
+		AND o.status NOT IN ($4, $5)
+		GROUP BY fee.kind, fee.name
+		ORDER BY fee.kind, fee.name`,
+		siteID, from, to, OrderStatusRejected, OrderStatusDeleted)
+	return &usage, err
+}
+
+// Pickup Slots
+
+func (pg Postgres) UpsertPickupSettings(settings *PickupSettings) error {
+	return pg.transact(func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(
+			`INSERT INTO site_pickup_settings (site_id, slot_minutes, max_orders_per_slot)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (site_id) DO UPDATE SET (slot_minutes, max_orders_per_slot) = (EXCLUDED.slot_minutes, EXCLUDED.max_orders_per_slot)`,
+			settings.SiteID, settings.SlotMinutes, settings.MaxOrdersPerSlot)
+		if err != nil {
+			return errors.Wrap(err, "upsert pickup settings")
+		}
+
+		if _, err = tx.Exec(`DELETE FROM site_opening_hours WHERE site_id = $1`, settings.SiteID); err != nil {
+			return errors.Wrap(err, "upsert pickup settings")
+		}
+
+		for _, hours := range settings.OpeningHours {
+			_, err = tx.Exec(
+				`INSERT INTO site_opening_hours (site_id, weekday, opens, closes)
+				VALUES ($1, $2, $3, $4)`,
+				settings.SiteID, hours.Weekday, hours.Opens, hours.Closes)
+			if err != nil {
+				return errors.Wrap(err, "upsert pickup settings")
+			}
+		}
+
+		return nil
+	})
+}
+
+func (pg Postgres) UpsertTipSettings(settings *TipSettings) error {
+	_, err := pg.Exec(`INSERT INTO site_tip_settings (site_id, percentages, pre_tax, max_percent) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (site_id) DO UPDATE SET (percentages, pre_tax, max_percent) =
+			(EXCLUDED.percentages, EXCLUDED.pre_tax, EXCLUDED.max_percent)`,
+		settings.SiteID, pq.Int64Array(settings.Percentages), settings.PreTax, settings.MaxPercent)
+	return err
+}
+
+func (pg Postgres) GetTipSettings(siteID KountaID) (*TipSettings, error) {
+	row := struct {
+		TipSettings
+		Percentages pq.Int64Array
+	}{}
+	err := pg.Get(&row, "SELECT * FROM site_tip_settings WHERE site_id = $1", siteID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	settings := row.TipSettings
+	settings.Percentages = row.Percentages
+	return &settings, nil
+}
+
+func (pg Postgres) UpsertStaffTip(tip *StaffTip) error {
+	_, err := pg.Exec(`INSERT INTO staff_tips (pos_order_id, site_id, staff_member_id, tips, sold_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (pos_order_id) DO UPDATE SET (staff_member_id, tips, sold_at, updated_at) =
+			(EXCLUDED.staff_member_id, EXCLUDED.tips, EXCLUDED.sold_at, EXCLUDED.updated_at)
+		WHERE staff_tips.updated_at <= EXCLUDED.updated_at`,
+		tip.PosOrderID, tip.SiteID, tip.StaffMemberID, tip.Tips, tip.SoldAt, tip.UpdatedAt)
+	return err
+}
+
+func (pg Postgres) SelectStaffTipsBySiteID(siteID KountaID, from, to time.Time) (*[]StaffTip, error) {
+	tips := []StaffTip{}
+	err := pg.Select(&tips, `SELECT pos_order_id, site_id, staff_member_id, tips, sold_at, updated_at FROM staff_tips
+		WHERE site_id = $1 AND sold_at >= $2 AND sold_at < $3 ORDER BY pos_order_id`, siteID, from, to)
+	return &tips, err
+}
+
+func (pg Postgres) GetPickupSettings(siteID KountaID) (*PickupSettings, error) {
+	settings := PickupSettings{}
+	err := pg.Get(&settings,
+		`SELECT site_id, slot_minutes, max_orders_per_slot FROM site_pickup_settings WHERE site_id = $1`, siteID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "get pickup settings")
+	}
+
+	err = pg.Select(&settings.OpeningHours,
+		`SELECT weekday, opens, closes FROM site_opening_hours WHERE site_id = $1 ORDER BY weekday, opens`, siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get pickup settings")
+	}
+	return &settings, nil
+}
+
+func (pg Postgres) SelectPickupTimesBySiteID(siteID KountaID, from, to time.Time) (map[DatabaseID]time.Time, error) {
+	rows := []struct {
+		ID         DatabaseID
+		PickupTime time.Time
+	}{}
+	err := pg.Select(&rows,
+		`SELECT id, pickup_time
+		FROM orders
+		WHERE site_id = $1
+		AND pickup_time >= $2 AND pickup_time < $3
+		AND status NOT IN ($4, $5)`,
+		siteID, from, to, OrderStatusRejected, OrderStatusDeleted)
+	if err != nil {
+		return nil, errors.Wrap(err, "select pickup times by site id")
+	}
+
+	pickupTimes := make(map[DatabaseID]time.Time, len(rows))
+	for _, row := range rows {
+		pickupTimes[row.ID] = row.PickupTime
+	}
+	return pickupTimes, nil
+}
+
+func (pg Postgres) UpsertPickupDetails(orderID DatabaseID, details PickupDetails) error {
+	_, err := pg.Exec(
+		`INSERT INTO pickup_details (order_id, customer_name, phone_number)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (order_id) DO UPDATE SET (customer_name, phone_number) = (EXCLUDED.customer_name, EXCLUDED.phone_number)`,
+		orderID, details.CustomerName, details.PhoneNumber)
+	return err
+}
+
+func (pg Postgres) GetPickupDetails(orderID DatabaseID) (*PickupDetails, error) {
+	details := PickupDetails{}
+	err := pg.Get(&details, `SELECT customer_name, phone_number FROM pickup_details WHERE order_id = $1`, orderID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &details, err
+}
+
+// Notifications
+
+func (pg Postgres) UpsertNotificationSettings(settings *NotificationSettings) error {
+	_, err := pg.Exec(
+		`INSERT INTO notification_settings (site_id, sender_name, sms_from, sms_enabled, email_enabled)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (site_id) DO UPDATE SET (sender_name, sms_from, sms_enabled, email_enabled) =
+			(EXCLUDED.sender_name, EXCLUDED.sms_from, EXCLUDED.sms_enabled, EXCLUDED.email_enabled)`,
+		settings.SiteID, settings.SenderName, settings.SMSFrom, settings.SMSEnabled, settings.EmailEnabled)
+	return err
+}
+
+func (pg Postgres) GetNotificationSettings(siteID KountaID) (*NotificationSettings, error) {
+	settings := NotificationSettings{}
+	err := pg.Get(&settings, `SELECT * FROM notification_settings WHERE site_id = $1`, siteID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &settings, err
+}
+
+func (pg Postgres) InsertNotification(notification *Notification) error {
+	return pg.QueryRow(
+		`INSERT INTO notifications (order_id, channel, recipient, template, provider_message_id, status, error, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`,
+		notification.OrderID,
+		notification.Channel,
+		notification.Recipient,
+		notification.Template,
+		notification.ProviderMessageID,
+		notification.Status,
+		notification.Error,
+		notification.CreatedAt).Scan(&notification.ID)
+}
+
+func (pg Postgres) SelectNotificationsByOrderID(orderID DatabaseID) (*[]Notification, error) {
+	notifications := []Notification{}
+	err := pg.Select(&notifications, `SELECT * FROM notifications WHERE order_id = $1 ORDER BY id`, orderID)
+	return &notifications, err
+}
+
+// Outbox
+
+func (pg Postgres) InsertOutboxEmail(email *OutboxEmail) error {
+	return pg.QueryRow(
+		`INSERT INTO email_outbox (template, "from", "to", subject, html_body, text_body, status, attempts, last_error, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id`,
+		email.Template,
+		email.From,
+		email.To,
+		email.Subject,
+		email.HTMLBody,
+		email.TextBody,
+		email.Status,
+		email.Attempts,
+		email.LastError,
+		email.NextAttemptAt,
+		email.CreatedAt).Scan(&email.ID)
+}
+
+func (pg Postgres) UpdateOutboxEmail(email *OutboxEmail) error {
+	_, err := pg.Exec(
+		`UPDATE email_outbox
+		SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4, sent_at = $5
+		WHERE id = $6`,
+		email.Status, email.Attempts, email.LastError, email.NextAttemptAt, email.SentAt, email.ID)
+	return err
+}
+
+func (pg Postgres) ClaimDueOutboxEmails(now time.Time, lease time.Duration, limit int) (*[]OutboxEmail, error) {
+	emails := []OutboxEmail{}
+	err := pg.Select(&emails,
+		`UPDATE email_outbox
+		SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`,
+		now.Add(lease), OutboxStatusPending, now, limit)
+	return &emails, err
+}
+
 func (pg Postgres) UpsertCategory(category *Category) error {
 	return pg.transact(func(tx *sqlx.Tx) error {
 		_, err := tx.Exec(
@@ -730,6 +2081,20 @@ func (pg Postgres) SelectMenuItemsByCategoryID(siteID KountaID, categoryID Datab
 	return &menuItems, err
 }
 
+// SelectMenuItemsBySiteID looks up all menu items for a Kounta site, priced for that site
+func (pg Postgres) SelectMenuItemsBySiteID(siteID KountaID) (*[]MenuItem, error) {
+	menuItems := []MenuItem{}
+	err := pg.Select(&menuItems,
+		`SELECT m.*, p.price
+		FROM menu_items m
+		JOIN site_menu_items_pricing p ON p.menu_item_id = m.id
+		JOIN sites s ON p.site_id = s.id
+		WHERE s.pos_id = $1
+		ORDER BY m.id`,
+		siteID)
+	return &menuItems, err
+}
+
 // GetMenuItem gets a single menu item by database ID
 func (pg Postgres) GetMenuItem(siteID KountaID, menuItemID DatabaseID) (*MenuItem, error) {
 	m := MenuItem{}
@@ -873,6 +2238,30 @@ func (pg Postgres) SelectMenuItemModifiers(siteID KountaID, menuItemID DatabaseI
 	return &modifiers, err
 }
 
+func (pg Postgres) SelectMenuItemModifiersBySiteID(siteID KountaID) (map[DatabaseID][]Modifier, error) {
+	rows := []struct {
+		MenuItemID DatabaseID
+		Modifier
+	}{}
+	err := pg.Select(&rows,
+		`SELECT mim.menu_item_id, m.*, p.price
+		FROM menu_item_modifiers_mapping mim
+		JOIN menu_modifiers m ON m.id = mim.modifier_id
+		JOIN site_menu_modifiers_pricing p ON m.id = p.menu_modifier_id
+		JOIN sites s ON p.site_id = s.id
+		WHERE s.pos_id = $1
+		ORDER BY m.id`, siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "select menu item modifiers by site id")
+	}
+
+	modifiersByItemID := map[DatabaseID][]Modifier{}
+	for _, row := range rows {
+		modifiersByItemID[row.MenuItemID] = append(modifiersByItemID[row.MenuItemID], row.Modifier)
+	}
+	return modifiersByItemID, nil
+}
+
 func (pg Postgres) DeleteMenuModifier(modifierID KountaID) error {
 	_, err := pg.Exec(`DELETE FROM menu_modifiers WHERE pos_id = $1`, modifierID)
 	return err
@@ -952,6 +2341,66 @@ func (pg Postgres) SelectOptionSetsByItemID(menuItemID DatabaseID) (*[]OptionSet
 
 	return &optionSets, nil
 }
+
+// SelectOptionSetsBySiteID loads the option sets and their options for a whole site in two queries
+func (pg Postgres) SelectOptionSetsBySiteID(siteID KountaID) (map[DatabaseID][]OptionSet, error) {
+	rows := []struct {
+		MenuItemID DatabaseID
+		OptionSet
+	}{}
+	err := pg.Select(&rows,
+		`SELECT mm.menu_item_id, o.*
+		FROM menu_option_sets o
+		JOIN menu_item_option_sets_mapping mm ON o.id = mm.option_set_id
+		JOIN site_menu_items_pricing p ON p.menu_item_id = mm.menu_item_id
+		JOIN sites s ON p.site_id = s.id
+		WHERE s.pos_id = $1
+		ORDER BY o.id`, siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "select option sets by site id")
+	}
+
+	optionSetsByItemID := map[DatabaseID][]OptionSet{}
+	if len(rows) == 0 {
+		return optionSetsByItemID, nil
+	}
+
+	optionSetIDs := make([]DatabaseID, len(rows))
+	for i, row := range rows {
+		optionSetIDs[i] = row.ID
+	}
+
+	options := []struct {
+		OptionSetID DatabaseID
+		Modifier
+	}{}
+	err = pg.Select(&options,
+		// Using price_ex_tax because old tables have price as price_ex_tax and reusing Modifier
+		`SELECT mom.option_set_id, m.*, mom.price AS price_ex_tax
+		FROM menu_modifiers m
+		JOIN menu_option_set_modifiers_mapping mom ON m.id = mom.modifier_id
+		WHERE mom.option_set_id = ANY($1)
+		ORDER BY m.id`, int64Array(optionSetIDs))
+	if err != nil {
+		return nil, errors.Wrap(err, "select option sets by site id")
+	}
+
+	optionsByOptionSetID := map[DatabaseID][]Modifier{}
+	for _, option := range options {
+		optionsByOptionSetID[option.OptionSetID] = append(optionsByOptionSetID[option.OptionSetID], option.Modifier)
+	}
+
+	for _, row := range rows {
+		optionSet := row.OptionSet
+		optionSet.Options = optionsByOptionSetID[optionSet.ID]
+		if optionSet.Options == nil {
+			optionSet.Options = []Modifier{}
+		}
+		optionSetsByItemID[row.MenuItemID] = append(optionSetsByItemID[row.MenuItemID], optionSet)
+	}
+	return optionSetsByItemID, nil
+}
+
 func (pg Postgres) DeleteOptionSet(optionSetID KountaID) error {
 	_, err := pg.Exec(`DELETE FROM menu_option_sets WHERE pos_id = $1`, optionSetID)
 	return err
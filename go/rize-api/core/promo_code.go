@@ -0,0 +1,295 @@
+package core
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PromoDiscountPercent = "percent"
+	PromoDiscountAmount  = "amount"
+)
+
+// PromoCode is a discount a guest can apply to a new order by entering its code. Empty SiteIDs means the code can be
+// used at every site, and empty MenuItemIDs and CategoryIDs mean it discounts the whole order.
+type PromoCode struct {
+	ID               DatabaseID `json:"id"`
+	Code             string     `json:"code"`
+	Description      string     `json:"description"`
+	DiscountType     string     `json:"discount_type"`
+	DiscountValue    int        `json:"discount_value"` // DiscountValue is a percentage for percent discounts, otherwise cents
+	MinimumSpend     int        `json:"minimum_spend"`
+	PerCustomerLimit int        `json:"per_customer_limit"` // PerCustomerLimit of 0 means unlimited
+	StartsAt         *time.Time `json:"starts_at"`
+	EndsAt           *time.Time `json:"ends_at"`
+	Active           bool       `json:"active"`
+	CreatedAt        time.Time  `json:"created_at"`
+	SiteIDs          []KountaID `json:"site_ids" db:"-"`
+	MenuItemIDs      []KountaID `json:"menu_item_ids" db:"-"`
+	CategoryIDs      []KountaID `json:"category_ids" db:"-"`
+}
+
+// PromoRedemption records a promo code used on an order. It has no OrderID while its order is being created.
+type PromoRedemption struct {
+	ID          DatabaseID
+	PromoCodeID DatabaseID
+	OrderID     sql.NullInt64
+	CustomerID  sql.NullInt64
+	Discount    int
+	CreatedAt   time.Time
+}
+
+// PromoCodeUsage is how much a promo code was used at a site, excluding rejected and deleted orders
+type PromoCodeUsage struct {
+	Code       string `json:"code"`
+	Orders     int    `json:"orders"`
+	Discount   int    `json:"discount"`
+	OrderTotal int    `json:"order_total"`
+}
+
+// PromoCodeError is returned when a promo code is invalid or can't be used on an order
+type PromoCodeError struct {
+	Reason string
+}
+
+func (e PromoCodeError) Error() string {
+	return e.Reason
+}
+
+// AddPromoCode will validate and save a new promo code. Codes are case insensitive and stored in upper case.
+func (app AppContext) AddPromoCode(promo *PromoCode) error {
+	promo.Code = normalizePromoCode(promo.Code)
+	if err := validatePromoCode(promo); err != nil {
+		return errors.Wrap(err, "add promo code")
+	}
+
+	existing, err := app.DB.GetPromoCodeByCode(promo.Code)
+	if err != nil {
+		return errors.Wrap(err, "add promo code")
+	}
+	if existing != nil {
+		return errors.Wrap(PromoCodeError{Reason: "promo code " + promo.Code + " already exists"}, "add promo code")
+	}
+
+	promo.Active = true
+	promo.CreatedAt = time.Now()
+	if err := app.DB.InsertPromoCode(promo); err != nil {
+		return errors.Wrap(err, "add promo code")
+	}
+	return nil
+}
+
+// DeactivatePromoCode will stop a promo code from being used on new orders
+func (app AppContext) DeactivatePromoCode(code string) error {
+	promo, err := app.DB.GetPromoCodeByCode(normalizePromoCode(code))
+	if err != nil {
+		return errors.Wrap(err, "deactivate promo code")
+	}
+	if promo == nil {
+		return errors.Wrap(PromoCodeError{Reason: "promo code not found"}, "deactivate promo code")
+	}
+
+	if err := app.DB.UpdatePromoCodeActive(promo.ID, false); err != nil {
+		return errors.Wrap(err, "deactivate promo code")
+	}
+	return nil
+}
+
+// PreviewPromoCode will return the discount the promo code on an order would give, so it can be shown before the
+// order is placed
+func (app AppContext) PreviewPromoCode(siteID KountaID, createOrder CreateOrder) (int, error) {
+	_, discount, err := app.discountForPromoCode(siteID, createOrder, time.Now())
+	if err != nil {
+		return 0, errors.Wrap(err, "preview promo code")
+	}
+	return discount, nil
+}
+
+// GetPromoCodeReport will return how much each promo code was used at a site on orders created in [from, to)
+func (app AppContext) GetPromoCodeReport(siteID KountaID, from, to time.Time) (*[]PromoCodeUsage, error) {
+	usage, err := app.DB.SelectPromoCodeUsage(siteID, from, to)
+	if err != nil {
+		return nil, errors.Wrap(err, "get promo code report")
+	}
+	return usage, nil
+}
+
+// applyPromoCode will redeem the promo code on a new order, if there is one, and add its discount to the order. The
+// returned redemption is not linked to an order until the order has been created.
+func (app AppContext) applyPromoCode(siteID KountaID, createOrder *CreateOrder) (*PromoRedemption, error) {
+	if createOrder.PromoCode == "" {
+		return nil, nil
+	}
+
+	promo, discount, err := app.discountForPromoCode(siteID, *createOrder, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "apply promo code")
+	}
+
+	redemption := PromoRedemption{
+		PromoCodeID: promo.ID,
+		Discount:    discount,
+		CreatedAt:   time.Now(),
+	}
+	if createOrder.CustomerID != 0 {
+		redemption.CustomerID = sql.NullInt64{Int64: int64(createOrder.CustomerID), Valid: true}
+	}
+	redeemed, err := app.DB.InsertPromoRedemption(&redemption, promo.PerCustomerLimit)
+	if err != nil {
+		return nil, errors.Wrap(err, "apply promo code")
+	}
+	if !redeemed {
+		return nil, errors.Wrap(PromoCodeError{Reason: "you have already used this promo code"}, "apply promo code")
+	}
+
+	createOrder.Discounts = append(createOrder.Discounts, OrderDiscount{Name: "Promo " + promo.Code, Amount: discount})
+	return &redemption, nil
+}
+
+// discountForPromoCode checks the promo code on an order can be used and works out its discount from current prices
+func (app AppContext) discountForPromoCode(siteID KountaID, createOrder CreateOrder, now time.Time) (*PromoCode, int, error) {
+	promo, err := app.DB.GetPromoCodeByCode(normalizePromoCode(createOrder.PromoCode))
+	if err != nil {
+		return nil, 0, err
+	}
+	if promo == nil || !promo.Active {
+		return nil, 0, PromoCodeError{Reason: "this promo code is not valid"}
+	}
+	if promo.StartsAt != nil && now.Before(*promo.StartsAt) {
+		return nil, 0, PromoCodeError{Reason: "this promo code can't be used yet"}
+	}
+	if promo.EndsAt != nil && !now.Before(*promo.EndsAt) {
+		return nil, 0, PromoCodeError{Reason: "this promo code has expired"}
+	}
+	if len(promo.SiteIDs) > 0 && !containsKountaID(promo.SiteIDs, siteID) {
+		return nil, 0, PromoCodeError{Reason: "this promo code can't be used here"}
+	}
+
+	if promo.PerCustomerLimit > 0 {
+		if createOrder.CustomerID == 0 {
+			return nil, 0, PromoCodeError{Reason: "sign in to use this promo code"}
+		}
+		uses, err := app.DB.CountPromoRedemptionsByCustomer(promo.ID, createOrder.CustomerID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if uses >= promo.PerCustomerLimit {
+			return nil, 0, PromoCodeError{Reason: "you have already used this promo code"}
+		}
+	}
+
+	subtotal, qualifying, err := app.promoSubtotals(siteID, createOrder, promo)
+	if err != nil {
+		return nil, 0, err
+	}
+	if subtotal < promo.MinimumSpend {
+		return nil, 0, PromoCodeError{Reason: "spend " + Cents(promo.MinimumSpend).String() + " to use this promo code"}
+	}
+	if qualifying == 0 {
+		return nil, 0, PromoCodeError{Reason: "this promo code doesn't apply to anything in your order"}
+	}
+
+	discount := promo.DiscountValue
+	if promo.DiscountType == PromoDiscountPercent {
+		discount = qualifying * promo.DiscountValue / 100
+	}
+	if discount > qualifying {
+		discount = qualifying
+	}
+	return promo, discount, nil
+}
+
+// promoSubtotals prices a new order from the site's menu, returning the order subtotal and the subtotal of the items
+// the promo code discounts
+func (app AppContext) promoSubtotals(siteID KountaID, createOrder CreateOrder, promo *PromoCode) (subtotal, qualifying int, err error) {
+	categories, err := app.GetCategoriesForSite(siteID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	menuItems := map[DatabaseID]MenuItem{}
+	categoryIDs := map[DatabaseID]KountaID{}
+	for _, category := range categories {
+		for _, menuItem := range category.MenuItems {
+			menuItems[menuItem.ID] = menuItem
+			categoryIDs[menuItem.ID] = category.PosID
+		}
+	}
+
+	for _, item := range createOrder.MenuItems {
+		menuItem, ok := menuItems[item.ID]
+		if !ok {
+			return 0, 0, errors.Errorf("menu item %d not found", item.ID)
+		}
+
+		price := menuItem.Price
+		for _, modifierID := range item.SelectedModifierIDs {
+			for _, modifier := range menuItem.Modifiers {
+				if modifier.ID == modifierID {
+					price += modifier.PriceWithTax
+				}
+			}
+		}
+		for _, selected := range item.SelectedOptions {
+			for _, optionSet := range menuItem.OptionSets {
+				for _, option := range optionSet.Options {
+					if optionSet.ID == selected.OptionSetID && option.ID == selected.ModifierID {
+						price += option.PriceWithTax
+					}
+				}
+			}
+		}
+
+		total := price * item.Quantity
+		subtotal += total
+
+		restricted := len(promo.MenuItemIDs) > 0 || len(promo.CategoryIDs) > 0
+		if !restricted || containsKountaID(promo.MenuItemIDs, menuItem.PosID) || containsKountaID(promo.CategoryIDs, categoryIDs[menuItem.ID]) {
+			qualifying += total
+		}
+	}
+	return subtotal, qualifying, nil
+}
+
+func validatePromoCode(promo *PromoCode) error {
+	if promo.Code == "" {
+		return PromoCodeError{Reason: "a promo code needs a code"}
+	}
+
+	switch promo.DiscountType {
+	case PromoDiscountPercent:
+		if promo.DiscountValue <= 0 || promo.DiscountValue > 100 {
+			return PromoCodeError{Reason: "a percent discount must be between 1 and 100"}
+		}
+	case PromoDiscountAmount:
+		if promo.DiscountValue <= 0 {
+			return PromoCodeError{Reason: "an amount discount must be positive"}
+		}
+	default:
+		return PromoCodeError{Reason: "unknown discount type " + promo.DiscountType}
+	}
+
+	if promo.MinimumSpend < 0 || promo.PerCustomerLimit < 0 {
+		return PromoCodeError{Reason: "minimum spend and per customer limit can't be negative"}
+	}
+	if promo.StartsAt != nil && promo.EndsAt != nil && !promo.EndsAt.After(*promo.StartsAt) {
+		return PromoCodeError{Reason: "a promo code must end after it starts"}
+	}
+	return nil
+}
+
+func normalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func containsKountaID(ids []KountaID, id KountaID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
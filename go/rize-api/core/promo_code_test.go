@@ -0,0 +1,218 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"pos"
+)
+
+func TestCreateNewOrderAppliesPromoCode(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	createOrder := pricedPromoOrder(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "save10", DiscountType: core.PromoDiscountPercent, DiscountValue: 10, CategoryIDs: []core.KountaID{core.TestCategory1PosID}})
+	createOrder.PromoCode = "SAVE10"
+
+	// act
+	order, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	// only the two items from category 1 are discounted
+	assert.Equal(t, []core.PriceLine{{Name: "Promo SAVE10", Amount: -200}}, kounta.lines)
+	assert.Equal(t, 200, order.Discount)
+
+	savedOrder, _ := app.DB.GetOrderByDatabaseID(order.ID)
+	assert.Equal(t, 200, savedOrder.Discount)
+
+	now := time.Now()
+	report, _ := app.GetPromoCodeReport(core.TestSitePosID, now.Add(-time.Hour), now.Add(time.Hour))
+	assert.Equal(t, []core.PromoCodeUsage{{Code: "SAVE10", Orders: 1, Discount: 200, OrderTotal: order.Total}}, *report)
+}
+
+func TestPromoCodePerCustomerLimit(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	kounta := &orderKounta{MockKounta: &pos.MockKounta{}}
+	app.Kounta = kounta
+	customer := createTestCustomer(app)
+	createOrder := pricedPromoOrder(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "WELCOME", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, PerCustomerLimit: 1})
+	createOrder.PromoCode = "welcome"
+	createOrder.CustomerID = customer.ID
+
+	_, firstErr := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, firstErr)
+	assert.IsType(t, core.PromoCodeError{}, errors.Cause(err))
+	assert.Equal(t, 1, len(kounta.orders))
+}
+
+func TestPreviewPromoCodeChecksRules(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	createOrder := pricedPromoOrder(t, app)
+
+	yesterday := time.Now().Add(-24 * time.Hour)
+	app.AddPromoCode(&core.PromoCode{Code: "EXPIRED", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, EndsAt: &yesterday})
+	app.AddPromoCode(&core.PromoCode{Code: "BIGSPEND", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, MinimumSpend: 5000})
+	app.AddPromoCode(&core.PromoCode{Code: "ELSEWHERE", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, SiteIDs: []core.KountaID{999}})
+	app.AddPromoCode(&core.PromoCode{Code: "ITEM5", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, MenuItemIDs: []core.KountaID{349}})
+
+	for _, code := range []string{"EXPIRED", "BIGSPEND", "ELSEWHERE", "ITEM5", "UNKNOWN"} {
+		createOrder.PromoCode = code
+
+		// act
+		_, err := app.PreviewPromoCode(core.TestSitePosID, createOrder)
+
+		// assert
+		assert.IsType(t, core.PromoCodeError{}, errors.Cause(err), code)
+	}
+}
+
+func TestPromoCodePerCustomerLimitCountsOrdersBeingCreated(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	createOrder := pricedPromoOrder(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "WELCOME", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, PerCustomerLimit: 1})
+	createOrder.PromoCode = "WELCOME"
+	createOrder.CustomerID = customer.ID
+
+	// the same order is placed again while the first is still being created in Kounta
+	kounta := &reentrantKounta{orderKounta: &orderKounta{MockKounta: &pos.MockKounta{}}, app: &app, createOrder: createOrder}
+	app.Kounta = kounta
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.NoError(t, err)
+	assert.IsType(t, core.PromoCodeError{}, errors.Cause(kounta.reentrantErr))
+	assert.Equal(t, 1, len(kounta.orders))
+}
+
+func TestPromoCodeIsFreedWhenOrderIsNotCreated(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	createOrder := pricedPromoOrder(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "WELCOME", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, PerCustomerLimit: 1})
+	createOrder.PromoCode = "WELCOME"
+	createOrder.CustomerID = customer.ID
+	promo, _ := app.DB.GetPromoCodeByCode("WELCOME")
+
+	app.Kounta = &failingPriceLineKounta{orderKounta: &orderKounta{MockKounta: &pos.MockKounta{}}}
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.Error(t, err)
+	uses, _ := app.DB.CountPromoRedemptionsByCustomer(promo.ID, customer.ID)
+	assert.Equal(t, 0, uses)
+}
+
+func TestRedemptionsAreFreedWhenOrderIsNotSaved(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.Kounta = &orderKounta{MockKounta: &pos.MockKounta{}}
+	customer := createTestCustomer(app)
+	reward := giveLoyaltyPoints(t, app, customer.ID, 20)
+	createOrder := pricedPromoOrder(t, app)
+	app.AddPromoCode(&core.PromoCode{Code: "WELCOME", DiscountType: core.PromoDiscountAmount, DiscountValue: 300, PerCustomerLimit: 1})
+	createOrder.PromoCode = "WELCOME"
+	createOrder.RewardID = reward.ID
+	createOrder.CustomerID = customer.ID
+	promo, _ := app.DB.GetPromoCodeByCode("WELCOME")
+
+	app.DB = insertOrderFailingDB{DB: app.DB}
+
+	// act
+	_, err := app.CreateNewOrder(core.TestSitePosID, createOrder)
+
+	// assert
+	assert.Error(t, err)
+	uses, _ := app.DB.CountPromoRedemptionsByCustomer(promo.ID, customer.ID)
+	assert.Equal(t, 0, uses)
+	balance, _ := app.GetLoyaltyBalance(customer.ID)
+	assert.Equal(t, 20, balance)
+}
+
+// helpers
+
+// insertOrderFailingDB fails to save orders
+type insertOrderFailingDB struct {
+	core.DB
+}
+
+func (db insertOrderFailingDB) InsertOrder(order *core.Order) error {
+	return errors.New("database is down")
+}
+
+// reentrantKounta places an order again from inside the first order's creation
+type reentrantKounta struct {
+	*orderKounta
+	app          *core.AppContext
+	createOrder  core.CreateOrder
+	reentered    bool
+	reentrantErr error
+}
+
+func (k *reentrantKounta) CreateOrderWithPriceLines(siteID core.KountaID, newOrder core.CreateOrder, lines []core.PriceLine) (core.KountaOrder, error) {
+	if !k.reentered {
+		k.reentered = true
+		_, k.reentrantErr = k.app.CreateNewOrder(siteID, k.createOrder)
+	}
+	return k.orderKounta.CreateOrderWithPriceLines(siteID, newOrder, lines)
+}
+
+// failingPriceLineKounta fails to create orders with price lines
+type failingPriceLineKounta struct {
+	*orderKounta
+}
+
+func (k *failingPriceLineKounta) CreateOrderWithPriceLines(siteID core.KountaID, newOrder core.CreateOrder, lines []core.PriceLine) (core.KountaOrder, error) {
+	return nil, errors.New("kounta is down")
+}
+
+// pricedPromoOrder prices menu items 345 at $10 and 347 at $5 and returns an order for two of 345 and one of 347
+func pricedPromoOrder(t *testing.T, app core.AppContext) core.CreateOrder {
+	app.TestInsertMenu(t)
+	site, _ := app.DB.GetSite(core.TestSitePosID)
+	menuItems, _ := app.DB.SelectMenuItemsBySiteID(core.TestSitePosID)
+
+	createOrder := core.CreateOrder{SiteID: core.TestSitePosID}
+	prices := map[core.KountaID]int{345: 1000, 347: 500}
+	quantities := map[core.KountaID]int{345: 2, 347: 1}
+	for _, menuItem := range *menuItems {
+		price, ok := prices[menuItem.PosID]
+		if !ok {
+			continue
+		}
+
+		menuItem.SiteID = site.ID
+		menuItem.Price = price
+		if err := app.DB.UpsertMenuItem(&menuItem); err != nil {
+			t.Fatal(err)
+		}
+		createOrder.MenuItems = append(createOrder.MenuItems, core.CreateOrderMenuItem{ID: menuItem.ID, Quantity: quantities[menuItem.PosID]})
+	}
+	return createOrder
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// sightingWindow is how far back from the newest sighting readings are used, older ones are from where the guest was
+	sightingWindow = 10 * time.Second
+	// rssiSmoothing is the weight of each new reading in a beacon's moving average, lower values smooth more
+	rssiSmoothing = 0.3
+	// minRSSI drops readings too weak to say anything about which table is closest
+	minRSSI = -100
+	// minTableConfidence is the confidence below which the guest is asked to pick their table
+	minTableConfidence = 0.7
+	// minMatchRSSI is how strong the best table's signal has to be to match it, weaker and the guest could be anywhere
+	// in the room, like when a phone only hears one faint beacon
+	minMatchRSSI       = -75
+	maxTableCandidates = 3
+)
+
+// BeaconSighting is one RSSI reading of a beacon by a guest's phone
+type BeaconSighting struct {
+	BeaconID string    `json:"beacon_id"`
+	RSSI     int       `json:"rssi"`
+	SeenAt   time.Time `json:"seen_at"`
+}
+
+// TableMatch is the table a guest is most likely sitting at. When Ambiguous, TableMap is nil and the guest should be
+// asked to pick from Candidates.
+type TableMatch struct {
+	TableMap   *TableMap        `json:"table_map"`
+	Confidence float64          `json:"confidence"`
+	Ambiguous  bool             `json:"ambiguous"`
+	Candidates []TableCandidate `json:"candidates"`
+}
+
+// TableCandidate is a table near a guest, with the smoothed RSSI of its strongest beacon
+type TableCandidate struct {
+	TableMap   TableMap `json:"table_map"`
+	RSSI       float64  `json:"rssi"`
+	Confidence float64  `json:"confidence"`
+}
+
+// ResolveTable will work out which table a guest is at from recent beacon sightings. Each beacon's readings are
+// smoothed with a moving average so a single spike doesn't move the guest to the next table, and the confidence of
+// each table is its share of the received signal power. A guest is only ever at one site, so only tables at the site
+// with the most received signal power are candidates. The match is ambiguous when even the best table is far away.
+func (app AppContext) ResolveTable(sightings []BeaconSighting) (*TableMatch, error) {
+	smoothed := smoothRSSI(sightings)
+
+	beaconIDs := []string{}
+	for beaconID := range smoothed {
+		beaconIDs = append(beaconIDs, beaconID)
+	}
+	tableMaps, err := app.DB.SelectTableMapsByBeaconIDs(beaconIDs)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolve table")
+	}
+
+	candidatesBySite := map[KountaID]map[string]*TableCandidate{}
+	for _, tableMap := range *tableMaps {
+		rssi := smoothed[tableMap.BeaconID]
+		candidates, ok := candidatesBySite[tableMap.SiteID]
+		if !ok {
+			candidates = map[string]*TableCandidate{}
+			candidatesBySite[tableMap.SiteID] = candidates
+		}
+
+		// a table with more than one beacon is as close as its nearest one
+		if candidate, ok := candidates[tableMap.TableName]; !ok || rssi > candidate.RSSI {
+			candidates[tableMap.TableName] = &TableCandidate{TableMap: tableMap, RSSI: rssi}
+		}
+	}
+
+	var candidates map[string]*TableCandidate
+	total := 0.0
+	for _, siteCandidates := range candidatesBySite {
+		siteTotal := 0.0
+		for _, candidate := range siteCandidates {
+			siteTotal += rssiPower(candidate.RSSI)
+		}
+		if siteTotal > total {
+			candidates, total = siteCandidates, siteTotal
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.Wrap(TableMapError{Reason: "no tables found nearby"}, "resolve table")
+	}
+
+	match := TableMatch{Candidates: []TableCandidate{}}
+	for _, candidate := range candidates {
+		candidate.Confidence = rssiPower(candidate.RSSI) / total
+		match.Candidates = append(match.Candidates, *candidate)
+	}
+	sort.Slice(match.Candidates, func(i, j int) bool {
+		return match.Candidates[i].Confidence > match.Candidates[j].Confidence
+	})
+	if len(match.Candidates) > maxTableCandidates {
+		match.Candidates = match.Candidates[:maxTableCandidates]
+	}
+
+	best := match.Candidates[0]
+	match.Confidence = best.Confidence
+	if best.Confidence < minTableConfidence || best.RSSI < minMatchRSSI {
+		match.Ambiguous = true
+		return &match, nil
+	}
+	match.TableMap = &best.TableMap
+	return &match, nil
+}
+
+// smoothRSSI returns the moving average RSSI of each beacon over the sighting window, oldest reading first
+func smoothRSSI(sightings []BeaconSighting) map[string]float64 {
+	readings := []BeaconSighting{}
+	newest := time.Time{}
+	for _, sighting := range sightings {
+		if sighting.RSSI >= 0 || sighting.RSSI < minRSSI {
+			continue
+		}
+		sighting.BeaconID = normalizeBeaconID(sighting.BeaconID)
+		readings = append(readings, sighting)
+		if sighting.SeenAt.After(newest) {
+			newest = sighting.SeenAt
+		}
+	}
+	sort.SliceStable(readings, func(i, j int) bool { return readings[i].SeenAt.Before(readings[j].SeenAt) })
+
+	smoothed := map[string]float64{}
+	for _, reading := range readings {
+		if newest.Sub(reading.SeenAt) > sightingWindow {
+			continue
+		}
+		average, ok := smoothed[reading.BeaconID]
+		if !ok {
+			smoothed[reading.BeaconID] = float64(reading.RSSI)
+			continue
+		}
+		smoothed[reading.BeaconID] = average + rssiSmoothing*(float64(reading.RSSI)-average)
+	}
+	return smoothed
+}
+
+// rssiPower converts dBm to milliwatts, so a beacon 3dB stronger counts twice as much
+func rssiPower(rssi float64) float64 {
+	return math.Pow(10, rssi/10)
+}
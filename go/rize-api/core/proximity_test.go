@@ -0,0 +1,158 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveTableIgnoresSpikeFromAdjacentTable(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	insertAdjacentTables(t, app)
+	now := time.Now()
+
+	// the guest sits at table 1, the beacon on table 2 spikes on the last reading
+	sightings := append(
+		beaconReadings("ff0000000001", now, -60, -61, -59, -60),
+		beaconReadings("ff0000000002", now, -72, -71, -73, -50)...,
+	)
+
+	// act
+	match, err := app.ResolveTable(sightings)
+
+	// assert
+	assert.NoError(t, err)
+	assert.False(t, match.Ambiguous)
+	assert.Equal(t, "1", match.TableMap.TableName)
+	assert.True(t, match.Confidence >= 0.7)
+	assert.Equal(t, 2, len(match.Candidates))
+}
+
+func TestResolveTableAsksWhenBetweenTables(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	insertAdjacentTables(t, app)
+	now := time.Now()
+
+	sightings := append(
+		beaconReadings("ff0000000001", now, -65, -66, -65),
+		beaconReadings("FF0000000002", now, -66, -65, -65)...,
+	)
+
+	// act
+	match, err := app.ResolveTable(sightings)
+
+	// assert
+	assert.NoError(t, err)
+	assert.True(t, match.Ambiguous)
+	assert.Nil(t, match.TableMap)
+	assert.Equal(t, 2, len(match.Candidates))
+	assert.InDelta(t, 0.5, match.Candidates[0].Confidence, 0.05)
+}
+
+func TestResolveTableUsesBestBeaconAndRecentReadings(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	insertAdjacentTables(t, app)
+	now := time.Now()
+
+	sightings := append(
+		beaconReadings("ff0000000003", now, -62, -63),
+		// table 2 was closest, but before the guest moved
+		core.BeaconSighting{BeaconID: "ff0000000002", RSSI: -40, SeenAt: now.Add(-time.Minute)},
+		// beacons that aren't mapped to a table are ignored
+		core.BeaconSighting{BeaconID: "ff0000000099", RSSI: -30, SeenAt: now},
+	)
+	sightings = append(sightings, beaconReadings("ff0000000001", now, -80, -81)...)
+
+	// act
+	match, err := app.ResolveTable(sightings)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, "1", match.TableMap.TableName)
+	assert.Equal(t, "ff0000000003", match.Candidates[0].TableMap.BeaconID)
+	assert.Equal(t, 1, len(match.Candidates))
+}
+
+func TestResolveTableAsksForSingleWeakSighting(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	insertAdjacentTables(t, app)
+
+	// act
+	match, err := app.ResolveTable(beaconReadings("ff0000000002", time.Now(), -90))
+
+	// assert
+	assert.NoError(t, err)
+	assert.True(t, match.Ambiguous)
+	assert.Nil(t, match.TableMap)
+	assert.Equal(t, "2", match.Candidates[0].TableMap.TableName)
+}
+
+func TestResolveTableWithNoKnownBeacons(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	insertAdjacentTables(t, app)
+
+	// act
+	_, err := app.ResolveTable(beaconReadings("ff0000000099", time.Now(), -60))
+
+	// assert
+	assert.IsType(t, core.TableMapError{}, errors.Cause(err))
+}
+
+func TestResolveTableOnlyUsesOneSite(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	insertAdjacentTables(t, app)
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000004", SiteID: 999, TableName: "1"})
+	now := time.Now()
+
+	// a beacon at the site next door can be heard through the wall
+	sightings := append(
+		beaconReadings("ff0000000001", now, -60, -61, -59),
+		beaconReadings("ff0000000004", now, -70, -71, -70)...,
+	)
+
+	// act
+	match, err := app.ResolveTable(sightings)
+
+	// assert
+	assert.NoError(t, err)
+	assert.False(t, match.Ambiguous)
+	assert.Equal(t, "ff0000000001", match.TableMap.BeaconID)
+	assert.Equal(t, 1, len(match.Candidates))
+	assert.Equal(t, 1.0, match.Confidence)
+}
+
+// helpers
+
+// insertAdjacentTables maps two beacons to table 1 and one to table 2, next to it
+func insertAdjacentTables(t *testing.T, app core.AppContext) {
+	for beaconID, tableName := range map[string]string{"ff0000000001": "1", "ff0000000003": "1", "ff0000000002": "2"} {
+		if err := app.AddTableMap(&core.TableMap{BeaconID: beaconID, SiteID: core.TestSitePosID, TableName: tableName}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// beaconReadings are one reading a second, the last one at now
+func beaconReadings(beaconID string, now time.Time, rssis ...int) []core.BeaconSighting {
+	sightings := []core.BeaconSighting{}
+	for i, rssi := range rssis {
+		seenAt := now.Add(time.Duration(i-len(rssis)+1) * time.Second)
+		sightings = append(sightings, core.BeaconSighting{BeaconID: beaconID, RSSI: rssi, SeenAt: seenAt})
+	}
+	return sightings
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"pjd"
+)
+
+// NotificationTemplateReceipt is the template used to email a guest an itemized receipt
+const NotificationTemplateReceipt = "receipt"
+
+// receiptWidth is the number of characters per line of a plain text receipt
+const receiptWidth = 40
+
+// Cents is an amount of money in cents, printed as dollars
+type Cents int
+
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign = "-"
+		c = -c
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, c/100, c%100)
+}
+
+// Receipt is an itemized record of a paid order
+type Receipt struct {
+	OrderID       DatabaseID    `json:"order_id"`
+	SiteName      string        `json:"site_name"`
+	SiteAddress   string        `json:"site_address"`
+	SitePhone     string        `json:"site_phone"`
+	Date          time.Time     `json:"date"`
+	Lines         []ReceiptLine `json:"lines"`
+	Subtotal      Cents         `json:"subtotal"`
+	Fees          []ReceiptFee  `json:"fees"` // Fees are broken out of the subtotal, before their tax
+	Tax           Cents         `json:"tax"`
+	Tip           Cents         `json:"tip"`
+	Total         Cents         `json:"total"`
+	GiftCard      Cents         `json:"gift_card"` // GiftCard is the part of the total paid by gift card
+	CardLast4     string        `json:"card_last_4"`
+	TransactionID string        `json:"transaction_id"`
+}
+
+// ReceiptLine is a single item on a Receipt
+type ReceiptLine struct {
+	Quantity         int      `json:"quantity"`
+	Name             string   `json:"name"`
+	Total            Cents    `json:"total"`
+	AddedModifiers   []string `json:"added_modifiers"`
+	RemovedModifiers []string `json:"removed_modifiers"`
+}
+
+// ReceiptFee is a site's fee charged on the order of a Receipt
+type ReceiptFee struct {
+	Name   string `json:"name"`
+	Amount Cents  `json:"amount"`
+}
+
+// SavePayment will save a payment for an order, tell anyone following the order about it, credit the customer with
+// loyalty points and, once the order is fully paid, email them a receipt if a customer is attached. Failing to add
+// points or send the receipt is logged and does not fail the payment.
+func (app AppContext) SavePayment(payment *Payment, order *Order) error {
+	if err := app.DB.InsertPayment(payment, order); err != nil {
+		return errors.Wrap(err, "save payment")
+	}
+	app.paymentSaved(payment, order)
+	return nil
+}
+
+// paymentSaved follows up a saved payment the way SavePayment describes
+func (app AppContext) paymentSaved(payment *Payment, order *Order) {
+	app.publishOrderEvent(OrderEventPayment, order)
+
+	// the payment has been taken, so failing to add points shouldn't fail it
+	if err := app.earnLoyaltyPoints(payment, order); err != nil {
+		log.Println(errors.Wrap(err, "save payment"))
+	}
+
+	if app.Mailer == nil {
+		return
+	}
+
+	// a gift card payment can be followed by a card payment for the rest of the order
+	balance, err := app.GetOrderBalance(order.ID)
+	if err != nil {
+		log.Println(errors.Wrap(err, "save payment"))
+		return
+	}
+	if balance > 0 {
+		return
+	}
+
+	if err := app.EmailReceipt(order.ID, ""); err != nil {
+		log.Println(errors.Wrap(err, "save payment"))
+	}
+}
+
+// EmailReceipt will email the receipt for a paid order. If to is empty the receipt is sent to the customer attached
+// to the payment or order, and nothing is sent if there is no customer.
+func (app AppContext) EmailReceipt(orderID DatabaseID, to string) error {
+	if app.Mailer == nil {
+		return errors.New("email receipt: no mailer configured")
+	}
+
+	order, err := app.FindOrderByID(orderID)
+	if err != nil {
+		return errors.Wrap(err, "email receipt")
+	}
+	if order == nil {
+		return errors.Errorf("email receipt: order %d not found", orderID)
+	}
+
+	receipt, payments, err := app.buildReceipt(order)
+	if err != nil {
+		return errors.Wrap(err, "email receipt")
+	}
+
+	if to == "" {
+		customerID := order.CustomerID
+		for _, payment := range payments {
+			if payment.CustomerID.Valid {
+				customerID = payment.CustomerID
+				break
+			}
+		}
+		if !customerID.Valid {
+			return nil
+		}
+
+		customer, err := app.DB.GetCustomer(DatabaseID(customerID.Int64))
+		if err != nil {
+			return errors.Wrap(err, "email receipt")
+		}
+		if customer == nil || customer.Email == "" {
+			return nil
+		}
+		to = customer.Email
+	}
+
+	if err := app.sendEmail(order, to, NotificationTemplateReceipt, receipt); err != nil {
+		return errors.Wrap(err, "email receipt")
+	}
+	return nil
+}
+
+// GetReceipt will return the receipt for a paid order
+func (app AppContext) GetReceipt(orderID DatabaseID) (*Receipt, error) {
+	order, err := app.FindOrderByID(orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get receipt")
+	}
+	if order == nil {
+		return nil, errors.Errorf("get receipt: order %d not found", orderID)
+	}
+
+	receipt, _, err := app.buildReceipt(order)
+	if err != nil {
+		return nil, errors.Wrap(err, "get receipt")
+	}
+	return receipt, nil
+}
+
+// GetReceiptPDF will return the receipt for a paid order as a PDF document
+func (app AppContext) GetReceiptPDF(orderID DatabaseID) ([]byte, error) {
+	receipt, err := app.GetReceipt(orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "get receipt pdf")
+	}
+	return pjd.TextPDF(receipt.TextLines()), nil
+}
+
+func (app AppContext) buildReceipt(order *Order) (*Receipt, []Payment, error) {
+	paymentsByOrderID, err := app.DB.SelectPaymentsByOrderIDs([]DatabaseID{order.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	payments := paymentsByOrderID[order.ID]
+	if len(payments) == 0 {
+		return nil, nil, errors.Errorf("order %d has not been paid", order.ID)
+	}
+
+	site, err := app.DB.GetSite(order.SiteID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if site == nil {
+		return nil, nil, errors.Errorf("site %d not found", order.SiteID)
+	}
+
+	fees, err := app.DB.SelectOrderFees(order.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	receipt := Receipt{
+		OrderID:   order.ID,
+		SiteName:  site.Name,
+		SitePhone: site.PhoneNumber,
+		Subtotal:  Cents(order.Total - order.TotalTax),
+		Tax:       Cents(order.TotalTax),
+		Total:     Cents(order.Total),
+	}
+	for _, payment := range payments {
+		receipt.Date = payment.Date
+		receipt.Tip += Cents(payment.Tip)
+		receipt.Total += Cents(payment.Tip)
+		if payment.Method == PaymentMethodGiftCard {
+			receipt.GiftCard += Cents(payment.Amount)
+			continue
+		}
+		receipt.CardLast4 = payment.CardLast4
+		receipt.TransactionID = payment.TransactionID
+	}
+	for _, fee := range *fees {
+		receipt.Fees = append(receipt.Fees, ReceiptFee{Name: fee.Name, Amount: Cents(fee.Amount - fee.Tax)})
+		receipt.Subtotal -= Cents(fee.Amount - fee.Tax)
+	}
+	if site.Address != nil {
+		receipt.SiteAddress = *site.Address
+	}
+
+	location, err := site.Location()
+	if err != nil {
+		return nil, nil, err
+	}
+	if location != nil {
+		receipt.Date = receipt.Date.In(location)
+	}
+
+	for _, line := range order.Lines {
+		receiptLine := ReceiptLine{Quantity: line.Quantity, Name: line.ProductName, Total: Cents(line.Total)}
+		for _, modifier := range line.AddedModifiers {
+			receiptLine.AddedModifiers = append(receiptLine.AddedModifiers, modifier.Name)
+		}
+		for _, modifier := range line.RemovedModifiers {
+			receiptLine.RemovedModifiers = append(receiptLine.RemovedModifiers, modifier.Name)
+		}
+		receipt.Lines = append(receipt.Lines, receiptLine)
+	}
+
+	return &receipt, payments, nil
+}
+
+// TextLines lays the receipt out as fixed width plain text, used for text emails and PDFs
+func (r Receipt) TextLines() []string {
+	lines := []string{r.SiteName}
+	if r.SiteAddress != "" {
+		lines = append(lines, r.SiteAddress)
+	}
+	if r.SitePhone != "" {
+		lines = append(lines, r.SitePhone)
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Order #%d", r.OrderID),
+		r.Date.Format("Jan 2, 2006 3:04 pm"),
+		strings.Repeat("-", receiptWidth))
+
+	for _, line := range r.Lines {
+		lines = append(lines, receiptRow(fmt.Sprintf("%d x %s", line.Quantity, line.Name), line.Total))
+		for _, name := range line.AddedModifiers {
+			lines = append(lines, "    + "+name)
+		}
+		for _, name := range line.RemovedModifiers {
+			lines = append(lines, "    - no "+name)
+		}
+	}
+
+	lines = append(lines,
+		strings.Repeat("-", receiptWidth),
+		receiptRow("Subtotal", r.Subtotal))
+	for _, fee := range r.Fees {
+		lines = append(lines, receiptRow(fee.Name, fee.Amount))
+	}
+	lines = append(lines,
+		receiptRow("Tax", r.Tax),
+		receiptRow("Tip", r.Tip),
+		receiptRow("Total", r.Total))
+
+	if r.GiftCard > 0 {
+		lines = append(lines, "", receiptRow("Paid by gift card", r.GiftCard))
+	}
+	if r.CardLast4 != "" {
+		lines = append(lines, "", "Paid with card ending in "+r.CardLast4)
+	}
+	if r.TransactionID != "" {
+		lines = append(lines, "Transaction "+r.TransactionID)
+	}
+	return lines
+}
+
+// receiptRow right aligns an amount after a label
+func receiptRow(label string, amount Cents) string {
+	value := amount.String()
+	width := receiptWidth - len(value) - 1
+	if runes := []rune(label); len(runes) > width {
+		label = string(runes[:width])
+	}
+	return fmt.Sprintf("%-*s %s", width, label, value)
+}
@@ -0,0 +1,141 @@
+package core_test
+
+import (
+	"bytes"
+	"database/sql"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+	"notify"
+)
+
+func TestSavePaymentEmailsReceiptToCustomer(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	mailer := &notify.FakeMailer{}
+	app.Mailer = mailer
+
+	customer := createTestCustomer(app)
+	order := insertPickupOrder(t, app)
+	payment := core.Payment{
+		Amount:     1500,
+		Tip:        300,
+		OrderID:    order.ID,
+		Date:       time.Now(),
+		CustomerID: sql.NullInt64{Int64: int64(customer.ID), Valid: true},
+		CardLast4:  "4242",
+	}
+
+	// act
+	err := app.SavePayment(&payment, order)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mailer.Sent))
+	assert.Equal(t, core.NotificationTemplateReceipt, mailer.Sent[0].Template)
+	assert.Equal(t, "bob@smith.com", mailer.Sent[0].To)
+
+	receipt := mailer.Sent[0].Data.(*core.Receipt)
+	assert.Equal(t, "Test Site 1", receipt.SiteName)
+	assert.Equal(t, 2, len(receipt.Lines))
+	assert.Equal(t, []string{"Test Modifier 1"}, receipt.Lines[0].AddedModifiers)
+	assert.Equal(t, []string{"Test Modifier 1"}, receipt.Lines[1].RemovedModifiers)
+	assert.Equal(t, core.Cents(1380), receipt.Subtotal)
+	assert.Equal(t, core.Cents(120), receipt.Tax)
+	assert.Equal(t, core.Cents(1800), receipt.Total)
+	assert.Equal(t, "4242", receipt.CardLast4)
+}
+
+func TestSavePaymentWithoutCustomerDoesNotEmailReceipt(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	mailer := &notify.FakeMailer{}
+	app.Mailer = mailer
+
+	order := insertPickupOrder(t, app)
+
+	// act
+	err := app.SavePayment(&core.Payment{Amount: 1500, OrderID: order.ID, Date: time.Now()}, order)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(mailer.Sent))
+}
+
+func TestEmailReceiptResendsToAddress(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+	mailer := &notify.FakeMailer{}
+	app.Mailer = mailer
+
+	order := insertPickupOrder(t, app)
+	assert.NoError(t, app.SavePayment(&core.Payment{Amount: 1500, OrderID: order.ID, Date: time.Now()}, order))
+
+	// act
+	err := app.EmailReceipt(order.ID, "guest@example.com")
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mailer.Sent))
+	assert.Equal(t, "guest@example.com", mailer.Sent[0].To)
+}
+
+func TestGetReceiptPDFForUnpaidOrderFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+
+	order := insertPickupOrder(t, app)
+
+	// act
+	pdf, err := app.GetReceiptPDF(order.ID)
+
+	// assert
+	assert.Error(t, err)
+	assert.Nil(t, pdf)
+}
+
+func TestGetReceiptPDF(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertMenu(t)
+
+	order := insertPickupOrder(t, app)
+	assert.NoError(t, app.SavePayment(&core.Payment{Amount: 1500, OrderID: order.ID, Date: time.Now(), CardLast4: "4242"}, order))
+
+	// act
+	pdf, err := app.GetReceiptPDF(order.ID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF")))
+	assert.Contains(t, string(pdf), "(Paid with card ending in 4242) Tj")
+}
+
+func TestReceiptTextLinesTruncatesLongNames(t *testing.T) {
+	// arrange
+	receipt := core.Receipt{
+		SiteName: "Test Site 1",
+		Lines:    []core.ReceiptLine{{Quantity: 1, Name: "Crème brûlée with crème fraîche and crêpes", Total: 1250}},
+	}
+
+	// act
+	lines := receipt.TextLines()
+
+	// assert
+	line := lines[5]
+	assert.True(t, utf8.ValidString(line))
+	assert.Equal(t, 40, utf8.RuneCountInString(line))
+	assert.Equal(t, "1 x Crème brûlée with crème fraîc $12.50", line)
+}
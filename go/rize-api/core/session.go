@@ -0,0 +1,260 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// Access tokens are not checked against the database, so a revoked session keeps working until its access token
+	// expires
+	accessTokenDuration  = 15 * time.Minute
+	refreshTokenDuration = 60 * 24 * time.Hour
+)
+
+// Session is a customer signed in on one device. A session lasts as long as its refresh token keeps being rotated.
+type Session struct {
+	ID         DatabaseID `json:"id"`
+	CustomerID DatabaseID `json:"-"`
+	Device     string     `json:"device"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// RefreshToken is a single use token that is exchanged for new session tokens. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID        DatabaseID
+	SessionID DatabaseID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// SessionTokens are issued when a session is started or refreshed
+type SessionTokens struct {
+	SessionID          DatabaseID `json:"session_id"`
+	AccessToken        string     `json:"access_token"`
+	AccessTokenExpiry  time.Time  `json:"access_token_expiry"`
+	RefreshToken       string     `json:"refresh_token"`
+	RefreshTokenExpiry time.Time  `json:"refresh_token_expiry"`
+}
+
+// AccessClaims are the contents of a verified access token
+type AccessClaims struct {
+	CustomerID DatabaseID `json:"sub"`
+	SessionID  DatabaseID `json:"sid"`
+	Expiry     int64      `json:"exp"`
+}
+
+// StartSession will create a session for a customer on a device and issue its first tokens
+func (app AppContext) StartSession(customerID DatabaseID, device string) (*SessionTokens, error) {
+	if len(app.TokenSigningKey) == 0 {
+		return nil, errors.New("start session: no token signing key configured")
+	}
+
+	now := time.Now()
+	session := Session{
+		CustomerID: customerID,
+		Device:     device,
+		CreatedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenDuration),
+	}
+	if err := app.DB.InsertSession(&session); err != nil {
+		return nil, errors.Wrap(err, "start session")
+	}
+
+	tokens, err := app.issueSessionTokens(&session, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "start session")
+	}
+	return tokens, nil
+}
+
+// RefreshSession will exchange a refresh token for new session tokens. Refresh tokens can only be used once; using one
+// again means it has been stolen, so the whole session is revoked.
+func (app AppContext) RefreshSession(refreshToken string) (*SessionTokens, error) {
+	now := time.Now()
+
+	token, err := app.DB.GetRefreshTokenByHash(hashRefreshToken(refreshToken))
+	if err != nil {
+		return nil, errors.Wrap(err, "refresh session")
+	}
+	if token == nil || now.After(token.ExpiresAt) {
+		return nil, AuthError{Reason: "invalid refresh token"}
+	}
+
+	session, err := app.DB.GetSession(token.SessionID)
+	if err != nil {
+		return nil, errors.Wrap(err, "refresh session")
+	}
+	if session == nil || session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return nil, AuthError{Reason: "this session has ended"}
+	}
+
+	used, err := app.DB.UseRefreshToken(token.ID, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "refresh session")
+	}
+	if !used {
+		log.Printf("refresh session: refresh token reused for session %d, revoking it", session.ID)
+		if err := app.DB.RevokeSession(session.ID, now); err != nil {
+			return nil, errors.Wrap(err, "refresh session")
+		}
+		return nil, AuthError{Reason: "this session has ended"}
+	}
+
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(refreshTokenDuration)
+	if err := app.DB.UpdateSession(session); err != nil {
+		return nil, errors.Wrap(err, "refresh session")
+	}
+
+	tokens, err := app.issueSessionTokens(session, now)
+	if err != nil {
+		return nil, errors.Wrap(err, "refresh session")
+	}
+	return tokens, nil
+}
+
+// VerifyAccessToken will check an access token's signature and expiry and return its claims
+func (app AppContext) VerifyAccessToken(accessToken string) (*AccessClaims, error) {
+	if len(app.TokenSigningKey) == 0 {
+		return nil, errors.New("verify access token: no token signing key configured")
+	}
+
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 2 {
+		return nil, AuthError{Reason: "invalid access token"}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, app.signAccessToken(parts[0])) {
+		return nil, AuthError{Reason: "invalid access token"}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, AuthError{Reason: "invalid access token"}
+	}
+
+	claims := AccessClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, AuthError{Reason: "invalid access token"}
+	}
+	if time.Now().Unix() >= claims.Expiry {
+		return nil, AuthError{Reason: "access token has expired"}
+	}
+	return &claims, nil
+}
+
+// ListSessions will return the sessions a customer is signed in to, most recently used first
+func (app AppContext) ListSessions(customerID DatabaseID) (*[]Session, error) {
+	sessions, err := app.DB.SelectActiveSessionsByCustomerID(customerID, time.Now())
+	if err != nil {
+		return nil, errors.Wrap(err, "list sessions")
+	}
+	return sessions, nil
+}
+
+// RevokeSession will sign a customer out of one of their sessions
+func (app AppContext) RevokeSession(customerID, sessionID DatabaseID) error {
+	session, err := app.DB.GetSession(sessionID)
+	if err != nil {
+		return errors.Wrap(err, "revoke session")
+	}
+	if session == nil || session.CustomerID != customerID {
+		return errors.Errorf("revoke session: session %d not found", sessionID)
+	}
+
+	if err := app.DB.RevokeSession(sessionID, time.Now()); err != nil {
+		return errors.Wrap(err, "revoke session")
+	}
+	return nil
+}
+
+// PurgeExpiredTokens will delete expired tokens and sessions, and sessions that have been revoked
+func (app AppContext) PurgeExpiredTokens() error {
+	if err := app.DB.DeleteExpiredTokens(time.Now()); err != nil {
+		return errors.Wrap(err, "purge expired tokens")
+	}
+	return nil
+}
+
+// RunTokenPurge will purge expired tokens every interval until stop is closed. It is meant to be run in a goroutine.
+func (app AppContext) RunTokenPurge(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := app.PurgeExpiredTokens(); err != nil {
+				log.Println(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (app AppContext) issueSessionTokens(session *Session, now time.Time) (*SessionTokens, error) {
+	if len(app.TokenSigningKey) == 0 {
+		return nil, errors.New("no token signing key configured")
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	refreshToken := base64.RawURLEncoding.EncodeToString(random)
+
+	token := RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hashRefreshToken(refreshToken),
+		CreatedAt: now,
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := app.DB.InsertRefreshToken(&token); err != nil {
+		return nil, err
+	}
+
+	accessTokenExpiry := now.Add(accessTokenDuration)
+	payload, err := json.Marshal(AccessClaims{CustomerID: session.CustomerID, SessionID: session.ID, Expiry: accessTokenExpiry.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+	accessToken := encodedPayload + "." + base64.RawURLEncoding.EncodeToString(app.signAccessToken(encodedPayload))
+
+	return &SessionTokens{
+		SessionID:          session.ID,
+		AccessToken:        accessToken,
+		AccessTokenExpiry:  accessTokenExpiry,
+		RefreshToken:       refreshToken,
+		RefreshTokenExpiry: token.ExpiresAt,
+	}, nil
+}
+
+func (app AppContext) signAccessToken(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, app.TokenSigningKey)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
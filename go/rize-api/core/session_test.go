@@ -0,0 +1,121 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshSessionRotatesRefreshToken(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	tokens, _ := app.StartSession(customer.ID, "iPhone")
+
+	// act
+	newTokens, err := app.RefreshSession(tokens.RefreshToken)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, tokens.SessionID, newTokens.SessionID)
+	assert.NotEqual(t, tokens.RefreshToken, newTokens.RefreshToken)
+
+	claims, err := app.VerifyAccessToken(newTokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+	assert.Equal(t, tokens.SessionID, claims.SessionID)
+}
+
+func TestReusedRefreshTokenRevokesSession(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	tokens, _ := app.StartSession(customer.ID, "iPhone")
+	newTokens, _ := app.RefreshSession(tokens.RefreshToken)
+
+	// act
+	_, err := app.RefreshSession(tokens.RefreshToken)
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+
+	// the legitimate holder of the rotated token is signed out too
+	_, err = app.RefreshSession(newTokens.RefreshToken)
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestVerifyAccessTokenRejectsTamperedToken(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	tokens, _ := app.StartSession(customer.ID, "iPhone")
+
+	otherApp := app
+	otherApp.TokenSigningKey = []byte("other-signing-key")
+
+	// act
+	_, err := otherApp.VerifyAccessToken(tokens.AccessToken)
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestListAndRevokeSessions(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	phoneTokens, _ := app.StartSession(customer.ID, "iPhone")
+	app.StartSession(customer.ID, "iPad")
+
+	// act
+	err := app.RevokeSession(customer.ID, phoneTokens.SessionID)
+
+	// assert
+	assert.NoError(t, err)
+	sessions, _ := app.ListSessions(customer.ID)
+	assert.Equal(t, 1, len(*sessions))
+	assert.Equal(t, "iPad", (*sessions)[0].Device)
+}
+
+func TestRevokeSessionOfOtherCustomerFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	tokens, _ := app.StartSession(customer.ID, "iPhone")
+
+	// act
+	err := app.RevokeSession(customer.ID+1, tokens.SessionID)
+
+	// assert
+	assert.Error(t, err)
+	sessions, _ := app.ListSessions(customer.ID)
+	assert.Equal(t, 1, len(*sessions))
+}
+
+func TestPurgeExpiredTokens(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	customer := createTestCustomer(app)
+	expiredToken, _ := app.CreateTokenForCustomerWithID(core.TokenServiceRize, core.TokenNameForgotPassword, customer.ID, time.Now().Add(-time.Minute))
+	tokens, _ := app.StartSession(customer.ID, "iPhone")
+	app.RevokeSession(customer.ID, tokens.SessionID)
+
+	// act
+	err := app.PurgeExpiredTokens()
+
+	// assert
+	assert.NoError(t, err)
+	token, _ := app.DB.GetToken(expiredToken.Token)
+	assert.Nil(t, token)
+	session, _ := app.DB.GetSession(tokens.SessionID)
+	assert.Nil(t, session)
+}
@@ -18,6 +18,8 @@ type Site struct {
 	UpdatedAt   time.Time  `json:"updated_at"`
 	Address     *string    `json:"address"`
 	PhoneNumber string     `json:"phone_number"`
+	TimeZone    string     `json:"time_zone"` // TimeZone is an IANA zone name, ex: America/New_York
+	TaxRate     float64    `json:"tax_rate"`  // TaxRate is the percent tax charged on taxable fees
 }
 
 // UpdateAllMenus will update the menu for each Rize site and store it in the database
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// legacyExternalIDService is the only service whose accounts were stored in Customer.ExternalID before customers
+// could have more than one connection
+const legacyExternalIDService = "facebook"
+
+// loginNonceExpiry is how long an app has to finish signing in with a nonce it was issued
+const loginNonceExpiry = 10 * time.Minute
+
+// IdentityProvider verifies the ID tokens issued when a customer signs in with an external account
+type IdentityProvider interface {
+	VerifyIDToken(idToken, nonce string) (*Connection, error)
+}
+
+// CustomerConnection links an external account to a customer. A customer can have one connection per service.
+type CustomerConnection struct {
+	ID         DatabaseID `json:"-"`
+	CustomerID DatabaseID `json:"-"`
+	Service    string     `json:"service"`
+	ExternalID string     `json:"-"`
+	Email      string     `json:"email"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IssueLoginNonce will return a nonce for an app to put in the ID token it signs in with. Each nonce can only be
+// used for one SocialLogin or LinkConnection, so a token can't be replayed.
+func (app AppContext) IssueLoginNonce() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", errors.Wrap(err, "issue login nonce")
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(random)
+
+	if err := app.DB.InsertLoginNonce(nonce, time.Now().Add(loginNonceExpiry)); err != nil {
+		return "", errors.Wrap(err, "issue login nonce")
+	}
+	return nonce, nil
+}
+
+// SocialLogin will sign a customer in with an ID token from an external service, linking the account to the existing
+// customer with the same verified email or creating a new customer, and start a session on device
+func (app AppContext) SocialLogin(service, idToken, nonce, device string) (*SessionTokens, error) {
+	connection, err := app.verifyIDToken(service, idToken, nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "social login")
+	}
+
+	customer, err := app.customerForConnection(connection)
+	if err != nil {
+		return nil, errors.Wrap(err, "social login")
+	}
+
+	tokens, err := app.StartSession(customer.ID, device)
+	if err != nil {
+		return nil, errors.Wrap(err, "social login")
+	}
+	return tokens, nil
+}
+
+// LinkConnection will add an external account to a signed in customer
+func (app AppContext) LinkConnection(customerID DatabaseID, service, idToken, nonce string) error {
+	connection, err := app.verifyIDToken(service, idToken, nonce)
+	if err != nil {
+		return errors.Wrap(err, "link connection")
+	}
+
+	existing, err := app.DB.GetCustomerConnection(connection.Service, connection.ID)
+	if err != nil {
+		return errors.Wrap(err, "link connection")
+	}
+	if existing != nil {
+		if existing.CustomerID == customerID {
+			return nil
+		}
+		return AuthError{Reason: "this account is already linked to another customer"}
+	}
+
+	connections, err := app.DB.SelectCustomerConnections(customerID)
+	if err != nil {
+		return errors.Wrap(err, "link connection")
+	}
+	for _, c := range *connections {
+		if c.Service == connection.Service {
+			return AuthError{Reason: "another " + connection.Service + " account is already linked"}
+		}
+	}
+
+	if err := app.linkConnection(customerID, connection); err != nil {
+		return errors.Wrap(err, "link connection")
+	}
+	return nil
+}
+
+// UnlinkConnection will remove an external account from a customer, as long as they can still sign in another way
+func (app AppContext) UnlinkConnection(customerID DatabaseID, service string) error {
+	customer, err := app.DB.GetCustomer(customerID)
+	if err != nil {
+		return errors.Wrap(err, "unlink connection")
+	}
+	if customer == nil {
+		return errors.Errorf("unlink connection: customer %d not found", customerID)
+	}
+
+	connections, err := app.DB.SelectCustomerConnections(customerID)
+	if err != nil {
+		return errors.Wrap(err, "unlink connection")
+	}
+
+	otherConnections := 0
+	linked := false
+	for _, c := range *connections {
+		if c.Service == service {
+			linked = true
+		} else {
+			otherConnections++
+		}
+	}
+	if !linked {
+		return nil
+	}
+	if customer.Password == "" && otherConnections == 0 {
+		return AuthError{Reason: "set a password or link another account before unlinking " + service}
+	}
+
+	if err := app.DB.DeleteCustomerConnection(customerID, service); err != nil {
+		return errors.Wrap(err, "unlink connection")
+	}
+	return nil
+}
+
+// ListConnections will return the external accounts linked to a customer
+func (app AppContext) ListConnections(customerID DatabaseID) (*[]CustomerConnection, error) {
+	connections, err := app.DB.SelectCustomerConnections(customerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "list connections")
+	}
+	return connections, nil
+}
+
+func (app AppContext) verifyIDToken(service, idToken, nonce string) (*Connection, error) {
+	provider, ok := app.IdentityProviders[service]
+	if !ok {
+		return nil, AuthError{Reason: "signing in with " + service + " is not supported"}
+	}
+	if nonce == "" {
+		return nil, AuthError{Reason: "a nonce is required to sign in with " + service}
+	}
+
+	used, err := app.DB.UseLoginNonce(nonce, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, AuthError{Reason: "the sign in nonce has expired or was already used"}
+	}
+	return provider.VerifyIDToken(idToken, nonce)
+}
+
+// customerForConnection finds the customer an external account belongs to, linking or creating one if needed
+func (app AppContext) customerForConnection(connection *Connection) (*Customer, error) {
+	existing, err := app.DB.GetCustomerConnection(connection.Service, connection.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return app.DB.GetCustomer(existing.CustomerID)
+	}
+
+	var customer *Customer
+	if connection.Service == legacyExternalIDService {
+		customer, err = app.DB.GetCustomerByExternalID(connection.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only a verified email proves the account belongs to the same person
+	if customer == nil && connection.Email != "" && connection.EmailVerified {
+		customer, err = app.DB.GetCustomerByEmail(connection.Email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if customer == nil {
+		firstName, lastName := splitName(connection.Name)
+		customer = &Customer{FirstName: firstName, LastName: lastName, Email: connection.Email}
+		if err := app.AddCustomer(customer); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := app.linkConnection(customer.ID, connection); err != nil {
+		return nil, err
+	}
+	return customer, nil
+}
+
+func (app AppContext) linkConnection(customerID DatabaseID, connection *Connection) error {
+	return app.DB.InsertCustomerConnection(&CustomerConnection{
+		CustomerID: customerID,
+		Service:    connection.Service,
+		ExternalID: connection.ID,
+		Email:      connection.Email,
+		CreatedAt:  time.Now(),
+	})
+}
+
+func splitName(name string) (firstName, lastName string) {
+	parts := strings.SplitN(strings.TrimSpace(name), " ", 2)
+	if len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return parts[0], ""
+}
@@ -0,0 +1,231 @@
+package core_test
+
+import (
+	"testing"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"oidc"
+)
+
+func TestSocialLoginCreatesCustomer(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "jane@doe.com", EmailVerified: true, Name: "Jane Doe"})
+
+	// act
+	tokens, err := app.SocialLogin("google", idToken, nonce, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	customer, _ := app.DB.GetCustomerByEmail("jane@doe.com")
+	assert.Equal(t, "Jane", customer.FirstName)
+	assert.Equal(t, "Doe", customer.LastName)
+
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+
+	// signing in again uses the same customer
+	idToken, nonce = issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "jane@doe.com", EmailVerified: true})
+	app.SocialLogin("google", idToken, nonce, "iPhone")
+	connections, _ := app.ListConnections(customer.ID)
+	assert.Equal(t, 1, len(*connections))
+}
+
+func TestSocialLoginLinksCustomerWithVerifiedEmail(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+	customer := createTestCustomer(app)
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "bob@smith.com", EmailVerified: true})
+
+	// act
+	tokens, err := app.SocialLogin("google", idToken, nonce, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+}
+
+func TestSocialLoginDoesNotLinkUnverifiedEmail(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+	customer := createTestCustomer(app)
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "bob@smith.com"})
+
+	// act
+	tokens, err := app.SocialLogin("google", idToken, nonce, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+	assert.NotEqual(t, customer.ID, claims.CustomerID)
+}
+
+func TestSocialLoginFindsLegacyFacebookCustomer(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+	customer := createTestCustomer(app)
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: customer.ExternalID})
+
+	// act
+	tokens, err := app.SocialLogin("facebook", idToken, nonce, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+}
+
+func TestLinkMultipleConnections(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+	customer := createTestCustomer(app)
+
+	googleToken, googleNonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1"})
+	appleToken, appleNonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "a-1"})
+
+	// act
+	googleErr := app.LinkConnection(customer.ID, "google", googleToken, googleNonce)
+	appleErr := app.LinkConnection(customer.ID, "apple", appleToken, appleNonce)
+
+	// assert
+	assert.NoError(t, googleErr)
+	assert.NoError(t, appleErr)
+	connections, _ := app.ListConnections(customer.ID)
+	assert.Equal(t, 2, len(*connections))
+
+	// the google account signs in to the same customer
+	googleToken, googleNonce = issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1"})
+	tokens, _ := app.SocialLogin("google", googleToken, googleNonce, "iPhone")
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+	assert.Equal(t, customer.ID, claims.CustomerID)
+}
+
+func TestLinkConnectionOwnedByAnotherCustomerFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+	customer := createTestCustomer(app)
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "jane@doe.com", EmailVerified: true})
+	app.SocialLogin("google", idToken, nonce, "iPhone")
+	idToken, nonce = issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "jane@doe.com", EmailVerified: true})
+
+	// act
+	err := app.LinkConnection(customer.ID, "google", idToken, nonce)
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestSocialLoginCannotReplayNonce(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "jane@doe.com", EmailVerified: true})
+
+	// act
+	_, err := app.SocialLogin("google", idToken, nonce, "iPhone")
+	_, replayErr := app.SocialLogin("google", idToken, nonce, "iPhone")
+
+	// assert
+	assert.NoError(t, err)
+	assert.IsType(t, core.AuthError{}, errors.Cause(replayErr))
+}
+
+func TestSocialLoginWithoutIssuedNonceFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+
+	idToken := issueIDTokenWithNonce(t, fake, oidc.IDTokenClaims{Subject: "g-1", Nonce: "made-up"})
+
+	// act
+	_, err := app.SocialLogin("google", idToken, "made-up", "iPhone")
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestUnlinkOnlyWayToSignInFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	fake := useFakeIdentityProvider(t, &app)
+	defer fake.Close()
+
+	idToken, nonce := issueIDToken(t, app, fake, oidc.IDTokenClaims{Subject: "g-1", Email: "jane@doe.com", EmailVerified: true})
+	tokens, _ := app.SocialLogin("google", idToken, nonce, "iPhone")
+	claims, _ := app.VerifyAccessToken(tokens.AccessToken)
+
+	// act
+	err := app.UnlinkConnection(claims.CustomerID, "google")
+
+	// assert
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+// helpers
+
+const testClientID = "test-client-id"
+
+// useFakeIdentityProvider configures google, apple and facebook logins to verify tokens from a local fake provider
+func useFakeIdentityProvider(t *testing.T, app *core.AppContext) *oidc.FakeIdentityProvider {
+	fake, err := oidc.NewFakeIdentityProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.IdentityProviders = map[string]core.IdentityProvider{}
+	for _, service := range []string{"google", "apple", "facebook"} {
+		app.IdentityProviders[service] = fake.Provider(service, testClientID)
+	}
+	return fake
+}
+
+// issueIDToken issues a login nonce and an ID token for it
+func issueIDToken(t *testing.T, app core.AppContext, fake *oidc.FakeIdentityProvider, claims oidc.IDTokenClaims) (string, string) {
+	nonce, err := app.IssueLoginNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims.Nonce = nonce
+	return issueIDTokenWithNonce(t, fake, claims), nonce
+}
+
+func issueIDTokenWithNonce(t *testing.T, fake *oidc.FakeIdentityProvider, claims oidc.IDTokenClaims) string {
+	claims.Audience = testClientID
+	idToken, err := fake.IssueIDToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return idToken
+}
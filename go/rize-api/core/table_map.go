@@ -1,7 +1,355 @@
 package core
 
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
 type TableMap struct {
 	BeaconID  string   `json:"beacon_id"`
 	SiteID    KountaID `json:"site_id"`
 	TableName string   `json:"table_id"` //todo: coordinate this rename with client apps
 }
+
+// FloorSection is an area of a site, like the patio or the bar, that tables are grouped into
+type FloorSection struct {
+	ID     DatabaseID   `json:"id"`
+	SiteID KountaID     `json:"site_id"`
+	Name   string       `json:"name"`
+	Tables []FloorTable `json:"tables" db:"-"`
+}
+
+// FloorTable is a table on a site's floor plan. Beacons are mapped to it by its name.
+type FloorTable struct {
+	ID        DatabaseID    `json:"-"`
+	SiteID    KountaID      `json:"site_id"`
+	SectionID sql.NullInt64 `json:"-"`
+	Name      string        `json:"name"`
+	Seats     int           `json:"seats"`
+	BeaconIDs []string      `json:"beacon_ids" db:"-"`
+}
+
+// FloorPlan is a site's tables by section. Tables that only exist in table mappings are Unsectioned with no seats.
+type FloorPlan struct {
+	SiteID      KountaID       `json:"site_id"`
+	Sections    []FloorSection `json:"sections"`
+	Unsectioned []FloorTable   `json:"unsectioned"`
+}
+
+// TableMapImport is what importing a CSV of table mappings changed
+type TableMapImport struct {
+	Created int `json:"created"`
+	Updated int `json:"updated"`
+}
+
+// TableMapError is returned when a table mapping or floor plan change is invalid
+type TableMapError struct {
+	Reason string
+}
+
+func (e TableMapError) Error() string {
+	return e.Reason
+}
+
+// tableMapCSVHeader is the first row of imported and exported CSVs
+var tableMapCSVHeader = []string{"beacon_id", "table_name"}
+
+// AddTableMap will map a beacon to a table. A beacon can only be mapped to one table.
+func (app AppContext) AddTableMap(tableMap *TableMap) error {
+	tableMap.BeaconID = normalizeBeaconID(tableMap.BeaconID)
+	if err := validateTableMap(*tableMap); err != nil {
+		return errors.Wrap(err, "add table map")
+	}
+
+	existing, err := app.DB.GetTableMapByBeaconID(tableMap.BeaconID)
+	if err != nil {
+		return errors.Wrap(err, "add table map")
+	}
+	if existing != nil {
+		return errors.Wrap(duplicateBeaconError(*existing), "add table map")
+	}
+
+	if err := app.DB.InsertTableMap(tableMap); err != nil {
+		// the beacon was mapped after it was checked above
+		if isUniqueViolation(err) {
+			if existing, getErr := app.DB.GetTableMapByBeaconID(tableMap.BeaconID); getErr == nil && existing != nil {
+				return errors.Wrap(duplicateBeaconError(*existing), "add table map")
+			}
+		}
+		return errors.Wrap(err, "add table map")
+	}
+	return nil
+}
+
+// UpdateTableMap will move a beacon to another table or site
+func (app AppContext) UpdateTableMap(beaconID string, tableMap *TableMap) error {
+	beaconID = normalizeBeaconID(beaconID)
+	tableMap.BeaconID = beaconID
+	if err := validateTableMap(*tableMap); err != nil {
+		return errors.Wrap(err, "update table map")
+	}
+
+	existing, err := app.DB.GetTableMapByBeaconID(beaconID)
+	if err != nil {
+		return errors.Wrap(err, "update table map")
+	}
+	if existing == nil {
+		return errors.Wrap(TableMapError{Reason: "beacon " + beaconID + " is not mapped to a table"}, "update table map")
+	}
+
+	if err := app.DB.UpdateTableMap(tableMap); err != nil {
+		return errors.Wrap(err, "update table map")
+	}
+	return nil
+}
+
+// DeleteTableMap will unmap a beacon from its table
+func (app AppContext) DeleteTableMap(beaconID string) error {
+	if err := app.DB.DeleteTableMap(normalizeBeaconID(beaconID)); err != nil {
+		return errors.Wrap(err, "delete table map")
+	}
+	return nil
+}
+
+// ListTableMaps will return a site's table mappings ordered by table name
+func (app AppContext) ListTableMaps(siteID KountaID) (*[]TableMap, error) {
+	tableMaps, err := app.DB.SelectTableMapsBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "list table maps for site %d", siteID)
+	}
+	return tableMaps, nil
+}
+
+// FindDuplicateTableMaps will return every mapping of beacons that are mapped more than once, which can only happen
+// with mappings made before duplicates were rejected. They have to be fixed before the unique index on beacon IDs can
+// be created.
+func (app AppContext) FindDuplicateTableMaps() (*[]TableMap, error) {
+	tableMaps, err := app.DB.SelectDuplicateTableMaps()
+	if err != nil {
+		return nil, errors.Wrap(err, "find duplicate table maps")
+	}
+	return tableMaps, nil
+}
+
+// ImportTableMaps will map beacons to tables at a site from a CSV with beacon_id and table_name columns. Beacons
+// already mapped at the site are moved to their new table. Nothing is imported if any row is invalid, lists a beacon
+// twice or lists a beacon mapped at another site, and every problem is reported in the error.
+func (app AppContext) ImportTableMaps(siteID KountaID, r io.Reader) (*TableMapImport, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, errors.Wrap(TableMapError{Reason: "not a valid CSV: " + err.Error()}, "import table maps")
+	}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), tableMapCSVHeader[0]) {
+		rows = rows[1:]
+	}
+
+	problems := []string{}
+	tableMaps := []TableMap{}
+	lines := map[string]int{}
+	result := TableMapImport{}
+	for i, row := range rows {
+		// line numbers include the header
+		line := i + 2
+		if len(row) < 2 {
+			problems = append(problems, fmt.Sprintf("line %d: expected a beacon_id and a table_name", line))
+			continue
+		}
+
+		tableMap := TableMap{BeaconID: normalizeBeaconID(row[0]), SiteID: siteID, TableName: strings.TrimSpace(row[1])}
+		if err := validateTableMap(tableMap); err != nil {
+			problems = append(problems, fmt.Sprintf("line %d: %s", line, err))
+			continue
+		}
+		if first, ok := lines[tableMap.BeaconID]; ok {
+			problems = append(problems, fmt.Sprintf("line %d: beacon %s is also on line %d", line, tableMap.BeaconID, first))
+			continue
+		}
+		lines[tableMap.BeaconID] = line
+
+		existing, err := app.DB.GetTableMapByBeaconID(tableMap.BeaconID)
+		if err != nil {
+			return nil, errors.Wrap(err, "import table maps")
+		}
+		if existing != nil && existing.SiteID != siteID {
+			problems = append(problems, fmt.Sprintf("line %d: %s", line, duplicateBeaconError(*existing)))
+			continue
+		}
+		if existing == nil {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		tableMaps = append(tableMaps, tableMap)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.Wrap(TableMapError{Reason: strings.Join(problems, "; ")}, "import table maps")
+	}
+	if err := app.DB.UpsertTableMaps(tableMaps); err != nil {
+		return nil, errors.Wrap(err, "import table maps")
+	}
+	return &result, nil
+}
+
+// ExportTableMaps will write a site's table mappings as a CSV that ImportTableMaps can read back
+func (app AppContext) ExportTableMaps(siteID KountaID, w io.Writer) error {
+	tableMaps, err := app.DB.SelectTableMapsBySiteID(siteID)
+	if err != nil {
+		return errors.Wrapf(err, "export table maps for site %d", siteID)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write(tableMapCSVHeader)
+	for _, tableMap := range *tableMaps {
+		writer.Write([]string{tableMap.BeaconID, tableMap.TableName})
+	}
+	writer.Flush()
+	return errors.Wrapf(writer.Error(), "export table maps for site %d", siteID)
+}
+
+// AddFloorSection will add a named section to a site's floor plan
+func (app AppContext) AddFloorSection(siteID KountaID, name string) (*FloorSection, error) {
+	section := FloorSection{SiteID: siteID, Name: strings.TrimSpace(name)}
+	if section.Name == "" {
+		return nil, errors.Wrap(TableMapError{Reason: "a section needs a name"}, "add floor section")
+	}
+
+	if err := app.DB.InsertFloorSection(&section); err != nil {
+		return nil, errors.Wrap(err, "add floor section")
+	}
+	return &section, nil
+}
+
+// SaveFloorTable will add a table to a site's floor plan, or update the section and seats of the table with the same
+// name
+func (app AppContext) SaveFloorTable(table *FloorTable) error {
+	table.Name = strings.TrimSpace(table.Name)
+	if table.Name == "" {
+		return errors.Wrap(TableMapError{Reason: "a table needs a name"}, "save floor table")
+	}
+	if table.Seats < 0 {
+		return errors.Wrap(TableMapError{Reason: "a table can't have negative seats"}, "save floor table")
+	}
+
+	if table.SectionID.Valid {
+		sections, err := app.DB.SelectFloorSectionsBySiteID(table.SiteID)
+		if err != nil {
+			return errors.Wrap(err, "save floor table")
+		}
+		if findFloorSection(*sections, DatabaseID(table.SectionID.Int64)) == nil {
+			return errors.Wrap(TableMapError{Reason: "section not found at this site"}, "save floor table")
+		}
+	}
+
+	if err := app.DB.UpsertFloorTable(table); err != nil {
+		return errors.Wrap(err, "save floor table")
+	}
+	return nil
+}
+
+// DeleteFloorTable will remove a table from a site's floor plan once no beacons are mapped to it
+func (app AppContext) DeleteFloorTable(siteID KountaID, name string) error {
+	tableMaps, err := app.DB.SelectTableMapsBySiteID(siteID)
+	if err != nil {
+		return errors.Wrap(err, "delete floor table")
+	}
+	for _, tableMap := range *tableMaps {
+		if tableMap.TableName == name {
+			return errors.Wrap(TableMapError{Reason: "move or delete the beacons on table " + name + " first"}, "delete floor table")
+		}
+	}
+
+	if err := app.DB.DeleteFloorTable(siteID, name); err != nil {
+		return errors.Wrap(err, "delete floor table")
+	}
+	return nil
+}
+
+// GetFloorPlan will return a site's sections and tables with the beacons mapped to each table
+func (app AppContext) GetFloorPlan(siteID KountaID) (*FloorPlan, error) {
+	sections, err := app.DB.SelectFloorSectionsBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get floor plan for site %d", siteID)
+	}
+	tables, err := app.DB.SelectFloorTablesBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get floor plan for site %d", siteID)
+	}
+	tableMaps, err := app.DB.SelectTableMapsBySiteID(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get floor plan for site %d", siteID)
+	}
+
+	beaconIDs := map[string][]string{}
+	for _, tableMap := range *tableMaps {
+		beaconIDs[tableMap.TableName] = append(beaconIDs[tableMap.TableName], tableMap.BeaconID)
+	}
+
+	plan := FloorPlan{SiteID: siteID, Sections: *sections, Unsectioned: []FloorTable{}}
+	for i := range plan.Sections {
+		plan.Sections[i].Tables = []FloorTable{}
+	}
+
+	for _, table := range *tables {
+		table.BeaconIDs = beaconIDs[table.Name]
+		delete(beaconIDs, table.Name)
+
+		section := findFloorSection(plan.Sections, DatabaseID(table.SectionID.Int64))
+		if !table.SectionID.Valid || section == nil {
+			plan.Unsectioned = append(plan.Unsectioned, table)
+			continue
+		}
+		section.Tables = append(section.Tables, table)
+	}
+
+	// tables that beacons are mapped to but were never added to the floor plan
+	names := []string{}
+	for name := range beaconIDs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		plan.Unsectioned = append(plan.Unsectioned, FloorTable{SiteID: siteID, Name: name, BeaconIDs: beaconIDs[name]})
+	}
+	return &plan, nil
+}
+
+func validateTableMap(tableMap TableMap) error {
+	if tableMap.BeaconID == "" {
+		return TableMapError{Reason: "a table map needs a beacon ID"}
+	}
+	if strings.Trim(tableMap.BeaconID, "0123456789abcdef") != "" {
+		return TableMapError{Reason: "beacon ID " + tableMap.BeaconID + " is not hexadecimal"}
+	}
+	if tableMap.SiteID == 0 {
+		return TableMapError{Reason: "a table map needs a site"}
+	}
+	if strings.TrimSpace(tableMap.TableName) == "" {
+		return TableMapError{Reason: "a table map needs a table name"}
+	}
+	return nil
+}
+
+func duplicateBeaconError(existing TableMap) TableMapError {
+	return TableMapError{Reason: fmt.Sprintf("beacon %s is already mapped to table %s at site %d", existing.BeaconID, existing.TableName, existing.SiteID)}
+}
+
+// normalizeBeaconID matches how beacon IDs are stored, ex: FF00-0000... becomes ff000000...
+func normalizeBeaconID(beaconID string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(beaconID), "-", "", -1))
+}
+
+func findFloorSection(sections []FloorSection, id DatabaseID) *FloorSection {
+	for i := range sections {
+		if sections[i].ID == id {
+			return &sections[i]
+		}
+	}
+	return nil
+}
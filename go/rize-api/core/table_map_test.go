@@ -0,0 +1,119 @@
+package core_test
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddTableMapRejectsDuplicateBeacon(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.AddTableMap(&core.TableMap{BeaconID: "FF00-0000-0001", SiteID: core.TestSitePosID, TableName: "1"})
+
+	// act
+	err := app.AddTableMap(&core.TableMap{BeaconID: "ff0000000001", SiteID: 999, TableName: "2"})
+
+	// assert
+	assert.IsType(t, core.TableMapError{}, errors.Cause(err))
+	assert.Contains(t, err.Error(), "already mapped to table 1 at site 123")
+
+	tableMaps, _ := app.ListTableMaps(core.TestSitePosID)
+	assert.Equal(t, []core.TableMap{{BeaconID: "ff0000000001", SiteID: core.TestSitePosID, TableName: "1"}}, *tableMaps)
+}
+
+func TestUpdateAndDeleteTableMap(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000001", SiteID: core.TestSitePosID, TableName: "1"})
+
+	// act
+	updateErr := app.UpdateTableMap("FF0000000001", &core.TableMap{SiteID: core.TestSitePosID, TableName: "Patio 4"})
+	updated, _ := app.DB.GetTableMapByBeaconID("ff0000000001")
+	deleteErr := app.DeleteTableMap("ff0000000001")
+	deleted, _ := app.DB.GetTableMapByBeaconID("ff0000000001")
+
+	// assert
+	assert.NoError(t, updateErr)
+	assert.Equal(t, "Patio 4", updated.TableName)
+	assert.NoError(t, deleteErr)
+	assert.Nil(t, deleted)
+}
+
+func TestImportAndExportTableMaps(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000001", SiteID: core.TestSitePosID, TableName: "1"})
+	csv := "beacon_id,table_name\nff0000000001,10\nff0000000002,11\n"
+
+	// act
+	result, err := app.ImportTableMaps(core.TestSitePosID, strings.NewReader(csv))
+	exported := bytes.Buffer{}
+	exportErr := app.ExportTableMaps(core.TestSitePosID, &exported)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, core.TableMapImport{Created: 1, Updated: 1}, *result)
+	assert.NoError(t, exportErr)
+	assert.Equal(t, csv, exported.String())
+}
+
+func TestImportTableMapsReportsEveryProblem(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000009", SiteID: 999, TableName: "1"})
+	csv := "beacon_id,table_name\nff0000000001,1\nff0000000001,2\nnot-a-beacon,3\nff0000000009,4\n"
+
+	// act
+	_, err := app.ImportTableMaps(core.TestSitePosID, strings.NewReader(csv))
+
+	// assert
+	assert.IsType(t, core.TableMapError{}, errors.Cause(err))
+	assert.Contains(t, err.Error(), "line 3: beacon ff0000000001 is also on line 2")
+	assert.Contains(t, err.Error(), "line 4: beacon ID notabeacon is not hexadecimal")
+	assert.Contains(t, err.Error(), "line 5: beacon ff0000000009 is already mapped to table 1 at site 999")
+
+	// nothing is imported
+	tableMaps, _ := app.ListTableMaps(core.TestSitePosID)
+	assert.Equal(t, 0, len(*tableMaps))
+}
+
+func TestGetFloorPlan(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	patio, _ := app.AddFloorSection(core.TestSitePosID, "Patio")
+	app.SaveFloorTable(&core.FloorTable{SiteID: core.TestSitePosID, Name: "1", Seats: 4, SectionID: sql.NullInt64{Int64: int64(patio.ID), Valid: true}})
+	app.SaveFloorTable(&core.FloorTable{SiteID: core.TestSitePosID, Name: "2", Seats: 2})
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000001", SiteID: core.TestSitePosID, TableName: "1"})
+	app.AddTableMap(&core.TableMap{BeaconID: "ff0000000003", SiteID: core.TestSitePosID, TableName: "3"})
+
+	// act
+	plan, err := app.GetFloorPlan(core.TestSitePosID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(plan.Sections))
+	assert.Equal(t, "Patio", plan.Sections[0].Name)
+	assert.Equal(t, 1, len(plan.Sections[0].Tables))
+	assert.Equal(t, 4, plan.Sections[0].Tables[0].Seats)
+	assert.Equal(t, []string{"ff0000000001"}, plan.Sections[0].Tables[0].BeaconIDs)
+
+	// table 3 only has a beacon mapped to it
+	assert.Equal(t, 2, len(plan.Unsectioned))
+	assert.Equal(t, "2", plan.Unsectioned[0].Name)
+	assert.Equal(t, "3", plan.Unsectioned[1].Name)
+	assert.Equal(t, []string{"ff0000000003"}, plan.Unsectioned[1].BeaconIDs)
+
+	deleteErr := app.DeleteFloorTable(core.TestSitePosID, "1")
+	assert.IsType(t, core.TableMapError{}, errors.Cause(deleteErr))
+}
@@ -19,7 +19,7 @@ const (
 )
 
 // TestInsertMenu inserts a menu matching pos.MockKounta.GetMenuForSite() into the database
-func (app AppContext) TestInsertMenu(t *testing.T) {
+func (app AppContext) TestInsertMenu(t testing.TB) {
 	site := Site{PosID: TestSitePosID, Name: "Test Site 1"}
 	if err := app.DB.InsertSite(&site); err != nil {
 		t.Fatalf("err: %s", err)
@@ -99,14 +99,14 @@ func (app AppContext) TestInsertMenu(t *testing.T) {
 }
 
 // TestInsertOrder inserts an order into the database
-func (app AppContext) TestInsertOrder(t *testing.T, order *Order) {
+func (app AppContext) TestInsertOrder(t testing.TB, order *Order) {
 	if err := app.DB.InsertOrder(order); err != nil {
 		t.Fatalf("err: %s", err)
 	}
 }
 
 // TestMarkCategoryClientFacing will mark a category as client facing (or not) in database
-func (app AppContext) TestMarkCategoryClientFacing(t *testing.T, category *Category, clientFacing bool) {
+func (app AppContext) TestMarkCategoryClientFacing(t testing.TB, category *Category, clientFacing bool) {
 	// if testing with PG DB
 	if pg, ok := app.DB.(Postgres); ok {
 		_, err := pg.Exec(
@@ -131,7 +131,7 @@ func (app AppContext) TestMarkCategoryClientFacing(t *testing.T, category *Categ
 }
 
 // TestMarkCategoryInstoreOnly will mark a category as instore-only (or not) in database
-func (app AppContext) TestMarkCategoryInstoreOnly(t *testing.T, category *Category, instoreOnly bool) {
+func (app AppContext) TestMarkCategoryInstoreOnly(t testing.TB, category *Category, instoreOnly bool) {
 	// if testing with PG DB
 	if pg, ok := app.DB.(Postgres); ok {
 		_, err := pg.Exec(
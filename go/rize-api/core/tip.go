@@ -0,0 +1,220 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultTipSettings are used for sites that haven't set their own
+var defaultTipSettings = TipSettings{Percentages: []int64{15, 18, 20}, MaxPercent: 50}
+
+// TipSettings are the tips suggested to guests at a site and the most they can tip
+type TipSettings struct {
+	SiteID      KountaID `json:"site_id"`
+	Percentages []int64  `json:"percentages"`
+	// PreTax suggests tips on the total before tax instead of the total guests pay
+	PreTax bool `json:"pre_tax"`
+	// MaxPercent is the largest tip allowed as a percent of the amount paid, so a typo doesn't add a huge tip
+	MaxPercent int `json:"max_percent"`
+}
+
+// TipSuggestion is a suggested tip in cents
+type TipSuggestion struct {
+	Percent int64 `json:"percent"`
+	Amount  int   `json:"amount"`
+}
+
+// StaffTip is the tip Kounta has for an order and the staff member who took it
+type StaffTip struct {
+	PosOrderID    KountaID  `json:"pos_order_id"`
+	SiteID        KountaID  `json:"site_id"`
+	StaffMemberID KountaID  `json:"staff_member_id"`
+	Tips          int       `json:"tips"`
+	SoldAt        time.Time `json:"sold_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// TipReport is what each staff member at a site was tipped on a day, and their share if tips are pooled
+type TipReport struct {
+	SiteID KountaID         `json:"site_id"`
+	Date   string           `json:"date"`
+	Total  int              `json:"total"`
+	Staff  []StaffTipReport `json:"staff"`
+}
+
+// StaffTipReport is one staff member's tips on a TipReport. PoolShare is an even split of the day's tips between
+// everyone on the report.
+type StaffTipReport struct {
+	StaffMemberID KountaID `json:"staff_member_id"`
+	Orders        int      `json:"orders"`
+	Tips          int      `json:"tips"`
+	PoolShare     int      `json:"pool_share"`
+}
+
+// TipError is returned for tips or tip settings that aren't allowed
+type TipError struct {
+	Reason string
+}
+
+func (e TipError) Error() string {
+	return e.Reason
+}
+
+// UpdateTipSettings will validate and save the tips suggested at a site
+func (app AppContext) UpdateTipSettings(settings TipSettings) error {
+	if len(settings.Percentages) == 0 {
+		return errors.Wrap(TipError{Reason: "at least one tip percentage is required"}, "update tip settings")
+	}
+	if settings.MaxPercent <= 0 || settings.MaxPercent > 100 {
+		return errors.Wrap(TipError{Reason: "the maximum tip has to be between 1 and 100 percent"}, "update tip settings")
+	}
+	for _, percent := range settings.Percentages {
+		if percent <= 0 || percent > int64(settings.MaxPercent) {
+			return errors.Wrap(TipError{Reason: fmt.Sprintf("tip percentages have to be between 1 and %d", settings.MaxPercent)}, "update tip settings")
+		}
+	}
+
+	if err := app.DB.UpsertTipSettings(&settings); err != nil {
+		return errors.Wrapf(err, "update tip settings for site %d", settings.SiteID)
+	}
+	return nil
+}
+
+// GetTipSettings will return a site's tip settings, or the defaults if it hasn't set any
+func (app AppContext) GetTipSettings(siteID KountaID) (*TipSettings, error) {
+	settings, err := app.DB.GetTipSettings(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tip settings for site %d", siteID)
+	}
+	if settings == nil {
+		defaults := defaultTipSettings
+		defaults.SiteID = siteID
+		return &defaults, nil
+	}
+	return settings, nil
+}
+
+// SuggestTips will return the tips suggested at a site for a total, which includes totalTax, rounded to the cent
+func (app AppContext) SuggestTips(siteID KountaID, total, totalTax int) (*[]TipSuggestion, error) {
+	settings, err := app.GetTipSettings(siteID)
+	if err != nil {
+		return nil, errors.Wrap(err, "suggest tips")
+	}
+
+	base := total
+	if settings.PreTax {
+		base = total - totalTax
+	}
+	suggestions := []TipSuggestion{}
+	for _, percent := range settings.Percentages {
+		amount := int(math.Round(float64(base) * float64(percent) / 100))
+		suggestions = append(suggestions, TipSuggestion{Percent: percent, Amount: amount})
+	}
+	return &suggestions, nil
+}
+
+// ValidateTip will return a TipError if a tip on amount is negative or more than the site allows
+func (app AppContext) ValidateTip(siteID KountaID, amount, tip int) error {
+	if tip < 0 {
+		return errors.Wrap(TipError{Reason: "the tip can not be negative"}, "validate tip")
+	}
+
+	settings, err := app.GetTipSettings(siteID)
+	if err != nil {
+		return errors.Wrap(err, "validate tip")
+	}
+	if tip*100 > amount*settings.MaxPercent {
+		return errors.Wrap(TipError{Reason: fmt.Sprintf("the tip can not be more than %d%% of the amount paid", settings.MaxPercent)}, "validate tip")
+	}
+	return nil
+}
+
+// RecordOrderUpdate will log an order update from Kounta and keep the order's tips and the staff member who took them,
+// for tip reports. Updates older than the last one recorded for an order don't change its tips.
+func (app AppContext) RecordOrderUpdate(update KountaOrderUpdate) error {
+	if err := app.DB.InsertOrderUpdate(update); err != nil {
+		return errors.Wrapf(err, "record order update for order %d", update.GetOrderID())
+	}
+	if update.GetStaffMemberID() == 0 {
+		return nil
+	}
+
+	tip := StaffTip{
+		PosOrderID:    update.GetOrderID(),
+		SiteID:        update.GetSiteID(),
+		StaffMemberID: update.GetStaffMemberID(),
+		Tips:          int(math.Round(update.GetTips() * 100)), // Kounta amounts are in dollars
+		SoldAt:        update.GetCreatedAt().UTC(),
+		UpdatedAt:     update.GetUpdatedAt().UTC(),
+	}
+	if update.GetDeleted() {
+		tip.Tips = 0
+	}
+	if err := app.DB.UpsertStaffTip(&tip); err != nil {
+		return errors.Wrapf(err, "record order update for order %d", update.GetOrderID())
+	}
+	return nil
+}
+
+// GetTipReport will return the tips taken by each staff member at a site on the day of date in the site's time zone,
+// most tipped first
+func (app AppContext) GetTipReport(siteID KountaID, date time.Time) (*TipReport, error) {
+	site, err := app.DB.GetSite(siteID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tip report for site %d", siteID)
+	}
+	if site == nil {
+		return nil, errors.Errorf("get tip report: site %d not found", siteID)
+	}
+	location, err := site.Location()
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tip report for site %d", siteID)
+	}
+	if location == nil {
+		location = time.UTC
+	}
+
+	date = date.In(location)
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, location)
+	// tips are kept in UTC, so the day is looked up in UTC too
+	tips, err := app.DB.SelectStaffTipsBySiteID(siteID, from.UTC(), from.AddDate(0, 0, 1).UTC())
+	if err != nil {
+		return nil, errors.Wrapf(err, "get tip report for site %d", siteID)
+	}
+
+	byStaff := map[KountaID]*StaffTipReport{}
+	report := TipReport{SiteID: siteID, Date: from.Format("2006-01-02"), Staff: []StaffTipReport{}}
+	for _, tip := range *tips {
+		staff, contains := byStaff[tip.StaffMemberID]
+		if !contains {
+			staff = &StaffTipReport{StaffMemberID: tip.StaffMemberID}
+			byStaff[tip.StaffMemberID] = staff
+		}
+		staff.Orders++
+		staff.Tips += tip.Tips
+		report.Total += tip.Tips
+	}
+
+	for _, staff := range byStaff {
+		report.Staff = append(report.Staff, *staff)
+	}
+	sort.Slice(report.Staff, func(i, j int) bool {
+		if report.Staff[i].Tips != report.Staff[j].Tips {
+			return report.Staff[i].Tips > report.Staff[j].Tips
+		}
+		return report.Staff[i].StaffMemberID < report.Staff[j].StaffMemberID
+	})
+
+	// the cents that don't split evenly go to whoever was tipped the most
+	for i := range report.Staff {
+		report.Staff[i].PoolShare = report.Total / len(report.Staff)
+		if i < report.Total%len(report.Staff) {
+			report.Staff[i].PoolShare++
+		}
+	}
+	return &report, nil
+}
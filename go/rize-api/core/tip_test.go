@@ -0,0 +1,127 @@
+package core_test
+
+import (
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegacyPaymentInfoTip(t *testing.T) {
+	// arrange
+	infos := []core.LegacyPaymentInfo{
+		{Metadata: map[string]interface{}{"tip": "250"}},
+		{Metadata: map[string]interface{}{"tip": float64(300)}},
+		{Metadata: map[string]interface{}{"tip": true}},
+		{Metadata: map[string]interface{}{"tip": "two dollars"}},
+		{},
+	}
+
+	// act
+	tips := []int{}
+	for _, info := range infos {
+		tips = append(tips, info.Tip())
+	}
+
+	// assert
+	assert.Equal(t, []int{250, 300, 0, 0, 0}, tips)
+}
+
+func TestSuggestTips(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.UpdateTipSettings(core.TipSettings{SiteID: 87654, Percentages: []int64{10, 25}, PreTax: true, MaxPercent: 30})
+
+	// act
+	defaults, err := app.SuggestTips(core.TestSitePosID, 2150, 150)
+	preTax, preTaxErr := app.SuggestTips(87654, 2150, 150)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []core.TipSuggestion{{Percent: 15, Amount: 323}, {Percent: 18, Amount: 387}, {Percent: 20, Amount: 430}}, *defaults)
+	assert.NoError(t, preTaxErr)
+	assert.Equal(t, []core.TipSuggestion{{Percent: 10, Amount: 200}, {Percent: 25, Amount: 500}}, *preTax)
+}
+
+func TestValidateTip(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.UpdateTipSettings(core.TipSettings{SiteID: 87654, Percentages: []int64{10}, MaxPercent: 30})
+
+	// act
+	badSettingsErr := app.UpdateTipSettings(core.TipSettings{SiteID: 87654, Percentages: []int64{40}, MaxPercent: 30})
+	negativeErr := app.ValidateTip(core.TestSitePosID, 1000, -1)
+	defaultMaxErr := app.ValidateTip(core.TestSitePosID, 1000, 500)
+	siteMaxErr := app.ValidateTip(87654, 1000, 301)
+	allowedErr := app.ValidateTip(87654, 1000, 300)
+
+	// assert
+	assert.IsType(t, core.TipError{}, errors.Cause(badSettingsErr))
+	assert.IsType(t, core.TipError{}, errors.Cause(negativeErr))
+	assert.NoError(t, defaultMaxErr)
+	assert.IsType(t, core.TipError{}, errors.Cause(siteMaxErr))
+	assert.NoError(t, allowedErr)
+}
+
+func TestGetTipReport(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.TestInsertSite(t, &core.Site{PosID: core.TestSitePosID, Name: "Test Site 1", TimeZone: "America/New_York"})
+	location, _ := time.LoadLocation("America/New_York")
+	noon := time.Date(2018, 3, 9, 12, 0, 0, 0, location)
+
+	updates := []tipUpdate{
+		{OrderID: 1, StaffMemberID: 7, Tips: 5, UpdatedAt: noon},
+		{OrderID: 1, StaffMemberID: 7, Tips: 6, UpdatedAt: noon.Add(time.Hour)},
+		{OrderID: 1, StaffMemberID: 7, Tips: 1, UpdatedAt: noon.Add(30 * time.Minute)},
+		{OrderID: 2, StaffMemberID: 7, Tips: 2.5, UpdatedAt: noon},
+		{OrderID: 3, StaffMemberID: 8, Tips: 3.01, UpdatedAt: noon.Add(11 * time.Hour)},
+		{OrderID: 4, StaffMemberID: 8, Tips: 10, UpdatedAt: noon.Add(13 * time.Hour)},
+		{OrderID: 5, Tips: 10, UpdatedAt: noon},
+	}
+	for _, update := range updates {
+		update.SiteID = core.TestSitePosID
+		if err := app.RecordOrderUpdate(update); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// act
+	report, err := app.GetTipReport(core.TestSitePosID, noon)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, "2018-03-09", report.Date)
+	assert.Equal(t, 1151, report.Total)
+	assert.Equal(t, []core.StaffTipReport{
+		{StaffMemberID: 7, Orders: 2, Tips: 850, PoolShare: 576},
+		{StaffMemberID: 8, Orders: 1, Tips: 301, PoolShare: 575},
+	}, report.Staff)
+	tips, _ := app.DB.SelectStaffTipsBySiteID(core.TestSitePosID, time.Time{}, noon.AddDate(0, 0, 2))
+	assert.Equal(t, time.UTC, (*tips)[0].SoldAt.Location())
+}
+
+// helpers
+
+// tipUpdate is a Kounta order update with only what tip reports use, sold when it was last updated
+type tipUpdate struct {
+	core.KountaOrderUpdate
+	OrderID       core.KountaID
+	SiteID        core.KountaID
+	StaffMemberID core.KountaID
+	Tips          float64
+	UpdatedAt     time.Time
+}
+
+func (u tipUpdate) GetOrderID() core.KountaID       { return u.OrderID }
+func (u tipUpdate) GetSiteID() core.KountaID        { return u.SiteID }
+func (u tipUpdate) GetStaffMemberID() core.KountaID { return u.StaffMemberID }
+func (u tipUpdate) GetTips() float64                { return u.Tips }
+func (u tipUpdate) GetCreatedAt() time.Time         { return u.UpdatedAt }
+func (u tipUpdate) GetUpdatedAt() time.Time         { return u.UpdatedAt }
+func (u tipUpdate) GetDeleted() bool                { return false }
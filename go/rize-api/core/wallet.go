@@ -0,0 +1,231 @@
+package core
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// GatewayCayan is used for cards vaulted by Cayan
+const GatewayCayan = "cayan"
+
+// SavedCard is a card in a customer's wallet. VaultID is the gateway's reference to the card and is never sent to
+// clients; they pay with a saved card by its ID.
+type SavedCard struct {
+	ID         DatabaseID `json:"id"`
+	CustomerID DatabaseID `json:"-"`
+	Gateway    string     `json:"gateway"`
+	VaultID    string     `json:"-"`
+	CardType   string     `json:"card_type"`
+	Last4      string     `json:"last_4"`
+	Expiry     string     `json:"expiry"`
+	IsDefault  bool       `json:"is_default"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AddCard will vault a card with CardConnect and save it to the customer's wallet. Cards are added to the customer's
+// existing CardConnect profile, and the first card saved becomes the default.
+func (app AppContext) AddCard(customerID DatabaseID, card CreditCard) (*SavedCard, error) {
+	cards, err := app.DB.SelectSavedCardsByCustomerID(customerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "add card")
+	}
+
+	card.VaultID = ""
+	for _, savedCard := range *cards {
+		if savedCard.Gateway == GatewayCardConnect {
+			card.VaultID = savedCard.VaultID
+			break
+		}
+	}
+
+	last4 := lastFour(card.Number)
+	if err := app.CardConnect.AddCreditCard(&card); err != nil {
+		return nil, errors.Wrap(err, "add card")
+	}
+
+	savedCard := SavedCard{
+		CustomerID: customerID,
+		Gateway:    GatewayCardConnect,
+		VaultID:    card.VaultID,
+		CardType:   card.Type,
+		Last4:      last4,
+		Expiry:     card.Expiry,
+		IsDefault:  len(*cards) == 0,
+		CreatedAt:  time.Now(),
+	}
+	if err := app.DB.InsertSavedCard(&savedCard); err != nil {
+		return nil, errors.Wrap(err, "add card")
+	}
+	return &savedCard, nil
+}
+
+// SaveCayanCard will save the vault ID Cayan returns from a payment to the customer's wallet
+func (app AppContext) SaveCayanCard(customerID DatabaseID, vaultID string, info LegacyPaymentInfo) (*SavedCard, error) {
+	cards, err := app.DB.SelectSavedCardsByCustomerID(customerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "save cayan card")
+	}
+	for _, savedCard := range *cards {
+		if savedCard.Gateway == GatewayCayan && savedCard.VaultID == vaultID {
+			return &savedCard, nil
+		}
+	}
+
+	last4 := info.CardLast4
+	if last4 == "" {
+		last4 = lastFour(info.CardNumber)
+	}
+
+	savedCard := SavedCard{
+		CustomerID: customerID,
+		Gateway:    GatewayCayan,
+		VaultID:    vaultID,
+		CardType:   info.CardType,
+		Last4:      last4,
+		Expiry:     info.CardExpiry,
+		IsDefault:  len(*cards) == 0,
+		CreatedAt:  time.Now(),
+	}
+	if err := app.DB.InsertSavedCard(&savedCard); err != nil {
+		return nil, errors.Wrap(err, "save cayan card")
+	}
+	return &savedCard, nil
+}
+
+// ListCards will return the cards in a customer's wallet
+func (app AppContext) ListCards(customerID DatabaseID) (*[]SavedCard, error) {
+	cards, err := app.DB.SelectSavedCardsByCustomerID(customerID)
+	if err != nil {
+		return nil, errors.Wrap(err, "list cards")
+	}
+	return cards, nil
+}
+
+// RemoveCard will delete a card from the customer's wallet and its gateway. If it was the default card the oldest
+// remaining card becomes the default.
+func (app AppContext) RemoveCard(customerID, cardID DatabaseID) error {
+	card, err := app.getSavedCard(customerID, cardID)
+	if err != nil {
+		return errors.Wrap(err, "remove card")
+	}
+
+	// Cayan vault records are not removed through the API and expire on their own
+	if card.Gateway == GatewayCardConnect {
+		if err := app.CardConnect.DeleteCreditCard(card.VaultID); err != nil {
+			return errors.Wrap(err, "remove card")
+		}
+	}
+
+	if err := app.DB.DeleteSavedCard(card.ID); err != nil {
+		return errors.Wrap(err, "remove card")
+	}
+
+	if card.IsDefault {
+		cards, err := app.DB.SelectSavedCardsByCustomerID(customerID)
+		if err != nil {
+			return errors.Wrap(err, "remove card")
+		}
+		if len(*cards) > 0 {
+			if err := app.DB.SetDefaultSavedCard(customerID, (*cards)[0].ID); err != nil {
+				return errors.Wrap(err, "remove card")
+			}
+		}
+	}
+	return nil
+}
+
+// SetDefaultCard will make a card the one the customer pays with by default. Only the wallet changes, the default
+// account on the customer's CardConnect profile is left as it was since saved card payments always name the account
+// they charge.
+func (app AppContext) SetDefaultCard(customerID, cardID DatabaseID) error {
+	if _, err := app.getSavedCard(customerID, cardID); err != nil {
+		return errors.Wrap(err, "set default card")
+	}
+
+	if err := app.DB.SetDefaultSavedCard(customerID, cardID); err != nil {
+		return errors.Wrap(err, "set default card")
+	}
+	return nil
+}
+
+// PayWithSavedCard will charge a card from the customer's wallet for an order and return the payment, which the
+// caller saves with SavePayment once the order is updated
+func (app AppContext) PayWithSavedCard(customerID, cardID, orderID DatabaseID, amount, tip int) (*Payment, error) {
+	card, err := app.getSavedCard(customerID, cardID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay with saved card")
+	}
+
+	// the site's tip limits apply once the order is saved, until then the defaults do
+	var siteID KountaID
+	order, err := app.DB.GetOrderByDatabaseID(orderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "pay with saved card")
+	}
+	if order != nil {
+		siteID = order.SiteID
+	}
+	if err := app.ValidateTip(siteID, amount, tip); err != nil {
+		return nil, errors.Wrap(err, "pay with saved card")
+	}
+
+	var transactionID string
+	switch card.Gateway {
+	case GatewayCardConnect:
+		transactionID, err = app.CardConnect.MakePayment(TokenizedPayment{
+			Gateway:    GatewayCardConnect,
+			Token:      card.VaultID,
+			OrderID:    orderID,
+			CustomerID: customerID,
+			Amount:     amount,
+			Tip:        tip,
+			Expiry:     card.Expiry,
+		})
+	case GatewayCayan:
+		transactionID, _, err = app.Cayan.MakePayment(LegacyPaymentInfo{
+			CardType:   card.CardType,
+			VaultID:    card.VaultID,
+			Amount:     amount,
+			Metadata:   map[string]interface{}{"tip": strconv.Itoa(tip)},
+			CustomerID: customerID,
+			OrderID:    orderID,
+		})
+	default:
+		return nil, errors.Errorf("pay with saved card: unknown gateway %s", card.Gateway)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "pay with saved card")
+	}
+
+	return &Payment{
+		Amount:        amount,
+		Tip:           tip,
+		OrderID:       orderID,
+		TransactionID: transactionID,
+		Date:          time.Now(),
+		CustomerID:    sql.NullInt64{Int64: int64(customerID), Valid: true},
+		CardLast4:     card.Last4,
+	}, nil
+}
+
+// getSavedCard returns a card from a customer's wallet, or an error if it is not theirs
+func (app AppContext) getSavedCard(customerID, cardID DatabaseID) (*SavedCard, error) {
+	card, err := app.DB.GetSavedCard(cardID)
+	if err != nil {
+		return nil, err
+	}
+	if card == nil || card.CustomerID != customerID {
+		return nil, errors.Errorf("card %d not found", cardID)
+	}
+	return card, nil
+}
+
+func lastFour(number string) string {
+	if len(number) < 4 {
+		return number
+	}
+	return number[len(number)-4:]
+}
@@ -0,0 +1,132 @@
+package core_test
+
+import (
+	"fmt"
+	"testing"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+)
+
+// vaultCardConnect vaults cards in a single profile and records deleted profiles and payments
+type vaultCardConnect struct {
+	core.CardConnect
+	accounts int
+	deleted  []string
+	payments []core.TokenizedPayment
+}
+
+func (c *vaultCardConnect) AddCreditCard(card *core.CreditCard) error {
+	c.accounts++
+	card.VaultID = fmt.Sprintf("12345/%d", c.accounts)
+	return nil
+}
+
+func (c *vaultCardConnect) DeleteCreditCard(vaultID string) error {
+	c.deleted = append(c.deleted, vaultID)
+	return nil
+}
+
+func (c *vaultCardConnect) MakePayment(p core.TokenizedPayment) (string, error) {
+	c.payments = append(c.payments, p)
+	return "transaction-1", nil
+}
+
+func TestAddCardMakesFirstCardDefault(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.CardConnect = &vaultCardConnect{}
+	customer := createTestCustomer(app)
+
+	// act
+	first, err := app.AddCard(customer.ID, core.CreditCard{Number: "4111111111111111", Expiry: "1220", Type: "VISA"})
+	second, _ := app.AddCard(customer.ID, core.CreditCard{Number: "5500000000000004", Expiry: "0121", Type: "MC"})
+
+	// assert
+	assert.NoError(t, err)
+	assert.True(t, first.IsDefault)
+	assert.False(t, second.IsDefault)
+	assert.Equal(t, "1111", first.Last4)
+	assert.Equal(t, "12345/2", second.VaultID)
+
+	cards, _ := app.ListCards(customer.ID)
+	assert.Equal(t, 2, len(*cards))
+}
+
+func TestSetDefaultCard(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	app.CardConnect = &vaultCardConnect{}
+	customer := createTestCustomer(app)
+	app.AddCard(customer.ID, core.CreditCard{Number: "4111111111111111"})
+	second, _ := app.AddCard(customer.ID, core.CreditCard{Number: "5500000000000004"})
+
+	// act
+	err := app.SetDefaultCard(customer.ID, second.ID)
+
+	// assert
+	assert.NoError(t, err)
+	cards, _ := app.ListCards(customer.ID)
+	assert.False(t, (*cards)[0].IsDefault)
+	assert.True(t, (*cards)[1].IsDefault)
+}
+
+func TestRemoveDefaultCardMovesDefault(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	cardConnect := &vaultCardConnect{}
+	app.CardConnect = cardConnect
+	customer := createTestCustomer(app)
+	first, _ := app.AddCard(customer.ID, core.CreditCard{Number: "4111111111111111"})
+	app.AddCard(customer.ID, core.CreditCard{Number: "5500000000000004"})
+
+	// act
+	err := app.RemoveCard(customer.ID, first.ID)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"12345/1"}, cardConnect.deleted)
+	cards, _ := app.ListCards(customer.ID)
+	assert.Equal(t, 1, len(*cards))
+	assert.True(t, (*cards)[0].IsDefault)
+}
+
+func TestPayWithSavedCard(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	cardConnect := &vaultCardConnect{}
+	app.CardConnect = cardConnect
+	customer := createTestCustomer(app)
+	card, _ := app.AddCard(customer.ID, core.CreditCard{Number: "4111111111111111", Expiry: "1220"})
+
+	// act
+	payment, err := app.PayWithSavedCard(customer.ID, card.ID, 7, 1500, 300)
+
+	// assert
+	assert.NoError(t, err)
+	assert.Equal(t, "transaction-1", payment.TransactionID)
+	assert.Equal(t, "1111", payment.CardLast4)
+	assert.Equal(t, "12345/1", cardConnect.payments[0].Token)
+	assert.Equal(t, "1220", cardConnect.payments[0].Expiry)
+}
+
+func TestPayWithAnotherCustomersCardFails(t *testing.T) {
+	// arrange
+	var app core.AppContext
+	defer testServer(&app)()
+	cardConnect := &vaultCardConnect{}
+	app.CardConnect = cardConnect
+	customer := createTestCustomer(app)
+	card, _ := app.AddCard(customer.ID, core.CreditCard{Number: "4111111111111111"})
+
+	// act
+	_, err := app.PayWithSavedCard(customer.ID+1, card.ID, 7, 1500, 0)
+
+	// assert
+	assert.Error(t, err)
+	assert.Equal(t, 0, len(cardConnect.payments))
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+)
+
+// Capture writes emails to disk instead of sending them, for local testing. Each email is saved as an .eml file that
+// can be opened by most mail clients, along with its HTML body so it can be viewed in a browser.
+type Capture struct {
+	Dir string
+}
+
+func (c Capture) Deliver(email core.OutboxEmail) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return errors.Wrap(err, "capture email")
+	}
+
+	now := time.Now()
+	message, err := buildMessage(email, now)
+	if err != nil {
+		return errors.Wrap(err, "capture email")
+	}
+
+	name := fmt.Sprintf("%s-%d-%s", now.Format("20060102-150405"), email.ID, email.Template)
+	path := filepath.Join(c.Dir, name+".eml")
+	if err := ioutil.WriteFile(path, message, 0644); err != nil {
+		return errors.Wrap(err, "capture email")
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.Dir, name+".html"), []byte(email.HTMLBody), 0644); err != nil {
+		return errors.Wrap(err, "capture email")
+	}
+
+	log.Printf("Captured email to %s: %s", email.To, path)
+	return nil
+}
@@ -0,0 +1,156 @@
+package mail
+
+import (
+	"log"
+	"net"
+	"net/textproto"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxAttempts = 5
+	firstRetryDelay    = time.Minute
+	maxRetryDelay      = time.Hour
+	outboxBatchSize    = 50
+	outboxLease        = 5 * time.Minute
+)
+
+// Transport delivers a rendered email
+type Transport interface {
+	Deliver(email core.OutboxEmail) error
+}
+
+// Mailer implements core.Mailer. Emails are rendered from the built in templates and saved to the outbox before
+// delivery so that transient failures can be retried by ProcessOutbox.
+type Mailer struct {
+	DB          core.DB
+	Transport   Transport
+	From        string
+	MaxAttempts int // MaxAttempts defaults to 5
+}
+
+func (m Mailer) SendEmail(template, toString, subject string, data interface{}) error {
+	htmlBody, textBody, err := Render(template, data)
+	if err != nil {
+		return errors.Wrap(err, "send email")
+	}
+
+	now := time.Now()
+	email := core.OutboxEmail{
+		Template: template,
+		From:     m.From,
+		To:       toString,
+		Subject:  subject,
+		HTMLBody: htmlBody,
+		TextBody: textBody,
+		Status:   core.OutboxStatusPending,
+		// leased like a claimed email, so ProcessOutbox won't send it again while it's being sent below
+		NextAttemptAt: now.Add(outboxLease),
+		CreatedAt:     now,
+	}
+	if err := m.DB.InsertOutboxEmail(&email); err != nil {
+		return errors.Wrap(err, "send email")
+	}
+
+	if err := m.deliver(&email); err != nil {
+		return errors.Wrap(err, "send email")
+	}
+	return nil
+}
+
+// ProcessOutbox will attempt delivery of every pending email that is due for a retry
+func (m Mailer) ProcessOutbox() error {
+	for {
+		emails, err := m.DB.ClaimDueOutboxEmails(time.Now(), outboxLease, outboxBatchSize)
+		if err != nil {
+			return errors.Wrap(err, "process outbox")
+		}
+		if len(*emails) == 0 {
+			return nil
+		}
+
+		for i := range *emails {
+			if err := m.deliver(&(*emails)[i]); err != nil {
+				log.Println(errors.Wrap(err, "process outbox"))
+			}
+		}
+
+		if len(*emails) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver attempts to send an email once and records the result in the outbox. An error is only returned if the
+// email has permanently failed or the outbox could not be updated.
+func (m Mailer) deliver(email *core.OutboxEmail) error {
+	maxAttempts := m.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	email.Attempts++
+	deliveryErr := m.Transport.Deliver(*email)
+
+	now := time.Now()
+	switch {
+	case deliveryErr == nil:
+		email.Status = core.OutboxStatusSent
+		email.LastError = ""
+		email.SentAt = &now
+	case isTransient(deliveryErr) && email.Attempts < maxAttempts:
+		email.Status = core.OutboxStatusPending
+		email.LastError = deliveryErr.Error()
+		email.NextAttemptAt = now.Add(retryDelay(email.Attempts))
+	default:
+		email.Status = core.OutboxStatusFailed
+		email.LastError = deliveryErr.Error()
+	}
+
+	if err := m.DB.UpdateOutboxEmail(email); err != nil {
+		return err
+	}
+
+	if email.Status == core.OutboxStatusFailed {
+		return errors.Wrapf(deliveryErr, "email %d to %s failed after %d attempts", email.ID, email.To, email.Attempts)
+	}
+	return nil
+}
+
+// retryDelay doubles the wait after every attempt, up to an hour
+func retryDelay(attempts int) time.Duration {
+	delay := firstRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// isTransient returns true for network errors and 4xx SMTP replies, which are worth retrying
+func isTransient(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case *textproto.Error:
+		return e.Code >= 400 && e.Code < 500
+	case net.Error:
+		return true
+	case TransientError:
+		return true
+	default:
+		return false
+	}
+}
+
+// TransientError can be returned by a Transport to have delivery retried
+type TransientError struct {
+	Reason string
+}
+
+func (e TransientError) Error() string {
+	return e.Reason
+}
@@ -0,0 +1,183 @@
+package mail
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeTransport struct {
+	errs      []error
+	delivered []core.OutboxEmail
+}
+
+func (f *fakeTransport) Deliver(email core.OutboxEmail) error {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return err
+	}
+	f.delivered = append(f.delivered, email)
+	return nil
+}
+
+func newTestMailer(transport Transport) (Mailer, *core.MemoryDB) {
+	db := &core.MemoryDB{}
+	db.Init()
+	return Mailer{DB: db, Transport: transport, From: "Rize <hello@example.com>"}, db
+}
+
+func TestSendEmailRendersAndDelivers(t *testing.T) {
+	transport := &fakeTransport{}
+	mailer, db := newTestMailer(transport)
+
+	err := mailer.SendEmail("forgot_password", "bob@smith.com", "Reset your password", core.TokenEmail{Token: "abc123", Expiry: "in 1 hour"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transport.delivered))
+	assert.Contains(t, transport.delivered[0].HTMLBody, "abc123")
+	assert.Contains(t, transport.delivered[0].TextBody, "abc123")
+	assert.Equal(t, core.OutboxStatusSent, db.Outbox[1].Status)
+}
+
+// outboxTransport processes the outbox while the first email is being delivered, like a concurrent worker would
+type outboxTransport struct {
+	fakeTransport
+	mailer *Mailer
+}
+
+func (o *outboxTransport) Deliver(email core.OutboxEmail) error {
+	if len(o.delivered) == 0 {
+		if err := o.mailer.ProcessOutbox(); err != nil {
+			return err
+		}
+	}
+	return o.fakeTransport.Deliver(email)
+}
+
+func TestSendEmailIsNotSentAgainByProcessOutbox(t *testing.T) {
+	transport := &outboxTransport{}
+	mailer, db := newTestMailer(transport)
+	transport.mailer = &mailer
+
+	err := mailer.SendEmail("forgot_password", "bob@smith.com", "Reset your password", core.TokenEmail{Token: "abc123"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transport.delivered))
+	assert.Equal(t, 1, db.Outbox[1].Attempts)
+}
+
+func TestSendEmailWithUnknownTemplateFails(t *testing.T) {
+	mailer, _ := newTestMailer(&fakeTransport{})
+
+	err := mailer.SendEmail("unknown", "bob@smith.com", "Subject", nil)
+
+	assert.Error(t, err)
+}
+
+func TestRenderReceipt(t *testing.T) {
+	receipt := &core.Receipt{
+		OrderID:  12,
+		SiteName: "Test Pizza",
+		Date:     time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC),
+		Lines: []core.ReceiptLine{
+			{Quantity: 2, Name: "Cheese Pizza", Total: 1800, AddedModifiers: []string{"Olives"}, RemovedModifiers: []string{"Onion"}},
+		},
+		Subtotal:  1750,
+		Fees:      []core.ReceiptFee{{Name: "Packaging", Amount: 50}},
+		Tax:       126,
+		Tip:       300,
+		Total:     2226,
+		CardLast4: "4242",
+	}
+
+	html, text, err := Render("receipt", receipt)
+
+	assert.NoError(t, err)
+	assert.Contains(t, html, "2 &times; Cheese Pizza")
+	assert.Contains(t, html, "+ Olives")
+	assert.Contains(t, html, "no Onion")
+	assert.Contains(t, html, "<td>Packaging</td><td align=\"right\">$0.50</td>")
+	assert.Contains(t, html, "$22.26")
+	assert.Contains(t, text, "2 x Cheese Pizza                  $18.00\n")
+	assert.Contains(t, text, "    - no Onion\n")
+	assert.Contains(t, text, "Paid with card ending in 4242\n")
+}
+
+func TestTransientFailureIsRetriedByProcessOutbox(t *testing.T) {
+	transport := &fakeTransport{errs: []error{&textproto.Error{Code: 421, Msg: "try again later"}}}
+	mailer, db := newTestMailer(transport)
+
+	err := mailer.SendEmail("forgot_password", "bob@smith.com", "Reset your password", core.TokenEmail{Token: "abc123"})
+	assert.NoError(t, err)
+	assert.Equal(t, core.OutboxStatusPending, db.Outbox[1].Status)
+	assert.Equal(t, 0, len(transport.delivered))
+
+	// make the retry due now
+	email := db.Outbox[1]
+	email.NextAttemptAt = time.Now().Add(-time.Second)
+	db.Outbox[1] = email
+
+	err = mailer.ProcessOutbox()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transport.delivered))
+	assert.Equal(t, core.OutboxStatusSent, db.Outbox[1].Status)
+	assert.Equal(t, 2, db.Outbox[1].Attempts)
+}
+
+func TestPermanentFailureIsNotRetried(t *testing.T) {
+	transport := &fakeTransport{errs: []error{&textproto.Error{Code: 550, Msg: "no such user"}}}
+	mailer, db := newTestMailer(transport)
+
+	err := mailer.SendEmail("forgot_password", "bob@smith.com", "Reset your password", core.TokenEmail{Token: "abc123"})
+
+	assert.Error(t, err)
+	assert.Equal(t, core.OutboxStatusFailed, db.Outbox[1].Status)
+}
+
+func TestRetriesStopAfterMaxAttempts(t *testing.T) {
+	transport := &fakeTransport{errs: []error{TransientError{"down"}, TransientError{"down"}}}
+	mailer, db := newTestMailer(transport)
+	mailer.MaxAttempts = 2
+
+	mailer.SendEmail("forgot_password", "bob@smith.com", "Reset your password", core.TokenEmail{Token: "abc123"})
+	email := db.Outbox[1]
+	email.NextAttemptAt = time.Now().Add(-time.Second)
+	db.Outbox[1] = email
+	mailer.ProcessOutbox()
+
+	assert.Equal(t, core.OutboxStatusFailed, db.Outbox[1].Status)
+	assert.Equal(t, 2, db.Outbox[1].Attempts)
+}
+
+func TestCaptureWritesMessageToDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mailer, _ := newTestMailer(Capture{Dir: dir})
+	err = mailer.SendEmail("forgot_password", "bob@smith.com", "Reset your password", core.TokenEmail{Token: "abc123"})
+	assert.NoError(t, err)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.Equal(t, 1, len(files))
+	message, _ := ioutil.ReadFile(files[0])
+	assert.True(t, strings.Contains(string(message), "To: bob@smith.com"))
+	assert.True(t, strings.Contains(string(message), "multipart/alternative"))
+}
+
+func TestIsTransient(t *testing.T) {
+	assert.True(t, isTransient(&textproto.Error{Code: 451}))
+	assert.False(t, isTransient(&textproto.Error{Code: 554}))
+	assert.False(t, isTransient(errors.New("invalid address")))
+}
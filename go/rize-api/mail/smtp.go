@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"fmt"
+	netmail "net/mail"
+	"net/smtp"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+)
+
+// SMTP delivers emails through an SMTP relay
+type SMTP struct {
+	Host     string
+	Port     int
+	Username string // Username and Password are optional, PLAIN auth is used when set
+	Password string
+}
+
+func (s SMTP) Deliver(email core.OutboxEmail) error {
+	from, err := netmail.ParseAddress(email.From)
+	if err != nil {
+		return errors.Wrap(err, "smtp: invalid from address")
+	}
+	to, err := netmail.ParseAddress(email.To)
+	if err != nil {
+		return errors.Wrap(err, "smtp: invalid to address")
+	}
+
+	message, err := buildMessage(email, time.Now())
+	if err != nil {
+		return errors.Wrap(err, "smtp: build message")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	err = smtp.SendMail(fmt.Sprintf("%s:%d", s.Host, s.Port), auth, from.Address, []string{to.Address}, message)
+	if err != nil {
+		return errors.Wrap(err, "smtp: send mail")
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package mail
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+
+	"github.com/pkg/errors"
+)
+
+// Templates are compiled into the binary so that the mailer does not depend on the working directory.
+// Each template has an HTML and a plain text body; the HTML body is rendered inside layoutHTML.
+
+const layoutHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+</head>
+<body style="margin: 0; padding: 24px; background-color: #f4f4f4; font-family: Helvetica, Arial, sans-serif; color: #333333;">
+<table width="100%" cellpadding="0" cellspacing="0" style="max-width: 600px; margin: 0 auto; background-color: #ffffff;">
+<tr><td style="padding: 24px;">
+{{template "content" .}}
+</td></tr>
+</table>
+</body>
+</html>
+`
+
+type emailTemplate struct {
+	html string
+	text string
+}
+
+var emailTemplates = map[string]emailTemplate{
+	"forgot_password": {
+		html: `<h1 style="font-size: 20px;">Reset your password</h1>
+<p>Use this code in the app to choose a new password:</p>
+<p style="font-size: 18px; font-weight: bold;">{{.Token}}</p>
+<p>This code expires {{.Expiry}}. If you didn't ask to reset your password you can ignore this email.</p>`,
+		text: `Reset your password
+
+Use this code in the app to choose a new password:
+
+{{.Token}}
+
+This code expires {{.Expiry}}. If you didn't ask to reset your password you can ignore this email.
+`,
+	},
+	"pickup_ready": {
+		html: `<h1 style="font-size: 20px;">Your order is ready</h1>
+<p>Hi {{.CustomerName}}, your order from {{.SenderName}} is ready for pickup{{if .PickupTime}} at {{.PickupTime}}{{end}}.</p>
+<p>{{.SiteName}}{{if .SitePhone}} &middot; {{.SitePhone}}{{end}}</p>`,
+		text: `Hi {{.CustomerName}}, your order from {{.SenderName}} is ready for pickup{{if .PickupTime}} at {{.PickupTime}}{{end}}.
+
+{{.SiteName}}{{if .SitePhone}} - {{.SitePhone}}{{end}}
+`,
+	},
+	"receipt": {
+		html: `<h1 style="font-size: 20px;">Thanks for your order</h1>
+<p>{{.SiteName}}{{if .SiteAddress}}<br>{{.SiteAddress}}{{end}}{{if .SitePhone}}<br>{{.SitePhone}}{{end}}</p>
+<p>Order #{{.OrderID}} &middot; {{.Date.Format "Jan 2, 2006 3:04 pm"}}</p>
+<table width="100%" cellpadding="4" cellspacing="0" style="border-top: 1px solid #dddddd; border-bottom: 1px solid #dddddd;">
+{{range .Lines}}<tr>
+<td valign="top">{{.Quantity}} &times; {{.Name}}{{range .AddedModifiers}}<br><span style="color: #777777;">+ {{.}}</span>{{end}}{{range .RemovedModifiers}}<br><span style="color: #777777;">no {{.}}</span>{{end}}</td>
+<td valign="top" align="right">{{.Total}}</td>
+</tr>
+{{end}}</table>
+<table width="100%" cellpadding="4" cellspacing="0">
+<tr><td>Subtotal</td><td align="right">{{.Subtotal}}</td></tr>
+{{range .Fees}}<tr><td>{{.Name}}</td><td align="right">{{.Amount}}</td></tr>
+{{end}}<tr><td>Tax</td><td align="right">{{.Tax}}</td></tr>
+<tr><td>Tip</td><td align="right">{{.Tip}}</td></tr>
+<tr><td><strong>Total</strong></td><td align="right"><strong>{{.Total}}</strong></td></tr>
+</table>
+{{if .GiftCard}}<p>{{.GiftCard}} paid by gift card</p>{{end}}
+{{if .CardLast4}}<p>Paid with card ending in {{.CardLast4}}</p>{{end}}`,
+		text: `{{range .TextLines}}{{.}}
+{{end}}`,
+	},
+}
+
+type parsedTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+var parsedTemplates = map[string]parsedTemplate{}
+
+func init() {
+	for name, t := range emailTemplates {
+		html := htmltemplate.Must(htmltemplate.New(name).Parse(layoutHTML))
+		htmltemplate.Must(html.New("content").Parse(t.html))
+
+		parsedTemplates[name] = parsedTemplate{
+			html: html,
+			text: texttemplate.Must(texttemplate.New(name).Parse(t.text)),
+		}
+	}
+}
+
+// Render will execute the HTML and plain text bodies of a template with data
+func Render(template string, data interface{}) (htmlBody, textBody string, err error) {
+	t, ok := parsedTemplates[template]
+	if !ok {
+		return "", "", errors.Errorf("render: unknown email template '%s'", template)
+	}
+
+	var html, text bytes.Buffer
+	if err := t.html.Execute(&html, data); err != nil {
+		return "", "", errors.Wrapf(err, "render %s html", template)
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return "", "", errors.Wrapf(err, "render %s text", template)
+	}
+
+	return html.String(), text.String(), nil
+}
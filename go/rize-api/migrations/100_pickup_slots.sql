@@ -0,0 +1,17 @@
+ALTER TABLE sites ADD COLUMN time_zone TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE site_pickup_settings (
+  site_id             BIGINT PRIMARY KEY,
+  slot_minutes        INTEGER NOT NULL,
+  max_orders_per_slot INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE site_opening_hours (
+  id      SERIAL PRIMARY KEY,
+  site_id BIGINT  NOT NULL REFERENCES site_pickup_settings (site_id) ON DELETE CASCADE,
+  weekday INTEGER NOT NULL,
+  opens   INTEGER NOT NULL,
+  closes  INTEGER NOT NULL
+);
+
+CREATE INDEX orders_site_id_pickup_time_idx ON orders (site_id, pickup_time);
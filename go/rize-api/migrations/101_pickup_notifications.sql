@@ -0,0 +1,27 @@
+CREATE TABLE pickup_details (
+  order_id      BIGINT PRIMARY KEY REFERENCES orders (id) ON DELETE CASCADE,
+  customer_name TEXT NOT NULL DEFAULT '',
+  phone_number  TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE notification_settings (
+  site_id       BIGINT PRIMARY KEY,
+  sender_name   TEXT    NOT NULL DEFAULT '',
+  sms_from      TEXT    NOT NULL DEFAULT '',
+  sms_enabled   BOOLEAN NOT NULL DEFAULT FALSE,
+  email_enabled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE notifications (
+  id                  SERIAL PRIMARY KEY,
+  order_id            BIGINT    NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+  channel             TEXT      NOT NULL,
+  recipient           TEXT      NOT NULL,
+  template            TEXT      NOT NULL,
+  provider_message_id TEXT      NOT NULL DEFAULT '',
+  status              TEXT      NOT NULL,
+  error               TEXT      NOT NULL DEFAULT '',
+  created_at          TIMESTAMP NOT NULL
+);
+
+CREATE INDEX notifications_order_id_idx ON notifications (order_id);
@@ -0,0 +1,17 @@
+CREATE TABLE email_outbox (
+  id              SERIAL PRIMARY KEY,
+  template        TEXT      NOT NULL,
+  "from"          TEXT      NOT NULL,
+  "to"            TEXT      NOT NULL,
+  subject         TEXT      NOT NULL,
+  html_body       TEXT      NOT NULL,
+  text_body       TEXT      NOT NULL,
+  status          TEXT      NOT NULL,
+  attempts        INTEGER   NOT NULL DEFAULT 0,
+  last_error      TEXT      NOT NULL DEFAULT '',
+  next_attempt_at TIMESTAMP NOT NULL,
+  created_at      TIMESTAMP NOT NULL,
+  sent_at         TIMESTAMP
+);
+
+CREATE INDEX email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN card_last_4 TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+CREATE TABLE login_failures (
+  email           TEXT      PRIMARY KEY,
+  failures        INTEGER   NOT NULL,
+  last_failure_at TIMESTAMP NOT NULL,
+  locked_until    TIMESTAMP
+);
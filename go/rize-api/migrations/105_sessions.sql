@@ -0,0 +1,22 @@
+CREATE TABLE sessions (
+  id           SERIAL PRIMARY KEY,
+  customer_id  INTEGER   NOT NULL REFERENCES customers (id) ON DELETE CASCADE,
+  device       TEXT      NOT NULL DEFAULT '',
+  created_at   TIMESTAMP NOT NULL,
+  last_used_at TIMESTAMP NOT NULL,
+  expires_at   TIMESTAMP NOT NULL,
+  revoked_at   TIMESTAMP
+);
+
+CREATE INDEX sessions_customer_id_idx ON sessions (customer_id);
+
+CREATE TABLE refresh_tokens (
+  id         SERIAL PRIMARY KEY,
+  session_id INTEGER   NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+  token_hash TEXT      NOT NULL UNIQUE,
+  created_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP NOT NULL,
+  used_at    TIMESTAMP
+);
+
+CREATE INDEX refresh_tokens_session_id_idx ON refresh_tokens (session_id);
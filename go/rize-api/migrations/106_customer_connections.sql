@@ -0,0 +1,20 @@
+CREATE TABLE customer_connections (
+  id          SERIAL PRIMARY KEY,
+  customer_id INTEGER   NOT NULL REFERENCES customers (id) ON DELETE CASCADE,
+  service     TEXT      NOT NULL,
+  external_id TEXT      NOT NULL,
+  email       TEXT      NOT NULL DEFAULT '',
+  created_at  TIMESTAMP NOT NULL,
+  UNIQUE (service, external_id),
+  UNIQUE (customer_id, service)
+);
+
+-- Customer.external_id only ever held Facebook IDs
+INSERT INTO customer_connections (customer_id, service, external_id, email, created_at)
+SELECT id, 'facebook', external_id, email, now() FROM customers WHERE external_id <> '';
+
+-- Nonces handed to the apps before a social login, each used by one ID token
+CREATE TABLE login_nonces (
+  nonce      TEXT      PRIMARY KEY,
+  expires_at TIMESTAMP NOT NULL
+);
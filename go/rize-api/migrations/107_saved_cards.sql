@@ -0,0 +1,14 @@
+CREATE TABLE saved_cards (
+  id          SERIAL PRIMARY KEY,
+  customer_id INTEGER   NOT NULL REFERENCES customers (id) ON DELETE CASCADE,
+  gateway     TEXT      NOT NULL,
+  vault_id    TEXT      NOT NULL,
+  card_type   TEXT      NOT NULL DEFAULT '',
+  last_4      TEXT      NOT NULL DEFAULT '',
+  expiry      TEXT      NOT NULL DEFAULT '',
+  is_default  BOOLEAN   NOT NULL DEFAULT FALSE,
+  created_at  TIMESTAMP NOT NULL,
+  UNIQUE (gateway, vault_id)
+);
+
+CREATE INDEX saved_cards_customer_id_idx ON saved_cards (customer_id);
@@ -0,0 +1,29 @@
+CREATE TABLE loyalty_rules (
+  site_id         BIGINT PRIMARY KEY,
+  cents_per_point INTEGER NOT NULL,
+  minimum_spend   INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE loyalty_rewards (
+  id       SERIAL PRIMARY KEY,
+  name     TEXT    NOT NULL,
+  points   INTEGER NOT NULL,
+  discount INTEGER NOT NULL,
+  active   BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+CREATE TABLE loyalty_entries (
+  id             SERIAL PRIMARY KEY,
+  customer_id    INTEGER   NOT NULL REFERENCES customers (id) ON DELETE CASCADE,
+  order_id       BIGINT    REFERENCES orders (id) ON DELETE SET NULL,
+  kind           TEXT      NOT NULL,
+  points         INTEGER   NOT NULL,
+  description    TEXT      NOT NULL DEFAULT '',
+  reversal_of    INTEGER   UNIQUE REFERENCES loyalty_entries (id) ON DELETE CASCADE,
+  transaction_id TEXT      NOT NULL DEFAULT '',
+  created_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX loyalty_entries_customer_id_idx ON loyalty_entries (customer_id);
+CREATE INDEX loyalty_entries_order_id_idx ON loyalty_entries (order_id);
+CREATE INDEX loyalty_entries_transaction_id_idx ON loyalty_entries (transaction_id) WHERE transaction_id <> '';
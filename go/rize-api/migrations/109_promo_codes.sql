@@ -0,0 +1,37 @@
+ALTER TABLE orders ADD COLUMN discount INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE promo_codes (
+  id                 SERIAL PRIMARY KEY,
+  code               TEXT      NOT NULL UNIQUE,
+  description        TEXT      NOT NULL DEFAULT '',
+  discount_type      TEXT      NOT NULL,
+  discount_value     INTEGER   NOT NULL,
+  minimum_spend      INTEGER   NOT NULL DEFAULT 0,
+  per_customer_limit INTEGER   NOT NULL DEFAULT 0,
+  starts_at          TIMESTAMP,
+  ends_at            TIMESTAMP,
+  active             BOOLEAN   NOT NULL DEFAULT TRUE,
+  created_at         TIMESTAMP NOT NULL
+);
+
+CREATE TABLE promo_code_restrictions (
+  id            SERIAL PRIMARY KEY,
+  promo_code_id INTEGER NOT NULL REFERENCES promo_codes (id) ON DELETE CASCADE,
+  kind          TEXT    NOT NULL,
+  pos_id        BIGINT  NOT NULL
+);
+
+CREATE INDEX promo_code_restrictions_promo_code_id_idx ON promo_code_restrictions (promo_code_id);
+
+-- promo codes are redeemed before their Kounta order is created, so a redemption has no order until then
+CREATE TABLE promo_code_redemptions (
+  id            SERIAL PRIMARY KEY,
+  promo_code_id INTEGER   NOT NULL REFERENCES promo_codes (id),
+  order_id      BIGINT    REFERENCES orders (id) ON DELETE CASCADE,
+  customer_id   INTEGER   REFERENCES customers (id) ON DELETE SET NULL,
+  discount      INTEGER   NOT NULL,
+  created_at    TIMESTAMP NOT NULL
+);
+
+CREATE INDEX promo_code_redemptions_promo_code_id_customer_id_idx ON promo_code_redemptions (promo_code_id, customer_id);
+CREATE INDEX promo_code_redemptions_order_id_idx ON promo_code_redemptions (order_id);
@@ -0,0 +1,22 @@
+ALTER TABLE payments ADD COLUMN method TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE gift_cards (
+  id              SERIAL PRIMARY KEY,
+  code            TEXT      NOT NULL UNIQUE,
+  initial_balance INTEGER   NOT NULL,
+  balance         INTEGER   NOT NULL CHECK (balance >= 0),
+  created_at      TIMESTAMP NOT NULL
+);
+
+-- every change to a balance is recorded here, so a card's balance can always be audited against its entries
+CREATE TABLE gift_card_entries (
+  id             SERIAL PRIMARY KEY,
+  gift_card_id   INTEGER   NOT NULL REFERENCES gift_cards (id),
+  kind           TEXT      NOT NULL,
+  amount         INTEGER   NOT NULL,
+  order_id       BIGINT    REFERENCES orders (id),
+  transaction_id TEXT      UNIQUE,
+  created_at     TIMESTAMP NOT NULL
+);
+
+CREATE INDEX gift_card_entries_gift_card_id_idx ON gift_card_entries (gift_card_id);
@@ -0,0 +1,24 @@
+CREATE INDEX table_mapping_site_id_idx ON table_mapping (site_id);
+
+-- A beacon can only be on one table. Repeats of the same mapping are dropped, but beacons mapped to different tables
+-- have to be fixed by hand first (FindDuplicateTableMaps lists them) or the unique index can't be created.
+DELETE FROM table_mapping a USING table_mapping b
+WHERE a.ctid < b.ctid AND a.beacon_id = b.beacon_id AND a.site_id = b.site_id AND a.table_name = b.table_name;
+
+CREATE UNIQUE INDEX table_mapping_beacon_id_idx ON table_mapping (beacon_id);
+
+CREATE TABLE floor_sections (
+  id      SERIAL PRIMARY KEY,
+  site_id BIGINT NOT NULL,
+  name    TEXT   NOT NULL,
+  UNIQUE (site_id, name)
+);
+
+CREATE TABLE floor_tables (
+  id         SERIAL PRIMARY KEY,
+  site_id    BIGINT  NOT NULL,
+  section_id INTEGER REFERENCES floor_sections (id) ON DELETE SET NULL,
+  name       TEXT    NOT NULL,
+  seats      INTEGER NOT NULL DEFAULT 0,
+  UNIQUE (site_id, name)
+);
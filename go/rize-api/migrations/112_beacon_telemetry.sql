@@ -0,0 +1,7 @@
+CREATE TABLE beacon_telemetry (
+  beacon_id          TEXT PRIMARY KEY,
+  battery_millivolts INTEGER   NOT NULL DEFAULT 0,
+  temperature        DOUBLE PRECISION,
+  source             TEXT      NOT NULL,
+  reported_at        TIMESTAMP NOT NULL
+);
@@ -0,0 +1,32 @@
+CREATE TABLE lrs_events (
+  id           SERIAL PRIMARY KEY,
+  site_id      BIGINT    NOT NULL,
+  elapsed_time BIGINT    NOT NULL,
+  uuid         TEXT      NOT NULL,
+  order_type   TEXT      NOT NULL DEFAULT '',
+  table_name   TEXT      NOT NULL DEFAULT '',
+  state        TEXT      NOT NULL,
+  pager_number TEXT      NOT NULL,
+  paged        BOOLEAN   NOT NULL DEFAULT FALSE,
+  received_at  TIMESTAMP NOT NULL,
+  outcome      TEXT      NOT NULL DEFAULT '',
+  error        TEXT      NOT NULL DEFAULT ''
+);
+
+CREATE INDEX lrs_events_site_id_received_at_idx ON lrs_events (site_id, received_at);
+
+CREATE TABLE pager_sessions (
+  id           SERIAL PRIMARY KEY,
+  site_id      BIGINT    NOT NULL,
+  pager_number BIGINT    NOT NULL,
+  uuid         TEXT      NOT NULL UNIQUE,
+  state        TEXT      NOT NULL,
+  elapsed_time BIGINT    NOT NULL DEFAULT 0,
+  order_id     BIGINT    REFERENCES orders (id) ON DELETE SET NULL,
+  table_name   TEXT      NOT NULL DEFAULT '',
+  started_at   TIMESTAMP NOT NULL,
+  paged_at     TIMESTAMP,
+  cleared_at   TIMESTAMP
+);
+
+CREATE INDEX pager_sessions_site_id_pager_number_idx ON pager_sessions (site_id, pager_number) WHERE cleared_at IS NULL;
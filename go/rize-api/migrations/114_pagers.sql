@@ -0,0 +1,10 @@
+CREATE TABLE pagers (
+  id          SERIAL PRIMARY KEY,
+  site_id     BIGINT    NOT NULL,
+  number      BIGINT    NOT NULL,
+  status      TEXT      NOT NULL,
+  order_id    BIGINT    REFERENCES orders (id) ON DELETE SET NULL,
+  assigned_at TIMESTAMP,
+  updated_at  TIMESTAMP NOT NULL,
+  UNIQUE (site_id, number)
+);
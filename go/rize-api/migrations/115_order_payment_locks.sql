@@ -0,0 +1,5 @@
+-- a check's orders are locked while it is paid so that the same orders can't be charged twice at once
+CREATE TABLE order_payment_locks (
+  order_id     BIGINT    PRIMARY KEY REFERENCES orders (id) ON DELETE CASCADE,
+  locked_until TIMESTAMP NOT NULL
+);
@@ -0,0 +1,18 @@
+CREATE TABLE site_tip_settings (
+  site_id     BIGINT    PRIMARY KEY,
+  percentages INTEGER[] NOT NULL,
+  pre_tax     BOOLEAN   NOT NULL DEFAULT FALSE,
+  max_percent INTEGER   NOT NULL
+);
+
+CREATE TABLE staff_tips (
+  id              SERIAL PRIMARY KEY,
+  pos_order_id    BIGINT    NOT NULL UNIQUE,
+  site_id         BIGINT    NOT NULL,
+  staff_member_id BIGINT    NOT NULL,
+  tips            INTEGER   NOT NULL,
+  sold_at         TIMESTAMP NOT NULL,
+  updated_at      TIMESTAMP NOT NULL
+);
+
+CREATE INDEX staff_tips_site_id_sold_at_idx ON staff_tips (site_id, sold_at);
@@ -0,0 +1,27 @@
+-- tax_rate is a percent charged on taxable fees, menu prices from Kounta already include their tax
+ALTER TABLE sites ADD COLUMN tax_rate DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+CREATE TABLE site_fee_rules (
+  id             SERIAL PRIMARY KEY,
+  site_id        BIGINT           NOT NULL,
+  name           TEXT             NOT NULL,
+  kind           TEXT             NOT NULL,
+  percent        DOUBLE PRECISION NOT NULL DEFAULT 0,
+  amount         INTEGER          NOT NULL DEFAULT 0,
+  min_party_size INTEGER          NOT NULL DEFAULT 0,
+  taxable        BOOLEAN          NOT NULL DEFAULT FALSE,
+  active         BOOLEAN          NOT NULL DEFAULT TRUE
+);
+
+CREATE INDEX site_fee_rules_site_id_idx ON site_fee_rules (site_id);
+
+CREATE TABLE order_fees (
+  id       SERIAL PRIMARY KEY,
+  order_id BIGINT  NOT NULL REFERENCES orders (id) ON DELETE CASCADE,
+  name     TEXT    NOT NULL,
+  kind     TEXT    NOT NULL,
+  amount   INTEGER NOT NULL,
+  tax      INTEGER NOT NULL
+);
+
+CREATE INDEX order_fees_order_id_idx ON order_fees (order_id);
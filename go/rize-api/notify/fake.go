@@ -0,0 +1,46 @@
+package notify
+
+// SMS is a text message recorded by FakeSMS
+type SMS struct {
+	From string
+	To   string
+	Body string
+}
+
+// FakeSMS records text messages instead of sending them, for tests and local development
+type FakeSMS struct {
+	Sent  []SMS
+	Error error // Error will be returned from SendSMS when set
+}
+
+func (f *FakeSMS) SendSMS(from, to, body string) (string, error) {
+	if f.Error != nil {
+		return "", f.Error
+	}
+
+	f.Sent = append(f.Sent, SMS{From: from, To: to, Body: body})
+	return "fake-message-id", nil
+}
+
+// Email is an email recorded by FakeMailer
+type Email struct {
+	Template string
+	To       string
+	Subject  string
+	Data     interface{}
+}
+
+// FakeMailer records emails instead of sending them, for tests and local development
+type FakeMailer struct {
+	Sent  []Email
+	Error error // Error will be returned from SendEmail when set
+}
+
+func (f *FakeMailer) SendEmail(template, toString, subject string, data interface{}) error {
+	if f.Error != nil {
+		return f.Error
+	}
+
+	f.Sent = append(f.Sent, Email{Template: template, To: toString, Subject: subject, Data: data})
+	return nil
+}
@@ -0,0 +1,89 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const twilioBaseURL = "https://api.twilio.com"
+
+// Twilio sends text messages through the Twilio REST API
+type Twilio struct {
+	BaseURL    string // BaseURL defaults to the Twilio API, set it to point at a local server
+	AccountSID string
+	AuthToken  string
+	NoOp       bool // set this to true to prevent messages from being sent.  useful for testing on non-production environments
+}
+
+type twilioResponse struct {
+	SID     string `json:"sid"`
+	Message string `json:"message"`
+}
+
+func (t Twilio) SendSMS(from, to, body string) (string, error) {
+	if t.NoOp {
+		return "noop-message-id", nil
+	}
+
+	baseURL := t.BaseURL
+	if baseURL == "" {
+		baseURL = twilioBaseURL
+	}
+
+	params := url.Values{}
+	params.Add("From", from)
+	params.Add("To", normalizePhoneNumber(to))
+	params.Add("Body", body)
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json", baseURL, t.AccountSID), strings.NewReader(params.Encode()))
+	if err != nil {
+		return "", errors.Wrap(err, "send sms")
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+	client := http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "send sms")
+	}
+	defer res.Body.Close()
+
+	response := twilioResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return "", errors.Wrapf(err, "send sms: %s", res.Status)
+	}
+	if res.StatusCode >= 300 {
+		return "", errors.Errorf("send sms: %s %s", res.Status, response.Message)
+	}
+
+	return response.SID, nil
+}
+
+// normalizePhoneNumber will format US phone numbers entered by customers, ex: (404) 123-4567, as E.164
+func normalizePhoneNumber(number string) string {
+	if strings.HasPrefix(number, "+") {
+		return number
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+
+	switch {
+	case len(digits) == 10:
+		return "+1" + digits
+	case len(digits) == 11 && digits[0] == '1':
+		return "+" + digits
+	default:
+		return number
+	}
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTwilioSendSMS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		assert.Equal(t, "AC123", user)
+		assert.Equal(t, "secret", password)
+		assert.Equal(t, "/2010-04-01/Accounts/AC123/Messages.json", r.URL.Path)
+		assert.Equal(t, "+14041234567", r.FormValue("To"))
+		assert.Equal(t, "Your order is ready", r.FormValue("Body"))
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"sid": "SM123"}`))
+	}))
+	defer server.Close()
+
+	api := Twilio{BaseURL: server.URL, AccountSID: "AC123", AuthToken: "secret"}
+	messageID, err := api.SendSMS("+15550001111", "(404) 123-4567", "Your order is ready")
+	assert.NoError(t, err)
+	assert.Equal(t, "SM123", messageID)
+}
+
+func TestTwilioSendSMSReturnsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "invalid To number"}`))
+	}))
+	defer server.Close()
+
+	api := Twilio{BaseURL: server.URL, AccountSID: "AC123", AuthToken: "secret"}
+	_, err := api.SendSMS("+15550001111", "123", "Your order is ready")
+	assert.Error(t, err)
+}
@@ -0,0 +1,262 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"pjd"
+)
+
+const (
+	// clockSkew is how far a token's expiry is allowed to be in the past, to allow for clocks being out of sync
+	clockSkew = time.Minute
+	// keyRefetchInterval is the least time between fetches of a provider's keys, so tokens with made up key IDs can't
+	// force a fetch on every login
+	keyRefetchInterval = time.Minute
+)
+
+// Provider verifies the ID tokens issued by an OpenID Connect identity provider, using the signing keys it publishes
+type Provider struct {
+	Service   string   // Service is stored on the Connection, ex: google
+	Issuer    string   // Issuer must match the token's iss claim
+	JWKSURL   string   // JWKSURL is where the provider publishes its signing keys
+	ClientIDs []string // ClientIDs are our app's client IDs, one of which must be the token's audience
+	// RequireNonce rejects tokens verified without a nonce, for providers whose sign in flow always issues one
+	RequireNonce bool
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewGoogle returns a Provider for Sign In with Google
+func NewGoogle(clientIDs ...string) *Provider {
+	return &Provider{
+		Service:   "google",
+		Issuer:    "https://accounts.google.com",
+		JWKSURL:   "https://www.googleapis.com/oauth2/v3/certs",
+		ClientIDs: clientIDs,
+	}
+}
+
+// NewApple returns a Provider for Sign in with Apple
+func NewApple(clientIDs ...string) *Provider {
+	return &Provider{
+		Service:      "apple",
+		Issuer:       "https://appleid.apple.com",
+		JWKSURL:      "https://appleid.apple.com/auth/keys",
+		ClientIDs:    clientIDs,
+		RequireNonce: true,
+	}
+}
+
+// NewFacebook returns a Provider for Facebook Login, using the OIDC tokens issued by Limited Login
+func NewFacebook(appIDs ...string) *Provider {
+	return &Provider{
+		Service:      "facebook",
+		Issuer:       "https://www.facebook.com",
+		JWKSURL:      "https://limited.facebook.com/.well-known/oauth/openid/jwks/",
+		ClientIDs:    appIDs,
+		RequireNonce: true,
+	}
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expiry   int64    `json:"exp"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	// Apple sends email_verified as the string "true"
+	EmailVerified interface{} `json:"email_verified"`
+}
+
+// audience is either a single client ID or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = audience(list)
+	return nil
+}
+
+type jwks struct {
+	Keys []struct {
+		KeyType string `json:"kty"`
+		KeyID   string `json:"kid"`
+		N       string `json:"n"`
+		E       string `json:"e"`
+	} `json:"keys"`
+}
+
+// VerifyIDToken will check an ID token's signature, issuer, audience, expiry and nonce and return the account it
+// identifies. The nonce has to match whenever the token has one, and is always required when RequireNonce is set. A
+// token whose nonce is the hex SHA-256 of nonce also matches, as Sign in with Apple only sees the hashed nonce.
+func (p *Provider) VerifyIDToken(idToken, nonce string) (*core.Connection, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, core.AuthError{Reason: "invalid id token"}
+	}
+
+	h := header{}
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, core.AuthError{Reason: "invalid id token"}
+	}
+	if h.Algorithm != "RS256" {
+		return nil, core.AuthError{Reason: "unsupported id token algorithm " + h.Algorithm}
+	}
+
+	key, err := p.key(h.KeyID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "verify %s id token", p.Service)
+	}
+	if key == nil {
+		return nil, core.AuthError{Reason: "id token signed with an unknown key"}
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, core.AuthError{Reason: "invalid id token"}
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, core.AuthError{Reason: "invalid id token signature"}
+	}
+
+	c := claims{}
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, core.AuthError{Reason: "invalid id token"}
+	}
+	if c.Issuer != p.Issuer {
+		return nil, core.AuthError{Reason: "id token was not issued by " + p.Service}
+	}
+	if !p.hasAudience(c.Audience) {
+		return nil, core.AuthError{Reason: "id token was not issued for this app"}
+	}
+	if time.Now().Add(-clockSkew).Unix() > c.Expiry {
+		return nil, core.AuthError{Reason: "id token has expired"}
+	}
+	if p.RequireNonce && nonce == "" {
+		return nil, core.AuthError{Reason: "a nonce is required to sign in with " + p.Service}
+	}
+	if (nonce != "" || c.Nonce != "") && c.Nonce != nonce && c.Nonce != hashNonce(nonce) {
+		return nil, core.AuthError{Reason: "id token nonce does not match"}
+	}
+	if c.Subject == "" {
+		return nil, core.AuthError{Reason: "id token has no subject"}
+	}
+
+	return &core.Connection{
+		ID:            c.Subject,
+		Name:          c.Name,
+		Email:         c.Email,
+		EmailVerified: c.EmailVerified == true || c.EmailVerified == "true",
+		Service:       p.Service,
+	}, nil
+}
+
+// hashNonce returns the hex SHA-256 of a nonce, or nothing for no nonce
+func hashNonce(nonce string) string {
+	if nonce == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(hash[:])
+}
+
+func (p *Provider) hasAudience(aud audience) bool {
+	for _, clientID := range p.ClientIDs {
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the public key with the given ID, fetching the provider's keys again when it is not known since
+// providers rotate their keys. Keys are fetched at most once every keyRefetchInterval, without holding up logins with
+// known keys.
+func (p *Provider) key(keyID string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[keyID]
+	fetch := !ok && time.Since(p.fetchedAt) >= keyRefetchInterval
+	if fetch {
+		p.fetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+	if !fetch {
+		return key, nil
+	}
+
+	keys, err := fetchKeys(p.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys = keys
+	return p.keys[keyID], nil
+}
+
+func fetchKeys(jwksURL string) (map[string]*rsa.PublicKey, error) {
+	set := jwks{}
+	if _, err := (pjd.HTTPClient{ContentType: "application/json"}).Get(jwksURL, &set); err != nil {
+		return nil, errors.Wrap(err, "fetch keys")
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.KeyType != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch keys: decode key %s", k.KeyID)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, errors.Wrapf(err, "fetch keys: decode key %s", k.KeyID)
+		}
+
+		keys[k.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"core"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyIDToken(t *testing.T) {
+	fake, err := NewFakeIdentityProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	cases := []struct {
+		name     string
+		claims   IDTokenClaims
+		clientID string
+		nonce    string
+		valid    bool
+	}{
+		{"valid", IDTokenClaims{Subject: "123", Audience: "app", Nonce: "n"}, "app", "n", true},
+		{"nonce not checked", IDTokenClaims{Subject: "123", Audience: "app"}, "app", "", true},
+		{"wrong audience", IDTokenClaims{Subject: "123", Audience: "other"}, "app", "", false},
+		{"wrong nonce", IDTokenClaims{Subject: "123", Audience: "app", Nonce: "n"}, "app", "x", false},
+		{"hashed nonce", IDTokenClaims{Subject: "123", Audience: "app", Nonce: "1b16b1df538ba12dc3f97edbb85caa7050d46c148134290feba80f8236c83db9"}, "app", "n", true},
+		{"wrong hashed nonce", IDTokenClaims{Subject: "123", Audience: "app", Nonce: "1b16b1df538ba12dc3f97edbb85caa7050d46c148134290feba80f8236c83db9"}, "app", "x", false},
+		{"nonce issued but not checked", IDTokenClaims{Subject: "123", Audience: "app", Nonce: "n"}, "app", "", false},
+		{"expired", IDTokenClaims{Subject: "123", Audience: "app", Expiry: time.Now().Add(-time.Hour)}, "app", "", false},
+		{"no subject", IDTokenClaims{Audience: "app"}, "app", "", false},
+	}
+
+	for _, c := range cases {
+		idToken, err := fake.IssueIDToken(c.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		connection, err := fake.Provider("google", c.clientID).VerifyIDToken(idToken, c.nonce)
+		if c.valid {
+			assert.NoError(t, err, c.name)
+			assert.Equal(t, "123", connection.ID, c.name)
+			assert.Equal(t, "google", connection.Service, c.name)
+		} else {
+			assert.IsType(t, core.AuthError{}, errors.Cause(err), c.name)
+		}
+	}
+}
+
+func TestVerifyIDTokenRejectsTamperedToken(t *testing.T) {
+	fake, err := NewFakeIdentityProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+
+	idToken, _ := fake.IssueIDToken(IDTokenClaims{Subject: "123", Audience: "app"})
+	otherToken, _ := fake.IssueIDToken(IDTokenClaims{Subject: "456", Audience: "app"})
+
+	// the header and claims of one token with the signature of another
+	parts, otherParts := strings.Split(idToken, "."), strings.Split(otherToken, ".")
+	_, err = fake.Provider("google", "app").VerifyIDToken(parts[0]+"."+parts[1]+"."+otherParts[2], "")
+
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestVerifyIDTokenRequiresNonce(t *testing.T) {
+	fake, err := NewFakeIdentityProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	provider := fake.Provider("apple", "app")
+	provider.RequireNonce = true
+
+	idToken, _ := fake.IssueIDToken(IDTokenClaims{Subject: "123", Audience: "app"})
+	_, err = provider.VerifyIDToken(idToken, "")
+
+	assert.IsType(t, core.AuthError{}, errors.Cause(err))
+}
+
+func TestVerifyIDTokenLimitsKeyFetches(t *testing.T) {
+	fake, err := NewFakeIdentityProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	provider := fake.Provider("google", "app")
+
+	idToken, _ := fake.IssueIDToken(IDTokenClaims{Subject: "123", Audience: "app"})
+	_, err = provider.VerifyIDToken(idToken, "")
+	assert.NoError(t, err)
+
+	for _, keyID := range []string{"made-up-1", "made-up-2", "made-up-3"} {
+		unknownToken, _ := fake.IssueIDToken(IDTokenClaims{Subject: "123", Audience: "app", KeyID: keyID})
+		_, err := provider.VerifyIDToken(unknownToken, "")
+		assert.IsType(t, core.AuthError{}, errors.Cause(err))
+	}
+
+	assert.Equal(t, 1, fake.KeyFetches())
+}
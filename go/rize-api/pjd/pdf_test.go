@@ -0,0 +1,30 @@
+package pjd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTextPDF(t *testing.T) {
+	pdf := TextPDF([]string{"Test Pizza", "1 x Pizza (large)    $10.00", "Café"})
+
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+	assert.Contains(t, string(pdf), `(1 x Pizza \(large\)    $10.00) Tj`)
+	assert.Contains(t, string(pdf), "(Caf?) Tj")
+	assert.Contains(t, string(pdf), "/Count 1")
+}
+
+func TestTextPDFAddsPages(t *testing.T) {
+	var lines []string
+	for i := 0; i < pdfLinesPerPage+1; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+
+	pdf := TextPDF(lines)
+
+	assert.Contains(t, string(pdf), "/Count 2")
+}